@@ -1,7 +1,6 @@
 package dataManager_v2
 
 import (
-	"encoding/binary"
 	"errors"
 	"io"
 	"os"
@@ -11,24 +10,38 @@ import (
 	"time"
 
 	"github.com/PAW122/TsunamiDB/data/defragmentationManager"
+	types "github.com/PAW122/TsunamiDB/types"
 )
 
 type fileRequest struct {
-	op         string // "read" | "write" | "write_inc" | "write_inc_ow" | "read_inc" | "delete_inc"
-	data       []byte
-	startPtr   int64
-	endPtr     int64
-	entrySize  uint64 // używane dla incTables
-	inc_id     uint64 // używane dla incTables
-	read_type  uint8  // 0 = by id, 1 = last N entries, 2 = first N entries (używane dla incTables)
-	count_from string // top | bottom incTables save using custom id
-	resp       chan fileResponse
+	op           string // "read" | "write" | "write_inc" | "write_inc_ow" | "read_inc" | "delete_inc" | "skip_inc" | "compact_inc" | "resize_inc" | "trim_inc"
+	data         []byte
+	startPtr     int64
+	endPtr       int64
+	entrySize    uint64                 // używane dla incTables
+	inc_id       uint64                 // używane dla incTables
+	amount       uint64                 // ilość rekordów dla read_inc range
+	read_type    uint8                  // 0 = by id, 1 = last N entries, 2 = first N entries, 3 = range, 4 = filter, 5 = time range (używane dla incTables)
+	count_from   string                 // top | bottom incTables save using custom id
+	newEntrySize uint64                 // resize_inc: docelowy rozmiar wpisu
+	scanLimit    uint64                 // read_inc filter: maks. ilość sprawdzonych wpisów
+	match        func(data []byte) bool // read_inc filter: predykat na zdekodowanym payloadzie
+	retention    types.IncRetention     // trim_inc: limity retencji
+	ts           int64                  // write_inc / write_inc_ow: znacznik czasu wpisu (unix nano, 0 = teraz)
+	fromTime     int64                  // read_inc time range: od (unix nano, włącznie)
+	toTime       int64                  // read_inc time range: do (unix nano, wyłącznie; 0 = bez limitu)
+	resp         chan fileResponse
 }
 
 type fileResponse struct {
 	data     []byte
 	startPtr int64
 	endPtr   int64
+	count    int64    // read_inc / skip_inc / compact_inc: liczba rekordów w pliku
+	ids      []uint64 // read_inc: id zwróconych rekordów; compact_inc: usunięte id
+	times    []int64  // read_inc: znaczniki czasu zwróconych rekordów (unix nano)
+	next     int64    // read_inc: kursor kolejnej strony (-1 = koniec)
+	scanned  int64    // read_inc filter: ilość sprawdzonych wpisów
 	err      error
 }
 
@@ -54,7 +67,9 @@ func init() {
 func sendToFileWorker(filePath string, req fileRequest) fileResponse {
 	// Dla write_inc i read_inc korzystamy z osobnego katalogu inc_tables
 	var fullPath string
-	if req.op == "write_inc" || req.op == "write_inc_ow" || req.op == "read_inc" || req.op == "delete_inc" {
+	isInc := req.op == "write_inc" || req.op == "write_inc_ow" || req.op == "read_inc" || req.op == "delete_inc" ||
+		req.op == "skip_inc" || req.op == "compact_inc" || req.op == "resize_inc" || req.op == "trim_inc"
+	if isInc {
 		fullPath = filepath.Join(baseIncTablesPath, filePath)
 	} else {
 		fullPath = filepath.Join(basePath, filePath)
@@ -65,7 +80,7 @@ func sendToFileWorker(filePath string, req fileRequest) fileResponse {
 		ch := make(chan fileRequest, 10000)
 		actual, _ := fileWorkers.LoadOrStore(fullPath, ch)
 		if actual == ch {
-			go fileWorkerLoop(fullPath, filePath, ch)
+			go fileWorkerLoop(fullPath, filePath, ch, isInc)
 		}
 		chAny = actual
 	}
@@ -85,7 +100,22 @@ func sendToFileWorker(filePath string, req fileRequest) fileResponse {
 	return resp
 }
 
-func handleDeleteIncFile(file **os.File, fullPath string) error {
+func handleDeleteIncFile(file **os.File, inc *incState, fullPath string, entrySize uint64) error {
+	// bloki overflow zwalniane po usunięciu pliku tabeli (entrySize == 0 - bez overflow)
+	var overflow [][]byte
+	if entrySize > 0 && *file != nil {
+		var err error
+		if overflow, err = allIncOverflowRecords(*file, int64(entrySize)+3); err != nil {
+			return err
+		}
+	}
+
+	// najpierw mapa - plik danych bez mapy to pusta tabela w starym układzie
+	inc.close()
+	if err := removeIncMapFiles(fullPath); err != nil {
+		return err
+	}
+
 	if *file != nil {
 		if err := (*file).Close(); err != nil {
 			return err
@@ -107,10 +137,29 @@ func handleDeleteIncFile(file **os.File, fullPath string) error {
 		return err
 	}
 	*file = reopen
+	freeIncOverflow(fullPath, overflow...)
 	return nil
 }
 
-func fileWorkerLoop(fullPath string, logicalPath string, ch chan fileRequest) {
+// handleExclusiveIncOp - operacje podmieniające uchwyt pliku (poza batchem).
+func handleExclusiveIncOp(file **os.File, inc *incState, fullPath string, req fileRequest) fileResponse {
+	switch req.op {
+	case "compact_inc":
+		return handleCompactInc(file, inc, fullPath, req.entrySize)
+	case "resize_inc":
+		if err := scrubIncFree(*file, inc, req.entrySize); err != nil {
+			return fileResponse{err: err}
+		}
+		return handleResizeInc(file, fullPath, req.entrySize, req.newEntrySize)
+	}
+	return fileResponse{err: handleDeleteIncFile(file, inc, fullPath, req.entrySize)}
+}
+
+func isExclusiveIncOp(op string) bool {
+	return op == "delete_inc" || op == "compact_inc" || op == "resize_inc"
+}
+
+func fileWorkerLoop(fullPath string, logicalPath string, ch chan fileRequest, isInc bool) {
 	defer func() {
 		if r := recover(); r != nil {
 			close(ch)
@@ -130,6 +179,12 @@ func fileWorkerLoop(fullPath string, logicalPath string, ch chan fileRequest) {
 	}
 	defer file.Close()
 
+	var inc *incState
+	if isInc {
+		inc = &incState{fullPath: fullPath}
+	}
+	defer inc.close()
+
 	for {
 		select {
 		case req := <-ch:
@@ -139,13 +194,12 @@ func fileWorkerLoop(fullPath string, logicalPath string, ch chan fileRequest) {
 				}
 				return
 			}
-			if req.op == "delete_inc" {
+			if isExclusiveIncOp(req.op) {
 				if len(pending) > 0 {
-					executeBatch(file, logicalPath, pending)
+					executeBatch(file, logicalPath, pending, inc)
 					pending = pending[:0]
 				}
-				err := handleDeleteIncFile(&file, fullPath)
-				req.resp <- fileResponse{err: err}
+				req.resp <- handleExclusiveIncOp(&file, inc, fullPath, req)
 				continue
 			}
 
@@ -154,13 +208,12 @@ func fileWorkerLoop(fullPath string, logicalPath string, ch chan fileRequest) {
 			for {
 				select {
 				case req := <-ch:
-					if req.op == "delete_inc" {
+					if isExclusiveIncOp(req.op) {
 						if len(pending) > 0 {
-							executeBatch(file, logicalPath, pending)
+							executeBatch(file, logicalPath, pending, inc)
 							pending = pending[:0]
 						}
-						err := handleDeleteIncFile(&file, fullPath)
-						req.resp <- fileResponse{err: err}
+						req.resp <- handleExclusiveIncOp(&file, inc, fullPath, req)
 						continue collectLoop
 					}
 					pending = append(pending, req)
@@ -170,13 +223,13 @@ func fileWorkerLoop(fullPath string, logicalPath string, ch chan fileRequest) {
 			}
 
 			if len(pending) > 0 {
-				executeBatch(file, logicalPath, pending)
+				executeBatch(file, logicalPath, pending, inc)
 				pending = pending[:0]
 			}
 
 		case <-ticker.C:
 			if len(pending) > 0 {
-				executeBatch(file, logicalPath, pending)
+				executeBatch(file, logicalPath, pending, inc)
 				pending = pending[:0]
 			}
 		}
@@ -208,7 +261,7 @@ func EnsureDirsForTests() {
 }
 
 // todo - potencjalna optymalizacja - tylko 1 przejście for po batchu
-func executeBatch(file *os.File, filePath string, batch []fileRequest) {
+func executeBatch(file *os.File, filePath string, batch []fileRequest, inc *incState) {
 	// 1) Wydziel write_inc (append-only, stały rekord) ORAZ write (stary tryb)
 	var writeIncReqs []*fileRequest
 	var overWriteIncReqs []*fileRequest
@@ -225,47 +278,37 @@ func executeBatch(file *os.File, filePath string, batch []fileRequest) {
 		}
 	}
 
+	// pozycje wpisów trzyma incMap - rekordy w pliku nie są przesuwane
 	if len(overWriteIncReqs) > 0 {
 		for _, req := range overWriteIncReqs {
 			recordSize := int64(req.entrySize) + 3
 			if recordSize <= 0 {
-				req.resp <- fileResponse{err: errors.New("write_inc_ow: invalid entry size")}
+				failIncWrite(filePath, req, errors.New("write_inc_ow: invalid entry size"))
 				continue
 			}
 			if int64(len(req.data)) != recordSize {
-				req.resp <- fileResponse{err: errors.New("write_inc_ow: data length mismatch with record size")}
+				failIncWrite(filePath, req, errors.New("write_inc_ow: data length mismatch with record size"))
 				continue
 			}
-
-			// aktualny rozmiar pliku
-			fileSize, err := file.Seek(0, io.SeekEnd)
+			t, err := inc.table(file, recordSize)
 			if err != nil {
-				req.resp <- fileResponse{err: err}
+				failIncWrite(filePath, req, err)
 				continue
 			}
-			// wyrównanie pliku do recordSize (sanity)
-			if rem := fileSize % recordSize; rem != 0 {
-				pad := make([]byte, recordSize-rem)
-				if _, err := file.WriteAt(pad, fileSize); err != nil {
-					req.resp <- fileResponse{err: err}
-					continue
-				}
-				fileSize += int64(len(pad))
-			}
 
-			numRecords := fileSize / recordSize
+			numRecords := t.m.len()
 			prefID := int64(req.inc_id)
 			from := strings.ToLower(req.count_from) // "top" | "bottom"
 
 			switch req.read_type {
-			case 0: // INSERT (wstaw w prefID, przesuwając ogon)
+			case 0: // INSERT (wstaw w prefID, kolejne pozycje przesuwają się o 1)
 				// mapowanie prefID -> effID
 				var effID int64
 				switch from {
 				case "top":
 					// dozwolone 0..numRecords (0 => jako najnowszy; numRecords => jako najstarszy)
 					if prefID < 0 || prefID > numRecords {
-						req.resp <- fileResponse{err: errors.New("write_inc_ow: insert id out of range (top)")}
+						failIncWrite(filePath, req, errors.New("write_inc_ow: insert id out of range (top)"))
 						continue
 					}
 					// wstaw w miejsce liczone od dołu:
@@ -274,57 +317,31 @@ func executeBatch(file *os.File, filePath string, batch []fileRequest) {
 					effID = numRecords - prefID
 				default: // bottom (domyślnie)
 					if prefID < 0 || prefID > numRecords {
-						req.resp <- fileResponse{err: errors.New("write_inc_ow: insert id out of range (bottom)")}
+						failIncWrite(filePath, req, errors.New("write_inc_ow: insert id out of range (bottom)"))
 						continue
 					}
 					effID = prefID
 				}
-
-				// powiększ plik o jeden rekord
-				newSize := fileSize + recordSize
-				if err := file.Truncate(newSize); err != nil {
-					req.resp <- fileResponse{err: err}
+				if effID < t.m.trimmed {
+					failIncWrite(filePath, req, errors.New("write_inc_ow: insert id was trimmed by retention"))
 					continue
 				}
 
-				// przesuń ogon od końca do effID (reverse-copy, aby nie nadpisać nieprzeczytanych danych)
-				failed := false
-				buf := make([]byte, recordSize)
-				for srcIdx := numRecords - 1; srcIdx >= effID; srcIdx-- {
-					srcOff := srcIdx * recordSize
-					dstOff := (srcIdx + 1) * recordSize
-
-					if _, err := file.ReadAt(buf, srcOff); err != nil && err != io.EOF {
-						req.resp <- fileResponse{err: err}
-						failed = true
-						break
-					}
-					if _, err := file.WriteAt(buf, dstOff); err != nil {
-						req.resp <- fileResponse{err: err}
-						failed = true
-						break
-					}
-				}
-				if failed {
-					continue // odpowiedź już wysłana
+				// nowy rekord trafia do wolnego slotu albo na koniec pliku, mapa wstawia go na effID
+				slot, err := t.allocSlot(file, recordSize, req.data, req.ts)
+				if err != nil {
+					failIncWrite(filePath, req, err)
+					continue
 				}
-
-				// zapisz nowy rekord w effID
-				offset := effID * recordSize
-				if _, err := file.WriteAt(req.data, offset); err != nil {
-					req.resp <- fileResponse{err: err}
+				if err := t.insert(effID, uint64(slot)); err != nil {
+					t.releaseSlot(file, recordSize, slot)
+					failIncWrite(filePath, req, err)
 					continue
 				}
+				t.order.forget()
 
 				// zwróć globalny id (bottom-based)
-				idBuf := make([]byte, 8)
-				binary.LittleEndian.PutUint64(idBuf, uint64(effID))
-				req.resp <- fileResponse{
-					data:     idBuf,
-					startPtr: offset,
-					endPtr:   offset + recordSize,
-					err:      nil,
-				}
+				req.resp <- incIDResponse(effID, slot, recordSize)
 
 			case 1: // OVERWRITE istniejącego
 				var effID int64
@@ -332,7 +349,7 @@ func executeBatch(file *os.File, filePath string, batch []fileRequest) {
 				case "top":
 					// dozwolone 0..numRecords-1 (0 => nadpisz najnowszy)
 					if prefID < 0 || prefID >= numRecords {
-						req.resp <- fileResponse{err: errors.New("write_inc_ow: overwrite id out of range (top)")}
+						failIncWrite(filePath, req, errors.New("write_inc_ow: overwrite id out of range (top)"))
 						continue
 					}
 					// 0(top) -> effID = numRecords-1 (najnowszy)
@@ -340,84 +357,114 @@ func executeBatch(file *os.File, filePath string, batch []fileRequest) {
 					effID = (numRecords - 1) - prefID
 				default: // bottom
 					if prefID < 0 || prefID >= numRecords {
-						req.resp <- fileResponse{err: errors.New("write_inc_ow: overwrite id out of range (bottom)")}
+						failIncWrite(filePath, req, errors.New("write_inc_ow: overwrite id out of range (bottom)"))
 						continue
 					}
 					effID = prefID
 				}
-
-				offset := effID * recordSize
-				if _, err := file.WriteAt(req.data, offset); err != nil {
-					req.resp <- fileResponse{err: err}
+				if effID < t.m.trimmed {
+					failIncWrite(filePath, req, errors.New("write_inc_ow: overwrite id was trimmed by retention"))
 					continue
 				}
 
-				idBuf := make([]byte, 8)
-				binary.LittleEndian.PutUint64(idBuf, uint64(effID))
-				req.resp <- fileResponse{
-					data:     idBuf,
-					startPtr: offset,
-					endPtr:   offset + recordSize,
-					err:      nil,
+				slot, deleted := t.m.get(effID)
+				// usunięta pozycja, której slot zwolniła kompakcja - wpis w nowym slocie
+				if slot == incMapNoSlot {
+					newSlot, err := t.allocSlot(file, recordSize, req.data, req.ts)
+					if err != nil {
+						failIncWrite(filePath, req, err)
+						continue
+					}
+					if err := t.set(effID, uint64(newSlot)); err != nil {
+						t.releaseSlot(file, recordSize, newSlot)
+						failIncWrite(filePath, req, err)
+						continue
+					}
+					t.order.forget()
+					req.resp <- incIDResponse(effID, newSlot, recordSize)
+					continue
+				}
+				old, err := readRecord(file, int64(slot), recordSize)
+				if err != nil {
+					failIncWrite(filePath, req, err)
+					continue
+				}
+				var oldTs int64
+				if deleted {
+					if oldTs, err = t.slotTime(slot); err != nil {
+						failIncWrite(filePath, req, err)
+						continue
+					}
+				}
+				if _, err := file.WriteAt(req.data, int64(slot)*recordSize); err != nil {
+					failIncWrite(filePath, req, err)
+					continue
+				}
+				// nadpisanie zachowuje czas wpisu, chyba że podano nowy (albo pozycja była usunięta)
+				if req.ts != 0 || deleted {
+					if err := t.touch(int64(slot), req.ts); err != nil {
+						// slot wraca do poprzedniego rekordu (old zostaje), overflow nowego jest zwalniany
+						_, _ = file.WriteAt(old, int64(slot)*recordSize)
+						failIncWrite(filePath, req, err)
+						continue
+					}
+					t.order.forget()
+				}
+				// nadpisanie usuniętego wpisu go "ożywia"
+				if deleted {
+					if err := t.set(effID, slot); err != nil {
+						// pozycja zostaje usunięta - poprzedni rekord i czas wracają do slotu
+						_, _ = file.WriteAt(old, int64(slot)*recordSize)
+						_ = t.setSlotTime(int64(slot), oldTs)
+						failIncWrite(filePath, req, err)
+						continue
+					}
 				}
+				freeIncOverflow(t.fullPath, old)
+
+				req.resp <- incIDResponse(effID, int64(slot), recordSize)
 
 			default:
-				req.resp <- fileResponse{err: errors.New("write_inc_ow: invalid read_type (use 0=insert,1=overwrite)")}
+				failIncWrite(filePath, req, errors.New("write_inc_ow: invalid read_type (use 0=insert,1=overwrite)"))
 			}
 		}
 	}
 
-	// 2) Obsłuż write_inc – każdy rekord append-only, policz id po rozmiarze pliku
+	// 2) Obsłuż write_inc – każdy rekord w wolnym/nowym slocie, id = kolejna pozycja w mapie
 	if len(writeIncReqs) > 0 {
 		for _, req := range writeIncReqs {
 			// stały rozmiar rekordu
 			recordSize := int64(req.entrySize) + 3
 			if recordSize <= 0 {
-				req.resp <- fileResponse{err: errors.New("invalid entry size for write_inc")}
+				failIncWrite(filePath, req, errors.New("invalid entry size for write_inc"))
 				continue
 			}
 			if int64(len(req.data)) != recordSize {
-				req.resp <- fileResponse{err: errors.New("write_inc: data length mismatch with record size")}
+				failIncWrite(filePath, req, errors.New("write_inc: data length mismatch with record size"))
 				continue
 			}
-
-			// aktualny rozmiar pliku (EOF)
-			fileSize, err := file.Seek(0, io.SeekEnd)
+			t, err := inc.table(file, recordSize)
 			if err != nil {
-				req.resp <- fileResponse{err: err}
+				failIncWrite(filePath, req, err)
 				continue
 			}
 
-			// jeśli plik nie jest wyrównany do recordSize, dopełnij zerami (ochrona przed uszkodzeniem)
-			if rem := fileSize % recordSize; rem != 0 {
-				pad := make([]byte, recordSize-rem)
-				if _, err := file.WriteAt(pad, fileSize); err != nil {
-					req.resp <- fileResponse{err: err}
-					continue
-				}
-				fileSize += int64(len(pad))
+			ts := incTimestamp(req.ts)
+			slot, err := t.allocSlot(file, recordSize, req.data, ts)
+			if err != nil {
+				failIncWrite(filePath, req, err)
+				continue
 			}
-
-			// wylicz id i offset
-			id := fileSize / recordSize
-			offset := id * recordSize
-			end := offset + recordSize
-
-			// write-at (append na wyliczonym offsetcie)
-			if _, err := file.WriteAt(req.data, offset); err != nil {
-				req.resp <- fileResponse{err: err}
+			id := t.m.len()
+			if err := t.insert(id, uint64(slot)); err != nil {
+				t.releaseSlot(file, recordSize, slot)
+				failIncWrite(filePath, req, err)
 				continue
 			}
+			t.order.appended(ts)
 
 			// zwróć start/end oraz id jako 8B LE w polu data
-			idBuf := make([]byte, 8)
-			binary.LittleEndian.PutUint64(idBuf, uint64(id))
-			req.resp <- fileResponse{
-				data:     idBuf,
-				startPtr: offset,
-				endPtr:   end,
-				err:      nil,
-			}
+			req.resp <- incIDResponse(id, slot, recordSize)
 		}
 	}
 
@@ -461,6 +508,20 @@ func executeBatch(file *os.File, filePath string, batch []fileRequest) {
 		}
 	}
 
+	// skip_inc (usunięcie pojedynczego wpisu) - po zapisach, przed odczytami
+	for i := range batch {
+		if batch[i].op == "skip_inc" {
+			batch[i].resp <- skipIncEntry(file, inc, &batch[i])
+		}
+	}
+
+	// trim_inc (retencja) - po zapisach, żeby limit obejmował wpisy z tego batcha
+	for i := range batch {
+		if batch[i].op == "trim_inc" {
+			batch[i].resp <- trimIncTable(file, inc, &batch[i])
+		}
+	}
+
 	// --- NOWE: obsługa read_inc ---
 	for i := range batch {
 		req := &batch[i]
@@ -475,18 +536,13 @@ func executeBatch(file *os.File, filePath string, batch []fileRequest) {
 			continue
 		}
 
-		// pobierz rozmiar pliku
-		fi, err := file.Stat()
+		t, err := inc.table(file, recordSize)
 		if err != nil {
 			req.resp <- fileResponse{err: err}
 			continue
 		}
-		fileSize := fi.Size()
-		if fileSize < 0 {
-			fileSize = 0
-		}
-		// liczba pełnych rekordów (ignorujemy ewentualny ogon)
-		numRecords := fileSize / recordSize
+		// liczba wpisów (łącznie z usuniętymi)
+		numRecords := t.m.len()
 
 		switch req.read_type {
 		case 0: // by id
@@ -495,77 +551,90 @@ func executeBatch(file *os.File, filePath string, batch []fileRequest) {
 				req.resp <- fileResponse{err: errors.New("read_inc: id out of range")}
 				continue
 			}
-			offset := id * recordSize
-			buf := make([]byte, recordSize)
-			_, err := file.ReadAt(buf, offset)
-			if err != nil && err != io.EOF {
-				req.resp <- fileResponse{err: err}
+			if id < t.m.trimmed {
+				req.resp <- fileResponse{err: ErrIncEntryNotFound}
 				continue
 			}
-			req.resp <- fileResponse{
-				data:     buf,
-				startPtr: offset,
-				endPtr:   offset + recordSize,
-				err:      nil,
-			}
-
-		case 1: // last N (NEWEST -> OLDEST in output)
-			n := int64(req.inc_id)
-			if n <= 0 || numRecords == 0 {
-				req.resp <- fileResponse{data: []byte{}, err: nil}
+			slot, _ := t.m.get(id)
+			if slot == incMapNoSlot {
+				req.resp <- fileResponse{err: ErrIncEntryNotFound}
 				continue
 			}
-			if n > numRecords {
-				n = numRecords
-			}
-			startIndex := numRecords - n
-			startOffset := startIndex * recordSize
-			totalBytes := n * recordSize
-
-			// czytamy blok last-N w naturalnej kolejności...
-			tmp := make([]byte, totalBytes)
-			_, err := file.ReadAt(tmp, startOffset)
-			if err != nil && err != io.EOF {
+			buf, err := readRecord(file, int64(slot), recordSize)
+			if err != nil {
 				req.resp <- fileResponse{err: err}
 				continue
 			}
-			// ...i odwracamy kolejność rekordów (żeby zwrócić newest->oldest)
-			out := make([]byte, totalBytes)
-			for i := int64(0); i < n; i++ {
-				srcStart := (n - 1 - i) * recordSize
-				dstStart := i * recordSize
-				copy(out[dstStart:dstStart+recordSize], tmp[srcStart:srcStart+recordSize])
+			ts, err := t.slotTime(slot)
+			if err != nil {
+				req.resp <- fileResponse{err: err}
+				continue
 			}
 			req.resp <- fileResponse{
-				data:     out,
-				startPtr: startOffset,
-				endPtr:   startOffset + totalBytes,
+				data:     buf,
+				times:    []int64{ts},
+				startPtr: int64(slot) * recordSize,
+				endPtr:   int64(slot+1) * recordSize,
 				err:      nil,
 			}
 
-		case 2: // first N (OLDEST -> NEWER)
-			n := int64(req.inc_id)
-			if n <= 0 || numRecords == 0 {
-				req.resp <- fileResponse{data: []byte{}, err: nil}
+		case 1, 2, 3:
+			// 1 = last N (NEWEST -> OLDEST), 2 = first N (OLDEST -> NEWER),
+			// 3 = range: od inc_id (liczone wg count_from) maks. amount żywych rekordów
+			// usunięte wpisy są pomijane; ciągłe sloty czytane są jednym ReadAt
+			start, n := int64(0), int64(req.inc_id)
+			top := req.read_type == 1
+			if req.read_type == 3 {
+				start, n = int64(req.inc_id), int64(req.amount)
+				top = strings.ToLower(req.count_from) == "top"
+			}
+			if n <= 0 || start < 0 || start >= numRecords {
+				req.resp <- fileResponse{data: []byte{}, count: numRecords, next: -1, err: nil}
 				continue
 			}
-			if n > numRecords {
-				n = numRecords
+
+			var (
+				ids, slots []uint64
+				cursor     int64
+				next       int64 = -1
+			)
+			if top {
+				// 0(top) = najnowszy rekord
+				ids, slots, cursor = t.m.liveBackward(numRecords-1-start, n)
+				if cursor >= 0 {
+					next = numRecords - 1 - cursor
+				}
+			} else {
+				ids, slots, cursor = t.m.liveForward(start, n)
+				if cursor < numRecords {
+					next = cursor
+				}
 			}
-			totalBytes := n * recordSize
-			buf := make([]byte, totalBytes)
-			_, err := file.ReadAt(buf, 0)
-			if err != nil && err != io.EOF {
+			data, err := readIncSlots(file, recordSize, slots)
+			if err != nil {
+				req.resp <- fileResponse{err: err}
+				continue
+			}
+			times, err := t.slotTimes(slots)
+			if err != nil {
 				req.resp <- fileResponse{err: err}
 				continue
 			}
 			req.resp <- fileResponse{
-				data:     buf,
-				startPtr: 0,
-				endPtr:   totalBytes,
-				err:      nil,
+				data:  data,
+				ids:   ids,
+				times: times,
+				count: numRecords,
+				next:  next,
+				err:   nil,
 			}
 
+		case 4:
+			req.resp <- filterIncEntries(file, t, recordSize, req)
+
+		case 5:
+			req.resp <- timeRangeIncEntries(file, t, recordSize, req)
+
 		default:
 			req.resp <- fileResponse{err: errors.New("read_inc: invalid read_type")}
 		}
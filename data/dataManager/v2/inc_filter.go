@@ -0,0 +1,101 @@
+package dataManager_v2
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	encoding_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+)
+
+// filterBatch - ilość wpisów czytanych naraz podczas skanowania z filtrem.
+const filterBatch = 256
+
+// filterIncEntries skanuje wpisy od inc_id (wg count_from) paczkami po filterBatch,
+// zwraca maks. amount pasujących i kończy po scanLimit sprawdzonych wpisach.
+// W pamięci jest naraz tylko jedna paczka + wynik.
+func filterIncEntries(file *os.File, t *incTable, recordSize int64, req *fileRequest) fileResponse {
+	if req.match == nil {
+		return fileResponse{err: errors.New("read_inc: missing filter")}
+	}
+	numRecords := t.m.len()
+	start, amount, scanLimit := int64(req.inc_id), int64(req.amount), int64(req.scanLimit)
+	if amount <= 0 || scanLimit <= 0 || start < 0 || start >= numRecords {
+		return fileResponse{data: []byte{}, count: numRecords, next: -1}
+	}
+
+	top := strings.ToLower(req.count_from) == "top"
+	pos := start
+	if top {
+		pos = numRecords - 1 - start
+	}
+
+	var (
+		out      []byte
+		outIDs   []uint64
+		outSlots []uint64
+		scanned  int64
+	)
+scan:
+	for scanned < scanLimit && pos >= 0 && pos < numRecords {
+		want := scanLimit - scanned
+		if want > filterBatch {
+			want = filterBatch
+		}
+		var ids, slots []uint64
+		var cursor int64
+		if top {
+			ids, slots, cursor = t.m.liveBackward(pos, want)
+		} else {
+			ids, slots, cursor = t.m.liveForward(pos, want)
+		}
+		if len(ids) == 0 {
+			pos = cursor
+			break
+		}
+		buf, err := readIncSlots(file, recordSize, slots)
+		if err != nil {
+			return fileResponse{err: err}
+		}
+
+		for i, id := range ids {
+			scanned++
+			rec := buf[int64(i)*recordSize : int64(i+1)*recordSize]
+			dec, err := encoding_v1.DecodeIncEntry(req.entrySize, rec)
+			var payload []byte
+			if err == nil {
+				payload, err = ResolveIncPayload(t.fullPath, dec)
+			}
+			if err == nil && req.match(payload) {
+				out = append(out, rec...)
+				outIDs = append(outIDs, id)
+				outSlots = append(outSlots, slots[i])
+			}
+			if int64(len(outIDs)) >= amount || scanned >= scanLimit {
+				if top {
+					pos = int64(id) - 1
+				} else {
+					pos = int64(id) + 1
+				}
+				break scan
+			}
+		}
+		pos = cursor
+	}
+
+	next := int64(-1)
+	if pos >= 0 && pos < numRecords {
+		next = pos
+		if top {
+			next = numRecords - 1 - pos
+		}
+	}
+	if out == nil {
+		out = []byte{}
+	}
+	times, err := t.slotTimes(outSlots)
+	if err != nil {
+		return fileResponse{err: err}
+	}
+	return fileResponse{data: out, ids: outIDs, times: times, count: numRecords, next: next, scanned: scanned}
+}
@@ -1,8 +1,11 @@
 package dataManager_v2
 
 import (
+	"io"
 	"os"
 	"path/filepath"
+
+	encoding_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
 )
 
 func GetIncRecordCount(filePath string, entrySize uint64) (uint64, error) {
@@ -17,3 +20,71 @@ func GetIncRecordCount(filePath string, entrySize uint64) (uint64, error) {
 	}
 	return uint64(fi.Size() / recordSize), nil
 }
+
+// GetIncEntryCount zwraca liczbę pozycji w mapie inc table (łącznie z usuniętymi i obciętymi),
+// czyli id następnego dopisanego wpisu.
+func GetIncEntryCount(filePath string, entrySize uint64) (uint64, error) {
+	rng, err := ReadIncDataFromFileAsync_FirstEntries(filePath, 0, entrySize)
+	return rng.Total, err
+}
+
+// GetIncTableSize zwraca rozmiar pliku inc table razem z plikiem overflow w bajtach (0, gdy plików nie ma).
+func GetIncTableSize(filePath string) (int64, error) {
+	var total int64
+	for _, p := range []string{filepath.Join(baseIncTablesPath, filePath), filepath.Join(basePath, incOverflowFile(filePath))} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, err
+		}
+		total += fi.Size()
+	}
+	return total, nil
+}
+
+// GetIncResizeGrowth zwraca, o ile bajtów urośnie inc table razem z plikiem overflow po zmianie
+// max_entry_size (ujemne, gdy się zmniejszy): różnica rozmiaru rekordów plus payloady wpisów,
+// które przy zmniejszeniu trafią do overflow. Tabela bez pliku (bez wpisów) nie rośnie.
+func GetIncResizeGrowth(filePath string, oldSize, newSize uint64) (int64, error) {
+	count, err := GetIncRecordCount(filePath, oldSize)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	growth := int64(count) * (int64(newSize) - int64(oldSize))
+	if newSize >= oldSize || newSize < encoding_v1.IncOverflowRefSize {
+		return growth, nil
+	}
+
+	f, err := os.Open(filepath.Join(baseIncTablesPath, filePath))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	recordSize := int64(oldSize) + 3
+	buf := make([]byte, 4096*recordSize)
+	for off := int64(0); off < int64(count)*recordSize; off += int64(len(buf)) {
+		n, err := f.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := int64(0); i+recordSize <= int64(n); i += recordSize {
+			rec := buf[i : i+recordSize]
+			if isIncOverflowRecord(rec) {
+				continue
+			}
+			dec, err := encoding_v1.DecodeIncEntry(oldSize, rec)
+			if err != nil {
+				return 0, err
+			}
+			if !dec.SkipBit && uint64(len(dec.Data)) > newSize {
+				growth += int64(len(dec.Data))
+			}
+		}
+	}
+	return growth, nil
+}
@@ -0,0 +1,279 @@
+package dataManager_v2
+
+/*
+incMap - mapowanie pozycja (id wpisu) -> slot (numer rekordu w pliku inc table).
+
+Rekordy w pliku nie są nigdy przesuwane: append i insert dopisują nowy slot na końcu pliku,
+a kolejność wpisów trzyma incMap. Dzięki temu insert w środku tabeli nie przepisuje ogona pliku.
+
+Wpisy trzymane są w kawałkach (chunk) o rozmiarze maks. incMapChunkMax, więc insert
+kosztuje O(liczba chunków + incMapChunkMax) operacji w pamięci, bez I/O na danych.
+Najwyższy bit wartości oznacza wpis usunięty (skip), dzięki czemu odczyty mogą
+przeskakiwać całe chunki bez żywych wpisów.
+
+Pozycje < trimmed zostały obcięte przez retencję (patrz inc_retention.go): nie mają wartości
+w chunkach, ale nadal liczą się do n, więc id kolejnych wpisów się nie zmieniają.
+
+Usunięta pozycja, której slot zwolniła kompakcja (inc_skip.go), zostaje w mapie z wartością
+incMapDeleted|incMapNoSlot - id kolejnych wpisów też się nie zmieniają.
+*/
+
+const (
+	incMapChunkMax  = 1024
+	incMapDeleted   = uint64(1) << 63
+	incMapSlotMask  = incMapDeleted - 1
+	incMapNoSlot    = incMapSlotMask // usunięta pozycja bez slotu w pliku (po compact_inc)
+	incMapChunkHalf = incMapChunkMax / 2
+)
+
+type incChunk struct {
+	vals []uint64
+	live int
+}
+
+type incMap struct {
+	chunks  []*incChunk
+	n       int64 // łącznie z obciętymi pozycjami
+	trimmed int64 // pozycje [0, trimmed) obcięte przez retencję
+}
+
+func newIncMap() *incMap {
+	return &incMap{}
+}
+
+func (m *incMap) len() int64 { return m.n }
+
+// locate zwraca chunk i offset dla pozycji pos (trimmed <= pos < n).
+func (m *incMap) locate(pos int64) (int, int) {
+	pos -= m.trimmed
+	for ci, c := range m.chunks {
+		if pos < int64(len(c.vals)) {
+			return ci, int(pos)
+		}
+		pos -= int64(len(c.vals))
+	}
+	return -1, -1
+}
+
+// get - obcięte pozycje są zwracane jako usunięte (slot bez znaczenia).
+func (m *incMap) get(pos int64) (slot uint64, deleted bool) {
+	if pos < m.trimmed {
+		return 0, true
+	}
+	ci, off := m.locate(pos)
+	v := m.chunks[ci].vals[off]
+	return v & incMapSlotMask, v&incMapDeleted != 0
+}
+
+// set podmienia wartość na pozycji pos (slot + flaga usunięcia).
+func (m *incMap) set(pos int64, v uint64) {
+	ci, off := m.locate(pos)
+	c := m.chunks[ci]
+	if c.vals[off]&incMapDeleted == 0 {
+		c.live--
+	}
+	if v&incMapDeleted == 0 {
+		c.live++
+	}
+	c.vals[off] = v
+}
+
+// insert wstawia v na pozycję pos (trimmed <= pos <= n), przesuwając kolejne pozycje o 1.
+func (m *incMap) insert(pos int64, v uint64) {
+	var ci, off int
+	if pos == m.n {
+		if len(m.chunks) == 0 || len(m.chunks[len(m.chunks)-1].vals) >= incMapChunkMax {
+			m.chunks = append(m.chunks, &incChunk{vals: make([]uint64, 0, incMapChunkMax)})
+		}
+		ci = len(m.chunks) - 1
+		off = len(m.chunks[ci].vals)
+	} else {
+		ci, off = m.locate(pos)
+	}
+
+	c := m.chunks[ci]
+	c.vals = append(c.vals, 0)
+	copy(c.vals[off+1:], c.vals[off:])
+	c.vals[off] = v
+	if v&incMapDeleted == 0 {
+		c.live++
+	}
+	m.n++
+
+	if len(c.vals) > incMapChunkMax {
+		m.split(ci)
+	}
+}
+
+func (m *incMap) split(ci int) {
+	c := m.chunks[ci]
+	right := &incChunk{vals: make([]uint64, len(c.vals)-incMapChunkHalf, incMapChunkMax)}
+	copy(right.vals, c.vals[incMapChunkHalf:])
+	c.vals = c.vals[:incMapChunkHalf]
+	c.live = countLive(c.vals)
+	right.live = countLive(right.vals)
+
+	m.chunks = append(m.chunks, nil)
+	copy(m.chunks[ci+2:], m.chunks[ci+1:])
+	m.chunks[ci+1] = right
+}
+
+func countLive(vals []uint64) int {
+	live := 0
+	for _, v := range vals {
+		if v&incMapDeleted == 0 {
+			live++
+		}
+	}
+	return live
+}
+
+// liveForward zbiera maks. n żywych pozycji od pos w stronę nowszych.
+// Zwraca pozycje, ich sloty oraz pozycję kolejnego nieodczytanego wpisu (n == koniec).
+func (m *incMap) liveForward(pos, n int64) ([]uint64, []uint64, int64) {
+	var ids, slots []uint64
+	if pos < m.trimmed {
+		pos = m.trimmed
+	}
+	base := m.trimmed
+	for _, c := range m.chunks {
+		if int64(len(ids)) >= n {
+			break
+		}
+		clen := int64(len(c.vals))
+		if base+clen <= pos {
+			base += clen
+			continue
+		}
+		if c.live == 0 {
+			pos = base + clen
+			base += clen
+			continue
+		}
+		for off := pos - base; off < clen && int64(len(ids)) < n; off++ {
+			if v := c.vals[off]; v&incMapDeleted == 0 {
+				ids = append(ids, uint64(base+off))
+				slots = append(slots, v&incMapSlotMask)
+			}
+			pos = base + off + 1
+		}
+		base += clen
+	}
+	if pos > m.n {
+		pos = m.n
+	}
+	return ids, slots, pos
+}
+
+// liveBackward zbiera maks. n żywych pozycji od pos (włącznie) w stronę starszych.
+// Zwraca pozycje (newest -> oldest), sloty oraz kolejną pozycję do odczytu (-1 = koniec).
+func (m *incMap) liveBackward(pos, n int64) ([]uint64, []uint64, int64) {
+	var ids, slots []uint64
+	if pos >= m.n {
+		pos = m.n - 1
+	}
+	end := m.n
+	for ci := len(m.chunks) - 1; ci >= 0 && int64(len(ids)) < n && pos >= 0; ci-- {
+		c := m.chunks[ci]
+		base := end - int64(len(c.vals))
+		end = base
+		if base > pos {
+			continue
+		}
+		if c.live == 0 {
+			pos = base - 1
+			continue
+		}
+		for off := pos - base; off >= 0 && int64(len(ids)) < n; off-- {
+			if v := c.vals[off]; v&incMapDeleted == 0 {
+				ids = append(ids, uint64(base+off))
+				slots = append(slots, v&incMapSlotMask)
+			}
+			pos = base + off - 1
+		}
+	}
+	if pos < m.trimmed {
+		pos = -1
+	}
+	return ids, slots, pos
+}
+
+// liveCount - liczba żywych wpisów.
+func (m *incMap) liveCount() int64 {
+	live := int64(0)
+	for _, c := range m.chunks {
+		live += int64(c.live)
+	}
+	return live
+}
+
+// slotsBetween zwraca sloty pozycji [from, to) (żywych i usuniętych, bez pozycji bez slotu).
+func (m *incMap) slotsBetween(from, to int64) []uint64 {
+	var out []uint64
+	base := m.trimmed
+	for _, c := range m.chunks {
+		clen := int64(len(c.vals))
+		for off := int64(0); off < clen; off++ {
+			if pos := base + off; pos >= from && pos < to && c.vals[off]&incMapSlotMask != incMapNoSlot {
+				out = append(out, c.vals[off]&incMapSlotMask)
+			}
+		}
+		base += clen
+		if base >= to {
+			break
+		}
+	}
+	return out
+}
+
+// values zwraca wartości pozycji [trimmed, n) w kolejności pozycji (checkpoint, kompakcja).
+func (m *incMap) values() []uint64 {
+	out := make([]uint64, 0, m.n)
+	for _, c := range m.chunks {
+		out = append(out, c.vals...)
+	}
+	return out
+}
+
+// trimTo obcina pozycje [trimmed, pos) i zwraca sloty obciętych wpisów (również usuniętych, o ile mają slot).
+func (m *incMap) trimTo(pos int64) []uint64 {
+	var freed []uint64
+	for m.trimmed < pos && len(m.chunks) > 0 {
+		c := m.chunks[0]
+		k := pos - m.trimmed
+		if k >= int64(len(c.vals)) {
+			k = int64(len(c.vals))
+		}
+		for _, v := range c.vals[:k] {
+			if slot := v & incMapSlotMask; slot != incMapNoSlot {
+				freed = append(freed, slot)
+			}
+		}
+		if k == int64(len(c.vals)) {
+			m.chunks = m.chunks[1:]
+		} else {
+			c.vals = append(c.vals[:0], c.vals[k:]...)
+			c.live = countLive(c.vals)
+		}
+		m.trimmed += k
+	}
+	return freed
+}
+
+// loadIncMapValues buduje mapę z wartości pozycji [trimmed, trimmed+len(vals)).
+func loadIncMapValues(trimmed int64, vals []uint64) *incMap {
+	m := &incMap{n: trimmed, trimmed: trimmed}
+	for len(vals) > 0 {
+		k := len(vals)
+		if k > incMapChunkMax {
+			k = incMapChunkMax
+		}
+		c := &incChunk{vals: make([]uint64, k, incMapChunkMax)}
+		copy(c.vals, vals[:k])
+		c.live = countLive(c.vals)
+		m.chunks = append(m.chunks, c)
+		m.n += int64(k)
+		vals = vals[k:]
+	}
+	return m
+}
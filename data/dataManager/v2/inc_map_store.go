@@ -0,0 +1,425 @@
+package dataManager_v2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	encoding_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+)
+
+/*
+Trwałość incMap (pliki obok inc table):
+
+	<table>.map    - checkpoint: "TSIM" | u64 gen | u64 count | count * u64 (slot | flaga usunięcia)
+	                 albo (tabela z obciętym początkiem) "TSI2" | u64 gen | u64 trimmed | u64 count | count * u64
+	<table>.maplog - log operacji: "TSIL" | u64 gen | rekordy [u8 op][u64 pos][u64 val]
+	                 (op trim: pos = nowa wartość trimmed; op time: pos = slot, val = czas zapisu slotu)
+
+Każda zmiana mapy to jeden dopisany rekord logu (po zapisaniu danych rekordu w pliku tabeli).
+Checkpoint o numerze gen zawiera wszystkie operacje z logów o gen <= gen, więc log z
+gen <= gen checkpointu jest przy otwarciu ignorowany - awaria między zapisem checkpointu a
+wyczyszczeniem logu nie powoduje podwójnego odtworzenia operacji. Niepełny rekord na końcu
+logu (awaria w trakcie zapisu) jest obcinany.
+
+Czas slotu (<table>.ts, inc_retention.go) trafia do logu przed operacją mapy, która go używa.
+Przy otwarciu czasy z logu są ponownie zapisywane w .ts, a checkpoint najpierw robi fsync .ts -
+utracony zapis .ts jest więc odtwarzany razem z mapą.
+
+Plik tabeli bez .map to stary układ (pozycja == slot) - migrowany przy pierwszym otwarciu.
+*/
+
+const (
+	incMapMagic         = "TSIM"
+	incMapMagicTrimmed  = "TSI2"
+	incMapLogMagic      = "TSIL"
+	incMapHeaderSize    = 4 + 8 + 8
+	incMapHeaderSize2   = 4 + 8 + 8 + 8
+	incMapLogHeaderSize = 4 + 8
+	incMapLogRecordSize = 1 + 8 + 8
+
+	incMapOpInsert = byte(1)
+	incMapOpSet    = byte(2)
+	incMapOpTrim   = byte(3)
+	incMapOpTime   = byte(4)
+)
+
+var errIncMapCorrupted = errors.New("inc map corrupted")
+
+// incMapCheckpointMin - minimalna liczba operacji w logu przed checkpointem
+// (checkpoint po max(incMapCheckpointMin, liczba wpisów) operacjach, więc koszt jest zamortyzowany).
+var incMapCheckpointMin int64 = 4096
+
+type incTable struct {
+	fullPath string
+	m        *incMap
+	log      *os.File
+	logSize  int64
+	logOps   int64
+	gen      uint64 // gen ostatniego checkpointu; aktywny log ma gen+1
+
+	free  []uint64 // sloty bez wpisu (obcięte przez retencję) do ponownego użycia
+	times *os.File // <table>.ts - czas zapisu każdego slotu (inc_retention.go)
+
+	logTimes []loggedTime // czasy slotów z logu - zapisywane w .ts przy otwarciu
+	order    incTimeOrder // czy czasy rosną z pozycją (inc_time.go)
+}
+
+type loggedTime struct {
+	slot uint64
+	ts   int64
+}
+
+func incMapPath(fullPath string) string    { return fullPath + ".map" }
+func incMapLogPath(fullPath string) string { return fullPath + ".maplog" }
+
+// openIncTable wczytuje mapę (checkpoint + log) albo migruje stary plik tabeli.
+func openIncTable(fullPath string, data *os.File, recordSize int64) (*incTable, error) {
+	recoverIncCompaction(fullPath)
+
+	t := &incTable{fullPath: fullPath}
+	raw, err := os.ReadFile(incMapPath(fullPath))
+	switch {
+	case err == nil:
+		if t.m, t.gen, err = decodeIncCheckpoint(raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", incMapPath(fullPath), err)
+		}
+	case os.IsNotExist(err):
+		vals, err := legacyIncMapValues(data, recordSize)
+		if err != nil {
+			return nil, err
+		}
+		t.m = loadIncMapValues(0, vals)
+		if err := writeIncCheckpoint(incMapPath(fullPath), 0, 0, vals); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if err := t.openLog(); err != nil {
+		return nil, err
+	}
+	if err := t.openRetention(data, recordSize); err != nil {
+		t.close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// legacyIncMapValues - stary układ: pozycja == slot, flaga usunięcia ze skip bitu rekordu.
+func legacyIncMapValues(data *os.File, recordSize int64) ([]uint64, error) {
+	fi, err := data.Stat()
+	if err != nil {
+		return nil, err
+	}
+	numRecords := fi.Size() / recordSize
+	vals := make([]uint64, 0, numRecords)
+
+	const chunkRecords = 4096
+	for idx := int64(0); idx < numRecords; idx += chunkRecords {
+		n := int64(chunkRecords)
+		if n > numRecords-idx {
+			n = numRecords - idx
+		}
+		buf := make([]byte, n*recordSize)
+		if _, err := data.ReadAt(buf, idx*recordSize); err != nil && err != io.EOF {
+			return nil, err
+		}
+		for i := int64(0); i < n; i++ {
+			v := uint64(idx + i)
+			if skip, _ := encoding_v1.PeekIncEntry(buf[i*recordSize:]); skip {
+				v |= incMapDeleted
+			}
+			vals = append(vals, v)
+		}
+	}
+	return vals, nil
+}
+
+func decodeIncCheckpoint(raw []byte) (*incMap, uint64, error) {
+	if len(raw) < incMapHeaderSize {
+		return nil, 0, errIncMapCorrupted
+	}
+	header, trimmed := incMapHeaderSize, uint64(0)
+	switch string(raw[:4]) {
+	case incMapMagic:
+	case incMapMagicTrimmed:
+		if len(raw) < incMapHeaderSize2 {
+			return nil, 0, errIncMapCorrupted
+		}
+		header = incMapHeaderSize2
+		trimmed = binary.LittleEndian.Uint64(raw[12:20])
+	default:
+		return nil, 0, errIncMapCorrupted
+	}
+	gen := binary.LittleEndian.Uint64(raw[4:12])
+	count := binary.LittleEndian.Uint64(raw[header-8 : header])
+	if uint64(len(raw)-header) != count*8 {
+		return nil, 0, errIncMapCorrupted
+	}
+	vals := make([]uint64, count)
+	for i := range vals {
+		vals[i] = binary.LittleEndian.Uint64(raw[header+i*8:])
+	}
+	return loadIncMapValues(int64(trimmed), vals), gen, nil
+}
+
+// writeIncCheckpoint zapisuje checkpoint atomowo (plik tymczasowy + fsync + rename).
+func writeIncCheckpoint(path string, gen uint64, trimmed int64, vals []uint64) error {
+	tmp := path + ".tmp"
+	if err := writeIncCheckpointFile(tmp, gen, trimmed, vals); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeIncCheckpointFile - format "TSIM" dopóki nic nie zostało obcięte (zgodność ze starszymi plikami).
+func writeIncCheckpointFile(path string, gen uint64, trimmed int64, vals []uint64) error {
+	header := incMapHeaderSize
+	if trimmed > 0 {
+		header = incMapHeaderSize2
+	}
+	buf := make([]byte, header+len(vals)*8)
+	binary.LittleEndian.PutUint64(buf[4:12], gen)
+	if trimmed > 0 {
+		copy(buf, incMapMagicTrimmed)
+		binary.LittleEndian.PutUint64(buf[12:20], uint64(trimmed))
+	} else {
+		copy(buf, incMapMagic)
+	}
+	binary.LittleEndian.PutUint64(buf[header-8:header], uint64(len(vals)))
+	for i, v := range vals {
+		binary.LittleEndian.PutUint64(buf[header+i*8:], v)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// openLog otwiera log i odtwarza jego operacje, jeżeli nie są już w checkpoincie.
+func (t *incTable) openLog() error {
+	path := incMapLogPath(t.fullPath)
+	raw, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(raw) < incMapLogHeaderSize || string(raw[:4]) != incMapLogMagic ||
+		binary.LittleEndian.Uint64(raw[4:12]) <= t.gen {
+		return t.resetLog()
+	}
+
+	valid := int64(incMapLogHeaderSize)
+	for off := incMapLogHeaderSize; off+incMapLogRecordSize <= len(raw); off += incMapLogRecordSize {
+		op := raw[off]
+		pos := int64(binary.LittleEndian.Uint64(raw[off+1:]))
+		v := binary.LittleEndian.Uint64(raw[off+9:])
+		if op == incMapOpTime {
+			t.logTimes = append(t.logTimes, loggedTime{slot: uint64(pos), ts: int64(v)})
+		} else if !t.m.apply(op, pos, v) {
+			break
+		}
+		valid += incMapLogRecordSize
+		t.logOps++
+	}
+
+	t.log, err = os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if valid != int64(len(raw)) {
+		if err := t.log.Truncate(valid); err != nil {
+			return err
+		}
+	}
+	t.logSize = valid
+	return nil
+}
+
+// resetLog zakłada pusty log o gen = gen checkpointu + 1.
+func (t *incTable) resetLog() error {
+	if t.log != nil {
+		t.log.Close()
+		t.log = nil
+	}
+	path := incMapLogPath(t.fullPath)
+	header := make([]byte, incMapLogHeaderSize)
+	copy(header, incMapLogMagic)
+	binary.LittleEndian.PutUint64(header[4:], t.gen+1)
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(header); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	t.log, err = os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	t.logSize = incMapLogHeaderSize
+	t.logOps = 0
+	return nil
+}
+
+// apply wykonuje operację logu na mapie; false = operacja niepoprawna (uszkodzony log).
+func (m *incMap) apply(op byte, pos int64, v uint64) bool {
+	switch op {
+	case incMapOpInsert:
+		if pos < m.trimmed || pos > m.n {
+			return false
+		}
+		m.insert(pos, v)
+	case incMapOpSet:
+		if pos < m.trimmed || pos >= m.n {
+			return false
+		}
+		m.set(pos, v)
+	case incMapOpTrim:
+		if pos < 0 || pos > m.n {
+			return false
+		}
+		m.trimTo(pos)
+	default:
+		return false
+	}
+	return true
+}
+
+// record zapisuje operację w logu i dopiero potem zmienia mapę.
+func (t *incTable) record(op byte, pos int64, v uint64) error {
+	rec := make([]byte, incMapLogRecordSize)
+	rec[0] = op
+	binary.LittleEndian.PutUint64(rec[1:], uint64(pos))
+	binary.LittleEndian.PutUint64(rec[9:], v)
+	if _, err := t.log.WriteAt(rec, t.logSize); err != nil {
+		return err
+	}
+	t.logSize += incMapLogRecordSize
+	t.logOps++
+	if op != incMapOpTime {
+		t.m.apply(op, pos, v)
+	}
+
+	if t.logOps >= incMapCheckpointMin && t.logOps >= t.m.len()-t.m.trimmed {
+		return t.checkpoint()
+	}
+	return nil
+}
+
+func (t *incTable) insert(pos int64, slot uint64) error {
+	return t.record(incMapOpInsert, pos, slot)
+}
+
+func (t *incTable) set(pos int64, v uint64) error {
+	return t.record(incMapOpSet, pos, v)
+}
+
+// checkpoint zapisuje całą mapę i zaczyna nowy log (czasy z logu są już w .ts po fsync).
+func (t *incTable) checkpoint() error {
+	if t.times != nil {
+		if err := t.times.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := writeIncCheckpoint(incMapPath(t.fullPath), t.gen+1, t.m.trimmed, t.m.values()); err != nil {
+		return err
+	}
+	t.gen++
+	return t.resetLog()
+}
+
+func (t *incTable) close() {
+	if t.log != nil {
+		t.log.Close()
+		t.log = nil
+	}
+	if t.times != nil {
+		t.times.Close()
+		t.times = nil
+	}
+}
+
+// removeIncMapFiles usuwa pliki mapy (usunięcie całej tabeli).
+func removeIncMapFiles(fullPath string) error {
+	for _, p := range []string{incMapPath(fullPath), incMapLogPath(fullPath), incTimesPath(fullPath)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Kompakcja podmienia plik danych, mapę i czasy slotów; kolejność: <tbl>.compact, <tbl>.map.compact
+// i <tbl>.ts.compact (z fsync), rename danych, rename mapy, rename czasów. Przy otwarciu:
+//   - <tbl>.compact istnieje   -> kompakcja nie doszła do skutku, usuń pliki tymczasowe
+//   - nie ma już <tbl>.compact -> dane zostały podmienione, dokończ podmianę mapy i czasów
+func recoverIncCompaction(fullPath string) {
+	mapTmp := incMapPath(fullPath) + ".compact"
+	timesTmp := incTimesPath(fullPath) + ".compact"
+	if _, err := os.Stat(fullPath + ".compact"); err == nil {
+		os.Remove(fullPath + ".compact")
+		os.Remove(mapTmp)
+		os.Remove(timesTmp)
+		return
+	}
+	if _, err := os.Stat(mapTmp); err == nil {
+		os.Rename(mapTmp, incMapPath(fullPath))
+	}
+	if _, err := os.Stat(timesTmp); err == nil {
+		os.Rename(timesTmp, incTimesPath(fullPath))
+	}
+}
+
+// incState - stan inc table należący do workera pliku (nil dla zwykłych plików danych).
+// Mapa otwierana jest leniwie przy pierwszej operacji, bo migracja potrzebuje rozmiaru rekordu.
+type incState struct {
+	fullPath string
+	t        *incTable
+}
+
+func (s *incState) table(file *os.File, recordSize int64) (*incTable, error) {
+	if s == nil {
+		return nil, errors.New("inc op on non-inc file")
+	}
+	if s.t == nil {
+		t, err := openIncTable(s.fullPath, file, recordSize)
+		if err != nil {
+			return nil, err
+		}
+		s.t = t
+	}
+	return s.t, nil
+}
+
+func (s *incState) close() {
+	if s != nil && s.t != nil {
+		s.t.close()
+		s.t = nil
+	}
+}
@@ -0,0 +1,130 @@
+package dataManager_v2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	defragmentationManager "github.com/PAW122/TsunamiDB/data/defragmentationManager"
+	encoding_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+	types "github.com/PAW122/TsunamiDB/types"
+)
+
+/*
+Wpisy inc table większe niż max_entry_size (overflow):
+
+  - payload zapisywany jest w osobnym pliku danych <table>.ovf (katalog danych KV, zwykły
+    worker "write" z listą wolnych bloków defragmentationManager), a rekord w tabeli trzyma
+    tylko referencję [start, end) z ustawionym overflowBit (encoding_v1.EncodeIncOverflowEntry)
+  - odczyty rozwiązują referencję (ResolveIncPayload); filtr dostaje pełny payload
+  - miejsce w pliku overflow zwalniane jest dopiero po nadpisaniu rekordu, który na nie
+    wskazywał (skip, overwrite, retencja, usunięcie tabeli) - awaria pomiędzy zostawia
+    nieużywany blok, ale nigdy nie zwalnia bloku dwa razy
+*/
+
+var ErrIncOverflowUnsupported = fmt.Errorf("entry size too small for overflow entries (min %d bytes)", encoding_v1.IncOverflowRefSize)
+
+// incOverflowFile - nazwa pliku overflow (w katalogu danych KV) dla inc table.
+func incOverflowFile(filePath string) string {
+	return filepath.Base(filePath) + ".ovf"
+}
+
+// EncodeIncPayload koduje payload do rekordu inc table. Payload większy niż entrySize
+// trafia do pliku overflow tabeli; overflow=true gdy rekord jest referencją.
+func EncodeIncPayload(filePath string, entrySize uint64, body []byte) (rec []byte, overflow bool, err error) {
+	if uint64(len(body)) <= entrySize {
+		return encoding_v1.EncodeIncEntry(entrySize, body), false, nil
+	}
+	if entrySize < encoding_v1.IncOverflowRefSize {
+		return nil, false, ErrIncOverflowUnsupported
+	}
+	start, end, err := SaveDataToFileAsync(body, incOverflowFile(filePath))
+	if err != nil {
+		return nil, false, err
+	}
+	return encoding_v1.EncodeIncOverflowEntry(entrySize, start, end), true, nil
+}
+
+// ResolveIncPayload zwraca payload zdekodowanego wpisu (dla overflow czyta go z pliku overflow).
+func ResolveIncPayload(filePath string, body types.IncTableBody) ([]byte, error) {
+	start, end, ok := encoding_v1.IncOverflowRef(body)
+	if !ok {
+		return body.Data, nil
+	}
+	if end < start {
+		return nil, errors.New("corrupted inc overflow reference")
+	}
+	return ReadDataFromFileAsync(incOverflowFile(filePath), start, end)
+}
+
+// failIncWrite odpowiada błędem zapisu, który nie zostawił rekordu w tabeli - payload
+// overflow rekordu nie ma właściciela i wraca na listę wolnych bloków.
+func failIncWrite(filePath string, req *fileRequest, err error) {
+	freeIncOverflow(filePath, req.data)
+	req.resp <- fileResponse{err: err}
+}
+
+func isIncOverflowRecord(rec []byte) bool {
+	return len(rec) > 3 && rec[0]&0b0000_0101 == 0b0000_0100
+}
+
+// freeIncOverflow oddaje bloki overflow podanych rekordów (rekordy skip i zwykłe są pomijane).
+func freeIncOverflow(filePath string, recs ...[]byte) {
+	for _, rec := range recs {
+		if !isIncOverflowRecord(rec) {
+			continue
+		}
+		dec, err := encoding_v1.DecodeIncEntry(uint64(len(rec)-3), rec)
+		if err != nil {
+			continue
+		}
+		if start, end, ok := encoding_v1.IncOverflowRef(dec); ok && end > start {
+			ovf := incOverflowFile(filePath)
+			defragmentationManager.MarkAsFree(fmt.Sprintf("%s@%d", ovf, start), ovf, start, end)
+		}
+	}
+}
+
+// incOverflowRecords czyta rekordy podanych slotów i zwraca tylko te z referencją overflow
+// (wołane przed nadpisaniem slotów, zwolnienie dopiero po zapisie).
+func incOverflowRecords(file *os.File, recordSize int64, slots []uint64) ([][]byte, error) {
+	var out [][]byte
+	for i := 0; i < len(slots); i += 4096 {
+		j := min(i+4096, len(slots))
+		buf, err := readIncSlots(file, recordSize, slots[i:j])
+		if err != nil {
+			return nil, err
+		}
+		for off := int64(0); off < int64(len(buf)); off += recordSize {
+			if rec := buf[off : off+recordSize]; isIncOverflowRecord(rec) {
+				out = append(out, rec)
+			}
+		}
+	}
+	return out, nil
+}
+
+// allIncOverflowRecords - rekordy z referencją overflow w całym pliku tabeli (usunięcie tabeli).
+func allIncOverflowRecords(file *os.File, recordSize int64) ([][]byte, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var out [][]byte
+	chunk := 4096 * recordSize
+	for off := int64(0); off+recordSize <= fi.Size(); off += chunk {
+		n := min(chunk, fi.Size()/recordSize*recordSize-off)
+		buf := make([]byte, n)
+		if _, err := file.ReadAt(buf, off); err != nil && err != io.EOF {
+			return nil, err
+		}
+		for i := int64(0); i < n; i += recordSize {
+			if rec := buf[i : i+recordSize]; isIncOverflowRecord(rec) {
+				out = append(out, rec)
+			}
+		}
+	}
+	return out, nil
+}
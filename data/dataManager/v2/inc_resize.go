@@ -0,0 +1,117 @@
+package dataManager_v2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	encoding_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+)
+
+var ErrIncEntryTooLarge = errors.New("inc entry does not fit the new entry size")
+
+/*
+Zmiana max_entry_size inc table (resize_inc):
+
+  - rekordy przepisywane są 1:1 do pliku tymczasowego w nowym rozmiarze, więc id
+    i pozycje w incIndex się nie zmieniają; rekordy skip zachowują nextEntryPointer
+  - przy zmniejszeniu wpisy, które się nie mieszczą, przenoszone są do pliku overflow
+    (inc_overflow.go); bez overflow (nowy rozmiar < IncOverflowRefSize) zmniejszenie jest
+    możliwe tylko wtedy, gdy wszystkie żywe wpisy się mieszczą (sprawdzane przed zapisem czegokolwiek)
+  - operacja wykonywana jest w workerze poza batchem, więc zapisy do tej tabeli czekają w kolejce
+*/
+
+func handleResizeInc(file **os.File, fullPath string, oldSize, newSize uint64) fileResponse {
+	oldRecord := int64(oldSize) + 3
+	newRecord := int64(newSize) + 3
+	if oldSize == 0 || newSize == 0 {
+		return fileResponse{err: errors.New("resize_inc: invalid entry size")}
+	}
+	fi, err := (*file).Stat()
+	if err != nil {
+		return fileResponse{err: err}
+	}
+	numRecords := fi.Size() / oldRecord
+	if oldSize == newSize {
+		return fileResponse{count: numRecords}
+	}
+
+	const chunkRecords = 4096
+	forEachChunk := func(fn func(idx int64, rec []byte) error) error {
+		for idx := int64(0); idx < numRecords; idx += chunkRecords {
+			n := int64(chunkRecords)
+			if n > numRecords-idx {
+				n = numRecords - idx
+			}
+			buf := make([]byte, n*oldRecord)
+			if _, err := (*file).ReadAt(buf, idx*oldRecord); err != nil && err != io.EOF {
+				return err
+			}
+			for i := int64(0); i < n; i++ {
+				if err := fn(idx+i, buf[i*oldRecord:(i+1)*oldRecord]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if newSize < oldSize {
+		err := forEachChunk(func(id int64, rec []byte) error {
+			dec, err := encoding_v1.DecodeIncEntry(oldSize, rec)
+			if err != nil {
+				return err
+			}
+			if !dec.SkipBit && uint64(len(dec.Data)) > newSize && newSize < encoding_v1.IncOverflowRefSize {
+				return fmt.Errorf("%w: id %d has %d bytes", ErrIncEntryTooLarge, id, len(dec.Data))
+			}
+			return nil
+		})
+		if err != nil {
+			return fileResponse{err: err}
+		}
+	}
+
+	tmpPath := fullPath + ".resize"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fileResponse{err: err}
+	}
+	var spilled [][]byte // rekordy przeniesione do overflow - przy błędzie ich bloki wracają na listę wolnych
+	err = forEachChunk(func(id int64, rec []byte) error {
+		var out []byte
+		if skip, next := encoding_v1.PeekIncEntry(rec); skip {
+			out = make([]byte, newRecord)
+			encoding_v1.SetSkipIncEntry(out, next)
+		} else {
+			dec, err := encoding_v1.DecodeIncEntry(oldSize, rec)
+			if err != nil {
+				return err
+			}
+			switch start, end, ok := encoding_v1.IncOverflowRef(dec); {
+			case ok:
+				out = encoding_v1.EncodeIncOverflowEntry(newSize, start, end)
+			case uint64(len(dec.Data)) > newSize:
+				if out, _, err = EncodeIncPayload(fullPath, newSize, dec.Data); err != nil {
+					return err
+				}
+				spilled = append(spilled, out)
+			default:
+				out = encoding_v1.EncodeIncEntry(newSize, dec.Data)
+			}
+		}
+		_, err := tmp.WriteAt(out, id*newRecord)
+		return err
+	})
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		freeIncOverflow(fullPath, spilled...)
+		return fileResponse{err: err}
+	}
+	if err := replaceIncFile(file, fullPath, tmp); err != nil {
+		return fileResponse{err: err}
+	}
+	return fileResponse{count: numRecords}
+}
@@ -0,0 +1,260 @@
+package dataManager_v2
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	encoding_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+	types "github.com/PAW122/TsunamiDB/types"
+)
+
+/*
+Retencja inc table (max_entries / max_bytes / max_age z deskryptora tabeli):
+
+  - obcinane są najstarsze pozycje (żywe i usunięte) - pozycje [0, trimmed) znikają z mapy,
+    ale id kolejnych wpisów się nie zmieniają (incMap.trimmed, op trim w logu mapy)
+  - sloty obciętych wpisów dostają skip bit i trafiają na listę wolnych slotów; kolejne zapisy
+    używają ich zamiast dopisywać na końcu pliku, więc plik przestaje rosnąć
+  - lista wolnych slotów nie jest zapisywana - przy otwarciu to sloty pliku, których nie ma w mapie
+    (również slot zapisany tuż przed awarią, zanim trafił do mapy)
+  - <table>.ts trzyma znacznik czasu wpisu w każdym slocie (u64 unix nano, indeks = slot; patrz
+    inc_time.go); każdy zapis czasu idzie też do logu mapy (op time), więc .ts jest odtwarzany
+    razem z mapą. Sloty bez czasu (tabele sprzed retencji) mają 0 - czas nieznany
+  - max_age: wpis bez czasu jest obcinany razem z pierwszym późniejszym wpisem, który wygasł
+    (jest od niego starszy), ale sam nie wygasa
+*/
+
+const incTimeSize = 8
+
+// incNow - źródło czasu dla znaczników slotów (podmieniane w testach).
+var incNow = time.Now
+
+func incTimesPath(fullPath string) string { return fullPath + ".ts" }
+
+// openRetention wylicza wolne sloty, otwiera plik czasów slotów i zapisuje w nim czasy z logu mapy.
+func (t *incTable) openRetention(data *os.File, recordSize int64) error {
+	fi, err := data.Stat()
+	if err != nil {
+		return err
+	}
+	numSlots := fi.Size() / recordSize
+
+	used := make([]uint64, (numSlots+63)/64)
+	for _, v := range t.m.values() {
+		if slot := int64(v & incMapSlotMask); slot < numSlots {
+			used[slot/64] |= 1 << (slot % 64)
+		}
+	}
+	t.free = t.free[:0]
+	for slot := numSlots - 1; slot >= 0; slot-- {
+		if used[slot/64]&(1<<(slot%64)) == 0 {
+			t.free = append(t.free, uint64(slot))
+		}
+	}
+
+	t.times, err = os.OpenFile(incTimesPath(t.fullPath), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, incTimeSize)
+	for _, lt := range t.logTimes {
+		binary.LittleEndian.PutUint64(buf, uint64(lt.ts))
+		if _, err := t.times.WriteAt(buf, int64(lt.slot)*incTimeSize); err != nil {
+			return err
+		}
+	}
+	t.logTimes = nil
+	return nil
+}
+
+// allocSlot zapisuje rekord w wolnym slocie (albo nowym na końcu pliku) i ustawia jego czas (ts 0 = teraz).
+func (t *incTable) allocSlot(file *os.File, recordSize int64, data []byte, ts int64) (int64, error) {
+	var slot int64
+	if n := len(t.free); n > 0 {
+		slot = int64(t.free[n-1])
+		t.free = t.free[:n-1]
+		// wolny slot bez skip bitu (awaria przed oznaczeniem) może jeszcze trzymać overflow
+		old, err := readRecord(file, slot, recordSize)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := file.WriteAt(data, slot*recordSize); err != nil {
+			return 0, err
+		}
+		freeIncOverflow(t.fullPath, old)
+	} else {
+		var err error
+		if slot, err = appendIncSlot(file, recordSize, data); err != nil {
+			return 0, err
+		}
+	}
+	if err := t.touch(slot, ts); err != nil {
+		t.releaseSlot(file, recordSize, slot)
+		return 0, err
+	}
+	return slot, nil
+}
+
+// releaseSlot oddaje slot zapisany przez allocSlot, który nie trafił do mapy (skip bit, lista wolnych).
+func (t *incTable) releaseSlot(file *os.File, recordSize int64, slot int64) {
+	rec := make([]byte, recordSize)
+	encoding_v1.SetSkipIncEntry(rec, 0)
+	if _, err := file.WriteAt(rec, slot*recordSize); err == nil {
+		t.free = append(t.free, uint64(slot))
+	}
+}
+
+// incTimestamp - czas wpisu: podany przez klienta albo teraz (ts 0).
+func incTimestamp(ts int64) int64 {
+	if ts == 0 {
+		return incNow().UnixNano()
+	}
+	return ts
+}
+
+// touch ustawia znacznik czasu slotu (ts 0 = teraz) w .ts i w logu mapy.
+func (t *incTable) touch(slot int64, ts int64) error {
+	return t.setSlotTime(slot, incTimestamp(ts))
+}
+
+// setSlotTime zapisuje czas slotu bez zamiany 0 na teraz. Gdy log mapy odrzuci zapis,
+// .ts wraca do poprzedniej wartości - błąd nie zostawia zmienionego czasu.
+func (t *incTable) setSlotTime(slot int64, ts int64) error {
+	prev, err := t.slotTime(uint64(slot))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, incTimeSize)
+	binary.LittleEndian.PutUint64(buf, uint64(ts))
+	if _, err := t.times.WriteAt(buf, slot*incTimeSize); err != nil {
+		return err
+	}
+	if err := t.record(incMapOpTime, slot, uint64(ts)); err != nil {
+		binary.LittleEndian.PutUint64(buf, uint64(prev))
+		_, _ = t.times.WriteAt(buf, slot*incTimeSize)
+		return err
+	}
+	return nil
+}
+
+// slotTime - czas zapisu slotu (unix nano, 0 = brak).
+func (t *incTable) slotTime(slot uint64) (int64, error) {
+	if slot == incMapNoSlot {
+		return 0, nil
+	}
+	buf := make([]byte, incTimeSize)
+	if _, err := t.times.ReadAt(buf, int64(slot)*incTimeSize); err != nil {
+		if err == io.EOF {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// retentionTarget wylicza nową wartość trimmed dla podanych limitów.
+func (t *incTable) retentionTarget(recordSize int64, r types.IncRetention) (int64, error) {
+	pos := t.m.trimmed
+
+	limit, limited := r.MaxEntries, r.MaxEntries > 0
+	if r.MaxBytes > 0 {
+		byBytes := r.MaxBytes / uint64(recordSize)
+		if byBytes == 0 {
+			byBytes = 1
+		}
+		if !limited || byBytes < limit {
+			limit, limited = byBytes, true
+		}
+	}
+	if live := uint64(t.m.liveCount()); limited && live > limit {
+		_, _, pos = t.m.liveForward(pos, int64(live-limit))
+	}
+
+	if r.MaxAgeSeconds > 0 {
+		cutoff := incNow().Add(-time.Duration(r.MaxAgeSeconds) * time.Second).UnixNano()
+		for p := pos; p < t.m.len(); p++ {
+			slot, _ := t.m.get(p)
+			ts, err := t.slotTime(slot)
+			if err != nil {
+				return 0, err
+			}
+			if ts == 0 {
+				continue // czas nieznany - obcięty dopiero razem z późniejszym wygasłym wpisem
+			}
+			if ts >= cutoff {
+				break
+			}
+			pos = p + 1
+		}
+	}
+	return pos, nil
+}
+
+// trimIncTable obcina najstarsze wpisy ponad limity retencji.
+// resp.count = nowa wartość trimmed (pierwsze nieobcięte id), resp.ids = [poprzednia wartość].
+func trimIncTable(file *os.File, inc *incState, req *fileRequest) fileResponse {
+	recordSize := int64(req.entrySize) + 3
+	if recordSize <= 3 {
+		return fileResponse{err: errors.New("trim_inc: invalid entry size")}
+	}
+	t, err := inc.table(file, recordSize)
+	if err != nil {
+		return fileResponse{err: err}
+	}
+	before := t.m.trimmed
+	pos, err := t.retentionTarget(recordSize, req.retention)
+	if err != nil {
+		return fileResponse{err: err}
+	}
+	if pos <= before {
+		return fileResponse{count: before, ids: []uint64{uint64(before)}}
+	}
+
+	freed := t.m.slotsBetween(before, pos)
+	overflow, err := incOverflowRecords(file, recordSize, freed)
+	if err != nil {
+		return fileResponse{err: err}
+	}
+	if err := t.record(incMapOpTrim, pos, 0); err != nil {
+		return fileResponse{err: err}
+	}
+	rec := make([]byte, recordSize)
+	encoding_v1.SetSkipIncEntry(rec, 0)
+	for _, slot := range freed {
+		if _, err := file.WriteAt(rec, int64(slot)*recordSize); err != nil {
+			return fileResponse{err: err}
+		}
+		t.free = append(t.free, slot)
+	}
+	freeIncOverflow(t.fullPath, overflow...)
+	return fileResponse{count: pos, ids: []uint64{uint64(before)}}
+}
+
+// scrubIncFree ustawia skip bit w wolnych slotach (awaria między zapisem trim w logu a
+// oznaczeniem rekordów), żeby resize nie traktował ich jako żywych wpisów.
+func scrubIncFree(file *os.File, inc *incState, entrySize uint64) error {
+	recordSize := int64(entrySize) + 3
+	if recordSize <= 3 {
+		return nil
+	}
+	t, err := inc.table(file, recordSize)
+	if err != nil {
+		return err
+	}
+	overflow, err := incOverflowRecords(file, recordSize, t.free)
+	if err != nil {
+		return err
+	}
+	rec := make([]byte, recordSize)
+	encoding_v1.SetSkipIncEntry(rec, 0)
+	for _, slot := range t.free {
+		if _, err := file.WriteAt(rec, int64(slot)*recordSize); err != nil {
+			return err
+		}
+	}
+	freeIncOverflow(t.fullPath, overflow...)
+	return nil
+}
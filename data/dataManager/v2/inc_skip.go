@@ -0,0 +1,264 @@
+package dataManager_v2
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	encoding_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+)
+
+/*
+Usuwanie pojedynczych wpisów inc table:
+
+  - usunięcie ustawia flagę w incMap (odczyty przeskakują całe chunki bez żywych wpisów)
+    oraz skipBit w rekordzie, żeby resize/migracja widziały wpis jako usunięty
+  - overwrite usuniętej pozycji zapisuje dane w jej slocie i zdejmuje flagę
+  - compact_inc fizycznie usuwa rekordy skip i zwalnia wolne sloty; id się nie zmieniają -
+    usunięte pozycje zostają w mapie jako incMapDeleted|incMapNoSlot (overwrite takiej
+    pozycji zapisuje dane w nowym slocie)
+*/
+
+var ErrIncEntryNotFound = errors.New("inc entry not found")
+
+func readRecord(file *os.File, slot, recordSize int64) ([]byte, error) {
+	buf := make([]byte, recordSize)
+	if _, err := file.ReadAt(buf, slot*recordSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readIncSlots czyta rekordy podanych slotów; ciągłe sloty czytane są jednym ReadAt.
+func readIncSlots(file *os.File, recordSize int64, slots []uint64) ([]byte, error) {
+	out := make([]byte, len(slots)*int(recordSize))
+	for i := 0; i < len(slots); {
+		j := i + 1
+		for j < len(slots) && slots[j] == slots[j-1]+1 {
+			j++
+		}
+		if _, err := file.ReadAt(out[int64(i)*recordSize:int64(j)*recordSize], int64(slots[i])*recordSize); err != nil && err != io.EOF {
+			return nil, err
+		}
+		i = j
+	}
+	return out, nil
+}
+
+// appendIncSlot dopisuje rekord w nowym slocie na końcu pliku (dopełniając niewyrównany ogon).
+func appendIncSlot(file *os.File, recordSize int64, data []byte) (int64, error) {
+	fileSize, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if rem := fileSize % recordSize; rem != 0 {
+		pad := make([]byte, recordSize-rem)
+		if _, err := file.WriteAt(pad, fileSize); err != nil {
+			return 0, err
+		}
+		fileSize += int64(len(pad))
+	}
+	slot := fileSize / recordSize
+	if _, err := file.WriteAt(data, slot*recordSize); err != nil {
+		return 0, err
+	}
+	return slot, nil
+}
+
+func incIDResponse(id, slot, recordSize int64) fileResponse {
+	idBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(idBuf, uint64(id))
+	return fileResponse{
+		data:     idBuf,
+		startPtr: slot * recordSize,
+		endPtr:   (slot + 1) * recordSize,
+	}
+}
+
+// skipIncEntry oznacza wpis jako usunięty; resp.data = rekord sprzed usunięcia, resp.ids = [id].
+func skipIncEntry(file *os.File, inc *incState, req *fileRequest) fileResponse {
+	recordSize := int64(req.entrySize) + 3
+	if recordSize <= 3 {
+		return fileResponse{err: errors.New("skip_inc: invalid entry size")}
+	}
+	t, err := inc.table(file, recordSize)
+	if err != nil {
+		return fileResponse{err: err}
+	}
+	numRecords := t.m.len()
+
+	id := int64(req.inc_id)
+	if strings.ToLower(req.count_from) == "top" {
+		id = numRecords - 1 - id
+	}
+	if id < 0 || id >= numRecords {
+		return fileResponse{err: ErrIncEntryNotFound}
+	}
+	slot, deleted := t.m.get(id)
+	if deleted {
+		return fileResponse{err: ErrIncEntryNotFound}
+	}
+
+	old, err := readRecord(file, int64(slot), recordSize)
+	if err != nil {
+		return fileResponse{err: err}
+	}
+	rec := make([]byte, recordSize)
+	encoding_v1.SetSkipIncEntry(rec, 0)
+	if _, err := file.WriteAt(rec, int64(slot)*recordSize); err != nil {
+		return fileResponse{err: err}
+	}
+	if err := t.set(id, slot|incMapDeleted); err != nil {
+		return fileResponse{err: err}
+	}
+	freeIncOverflow(t.fullPath, old)
+
+	return fileResponse{
+		data:  old,
+		ids:   []uint64{uint64(id)},
+		count: numRecords,
+	}
+}
+
+// handleCompactInc przepisuje plik bez usuniętych wpisów, w kolejności pozycji; mapa zachowuje
+// wszystkie pozycje, zmieniają się tylko sloty. Wywoływane poza batchem (jak delete_inc), bo podmienia uchwyt pliku.
+// resp.ids = id usuniętych wpisów, których rekordy zostały usunięte z pliku (rosnąco).
+func handleCompactInc(file **os.File, inc *incState, fullPath string, entrySize uint64) fileResponse {
+	recordSize := int64(entrySize) + 3
+	if recordSize <= 3 {
+		return fileResponse{err: errors.New("compact_inc: invalid entry size")}
+	}
+	t, err := inc.table(*file, recordSize)
+	if err != nil {
+		return fileResponse{err: err}
+	}
+
+	// nowa mapa: te same pozycje, żywe wpisy w kolejnych slotach, usunięte bez slotu
+	vals := t.m.values()
+	removed := make([]uint64, 0)
+	slots := make([]uint64, 0, len(vals))
+	remapped := make([]uint64, len(vals))
+	for i, v := range vals {
+		if v&incMapDeleted != 0 {
+			if v&incMapSlotMask != incMapNoSlot {
+				removed = append(removed, uint64(t.m.trimmed+int64(i)))
+			}
+			remapped[i] = incMapDeleted | incMapNoSlot
+			continue
+		}
+		remapped[i] = uint64(len(slots))
+		slots = append(slots, v)
+	}
+	if len(removed) == 0 && len(t.free) == 0 {
+		return fileResponse{ids: removed, count: int64(len(vals))}
+	}
+
+	tmpPath := fullPath + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fileResponse{err: err}
+	}
+	const chunkRecords = 4096
+	for i := 0; i < len(slots); i += chunkRecords {
+		j := i + chunkRecords
+		if j > len(slots) {
+			j = len(slots)
+		}
+		buf, err := readIncSlots(*file, recordSize, slots[i:j])
+		if err == nil {
+			_, err = tmp.WriteAt(buf, int64(i)*recordSize)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fileResponse{err: err}
+		}
+	}
+
+	// czasy slotów w nowej kolejności
+	times := make([]byte, len(slots)*incTimeSize)
+	for i, slot := range slots {
+		ts, err := t.slotTime(slot)
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fileResponse{err: err}
+		}
+		binary.LittleEndian.PutUint64(times[i*incTimeSize:], uint64(ts))
+	}
+	mapTmp := incMapPath(fullPath) + ".compact"
+	timesTmp := incTimesPath(fullPath) + ".compact"
+	err = writeIncCheckpointFile(mapTmp, t.gen+1, t.m.trimmed, remapped)
+	if err == nil {
+		err = writeSyncedFile(timesTmp, times)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		os.Remove(mapTmp)
+		os.Remove(timesTmp)
+		return fileResponse{err: err}
+	}
+	if err := replaceIncFile(file, fullPath, tmp); err != nil {
+		os.Remove(mapTmp)
+		os.Remove(timesTmp)
+		return fileResponse{err: err}
+	}
+	// dane już podmienione - przy błędzie mapa i czasy zostaną dokończone przy ponownym otwarciu (recoverIncCompaction)
+	inc.close()
+	if err := os.Rename(mapTmp, incMapPath(fullPath)); err != nil {
+		return fileResponse{err: err}
+	}
+	if err := os.Rename(timesTmp, incTimesPath(fullPath)); err != nil {
+		return fileResponse{err: err}
+	}
+	if _, err := inc.table(*file, recordSize); err != nil {
+		return fileResponse{err: err}
+	}
+	return fileResponse{ids: removed, count: int64(len(slots))}
+}
+
+func writeSyncedFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replaceIncFile podmienia plik inc table na gotowy plik tymczasowy i otwiera go ponownie.
+// Uchwyt *file po powrocie zawsze wskazuje na fullPath (również przy błędzie rename).
+func replaceIncFile(file **os.File, fullPath string, tmp *os.File) error {
+	tmpPath := tmp.Name()
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	tmp.Close()
+
+	if err := (*file).Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(tmpPath, fullPath)
+	reopen, err := os.OpenFile(fullPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	*file = reopen
+	if renameErr != nil {
+		os.Remove(tmpPath)
+		return renameErr
+	}
+	return nil
+}
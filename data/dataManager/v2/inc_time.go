@@ -0,0 +1,227 @@
+package dataManager_v2
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+)
+
+/*
+Znaczniki czasu wpisów inc table:
+
+  - każdy slot ma znacznik w <table>.ts (u64 unix nano, indeks = slot), ustawiany przy zapisie
+    wpisu: czas serwera albo czas podany przez klienta; nadpisanie zachowuje znacznik, chyba że
+    klient poda nowy
+  - odczyty zwracają znacznik razem z rekordem (IncRange.Times); 0 = czas nieznany (wpisy
+    sprzed znaczników, usunięte pozycje bez slotu) - takie wpisy nie trafiają do time range
+  - time range szuka binarnie pierwszej pozycji z czasem >= from (bottom) albo >= to (top),
+    o ile czasy rosną razem z id (incTimeOrder, pozycje z czasem 0 są pomijane); w przeciwnym
+    razie czyta tabelę liniowo od kursora i filtruje każdy wpis
+*/
+
+// timeBatch - ilość wpisów czytanych naraz przy odczycie zakresu czasu.
+const timeBatch = 256
+
+// incTimeOrder - czy czasy pozycji [trimmed, len) rosną razem z pozycją. Wyliczane leniwie przy
+// pierwszym time range (skan .ts), potem aktualizowane przy append; inne zmiany czasów je unieważniają.
+type incTimeOrder struct {
+	known   bool
+	ordered bool
+	last    int64 // największy czas w tabeli (gdy known)
+}
+
+func (o *incTimeOrder) forget() { *o = incTimeOrder{} }
+
+// appended - nowy wpis na końcu tabeli z czasem ts.
+func (o *incTimeOrder) appended(ts int64) {
+	if !o.known {
+		return
+	}
+	if ts < o.last {
+		o.ordered = false
+	}
+	o.last = max(o.last, ts)
+}
+
+// timesOrdered - czy można szukać binarnie po czasie (pierwsze wywołanie skanuje czasy całej tabeli).
+func (t *incTable) timesOrdered() (bool, error) {
+	if t.order.known {
+		return t.order.ordered, nil
+	}
+	vals := t.m.values()
+	ordered, last := true, int64(0)
+	for i := 0; i < len(vals); i += timeBatch * 16 {
+		slots := make([]uint64, 0, timeBatch*16)
+		for _, v := range vals[i:min(i+timeBatch*16, len(vals))] {
+			if slot := v & incMapSlotMask; slot != incMapNoSlot {
+				slots = append(slots, slot)
+			}
+		}
+		times, err := t.slotTimes(slots)
+		if err != nil {
+			return false, err
+		}
+		for _, ts := range times {
+			if ts == 0 {
+				continue
+			}
+			if ts < last {
+				ordered = false
+			}
+			last = max(last, ts)
+		}
+	}
+	t.order = incTimeOrder{known: true, ordered: ordered, last: last}
+	return ordered, nil
+}
+
+// slotTimes czyta znaczniki czasu podanych slotów (ciągłe sloty jednym ReadAt).
+func (t *incTable) slotTimes(slots []uint64) ([]int64, error) {
+	if len(slots) == 0 {
+		return nil, nil
+	}
+	buf, err := readIncSlots(t.times, incTimeSize, slots)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]int64, len(slots))
+	for i := range out {
+		out[i] = int64(binary.LittleEndian.Uint64(buf[i*incTimeSize:]))
+	}
+	return out, nil
+}
+
+// searchTime - pierwsza pozycja w [trimmed, len), za którą nie ma już czasów < ts (len, gdy brak).
+// Wymaga timesOrdered; pozycje z czasem 0 są pomijane.
+func (t *incTable) searchTime(ts int64) (int64, error) {
+	lo, hi := t.m.trimmed, t.m.len()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		p, v := mid, int64(0)
+		for ; p < hi; p++ {
+			slot, _ := t.m.get(p)
+			var err error
+			if v, err = t.slotTime(slot); err != nil {
+				return 0, err
+			}
+			if v != 0 {
+				break
+			}
+		}
+		if p < hi && v < ts {
+			lo = p + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// timeRangeIncEntries zwraca maks. amount żywych wpisów z czasem w [fromTime, toTime).
+// inc_id = kursor jak w range (bottom: od najstarszego, top: 0 = najnowszy).
+func timeRangeIncEntries(file *os.File, t *incTable, recordSize int64, req *fileRequest) fileResponse {
+	numRecords := t.m.len()
+	from, to, amount := req.fromTime, req.toTime, int64(req.amount)
+	empty := fileResponse{data: []byte{}, count: numRecords, next: -1}
+	if amount <= 0 || int64(req.inc_id) < 0 || int64(req.inc_id) >= numRecords || (to > 0 && to <= from) {
+		return empty
+	}
+	inRange := func(ts int64) bool { return ts != 0 && ts >= from && (to == 0 || ts < to) }
+
+	ordered, err := t.timesOrdered()
+	if err != nil {
+		return fileResponse{err: err}
+	}
+	top := strings.ToLower(req.count_from) == "top"
+	var pos int64
+	switch {
+	case !ordered && top:
+		pos = numRecords - 1 - int64(req.inc_id)
+	case !ordered:
+		pos = max(t.m.trimmed, int64(req.inc_id))
+	case top:
+		end := numRecords
+		if to > 0 {
+			var err error
+			if end, err = t.searchTime(to); err != nil {
+				return fileResponse{err: err}
+			}
+		}
+		pos = min(end-1, numRecords-1-int64(req.inc_id))
+	default:
+		lb, err := t.searchTime(from)
+		if err != nil {
+			return fileResponse{err: err}
+		}
+		pos = max(lb, int64(req.inc_id))
+	}
+
+	var (
+		out      []byte
+		outIDs   []uint64
+		outTimes []int64
+		done     bool
+		lastID   int64
+	)
+	for !done && pos >= t.m.trimmed && pos < numRecords && int64(len(outIDs)) < amount {
+		var ids, slots []uint64
+		var cursor int64
+		if top {
+			ids, slots, cursor = t.m.liveBackward(pos, timeBatch)
+		} else {
+			ids, slots, cursor = t.m.liveForward(pos, timeBatch)
+		}
+		if len(ids) == 0 {
+			pos = cursor
+			break
+		}
+		times, err := t.slotTimes(slots)
+		if err != nil {
+			return fileResponse{err: err}
+		}
+		var keep []uint64
+		for i, id := range ids {
+			ts := times[i]
+			// za końcem zakresu (w kierunku czytania) nie ma już czego szukać - tylko przy rosnących czasach
+			if ordered && ts != 0 && ((!top && to > 0 && ts >= to) || (top && ts < from)) {
+				done = true
+				break
+			}
+			if !inRange(ts) {
+				continue
+			}
+			keep = append(keep, slots[i])
+			outIDs = append(outIDs, id)
+			outTimes = append(outTimes, ts)
+			lastID = int64(id)
+			if int64(len(outIDs)) >= amount {
+				break
+			}
+		}
+		buf, err := readIncSlots(file, recordSize, keep)
+		if err != nil {
+			return fileResponse{err: err}
+		}
+		out = append(out, buf...)
+		pos = cursor
+		if int64(len(outIDs)) >= amount {
+			// kolejna strona zaczyna się za ostatnim zwróconym wpisem
+			pos = lastID + 1
+			if top {
+				pos = lastID - 1
+			}
+		}
+	}
+
+	next := int64(-1)
+	if !done && pos >= t.m.trimmed && pos < numRecords {
+		next = pos
+		if top {
+			next = numRecords - 1 - pos
+		}
+	}
+	if out == nil {
+		out = []byte{}
+	}
+	return fileResponse{data: out, ids: outIDs, times: outTimes, count: numRecords, next: next}
+}
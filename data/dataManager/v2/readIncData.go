@@ -1,6 +1,12 @@
 package dataManager_v2
 
 func ReadIncDataFromFileAsync_ById(filePath string, id uint64, entrySize uint64) ([]byte, error) {
+	data, _, err := ReadIncEntryFromFileAsync_ById(filePath, id, entrySize)
+	return data, err
+}
+
+// ReadIncEntryFromFileAsync_ById - rekord o danym id razem z jego znacznikiem czasu (unix nano).
+func ReadIncEntryFromFileAsync_ById(filePath string, id uint64, entrySize uint64) ([]byte, int64, error) {
 	respChan := make(chan fileResponse, 1)
 	req := fileRequest{
 		op:        "read_inc",
@@ -11,42 +17,97 @@ func ReadIncDataFromFileAsync_ById(filePath string, id uint64, entrySize uint64)
 	}
 	resp := sendToFileWorker(filePath, req)
 	if resp.err != nil {
-		return nil, resp.err
+		return nil, 0, resp.err
 	}
+	var ts int64
+	if len(resp.times) > 0 {
+		ts = resp.times[0]
+	}
+	return resp.data, ts, nil
+}
 
-	return resp.data, nil
+// IncRange - wynik odczytu wielu wpisów inc table (usunięte wpisy są pominięte).
+type IncRange struct {
+	Data    []byte   // rekordy (entrySize+3) w kolejności odczytu
+	IDs     []uint64 // id (liczone od najstarszego) kolejnych rekordów z Data
+	Times   []int64  // znaczniki czasu kolejnych rekordów z Data (unix nano)
+	Total   uint64   // liczba rekordów w pliku w chwili odczytu (łącznie z usuniętymi)
+	Next    uint64   // kursor kolejnej strony (w tych samych jednostkach co start)
+	HasMore bool     // false = Next nie ma znaczenia
+	Scanned uint64   // filter: ilość sprawdzonych wpisów
 }
 
-func ReadIncDataFromFileAsync_LastEntries(filePath string, amount_to_read uint64, entrySize uint64) ([]byte, error) {
-	respChan := make(chan fileResponse, 1)
-	req := fileRequest{
-		op:        "read_inc",
-		entrySize: entrySize,
-		inc_id:    amount_to_read, // id używane jako ilość wpisów do odczytania
-		read_type: 1,
-		resp:      respChan,
-	}
+func readIncRange(filePath string, req fileRequest) (IncRange, error) {
+	req.op = "read_inc"
+	req.resp = make(chan fileResponse, 1)
 	resp := sendToFileWorker(filePath, req)
 	if resp.err != nil {
-		return nil, resp.err
+		return IncRange{}, resp.err
 	}
+	out := IncRange{Data: resp.data, IDs: resp.ids, Times: resp.times, Total: uint64(resp.count), Scanned: uint64(resp.scanned)}
+	if resp.next >= 0 {
+		out.Next = uint64(resp.next)
+		out.HasMore = true
+	}
+	return out, nil
+}
 
-	return resp.data, nil
+// ReadIncDataFromFileAsync_LastEntries - N najnowszych wpisów, od najnowszego.
+func ReadIncDataFromFileAsync_LastEntries(filePath string, amount_to_read uint64, entrySize uint64) (IncRange, error) {
+	return readIncRange(filePath, fileRequest{
+		entrySize: entrySize,
+		inc_id:    amount_to_read, // id używane jako ilość wpisów do odczytania
+		read_type: 1,
+	})
 }
 
-func ReadIncDataFromFileAsync_FirstEntries(filePath string, amount_to_read uint64, entrySize uint64) ([]byte, error) {
-	respChan := make(chan fileResponse, 1)
-	req := fileRequest{
-		op:        "read_inc",
+// ReadIncDataFromFileAsync_FirstEntries - N najstarszych wpisów, od najstarszego.
+func ReadIncDataFromFileAsync_FirstEntries(filePath string, amount_to_read uint64, entrySize uint64) (IncRange, error) {
+	return readIncRange(filePath, fileRequest{
 		entrySize: entrySize,
 		inc_id:    amount_to_read, // id używane jako ilość wpisów do odczytania
 		read_type: 2,
-		resp:      respChan,
-	}
-	resp := sendToFileWorker(filePath, req)
-	if resp.err != nil {
-		return nil, resp.err
-	}
+	})
+}
+
+// ReadIncDataFromFileAsync_Range czyta maks. amount wpisów zaczynając od start
+// (count_from = "bottom": start od najstarszego, "top": start od najnowszego).
+func ReadIncDataFromFileAsync_Range(filePath string, start uint64, amount uint64, entrySize uint64, countFrom string) (IncRange, error) {
+	return readIncRange(filePath, fileRequest{
+		entrySize:  entrySize,
+		inc_id:     start,
+		amount:     amount,
+		read_type:  3,
+		count_from: countFrom,
+	})
+}
+
+// ReadIncDataFromFileAsync_Filter przegląda maks. scanLimit wpisów od start (jak w Range)
+// i zwraca maks. amount wpisów, dla których match(payload) == true. Next wskazuje
+// pierwszy nieprzejrzany wpis, więc kolejne wywołanie kontynuuje skanowanie.
+func ReadIncDataFromFileAsync_Filter(filePath string, start uint64, amount uint64, scanLimit uint64, entrySize uint64, countFrom string, match func(data []byte) bool) (IncRange, error) {
+	return readIncRange(filePath, fileRequest{
+		entrySize:  entrySize,
+		inc_id:     start,
+		amount:     amount,
+		scanLimit:  scanLimit,
+		match:      match,
+		read_type:  4,
+		count_from: countFrom,
+	})
+}
 
-	return resp.data, nil
+// ReadIncDataFromFileAsync_TimeRange zwraca maks. amount wpisów ze znacznikiem czasu w [from, to)
+// (unix nano, to = 0 bez górnej granicy). Początek zakresu szukany jest binarnie, więc wynik jest
+// dokładny, gdy czasy rosną razem z id (zwykłe dopisywanie). start jak w Range: kursor kolejnej strony.
+func ReadIncDataFromFileAsync_TimeRange(filePath string, from, to int64, start uint64, amount uint64, entrySize uint64, countFrom string) (IncRange, error) {
+	return readIncRange(filePath, fileRequest{
+		entrySize:  entrySize,
+		inc_id:     start,
+		amount:     amount,
+		fromTime:   from,
+		toTime:     to,
+		read_type:  5,
+		count_from: countFrom,
+	})
 }
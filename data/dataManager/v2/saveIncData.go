@@ -1,24 +1,32 @@
 package dataManager_v2
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+
+	types "github.com/PAW122/TsunamiDB/types"
+)
 
 // push nowego elementu do table
 // w przypadku inc_table fileResponse.data będzie == uint64 id wpisu
-func SaveIncDataToFileAsync(data []byte, filePath string, entry_size uint64) (uint64, error) {
+// ts = znacznik czasu wpisu (unix nano), 0 = czas serwera
+func SaveIncDataToFileAsync(data []byte, filePath string, entry_size uint64, ts int64) (uint64, error) {
 	respChan := make(chan fileResponse, 1)
 	req := fileRequest{
 		op:        "write_inc",
 		data:      data,
 		entrySize: entry_size,
+		ts:        ts,
 		resp:      respChan,
 	}
 	resp := sendToFileWorker(filePath, req)
-	id := binary.LittleEndian.Uint64(resp.data)
-	return id, resp.err
+	if resp.err != nil {
+		return 0, resp.err
+	}
+	return binary.LittleEndian.Uint64(resp.data), nil
 }
 
 // allows you to enter a new element anywhere in inc_table as long as it is not a new id
-func SaveIncDataToFileAsync_Put(data []byte, filePath string, entry_size uint64, pref_id uint64, count_from string) (uint64, error) {
+func SaveIncDataToFileAsync_Put(data []byte, filePath string, entry_size uint64, pref_id uint64, count_from string, ts int64) (uint64, error) {
 	respChan := make(chan fileResponse, 1)
 	req := fileRequest{
 		op:         "write_inc_ow", // overwrite if exists
@@ -27,15 +35,18 @@ func SaveIncDataToFileAsync_Put(data []byte, filePath string, entry_size uint64,
 		inc_id:     pref_id, // custom id
 		read_type:  0,       // 0 = append
 		count_from: count_from,
+		ts:         ts,
 		resp:       respChan,
 	}
 	resp := sendToFileWorker(filePath, req)
-	id := binary.LittleEndian.Uint64(resp.data)
-	return id, resp.err
+	if resp.err != nil {
+		return 0, resp.err
+	}
+	return binary.LittleEndian.Uint64(resp.data), nil
 }
 
-// overwriting an existing inc_table entry with a given id
-func SaveIncDataToFileAsync_OverWrite(data []byte, filePath string, entry_size uint64, pref_id uint64, count_from string) (uint64, error) {
+// overwriting an existing inc_table entry with a given id (ts 0 keeps the entry timestamp)
+func SaveIncDataToFileAsync_OverWrite(data []byte, filePath string, entry_size uint64, pref_id uint64, count_from string, ts int64) (uint64, error) {
 	respChan := make(chan fileResponse, 1)
 	req := fileRequest{
 		op:         "write_inc_ow", // overwrite if exists
@@ -44,20 +55,93 @@ func SaveIncDataToFileAsync_OverWrite(data []byte, filePath string, entry_size u
 		inc_id:     pref_id, // custom id
 		read_type:  1,       // 1 = overwrite existing
 		count_from: count_from,
+		ts:         ts,
 		resp:       respChan,
 	}
 	resp := sendToFileWorker(filePath, req)
-	id := binary.LittleEndian.Uint64(resp.data)
-	return id, resp.err
+	if resp.err != nil {
+		return 0, resp.err
+	}
+	return binary.LittleEndian.Uint64(resp.data), nil
 }
 
 // DeleteIncTableFile removes the file backing an incremental table via the file worker.
-func DeleteIncTableFile(filePath string) error {
+// entry_size > 0 pozwala zwolnić też payloady overflow tabeli.
+func DeleteIncTableFile(filePath string, entry_size uint64) error {
 	respChan := make(chan fileResponse, 1)
 	req := fileRequest{
-		op:   "delete_inc",
-		resp: respChan,
+		op:        "delete_inc",
+		entrySize: entry_size,
+		resp:      respChan,
 	}
 	resp := sendToFileWorker(filePath, req)
 	return resp.err
 }
+
+// DeleteIncEntry oznacza pojedynczy wpis jako usunięty (skip bit).
+// Zwraca id (liczone od najstarszego) oraz rekord sprzed usunięcia.
+func DeleteIncEntry(filePath string, entry_size uint64, id uint64, count_from string) (uint64, []byte, error) {
+	respChan := make(chan fileResponse, 1)
+	req := fileRequest{
+		op:         "skip_inc",
+		entrySize:  entry_size,
+		inc_id:     id,
+		count_from: count_from,
+		resp:       respChan,
+	}
+	resp := sendToFileWorker(filePath, req)
+	if resp.err != nil {
+		return 0, nil, resp.err
+	}
+	return resp.ids[0], resp.data, nil
+}
+
+// CompactIncTable fizycznie usuwa wpisy oznaczone jako skip; id pozostałych wpisów się nie zmieniają.
+// Zwraca id wpisów usuniętych z pliku (rosnąco) i liczbę pozostałych rekordów.
+func CompactIncTable(filePath string, entry_size uint64) ([]uint64, uint64, error) {
+	respChan := make(chan fileResponse, 1)
+	req := fileRequest{
+		op:        "compact_inc",
+		entrySize: entry_size,
+		resp:      respChan,
+	}
+	resp := sendToFileWorker(filePath, req)
+	if resp.err != nil {
+		return nil, 0, resp.err
+	}
+	return resp.ids, uint64(resp.count), nil
+}
+
+// ResizeIncTable przepisuje inc table na nowy rozmiar wpisu; id wpisów się nie zmieniają.
+// Zwraca liczbę rekordów (łącznie z usuniętymi).
+func ResizeIncTable(filePath string, entry_size uint64, new_entry_size uint64) (uint64, error) {
+	respChan := make(chan fileResponse, 1)
+	req := fileRequest{
+		op:           "resize_inc",
+		entrySize:    entry_size,
+		newEntrySize: new_entry_size,
+		resp:         respChan,
+	}
+	resp := sendToFileWorker(filePath, req)
+	if resp.err != nil {
+		return 0, resp.err
+	}
+	return uint64(resp.count), nil
+}
+
+// TrimIncTable obcina najstarsze wpisy ponad limity retencji (id pozostałych się nie zmieniają).
+// Zwraca zakres obciętych id [from, to); from == to, gdy nic nie zostało obcięte.
+func TrimIncTable(filePath string, entry_size uint64, retention types.IncRetention) (uint64, uint64, error) {
+	respChan := make(chan fileResponse, 1)
+	req := fileRequest{
+		op:        "trim_inc",
+		entrySize: entry_size,
+		retention: retention,
+		resp:      respChan,
+	}
+	resp := sendToFileWorker(filePath, req)
+	if resp.err != nil {
+		return 0, 0, resp.err
+	}
+	return resp.ids[0], uint64(resp.count), nil
+}
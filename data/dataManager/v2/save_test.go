@@ -1,13 +1,20 @@
 package dataManager_v2
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	defrag "github.com/PAW122/TsunamiDB/data/defragmentationManager"
 	encoding_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+	types "github.com/PAW122/TsunamiDB/types"
 )
 
 func setupDataManagerTest(t *testing.T) func() {
@@ -149,7 +156,7 @@ func TestIncTableAppendAndDelete(t *testing.T) {
 	entrySize := uint64(8)
 
 	enc1 := encoding_v1.EncodeIncEntry(entrySize, []byte("foo"))
-	id1, err := SaveIncDataToFileAsync(enc1, table, entrySize)
+	id1, err := SaveIncDataToFileAsync(enc1, table, entrySize, 0)
 	if err != nil {
 		t.Fatalf("save inc 1: %v", err)
 	}
@@ -158,7 +165,7 @@ func TestIncTableAppendAndDelete(t *testing.T) {
 	}
 
 	enc2 := encoding_v1.EncodeIncEntry(entrySize, []byte("bar"))
-	id2, err := SaveIncDataToFileAsync(enc2, table, entrySize)
+	id2, err := SaveIncDataToFileAsync(enc2, table, entrySize, 0)
 	if err != nil {
 		t.Fatalf("save inc 2: %v", err)
 	}
@@ -187,7 +194,7 @@ func TestIncTableAppendAndDelete(t *testing.T) {
 		t.Fatalf("unexpected inc payload: %q", decoded.Data)
 	}
 
-	if err := DeleteIncTableFile(table); err != nil {
+	if err := DeleteIncTableFile(table, entrySize); err != nil {
 		t.Fatalf("delete inc: %v", err)
 	}
 	stat, err = os.Stat(filepath.Join(baseIncTablesPath, table))
@@ -200,7 +207,7 @@ func TestIncTableAppendAndDelete(t *testing.T) {
 
 	shutdownFileWorkersForTests()
 
-	id3, err := SaveIncDataToFileAsync(enc1, table, entrySize)
+	id3, err := SaveIncDataToFileAsync(enc1, table, entrySize, 0)
 	if err != nil {
 		t.Fatalf("save inc after delete: %v", err)
 	}
@@ -210,3 +217,776 @@ func TestIncTableAppendAndDelete(t *testing.T) {
 
 	shutdownFileWorkersForTests()
 }
+
+func TestIncTableRangeRead(t *testing.T) {
+	setupDataManagerTest(t)
+
+	table := "inc_range_test.tbl"
+	entrySize := uint64(8)
+	recordSize := int(entrySize) + 3
+	for i := 0; i < 10; i++ {
+		enc := encoding_v1.EncodeIncEntry(entrySize, []byte{byte('a' + i)})
+		if _, err := SaveIncDataToFileAsync(enc, table, entrySize, 0); err != nil {
+			t.Fatalf("save inc %d: %v", i, err)
+		}
+	}
+
+	payloads := func(data []byte) string {
+		out := ""
+		for i := 0; i+recordSize <= len(data); i += recordSize {
+			dec, err := encoding_v1.DecodeIncEntry(entrySize, data[i:i+recordSize])
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			out += string(dec.Data)
+		}
+		return out
+	}
+
+	rng, err := ReadIncDataFromFileAsync_Range(table, 3, 4, entrySize, "bottom")
+	if err != nil {
+		t.Fatalf("range bottom: %v", err)
+	}
+	if got := payloads(rng.Data); got != "defg" || rng.IDs[0] != 3 || rng.Total != 10 || rng.Next != 7 {
+		t.Fatalf("bottom window: data=%q ids=%v total=%d next=%d", got, rng.IDs, rng.Total, rng.Next)
+	}
+
+	rng, err = ReadIncDataFromFileAsync_Range(table, 1, 3, entrySize, "top")
+	if err != nil {
+		t.Fatalf("range top: %v", err)
+	}
+	if got := payloads(rng.Data); got != "ihg" || rng.IDs[0] != 8 || rng.Next != 4 {
+		t.Fatalf("top window: data=%q ids=%v next=%d", got, rng.IDs, rng.Next)
+	}
+
+	// okno przycięte do końca pliku i start poza zakresem
+	if rng, _ = ReadIncDataFromFileAsync_Range(table, 8, 5, entrySize, "bottom"); payloads(rng.Data) != "ij" {
+		t.Fatalf("clipped window: %q", payloads(rng.Data))
+	}
+	if rng, _ = ReadIncDataFromFileAsync_Range(table, 10, 5, entrySize, "top"); len(rng.Data) != 0 || rng.Total != 10 {
+		t.Fatalf("out of range window should be empty, got %d bytes", len(rng.Data))
+	}
+}
+
+func TestIncTableFilterRead(t *testing.T) {
+	setupDataManagerTest(t)
+
+	table := "inc_filter_test.tbl"
+	entrySize := uint64(8)
+	// więcej wpisów niż filterBatch, żeby skan przechodził przez kilka paczek
+	for i := 0; i < 600; i++ {
+		enc := encoding_v1.EncodeIncEntry(entrySize, []byte(fmt.Sprintf("x%03d", i)))
+		if _, err := SaveIncDataToFileAsync(enc, table, entrySize, 0); err != nil {
+			t.Fatalf("save inc %d: %v", i, err)
+		}
+	}
+	if _, _, err := DeleteIncEntry(table, entrySize, 207, "bottom"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	match := func(data []byte) bool { return len(data) == 4 && data[2] == '0' && data[3] == '7' }
+
+	rng, err := ReadIncDataFromFileAsync_Filter(table, 0, 3, 1000, entrySize, "bottom", match)
+	if err != nil {
+		t.Fatalf("filter bottom: %v", err)
+	}
+	if fmt.Sprint(rng.IDs) != "[7 107 307]" || !rng.HasMore || rng.Next != 308 || rng.Scanned != 307 {
+		t.Fatalf("bottom filter: ids=%v next=%d more=%v scanned=%d", rng.IDs, rng.Next, rng.HasMore, rng.Scanned)
+	}
+
+	// wznowienie od kursora kończy się na końcu tabeli
+	rng, _ = ReadIncDataFromFileAsync_Filter(table, rng.Next, 10, 1000, entrySize, "bottom", match)
+	if fmt.Sprint(rng.IDs) != "[407 507]" || rng.HasMore {
+		t.Fatalf("resumed filter: ids=%v more=%v", rng.IDs, rng.HasMore)
+	}
+
+	// scan_limit przerywa skan przed znalezieniem amount wpisów
+	rng, _ = ReadIncDataFromFileAsync_Filter(table, 0, 10, 150, entrySize, "top", match)
+	if fmt.Sprint(rng.IDs) != "[507]" || rng.Scanned != 150 || rng.Next != 150 {
+		t.Fatalf("top filter: ids=%v scanned=%d next=%d", rng.IDs, rng.Scanned, rng.Next)
+	}
+}
+
+func TestIncTableSkipAndCompact(t *testing.T) {
+	setupDataManagerTest(t)
+
+	table := "inc_skip_test.tbl"
+	entrySize := uint64(8)
+	recordSize := int(entrySize) + 3
+	for i := 0; i < 6; i++ {
+		enc := encoding_v1.EncodeIncEntry(entrySize, []byte{byte('a' + i)})
+		if _, err := SaveIncDataToFileAsync(enc, table, entrySize, 0); err != nil {
+			t.Fatalf("save inc %d: %v", i, err)
+		}
+	}
+
+	payloads := func(data []byte) string {
+		out := ""
+		for i := 0; i+recordSize <= len(data); i += recordSize {
+			dec, err := encoding_v1.DecodeIncEntry(entrySize, data[i:i+recordSize])
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			out += string(dec.Data)
+		}
+		return out
+	}
+
+	// usuń b, c (ciąg) i f (ostatni)
+	for _, id := range []uint64{1, 2, 5} {
+		if _, _, err := DeleteIncEntry(table, entrySize, id, "bottom"); err != nil {
+			t.Fatalf("delete %d: %v", id, err)
+		}
+	}
+	if _, _, err := DeleteIncEntry(table, entrySize, 2, "bottom"); !errors.Is(err, ErrIncEntryNotFound) {
+		t.Fatalf("double delete should be not found, got %v", err)
+	}
+
+	rng, err := ReadIncDataFromFileAsync_FirstEntries(table, 10, entrySize)
+	if err != nil {
+		t.Fatalf("first entries: %v", err)
+	}
+	if got := payloads(rng.Data); got != "ade" {
+		t.Fatalf("first entries after delete: %q ids=%v", got, rng.IDs)
+	}
+	rng, err = ReadIncDataFromFileAsync_LastEntries(table, 2, entrySize)
+	if err != nil {
+		t.Fatalf("last entries: %v", err)
+	}
+	if got := payloads(rng.Data); got != "ed" {
+		t.Fatalf("last entries after delete: %q", got)
+	}
+	rng, err = ReadIncDataFromFileAsync_Range(table, 0, 2, entrySize, "bottom")
+	if err != nil {
+		t.Fatalf("range: %v", err)
+	}
+	if got := payloads(rng.Data); got != "ad" || rng.Next != 4 || !rng.HasMore {
+		t.Fatalf("range after delete: %q next=%d more=%v", got, rng.Next, rng.HasMore)
+	}
+
+	// overwrite w środku ciągu usuniętych - wpis znowu widoczny
+	enc := encoding_v1.EncodeIncEntry(entrySize, []byte("C"))
+	if _, err := SaveIncDataToFileAsync_OverWrite(enc, table, entrySize, 2, "bottom", 0); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	// insert przed usuniętym rekordem przesuwa wskaźniki
+	enc = encoding_v1.EncodeIncEntry(entrySize, []byte("X"))
+	if _, err := SaveIncDataToFileAsync_Put(enc, table, entrySize, 1, "bottom", 0); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	rng, err = ReadIncDataFromFileAsync_FirstEntries(table, 10, entrySize)
+	if err != nil {
+		t.Fatalf("first entries: %v", err)
+	}
+	if got := payloads(rng.Data); got != "aXCde" {
+		t.Fatalf("after overwrite+insert: %q ids=%v", got, rng.IDs)
+	}
+
+	removed, total, err := CompactIncTable(table, entrySize)
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if len(removed) != 2 || removed[0] != 2 || removed[1] != 6 || total != 5 {
+		t.Fatalf("compact removed=%v total=%d", removed, total)
+	}
+	// id się nie zmieniają - usunięte pozycje zostają w mapie bez slotu
+	raw, err := ReadIncDataFromFileAsync_ById(table, 3, entrySize)
+	if err != nil {
+		t.Fatalf("read after compact: %v", err)
+	}
+	if got := payloads(raw); got != "C" {
+		t.Fatalf("id 3 after compact: %q", got)
+	}
+	if _, err := ReadIncDataFromFileAsync_ById(table, 2, entrySize); !errors.Is(err, ErrIncEntryNotFound) {
+		t.Fatalf("compacted id 2 should be not found, got %v", err)
+	}
+	rng, err = ReadIncDataFromFileAsync_FirstEntries(table, 10, entrySize)
+	if err != nil {
+		t.Fatalf("first entries: %v", err)
+	}
+	if got := payloads(rng.Data); got != "aXCde" || fmt.Sprint(rng.IDs) != "[0 1 3 4 5]" || rng.Total != 7 {
+		t.Fatalf("after compact: %q ids=%v total=%d", got, rng.IDs, rng.Total)
+	}
+
+	shutdownFileWorkersForTests()
+	if raw, err := ReadIncDataFromFileAsync_ById(table, 3, entrySize); err != nil || payloads(raw) != "C" {
+		t.Fatalf("id 3 after compact+reopen: %q %v", payloads(raw), err)
+	}
+
+	// overwrite pozycji zwolnionej przez kompakcję zapisuje wpis w nowym slocie
+	enc = encoding_v1.EncodeIncEntry(entrySize, []byte("B"))
+	if _, err := SaveIncDataToFileAsync_OverWrite(enc, table, entrySize, 2, "bottom", 0); err != nil {
+		t.Fatalf("overwrite compacted: %v", err)
+	}
+	if removed, _, err := CompactIncTable(table, entrySize); err != nil || len(removed) != 0 {
+		t.Fatalf("second compact removed=%v err=%v", removed, err)
+	}
+	rng, err = ReadIncDataFromFileAsync_FirstEntries(table, 10, entrySize)
+	if err != nil {
+		t.Fatalf("first entries: %v", err)
+	}
+	if got := payloads(rng.Data); got != "aXBCde" || fmt.Sprint(rng.IDs) != "[0 1 2 3 4 5]" {
+		t.Fatalf("after overwrite of compacted id: %q ids=%v", got, rng.IDs)
+	}
+}
+
+func TestIncTableResize(t *testing.T) {
+	setupDataManagerTest(t)
+
+	table := "inc_resize_test.tbl"
+	entrySize := uint64(8)
+	for _, msg := range []string{"a", "bbbbbb", "cc", "d"} {
+		if _, err := SaveIncDataToFileAsync(encoding_v1.EncodeIncEntry(entrySize, []byte(msg)), table, entrySize, 0); err != nil {
+			t.Fatalf("save inc %s: %v", msg, err)
+		}
+	}
+	if _, _, err := DeleteIncEntry(table, entrySize, 2, "bottom"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	readAll := func(size uint64) string {
+		rng, err := ReadIncDataFromFileAsync_FirstEntries(table, 10, size)
+		if err != nil {
+			t.Fatalf("first entries: %v", err)
+		}
+		out := ""
+		rs := int(size) + 3
+		for i := 0; i+rs <= len(rng.Data); i += rs {
+			dec, err := encoding_v1.DecodeIncEntry(size, rng.Data[i:i+rs])
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			out += fmt.Sprintf("%d:%s ", rng.IDs[i/rs], dec.Data)
+		}
+		return out
+	}
+
+	count, err := ResizeIncTable(table, entrySize, 32)
+	if err != nil || count != 4 {
+		t.Fatalf("grow: count=%d err=%v", count, err)
+	}
+	if got := readAll(32); got != "0:a 1:bbbbbb 3:d " {
+		t.Fatalf("after grow: %q", got)
+	}
+	if stat, _ := os.Stat(filepath.Join(baseIncTablesPath, table)); stat.Size() != 4*35 {
+		t.Fatalf("unexpected size after grow: %d", stat.Size())
+	}
+
+	if _, err := ResizeIncTable(table, 32, 4); !errors.Is(err, ErrIncEntryTooLarge) {
+		t.Fatalf("expected too large error, got %v", err)
+	}
+	if got := readAll(32); got != "0:a 1:bbbbbb 3:d " {
+		t.Fatalf("failed shrink must not change data: %q", got)
+	}
+
+	if _, err := ResizeIncTable(table, 32, 6); err != nil {
+		t.Fatalf("shrink: %v", err)
+	}
+	if got := readAll(6); got != "0:a 1:bbbbbb 3:d " {
+		t.Fatalf("after shrink: %q", got)
+	}
+	// usunięty wpis nadal jest pomijany, a append dostaje kolejne id
+	id, err := SaveIncDataToFileAsync(encoding_v1.EncodeIncEntry(6, []byte("e")), table, 6, 0)
+	if err != nil || id != 4 {
+		t.Fatalf("append after resize: id=%d err=%v", id, err)
+	}
+}
+
+func TestIncMapMatchesSliceModel(t *testing.T) {
+	m := newIncMap()
+	var model []uint64
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		switch op := rnd.Intn(10); {
+		case op < 6 || len(model) == 0:
+			pos := rnd.Intn(len(model) + 1)
+			v := uint64(i)
+			m.insert(int64(pos), v)
+			model = append(model, 0)
+			copy(model[pos+1:], model[pos:])
+			model[pos] = v
+		default:
+			pos := rnd.Intn(len(model))
+			v := model[pos] ^ incMapDeleted
+			m.set(int64(pos), v)
+			model[pos] = v
+		}
+	}
+	if got := m.values(); fmt.Sprint(got) != fmt.Sprint(model) {
+		t.Fatalf("map diverged from model")
+	}
+
+	var live []uint64
+	for pos, v := range model {
+		if v&incMapDeleted == 0 {
+			live = append(live, uint64(pos))
+		}
+	}
+	ids, _, cursor := m.liveForward(0, int64(len(model)))
+	if fmt.Sprint(ids) != fmt.Sprint(live) || cursor != int64(len(model)) {
+		t.Fatalf("liveForward mismatch: cursor=%d", cursor)
+	}
+	ids, _, cursor = m.liveBackward(int64(len(model))-1, int64(len(model)))
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	if fmt.Sprint(ids) != fmt.Sprint(live) || cursor != -1 {
+		t.Fatalf("liveBackward mismatch: cursor=%d", cursor)
+	}
+}
+
+func TestIncTableInsertKeepsSlots(t *testing.T) {
+	setupDataManagerTest(t)
+	incMapCheckpointMin = 8
+	t.Cleanup(func() { incMapCheckpointMin = 4096 })
+
+	table := "inc_insert_test.tbl"
+	entrySize := uint64(8)
+	recordSize := int64(entrySize) + 3
+	full := filepath.Join(baseIncTablesPath, table)
+	if _, err := SaveIncDataToFileAsync(encoding_v1.EncodeIncEntry(entrySize, []byte("last")), table, entrySize, 0); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	// każdy insert na początek tabeli dopisuje tylko jeden slot, pierwszy rekord zostaje na miejscu
+	for i := 0; i < 20; i++ {
+		enc := encoding_v1.EncodeIncEntry(entrySize, []byte(fmt.Sprintf("i%d", i)))
+		id, err := SaveIncDataToFileAsync_Put(enc, table, entrySize, 0, "bottom", 0)
+		if err != nil || id != 0 {
+			t.Fatalf("insert %d: id=%d err=%v", i, id, err)
+		}
+	}
+	raw, _ := os.ReadFile(full)
+	if int64(len(raw)) != 21*recordSize {
+		t.Fatalf("unexpected file size %d", len(raw))
+	}
+	if dec, _ := encoding_v1.DecodeIncEntry(entrySize, raw[:recordSize]); string(dec.Data) != "last" {
+		t.Fatalf("slot 0 was rewritten: %q", dec.Data)
+	}
+	if _, _, err := DeleteIncEntry(table, entrySize, 1, "bottom"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	check := func(stage string) {
+		t.Helper()
+		rng, err := ReadIncDataFromFileAsync_FirstEntries(table, 3, entrySize)
+		if err != nil {
+			t.Fatalf("%s: first entries: %v", stage, err)
+		}
+		got := ""
+		for i := int64(0); i < int64(len(rng.Data)); i += recordSize {
+			dec, _ := encoding_v1.DecodeIncEntry(entrySize, rng.Data[i:i+recordSize])
+			got += string(dec.Data) + " "
+		}
+		if got != "i19 i17 i16 " || rng.Total != 21 {
+			t.Fatalf("%s: unexpected entries %q total=%d", stage, got, rng.Total)
+		}
+		last, err := ReadIncDataFromFileAsync_ById(table, 20, entrySize)
+		if dec, _ := encoding_v1.DecodeIncEntry(entrySize, last); err != nil || string(dec.Data) != "last" {
+			t.Fatalf("%s: last entry %q err=%v", stage, dec.Data, err)
+		}
+	}
+	check("live")
+
+	// ponowne otwarcie: checkpoint + log
+	shutdownFileWorkersForTests()
+	check("reopen")
+
+	// niepełny rekord na końcu logu (awaria w trakcie zapisu) jest ignorowany
+	shutdownFileWorkersForTests()
+	lf, err := os.OpenFile(incMapLogPath(full), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	lf.Write([]byte{incMapOpInsert, 0, 0})
+	lf.Close()
+	check("torn log")
+}
+
+func TestIncTableLegacyMigration(t *testing.T) {
+	setupDataManagerTest(t)
+
+	table := "inc_legacy_test.tbl"
+	entrySize := uint64(8)
+	var raw []byte
+	for _, msg := range []string{"a", "b", "c"} {
+		raw = append(raw, encoding_v1.EncodeIncEntry(entrySize, []byte(msg))...)
+	}
+	// stary układ: usunięty rekord ze wskaźnikiem na następny żywy
+	encoding_v1.SetSkipIncEntry(raw[entrySize+3:2*(entrySize+3)], 2)
+	full := filepath.Join(baseIncTablesPath, table)
+	if err := os.WriteFile(full, raw, 0644); err != nil {
+		t.Fatalf("write legacy: %v", err)
+	}
+
+	rng, err := ReadIncDataFromFileAsync_FirstEntries(table, 10, entrySize)
+	if err != nil {
+		t.Fatalf("read legacy: %v", err)
+	}
+	if fmt.Sprint(rng.IDs) != "[0 2]" || rng.Total != 3 {
+		t.Fatalf("unexpected legacy read ids=%v total=%d", rng.IDs, rng.Total)
+	}
+	// czas zapisu starych wpisów jest nieznany
+	if fmt.Sprint(rng.Times) != "[0 0]" {
+		t.Fatalf("legacy entries should have no timestamp, got %v", rng.Times)
+	}
+	if _, err := os.Stat(incMapPath(full)); err != nil {
+		t.Fatalf("expected checkpoint after migration: %v", err)
+	}
+	id, err := SaveIncDataToFileAsync_Put(encoding_v1.EncodeIncEntry(entrySize, []byte("x")), table, entrySize, 0, "bottom", 0)
+	if err != nil || id != 0 {
+		t.Fatalf("insert after migration: id=%d err=%v", id, err)
+	}
+	rng, _ = ReadIncDataFromFileAsync_FirstEntries(table, 10, entrySize)
+	if fmt.Sprint(rng.IDs) != "[0 1 3]" {
+		t.Fatalf("unexpected ids after insert: %v", rng.IDs)
+	}
+}
+
+func TestIncTableRetention(t *testing.T) {
+	setupDataManagerTest(t)
+	clock := time.Unix(1_700_000_000, 0)
+	incNow = func() time.Time { return clock }
+	t.Cleanup(func() { incNow = time.Now })
+
+	table := "inc_retention_test.tbl"
+	entrySize := uint64(8)
+	recordSize := int64(entrySize) + 3
+	full := filepath.Join(baseIncTablesPath, table)
+	save := func(msg string) uint64 {
+		t.Helper()
+		id, err := SaveIncDataToFileAsync(encoding_v1.EncodeIncEntry(entrySize, []byte(msg)), table, entrySize, 0)
+		if err != nil {
+			t.Fatalf("save %s: %v", msg, err)
+		}
+		return id
+	}
+	firstIDs := func(stage string, n uint64) string {
+		t.Helper()
+		rng, err := ReadIncDataFromFileAsync_FirstEntries(table, n, entrySize)
+		if err != nil {
+			t.Fatalf("%s: first entries: %v", stage, err)
+		}
+		out := ""
+		for i, id := range rng.IDs {
+			dec, _ := encoding_v1.DecodeIncEntry(entrySize, rng.Data[int64(i)*recordSize:int64(i+1)*recordSize])
+			out += fmt.Sprintf("%d:%s ", id, dec.Data)
+		}
+		return out
+	}
+	fileSize := func() int64 {
+		fi, _ := os.Stat(full)
+		return fi.Size()
+	}
+
+	for i := 0; i < 10; i++ {
+		save(fmt.Sprintf("m%d", i))
+	}
+	if _, _, err := DeleteIncEntry(table, entrySize, 8, "bottom"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	// 9 żywych wpisów, limit 3 -> obcięte pozycje 0..5, zostają 6 7 9 (+ usunięty 8)
+	from, to, err := TrimIncTable(table, entrySize, types.IncRetention{MaxEntries: 3})
+	if err != nil || from != 0 || to != 6 {
+		t.Fatalf("trim by entries: from=%d to=%d err=%v", from, to, err)
+	}
+	if got := firstIDs("trimmed", 10); got != "6:m6 7:m7 9:m9 " {
+		t.Fatalf("unexpected entries after trim: %q", got)
+	}
+	if _, err := ReadIncDataFromFileAsync_ById(table, 3, entrySize); !errors.Is(err, ErrIncEntryNotFound) {
+		t.Fatalf("trimmed id should be not found, got %v", err)
+	}
+
+	// nowe wpisy dostają kolejne id i trafiają do zwolnionych slotów
+	size := fileSize()
+	clock = clock.Add(2 * time.Hour)
+	if id := save("n0"); id != 10 {
+		t.Fatalf("expected monotonic id 10, got %d", id)
+	}
+	save("n1")
+	if fileSize() != size {
+		t.Fatalf("file grew from %d to %d despite free slots", size, fileSize())
+	}
+
+	// max_bytes: 3 rekordy; max_age: wpisy starsze niż godzina (m6, m7, m9, usunięty 8)
+	if _, to, _ = TrimIncTable(table, entrySize, types.IncRetention{MaxBytes: uint64(3 * recordSize), MaxAgeSeconds: 3600}); to != 10 {
+		t.Fatalf("trim by age: to=%d", to)
+	}
+	check := func(stage string) {
+		t.Helper()
+		if got := firstIDs(stage, 10); got != "10:n0 11:n1 " {
+			t.Fatalf("%s: unexpected entries %q", stage, got)
+		}
+	}
+	check("age")
+
+	// ponowne otwarcie: trimmed z logu mapy, wolne sloty wyliczone z pliku
+	shutdownFileWorkersForTests()
+	check("reopen")
+	if id := save("n2"); id != 12 || fileSize() != size {
+		t.Fatalf("after reopen: id=%d size=%d want %d", id, fileSize(), size)
+	}
+
+	// kompakcja zwalnia miejsce po obciętych wpisach, ale nie zmienia id
+	if _, _, err := CompactIncTable(table, entrySize); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if fileSize() != 3*recordSize || firstIDs("compacted", 10) != "10:n0 11:n1 12:n2 " {
+		t.Fatalf("after compact: size=%d entries=%q", fileSize(), firstIDs("compacted", 10))
+	}
+	shutdownFileWorkersForTests()
+	if id := save("n3"); id != 13 {
+		t.Fatalf("id after compact+reopen: %d", id)
+	}
+}
+
+func TestIncTableOverflow(t *testing.T) {
+	setupDataManagerTest(t)
+
+	table := "inc_overflow_test.tbl"
+	entrySize := uint64(32)
+	ovfPath := filepath.Join(basePath, incOverflowFile(table))
+	ovfSize := func() int64 {
+		fi, err := os.Stat(ovfPath)
+		if err != nil {
+			return 0
+		}
+		return fi.Size()
+	}
+	save := func(body []byte) uint64 {
+		t.Helper()
+		rec, _, err := EncodeIncPayload(table, entrySize, body)
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		id, err := SaveIncDataToFileAsync(rec, table, entrySize, 0)
+		if err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		return id
+	}
+	read := func(id uint64) string {
+		t.Helper()
+		raw, err := ReadIncDataFromFileAsync_ById(table, id, entrySize)
+		if err != nil {
+			t.Fatalf("read %d: %v", id, err)
+		}
+		dec, err := encoding_v1.DecodeIncEntry(entrySize, raw)
+		if err != nil {
+			t.Fatalf("decode %d: %v", id, err)
+		}
+		payload, err := ResolveIncPayload(table, dec)
+		if err != nil {
+			t.Fatalf("resolve %d: %v", id, err)
+		}
+		return string(payload)
+	}
+
+	big := func(c byte) []byte { return bytes.Repeat([]byte{c}, 100) }
+	save([]byte("small"))
+	save(big('a'))
+	save(big('b'))
+	if got := read(1); got != string(big('a')) {
+		t.Fatalf("overflow payload mismatch: %q", got)
+	}
+	if ovfSize() != 200 {
+		t.Fatalf("expected 200 overflow bytes, got %d", ovfSize())
+	}
+
+	// filtr widzi pełny payload, nie referencję
+	rng, err := ReadIncDataFromFileAsync_Filter(table, 0, 10, 100, entrySize, "bottom", func(data []byte) bool {
+		return bytes.Contains(data, []byte("bbbb"))
+	})
+	if err != nil || len(rng.IDs) != 1 || rng.IDs[0] != 2 {
+		t.Fatalf("filter on overflow payload: ids=%v err=%v", rng.IDs, err)
+	}
+
+	// usunięcie i nadpisanie zwalniają bloki, kolejne payloady je reużywają
+	if _, _, err := DeleteIncEntry(table, entrySize, 1, "bottom"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	save(big('c'))
+	rec, _, _ := EncodeIncPayload(table, entrySize, []byte("inline"))
+	if _, err := SaveIncDataToFileAsync_OverWrite(rec, table, entrySize, 2, "bottom", 0); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	save(big('d'))
+	if ovfSize() != 200 {
+		t.Fatalf("freed overflow blocks were not reused, file has %d bytes", ovfSize())
+	}
+	if read(3) != string(big('c')) || read(4) != string(big('d')) || read(2) != "inline" {
+		t.Fatalf("unexpected entries after reuse: %q %q %q", read(2), read(3), read(4))
+	}
+
+	// zmniejszenie entry size przenosi za duże wpisy do overflow
+	save([]byte(strings.Repeat("e", 20)))
+	if _, err := ResizeIncTable(table, entrySize, 16); err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	entrySize = 16
+	if read(5) != strings.Repeat("e", 20) || read(3) != string(big('c')) || read(0) != "small" {
+		t.Fatalf("unexpected entries after resize: %q %q %q", read(5), read(3), read(0))
+	}
+	if _, err := ResizeIncTable(table, entrySize, 8); !errors.Is(err, ErrIncEntryTooLarge) {
+		t.Fatalf("shrinking below the overflow reference should fail, got %v", err)
+	}
+	if _, _, err := EncodeIncPayload(table, 8, big('x')); !errors.Is(err, ErrIncOverflowUnsupported) {
+		t.Fatalf("expected ErrIncOverflowUnsupported, got %v", err)
+	}
+
+	// usunięcie tabeli oddaje wszystkie bloki overflow
+	size := ovfSize()
+	if err := DeleteIncTableFile(table, entrySize); err != nil {
+		t.Fatalf("delete table: %v", err)
+	}
+	for _, c := range []byte("cd") {
+		save(big(c))
+	}
+	save([]byte(strings.Repeat("f", 20)))
+	if ovfSize() != size {
+		t.Fatalf("overflow file grew after table delete: %d -> %d", size, ovfSize())
+	}
+}
+
+func TestIncTableTimeRange(t *testing.T) {
+	setupDataManagerTest(t)
+	base := time.Date(2025, 1, 2, 18, 0, 0, 0, time.UTC)
+	clock := base
+	incNow = func() time.Time { return clock }
+	t.Cleanup(func() { incNow = time.Now })
+
+	table := "inc_time_test.tbl"
+	entrySize := uint64(8)
+	at := func(min int) int64 { return base.Add(time.Duration(min) * time.Minute).UnixNano() }
+	for i := 0; i < 10; i++ {
+		clock = base.Add(time.Duration(i) * time.Minute)
+		if _, err := SaveIncDataToFileAsync(encoding_v1.EncodeIncEntry(entrySize, []byte(fmt.Sprint(i))), table, entrySize, 0); err != nil {
+			t.Fatalf("save %d: %v", i, err)
+		}
+	}
+	// czas podany przez klienta
+	if _, err := SaveIncDataToFileAsync(encoding_v1.EncodeIncEntry(entrySize, []byte("late")), table, entrySize, at(30)); err != nil {
+		t.Fatalf("save with ts: %v", err)
+	}
+	if _, _, err := DeleteIncEntry(table, entrySize, 4, "bottom"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	query := func(from, to int64, start, amount uint64, countFrom string) IncRange {
+		t.Helper()
+		rng, err := ReadIncDataFromFileAsync_TimeRange(table, from, to, start, amount, entrySize, countFrom)
+		if err != nil {
+			t.Fatalf("time range: %v", err)
+		}
+		if len(rng.Times) != len(rng.IDs) {
+			t.Fatalf("times/ids mismatch: %d vs %d", len(rng.Times), len(rng.IDs))
+		}
+		return rng
+	}
+
+	// [18:02, 18:07) bez usuniętego 4, stronicowanie po 2
+	var got []uint64
+	rng := query(at(2), at(7), 0, 2, "bottom")
+	for {
+		got = append(got, rng.IDs...)
+		if !rng.HasMore {
+			break
+		}
+		rng = query(at(2), at(7), rng.Next, 2, "bottom")
+	}
+	if fmt.Sprint(got) != "[2 3 5 6]" {
+		t.Fatalf("bottom pages: %v", got)
+	}
+	if rng := query(at(2), at(7), 0, 10, "top"); fmt.Sprint(rng.IDs) != "[6 5 3 2]" || rng.Times[0] != at(6) {
+		t.Fatalf("top: %v %v", rng.IDs, rng.Times)
+	}
+	if rng := query(at(9), 0, 0, 10, "bottom"); fmt.Sprint(rng.IDs) != "[9 10]" || rng.Times[1] != at(30) || rng.HasMore {
+		t.Fatalf("open end: %v %v more=%v", rng.IDs, rng.Times, rng.HasMore)
+	}
+	if rng := query(at(31), 0, 0, 10, "bottom"); len(rng.IDs) != 0 || rng.HasMore {
+		t.Fatalf("after last entry: %v", rng.IDs)
+	}
+
+	// overwrite zachowuje czas wpisu, chyba że podano nowy
+	clock = base.Add(time.Hour)
+	if _, err := SaveIncDataToFileAsync_OverWrite(encoding_v1.EncodeIncEntry(entrySize, []byte("x")), table, entrySize, 1, "bottom", 0); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	if _, ts, err := ReadIncEntryFromFileAsync_ById(table, 1, entrySize); err != nil || ts != at(1) {
+		t.Fatalf("overwrite changed timestamp: %v %v", time.Unix(0, ts), err)
+	}
+	if _, err := SaveIncDataToFileAsync_OverWrite(encoding_v1.EncodeIncEntry(entrySize, []byte("y")), table, entrySize, 1, "bottom", at(45)); err != nil {
+		t.Fatalf("overwrite with ts: %v", err)
+	}
+	if _, ts, _ := ReadIncEntryFromFileAsync_ById(table, 1, entrySize); ts != at(45) {
+		t.Fatalf("timestamp not replaced: %v", time.Unix(0, ts))
+	}
+
+	// czasy nie rosną już z id (1 ma 18:45) - time range czyta liniowo zamiast szukać binarnie
+	if rng := query(at(40), at(50), 0, 10, "bottom"); fmt.Sprint(rng.IDs) != "[1]" {
+		t.Fatalf("out of order bottom: %v", rng.IDs)
+	}
+	if rng := query(at(8), at(50), 0, 10, "top"); fmt.Sprint(rng.IDs) != "[10 9 8 1]" {
+		t.Fatalf("out of order top: %v", rng.IDs)
+	}
+
+	// znaczniki przetrwają ponowne otwarcie
+	shutdownFileWorkersForTests()
+	if rng := query(at(5), at(6), 0, 10, "bottom"); fmt.Sprint(rng.IDs) != "[5]" || rng.Times[0] != at(5) {
+		t.Fatalf("after reopen: %v %v", rng.IDs, rng.Times)
+	}
+
+	// utracony zapis .ts (awaria przed zapisem na dysk) - czasy odtworzone z logu mapy
+	shutdownFileWorkersForTests()
+	if err := os.Truncate(incTimesPath(filepath.Join(baseIncTablesPath, table)), 0); err != nil {
+		t.Fatalf("truncate times: %v", err)
+	}
+	if _, ts, _ := ReadIncEntryFromFileAsync_ById(table, 1, entrySize); ts != at(45) {
+		t.Fatalf("timestamp not recovered from map log: %v", time.Unix(0, ts))
+	}
+	if rng := query(at(2), at(7), 0, 10, "bottom"); fmt.Sprint(rng.IDs) != "[2 3 5 6]" {
+		t.Fatalf("time range after recovery: %v", rng.IDs)
+	}
+}
+
+func TestIncTableTouchFailureKeepsTime(t *testing.T) {
+	setupDataManagerTest(t)
+	table := "inc_touch_fail_test.tbl"
+	entrySize := uint64(8)
+	recordSize := int64(entrySize) + 3
+	if _, err := SaveIncDataToFileAsync(encoding_v1.EncodeIncEntry(entrySize, []byte("a")), table, entrySize, 1_700_000_000_000_000_000); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	shutdownFileWorkersForTests()
+
+	full := filepath.Join(baseIncTablesPath, table)
+	file, err := os.OpenFile(full, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer file.Close()
+	tbl, err := openIncTable(full, file, recordSize)
+	if err != nil {
+		t.Fatalf("open table: %v", err)
+	}
+	defer tbl.close()
+
+	// przywracanie czasu po nieudanym "ożywieniu" - 0 (wpis bez czasu) nie zamienia się na teraz
+	if err := tbl.setSlotTime(0, 0); err != nil {
+		t.Fatalf("set slot time: %v", err)
+	}
+	if ts, _ := tbl.slotTime(0); ts != 0 {
+		t.Fatalf("restored zero time replaced with %d", ts)
+	}
+	if err := tbl.setSlotTime(0, 1_700_000_000_000_000_000); err != nil {
+		t.Fatalf("set slot time: %v", err)
+	}
+
+	// log mapy odrzuca zapis - .ts nie może zostać z nowym czasem
+	tbl.log.Close()
+	if err := tbl.touch(0, 1_800_000_000_000_000_000); err == nil {
+		t.Fatalf("touch should fail with closed map log")
+	}
+	if ts, err := tbl.slotTime(0); err != nil || ts != 1_700_000_000_000_000_000 {
+		t.Fatalf("time changed after failed touch: %d %v", ts, err)
+	}
+}
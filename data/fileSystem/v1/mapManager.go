@@ -81,6 +81,10 @@ type tableIndex struct {
 	walSyncCond      *sync.Cond
 	walSyncRequested int64
 	walSyncCompleted int64
+
+	// zużycie tabeli (quota): liczba kluczy i suma rozmiarów wpisów
+	keyCount  int64
+	byteCount int64
 }
 
 type cachedIndex struct {
@@ -316,6 +320,12 @@ func (ti *tableIndex) storeKey(k string, v entry) (entry, bool) {
 	prev, existed := s.m[k]
 	s.m[k] = v
 	s.mu.Unlock()
+	if existed {
+		atomic.AddInt64(&ti.byteCount, int64(v.end-v.start)-int64(prev.end-prev.start))
+	} else {
+		atomic.AddInt64(&ti.keyCount, 1)
+		atomic.AddInt64(&ti.byteCount, int64(v.end-v.start))
+	}
 	return prev, existed
 }
 
@@ -330,6 +340,10 @@ func (ti *tableIndex) deleteKey(k string) (entry, bool) {
 	prev, existed := s.m[k]
 	delete(s.m, k)
 	s.mu.Unlock()
+	if existed {
+		atomic.AddInt64(&ti.keyCount, -1)
+		atomic.AddInt64(&ti.byteCount, -int64(prev.end-prev.start))
+	}
 	return prev, existed
 }
 
@@ -675,6 +689,8 @@ func ResetForTests() {
 			s.mu.Unlock()
 		}
 		ti.regexCache = sync.Map{}
+		atomic.StoreInt64(&ti.keyCount, 0)
+		atomic.StoreInt64(&ti.byteCount, 0)
 	}
 
 	lastIndexCache.Store((*cachedIndex)(nil))
@@ -749,3 +765,12 @@ func GetKeysByRegex(table, pattern string, max int) ([]string, error) {
 	}
 	return idx.keysByRegex(pattern, max)
 }
+
+// TableUsage zwraca liczbę kluczy i łączny rozmiar (zakodowanych) danych tabeli.
+func TableUsage(table string) (keys int64, bytes int64, err error) {
+	idx, err := getTableIndex(table)
+	if err != nil {
+		return 0, 0, err
+	}
+	return atomic.LoadInt64(&idx.keyCount), atomic.LoadInt64(&idx.byteCount), nil
+}
@@ -1,32 +1,82 @@
 package incindex
 
 import (
-	"encoding/json"
 	"errors"
-	"os"
+	"log"
 	"path/filepath"
 	"sync"
 )
 
 const baseDir = "./db/inc_tables"
 
+// DefaultName - nazwa klucza ustawianego headerem entry_key.
+const DefaultName = "entry_key"
+
 var (
 	ErrDuplicateKey = errors.New("incindex: duplicate entry_key")
+	ErrCorrupt      = errors.New("incindex: entry missing from positions")
+)
+
+// DuplicateKeyError - klucz Name zajęty przez inny wpis albo trwający zapis (errors.Is(err, ErrDuplicateKey)).
+type DuplicateKeyError struct {
+	Name string
+}
+
+func (e *DuplicateKeyError) Error() string { return e.Name + " already exists" }
+
+func (e *DuplicateKeyError) Is(target error) bool { return target == ErrDuplicateKey }
+
+/*
+Indeks kluczy wpisów inc table (entry_key + dowolne nazwane klucze dodatkowe).
+
+Pozycje wpisów trzymane są w kawałkach (chunk) jak incMap w dataManager_v2, a lookup
+wskazuje na *entry, nie na numer pozycji - insert nie musi przeliczać pozycji kolejnych
+wpisów. Pozycja wpisu liczona jest przy odczycie (suma długości wcześniejszych chunków).
+
+Każda zmiana to jedna linia w logu <table>.idxlog; co jakiś czas cały indeks zapisywany jest
+jako checkpoint <table>.idx (patrz store.go).
+*/
+
+const (
+	chunkMax  = 512
+	chunkHalf = chunkMax / 2
 )
 
+type entry struct {
+	keys  map[string]string // name -> value
+	chunk *chunk
+}
+
+type chunk struct {
+	entries []*entry // nil = pozycja bez kluczy
+}
+
 type tableIndex struct {
-	mu        sync.RWMutex
-	path      string
-	keys      []string
-	positions map[string]uint64
+	mu      sync.RWMutex
+	path    string
+	chunks  []*chunk
+	length  uint64                       // pozycje za length nie mają kluczy
+	lookup  map[string]map[string]*entry // name -> value -> entry
+	keyed   int                          // liczba wpisów z kluczami
+	trimmed uint64                       // pozycje < trimmed obcięte przez retencję (bez kluczy)
+	// name -> value -> klucze trwających zapisów (ReserveKeys), tylko w pamięci
+	reserved map[string]map[string]struct{}
+	// indeks porównany z mapą inc table po wczytaniu (Reconcile)
+	reconciled bool
+
+	store
 }
 
 var (
 	indices sync.Map // map[string]*tableIndex
 )
 
-type diskPayload struct {
-	Keys []string `json:"keys"`
+func newTableIndex(path string) *tableIndex {
+	return &tableIndex{
+		path:     path,
+		lookup:   make(map[string]map[string]*entry),
+		reserved: make(map[string]map[string]struct{}),
+	}
 }
 
 func getIndex(tableFile string) (*tableIndex, error) {
@@ -34,89 +84,227 @@ func getIndex(tableFile string) (*tableIndex, error) {
 		return v.(*tableIndex), nil
 	}
 
-	idx := &tableIndex{
-		path:      filepath.Join(baseDir, tableFile+".idx"),
-		keys:      make([]string, 0, 128),
-		positions: make(map[string]uint64),
-	}
-
+	idx := newTableIndex(filepath.Join(baseDir, tableFile+".idx"))
 	if err := idx.load(); err != nil {
 		return nil, err
 	}
 
-	actual, _ := indices.LoadOrStore(tableFile, idx)
+	actual, loaded := indices.LoadOrStore(tableFile, idx)
+	if loaded {
+		idx.closeLog()
+	}
 	return actual.(*tableIndex), nil
 }
 
-func (t *tableIndex) load() error {
-	if err := os.MkdirAll(baseDir, 0o755); err != nil {
-		return err
-	}
+// --- sekwencja pozycji ---
 
-	data, err := os.ReadFile(t.path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
+func (t *tableIndex) at(pos uint64) (*chunk, int) {
+	for _, c := range t.chunks {
+		if pos < uint64(len(c.entries)) {
+			return c, int(pos)
 		}
-		return err
+		pos -= uint64(len(c.entries))
 	}
-	if len(data) == 0 {
+	return nil, -1
+}
+
+func (t *tableIndex) entryAt(pos uint64) *entry {
+	if pos >= t.length {
 		return nil
 	}
+	c, off := t.at(pos)
+	return c.entries[off]
+}
 
-	var payload diskPayload
-	if err := json.Unmarshal(data, &payload); err != nil {
-		return err
+// position - pozycja wpisu; false = wpisu nie ma w swoim chunku (indeks niespójny).
+func (t *tableIndex) position(e *entry) (uint64, bool) {
+	base := uint64(0)
+	for _, c := range t.chunks {
+		if c == e.chunk {
+			for off, other := range c.entries {
+				if other == e {
+					return base + uint64(off), true
+				}
+			}
+			return 0, false
+		}
+		base += uint64(len(c.entries))
 	}
+	return 0, false
+}
 
-	t.keys = append(t.keys[:0], payload.Keys...)
-	t.positions = make(map[string]uint64, len(payload.Keys))
-	for i, key := range payload.Keys {
-		if key == "" {
-			continue
+func (t *tableIndex) ensureLength(length uint64) {
+	for t.length < length {
+		if len(t.chunks) == 0 || len(t.chunks[len(t.chunks)-1].entries) >= chunkMax {
+			t.chunks = append(t.chunks, &chunk{entries: make([]*entry, 0, chunkMax)})
+		}
+		c := t.chunks[len(t.chunks)-1]
+		n := uint64(chunkMax - len(c.entries))
+		if n > length-t.length {
+			n = length - t.length
 		}
-		t.positions[key] = uint64(i)
+		c.entries = append(c.entries, make([]*entry, n)...)
+		t.length += n
 	}
-	return nil
 }
 
-func (t *tableIndex) saveLocked() error {
-	payload := diskPayload{Keys: t.keys}
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
+// insertAt wstawia pustą pozycję pos; kolejne pozycje przesuwają się o 1.
+func (t *tableIndex) insertAt(pos uint64) {
+	if pos >= t.length {
+		// za ostatnim kluczem nie ma czego przesuwać
+		return
 	}
+	var ci int
+	var off int
+	base := uint64(0)
+	for i, c := range t.chunks {
+		if pos < base+uint64(len(c.entries)) {
+			ci, off = i, int(pos-base)
+			break
+		}
+		base += uint64(len(c.entries))
+	}
+	c := t.chunks[ci]
+	c.entries = append(c.entries, nil)
+	copy(c.entries[off+1:], c.entries[off:])
+	c.entries[off] = nil
+	t.length++
+
+	if len(c.entries) > chunkMax {
+		right := &chunk{entries: make([]*entry, len(c.entries)-chunkHalf, chunkMax)}
+		copy(right.entries, c.entries[chunkHalf:])
+		c.entries = c.entries[:chunkHalf]
+		for _, e := range right.entries {
+			if e != nil {
+				e.chunk = right
+			}
+		}
+		t.chunks = append(t.chunks, nil)
+		copy(t.chunks[ci+2:], t.chunks[ci+1:])
+		t.chunks[ci+1] = right
+	}
+}
 
-	tmp := t.path + ".tmp"
-	if err := os.WriteFile(tmp, data, 0o644); err != nil {
-		return err
+func (t *tableIndex) owner(name, value string) (*entry, bool) {
+	e, ok := t.lookup[name][value]
+	return e, ok
+}
+
+func (t *tableIndex) isReserved(name, value string) bool {
+	_, ok := t.reserved[name][value]
+	return ok
+}
+
+// setKey ustawia klucz name=value na pozycji pos (poprzednia wartość tej nazwy jest usuwana).
+func (t *tableIndex) setKey(pos uint64, name, value string) {
+	t.ensureLength(pos + 1)
+	c, off := t.at(pos)
+	e := c.entries[off]
+	if e == nil {
+		e = &entry{keys: make(map[string]string, 1), chunk: c}
+		c.entries[off] = e
+		t.keyed++
+	}
+	if old, ok := e.keys[name]; ok {
+		delete(t.lookup[name], old)
 	}
-	return os.Rename(tmp, t.path)
+	e.keys[name] = value
+	if t.lookup[name] == nil {
+		t.lookup[name] = make(map[string]*entry)
+	}
+	t.lookup[name][value] = e
 }
 
-func (t *tableIndex) ensureLength(length uint64) {
-	for uint64(len(t.keys)) < length {
-		t.keys = append(t.keys, "")
+// unsetKey usuwa klucz name z pozycji pos.
+func (t *tableIndex) unsetKey(pos uint64, name string) {
+	e := t.entryAt(pos)
+	if e == nil {
+		return
+	}
+	if old, ok := e.keys[name]; ok {
+		delete(t.lookup[name], old)
+		delete(e.keys, name)
+	}
+	if len(e.keys) == 0 {
+		t.clearAt(pos)
 	}
 }
 
-func (t *tableIndex) reindexFrom(pos uint64) {
-	if pos > uint64(len(t.keys)) {
-		pos = uint64(len(t.keys))
+// clearAt usuwa wszystkie klucze z pozycji pos.
+func (t *tableIndex) clearAt(pos uint64) map[string]string {
+	if pos >= t.length {
+		return nil
 	}
-	for i := int(pos); i < len(t.keys); i++ {
-		key := t.keys[i]
-		if key == "" {
-			continue
+	c, off := t.at(pos)
+	e := c.entries[off]
+	if e == nil {
+		return nil
+	}
+	for name, value := range e.keys {
+		delete(t.lookup[name], value)
+	}
+	c.entries[off] = nil
+	t.keyed--
+	return e.keys
+}
+
+// clearBefore usuwa klucze z pozycji [trimmed, pos) - początek tabeli obcięty przez retencję.
+func (t *tableIndex) clearBefore(pos uint64) {
+	t.eachBetween(t.trimmed, pos, func(c *chunk, off int) {
+		for name, value := range c.entries[off].keys {
+			delete(t.lookup[name], value)
 		}
-		t.positions[key] = uint64(i)
+		c.entries[off] = nil
+		t.keyed--
+	})
+	if pos > t.trimmed {
+		t.trimmed = pos
 	}
 }
 
+// clearFrom usuwa klucze z pozycji >= pos (Reconcile).
+func (t *tableIndex) clearFrom(pos uint64) {
+	t.eachBetween(pos, t.length, func(c *chunk, off int) {
+		for name, value := range c.entries[off].keys {
+			delete(t.lookup[name], value)
+		}
+		c.entries[off] = nil
+		t.keyed--
+	})
+}
+
+// eachBetween woła fn dla pozycji z kluczami z zakresu [from, to).
+func (t *tableIndex) eachBetween(from, to uint64, fn func(c *chunk, off int)) {
+	base := uint64(0)
+	for _, c := range t.chunks {
+		if base >= to {
+			return
+		}
+		clen := uint64(len(c.entries))
+		if base+clen > from {
+			for off := range c.entries {
+				if pos := base + uint64(off); pos >= from && pos < to && c.entries[off] != nil {
+					fn(c, off)
+				}
+			}
+		}
+		base += clen
+	}
+}
+
+// --- API ---
+
+// Insert wstawia pozycję pos z kluczem entry_key (key może być pusty - samo przesunięcie pozycji).
 func Insert(tableFile string, pos uint64, key string) error {
-	if key == "" {
-		return nil
+	keys := map[string]string{}
+	if key != "" {
+		keys[DefaultName] = key
 	}
+	return InsertKeys(tableFile, pos, keys)
+}
+
+// InsertKeys wstawia pozycję pos (kolejne wpisy przesuwają się o 1) z kluczami name -> value.
+func InsertKeys(tableFile string, pos uint64, keys map[string]string) error {
 	idx, err := getIndex(tableFile)
 	if err != nil {
 		return err
@@ -125,19 +313,103 @@ func Insert(tableFile string, pos uint64, key string) error {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	if _, exists := idx.positions[key]; exists {
-		return ErrDuplicateKey
+	for name, value := range keys {
+		if idx.isReserved(name, value) {
+			return ErrDuplicateKey
+		}
+	}
+	return idx.insertKeys(pos, keys)
+}
+
+func (t *tableIndex) insertKeys(pos uint64, keys map[string]string) error {
+	for name, value := range keys {
+		if _, exists := t.owner(name, value); exists {
+			return ErrDuplicateKey
+		}
 	}
+	if pos >= t.length && !hasKeys(keys) {
+		return nil
+	}
+	return t.apply(logOp{Op: opInsert, Pos: pos, Keys: keys})
+}
 
-	idx.ensureLength(pos)
-	idx.keys = append(idx.keys, "")
-	copy(idx.keys[pos+1:], idx.keys[pos:])
-	idx.keys[pos] = key
-	idx.reindexFrom(pos)
-	return idx.saveLocked()
+// Reservation - klucze zarezerwowane przez ReserveKeys dla wpisu, który jeszcze nie jest zapisany.
+type Reservation struct {
+	idx  *tableIndex
+	keys map[string]string
+	done bool
 }
 
+// ReserveKeys rezerwuje klucze wpisu przed zapisem danych: równoległy zapis z tym samym kluczem
+// dostaje ErrDuplicateKey, zanim cokolwiek zapisze. Po zapisie wpisu Insert wstawia pozycję
+// z kluczami, przy błędzie zapisu Release zwalnia rezerwację (po Insert nic nie robi).
+func ReserveKeys(tableFile string, keys map[string]string) (*Reservation, error) {
+	idx, err := getIndex(tableFile)
+	if err != nil {
+		return nil, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for name, value := range keys {
+		if _, exists := idx.owner(name, value); exists || idx.isReserved(name, value) {
+			return nil, &DuplicateKeyError{Name: name}
+		}
+	}
+	for name, value := range keys {
+		if value == "" {
+			continue
+		}
+		if idx.reserved[name] == nil {
+			idx.reserved[name] = make(map[string]struct{})
+		}
+		idx.reserved[name][value] = struct{}{}
+	}
+	return &Reservation{idx: idx, keys: keys}, nil
+}
+
+// Insert wstawia pozycję pos z zarezerwowanymi kluczami (jak InsertKeys) i kończy rezerwację.
+func (r *Reservation) Insert(pos uint64) error {
+	r.idx.mu.Lock()
+	defer r.idx.mu.Unlock()
+	r.releaseLocked()
+	return r.idx.insertKeys(pos, r.keys)
+}
+
+// Release zwalnia klucze, które nie zostały wstawione.
+func (r *Reservation) Release() {
+	r.idx.mu.Lock()
+	defer r.idx.mu.Unlock()
+	r.releaseLocked()
+}
+
+func (r *Reservation) releaseLocked() {
+	if r.done {
+		return
+	}
+	r.done = true
+	for name, value := range r.keys {
+		delete(r.idx.reserved[name], value)
+	}
+}
+
+func hasKeys(keys map[string]string) bool {
+	for _, v := range keys {
+		if v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Set ustawia entry_key wpisu na pozycji pos (overwrite).
 func Set(tableFile string, pos uint64, key string) error {
+	return SetKey(tableFile, pos, DefaultName, key)
+}
+
+// SetKey ustawia klucz name wpisu na pozycji pos.
+func SetKey(tableFile string, pos uint64, name, key string) error {
 	if key == "" {
 		return nil
 	}
@@ -149,41 +421,74 @@ func Set(tableFile string, pos uint64, key string) error {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	idx.ensureLength(pos + 1)
-
-	current := idx.keys[pos]
-	if current == key {
-		idx.positions[key] = pos
-		return idx.saveLocked()
+	if e, exists := idx.owner(name, key); exists {
+		owner, ok := idx.position(e)
+		if !ok {
+			return ErrCorrupt
+		}
+		if owner == pos {
+			return nil
+		}
+		return ErrDuplicateKey
 	}
-
-	if _, exists := idx.positions[key]; exists {
+	if idx.isReserved(name, key) {
 		return ErrDuplicateKey
 	}
+	return idx.apply(logOp{Op: opSet, Pos: pos, Name: name, Key: key})
+}
+
+func Lookup(tableFile, key string) (uint64, bool, error) {
+	return LookupKey(tableFile, DefaultName, key)
+}
 
-	if current != "" {
-		delete(idx.positions, current)
+// LookupKey zwraca pozycję wpisu z kluczem name=key.
+func LookupKey(tableFile, name, key string) (uint64, bool, error) {
+	idx, err := getIndex(tableFile)
+	if err != nil {
+		return 0, false, err
 	}
 
-	idx.keys[pos] = key
-	idx.positions[key] = pos
-	return idx.saveLocked()
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	e, ok := idx.owner(name, key)
+	if !ok {
+		return 0, false, nil
+	}
+	pos, ok := idx.position(e)
+	if !ok {
+		return 0, false, ErrCorrupt
+	}
+	return pos, true, nil
 }
 
-func Lookup(tableFile, key string) (uint64, bool, error) {
+// KeysAt zwraca klucze wpisu na pozycji pos (nil, gdy brak).
+func KeysAt(tableFile string, pos uint64) (map[string]string, error) {
 	idx, err := getIndex(tableFile)
 	if err != nil {
-		return 0, false, err
+		return nil, err
 	}
 
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
-	pos, ok := idx.positions[key]
-	return pos, ok, nil
+	e := idx.entryAt(pos)
+	if e == nil {
+		return nil, nil
+	}
+	out := make(map[string]string, len(e.keys))
+	for name, value := range e.keys {
+		out[name] = value
+	}
+	return out, nil
 }
 
 func Remove(tableFile, key string) error {
+	return RemoveKey(tableFile, DefaultName, key)
+}
+
+// RemoveKey usuwa klucz name=key (pozostałe klucze wpisu zostają).
+func RemoveKey(tableFile, name, key string) error {
 	if key == "" {
 		return nil
 	}
@@ -195,34 +500,107 @@ func Remove(tableFile, key string) error {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	pos, ok := idx.positions[key]
+	e, ok := idx.owner(name, key)
 	if !ok {
 		return nil
 	}
+	pos, ok := idx.position(e)
+	if !ok {
+		return ErrCorrupt
+	}
+	return idx.apply(logOp{Op: opUnset, Pos: pos, Name: name})
+}
+
+// RemoveAt usuwa wszystkie klucze przypisane do pozycji pos (usunięty wpis). Zwraca usunięte klucze.
+func RemoveAt(tableFile string, pos uint64) (map[string]string, error) {
+	idx, err := getIndex(tableFile)
+	if err != nil {
+		return nil, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
 
-	delete(idx.positions, key)
-	if int(pos) < len(idx.keys) {
-		idx.keys[pos] = ""
+	e := idx.entryAt(pos)
+	if e == nil {
+		return nil, nil
 	}
-	return idx.saveLocked()
+	keys := e.keys
+	return keys, idx.apply(logOp{Op: opClear, Pos: pos})
 }
 
-func DropTable(tableFile string) error {
-	indices.Delete(tableFile)
-	path := filepath.Join(baseDir, tableFile+".idx")
-	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+// ClearBefore usuwa klucze wpisów o pozycjach < pos (obcięte przez retencję inc table).
+// Do logu trafia tylko zmiana, która faktycznie usuwa klucze.
+func ClearBefore(tableFile string, pos uint64) error {
+	idx, err := getIndex(tableFile)
+	if err != nil {
 		return err
 	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if pos <= idx.trimmed {
+		return nil
+	}
+	keyed := false
+	idx.eachBetween(idx.trimmed, pos, func(*chunk, int) { keyed = true })
+	if !keyed {
+		idx.trimmed = pos
+		return nil
+	}
+	return idx.apply(logOp{Op: opTrim, Pos: pos})
+}
+
+// Reconcile porównuje indeks wczytany z dysku z mapą inc table (raz, przy pierwszym użyciu tabeli).
+// count zwraca liczbę pozycji w mapie. Log indeksu jest dopisywany po logu mapy, więc awaria
+// między nimi może zostawić klucze na pozycjach, których mapa nie ma - wskazywałyby na cudzy
+// wpis dopisany później, dlatego są usuwane. Wpis, którego kluczy nie zdążono zapisać, zostaje bez kluczy.
+// count jest wołane pod lockiem indeksu, żeby żaden zapis kluczy nie wszedł między odczyt a porównanie.
+func Reconcile(tableFile string, count func() (uint64, error)) error {
+	idx, err := getIndex(tableFile)
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.reconciled {
+		return nil
+	}
+	n, err := count()
+	if err != nil {
+		return err
+	}
+	keyed := false
+	idx.eachBetween(n, idx.length, func(*chunk, int) { keyed = true })
+	if keyed {
+		if err := idx.apply(logOp{Op: opCut, Pos: n}); err != nil {
+			return err
+		}
+		log.Printf("incindex %s: removed keys past the last of %d entries", tableFile, n)
+	}
+	idx.reconciled = true
 	return nil
 }
 
+func DropTable(tableFile string) error {
+	if v, ok := indices.LoadAndDelete(tableFile); ok {
+		idx := v.(*tableIndex)
+		idx.mu.Lock()
+		idx.closeLog()
+		idx.mu.Unlock()
+	}
+	return removeFiles(filepath.Join(baseDir, tableFile+".idx"))
+}
+
 func ResetForTests() {
 	indices.Range(func(key, value any) bool {
 		idx := value.(*tableIndex)
 		idx.mu.Lock()
-		idx.keys = nil
-		idx.positions = make(map[string]uint64)
-		_ = os.Remove(idx.path)
+		idx.closeLog()
+		_ = removeFiles(idx.path)
 		idx.mu.Unlock()
 		indices.Delete(key)
 		return true
@@ -0,0 +1,247 @@
+package incindex
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setupIndexTest(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+	t.Cleanup(func() {
+		ResetForTests()
+		_ = os.Chdir(wd)
+	})
+}
+
+// reopen zapomina indeks w pamięci, następny dostęp wczytuje checkpoint + log.
+func reopen(tableFile string) {
+	if v, ok := indices.LoadAndDelete(tableFile); ok {
+		v.(*tableIndex).closeLog()
+	}
+}
+
+func mustLookup(t *testing.T, table, name, key string, want uint64) {
+	t.Helper()
+	pos, ok, err := LookupKey(table, name, key)
+	if err != nil || !ok || pos != want {
+		t.Fatalf("lookup %s=%s: pos=%d ok=%v err=%v (want %d)", name, key, pos, ok, err, want)
+	}
+}
+
+func TestInsertShiftsAndPersists(t *testing.T) {
+	setupIndexTest(t)
+	table := "t.tbl"
+
+	for i, key := range []string{"a", "b", "c"} {
+		if err := Insert(table, uint64(i), key); err != nil {
+			t.Fatalf("insert %s: %v", key, err)
+		}
+	}
+	// insert bez klucza też przesuwa kolejne pozycje
+	if err := Insert(table, 1, ""); err != nil {
+		t.Fatalf("insert empty: %v", err)
+	}
+	if err := InsertKeys(table, 0, map[string]string{DefaultName: "z", "email": "z@x"}); err != nil {
+		t.Fatalf("insert keys: %v", err)
+	}
+	if err := Insert(table, 5, "a"); err != ErrDuplicateKey {
+		t.Fatalf("expected duplicate, got %v", err)
+	}
+	if err := SetKey(table, 3, "email", "b@x"); err != nil {
+		t.Fatalf("set key: %v", err)
+	}
+
+	check := func() {
+		t.Helper()
+		mustLookup(t, table, DefaultName, "z", 0)
+		mustLookup(t, table, DefaultName, "a", 1)
+		mustLookup(t, table, DefaultName, "b", 3)
+		mustLookup(t, table, DefaultName, "c", 4)
+		mustLookup(t, table, "email", "z@x", 0)
+		mustLookup(t, table, "email", "b@x", 3)
+	}
+	check()
+	reopen(table)
+	check()
+
+	if keys, err := RemoveAt(table, 3); err != nil || keys["email"] != "b@x" {
+		t.Fatalf("remove at: %v %v", keys, err)
+	}
+	reopen(table)
+	mustLookup(t, table, DefaultName, "c", 4)
+	if _, ok, _ := Lookup(table, "b"); ok {
+		t.Fatalf("removed key still present")
+	}
+}
+
+func TestLogReplayAndCheckpoint(t *testing.T) {
+	setupIndexTest(t)
+	checkpointMin = 4
+	t.Cleanup(func() { checkpointMin = 1024 })
+	table := "t.tbl"
+	path := filepath.Join(baseDir, table+".idx")
+
+	for i, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if err := Insert(table, uint64(i), key); err != nil {
+			t.Fatalf("insert %s: %v", key, err)
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected checkpoint: %v", err)
+	}
+	reopen(table)
+
+	// niedokończona linia na końcu logu jest ignorowana
+	f, err := os.OpenFile(logPath(path), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	f.WriteString(`{"op":"insert","pos":0,"keys":{"entry_key":"tor`)
+	f.Close()
+	mustLookup(t, table, DefaultName, "f", 5)
+	if _, ok, _ := Lookup(table, "torn"); ok {
+		t.Fatalf("torn record replayed")
+	}
+	if err := Insert(table, 0, "g"); err != nil {
+		t.Fatalf("insert after torn log: %v", err)
+	}
+	reopen(table)
+	mustLookup(t, table, DefaultName, "g", 0)
+	mustLookup(t, table, DefaultName, "f", 6)
+
+	// log starszy niż checkpoint (awaria przed założeniem nowego logu) nie jest odtwarzany ponownie
+	if err := Insert(table, 0, ""); err != nil {
+		t.Fatalf("insert empty: %v", err)
+	}
+	idx, _ := getIndex(table)
+	idx.mu.Lock()
+	oldLog, _ := os.ReadFile(logPath(path))
+	if err := idx.checkpoint(); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	idx.mu.Unlock()
+	reopen(table)
+	os.WriteFile(logPath(path), oldLog, 0o644)
+	mustLookup(t, table, DefaultName, "g", 1)
+	mustLookup(t, table, DefaultName, "a", 2)
+}
+
+func TestLoadLegacyFormat(t *testing.T) {
+	setupIndexTest(t)
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	legacy := `{"keys":["a","","c"]}`
+	if err := os.WriteFile(filepath.Join(baseDir, "old.tbl.idx"), []byte(legacy), 0o644); err != nil {
+		t.Fatalf("write legacy: %v", err)
+	}
+	mustLookup(t, "old.tbl", DefaultName, "c", 2)
+	if err := Insert("old.tbl", 1, "b"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	reopen("old.tbl")
+	mustLookup(t, "old.tbl", DefaultName, "b", 1)
+	mustLookup(t, "old.tbl", DefaultName, "c", 3)
+}
+
+func TestReserveKeys(t *testing.T) {
+	setupIndexTest(t)
+	table := "r.tbl"
+	if err := Insert(table, 0, "taken"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	if _, err := ReserveKeys(table, map[string]string{DefaultName: "taken"}); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected duplicate for existing key, got %v", err)
+	}
+	res, err := ReserveKeys(table, map[string]string{DefaultName: "new"})
+	if err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	// zarezerwowany klucz jest zajęty dla innych zapisów
+	if _, err := ReserveKeys(table, map[string]string{DefaultName: "new"}); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected duplicate for reserved key, got %v", err)
+	}
+	if err := Insert(table, 1, "new"); err != ErrDuplicateKey {
+		t.Fatalf("expected insert of reserved key to fail, got %v", err)
+	}
+	if err := Set(table, 0, "new"); err != ErrDuplicateKey {
+		t.Fatalf("expected set of reserved key to fail, got %v", err)
+	}
+	if err := res.Insert(1); err != nil {
+		t.Fatalf("insert reserved: %v", err)
+	}
+	res.Release()
+	mustLookup(t, table, DefaultName, "new", 1)
+
+	// zwolniona rezerwacja (nieudany zapis) nie blokuje klucza
+	res, err = ReserveKeys(table, map[string]string{DefaultName: "retry"})
+	if err != nil {
+		t.Fatalf("reserve retry: %v", err)
+	}
+	res.Release()
+	if res, err := ReserveKeys(table, map[string]string{DefaultName: "retry"}); err != nil {
+		t.Fatalf("reserve after release: %v", err)
+	} else {
+		res.Release()
+	}
+}
+
+func TestReconcileDropsKeysPastMap(t *testing.T) {
+	setupIndexTest(t)
+	table := "c.tbl"
+	for i, key := range []string{"a", "b", "c"} {
+		if err := Insert(table, uint64(i), key); err != nil {
+			t.Fatalf("insert %s: %v", key, err)
+		}
+	}
+
+	// mapa ma 2 pozycje - wpis "c" nie trafił do logu mapy przed awarią
+	reopen(table)
+	if err := Reconcile(table, func() (uint64, error) { return 2, nil }); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if _, ok, _ := Lookup(table, "c"); ok {
+		t.Fatal("key past the map still indexed")
+	}
+	mustLookup(t, table, DefaultName, "b", 1)
+	// raz na wczytanie indeksu
+	if err := Reconcile(table, func() (uint64, error) { return 0, errors.New("called twice") }); err != nil {
+		t.Fatalf("second reconcile: %v", err)
+	}
+
+	reopen(table)
+	if _, ok, _ := Lookup(table, "c"); ok {
+		t.Fatal("cut not persisted")
+	}
+	if err := Insert(table, 2, "c"); err != nil {
+		t.Fatalf("reinsert c: %v", err)
+	}
+}
+
+func TestLookupReportsCorruptPosition(t *testing.T) {
+	setupIndexTest(t)
+	table := "p.tbl"
+	if err := Insert(table, 0, "a"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	idx, _ := getIndex(table)
+	e, _ := idx.owner(DefaultName, "a")
+	e.chunk = &chunk{}
+	if _, _, err := Lookup(table, "a"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	if err := Set(table, 1, "a"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt from set, got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package incindex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+/*
+Trwałość indeksu:
+
+	<table>.idx    - checkpoint (JSON): {"gen":G,"length":L,"trimmed":T,"entries":[{"pos":P,"keys":{"name":"value"}}]}
+	<table>.idxlog - log (JSON lines): pierwsza linia {"gen":G+1}, dalej po jednej operacji na linię
+
+Checkpoint o numerze G zawiera wszystkie operacje z logów o gen <= G, więc log, którego nagłówek
+ma gen <= G, jest przy otwarciu pomijany (awaria między zapisem checkpointu a założeniem nowego logu).
+Niepełna lub uszkodzona ostatnia linia logu (awaria w trakcie zapisu) jest obcinana.
+Stary format .idx ({"keys":[...]}) jest wczytywany jako klucze entry_key.
+*/
+
+const (
+	opInsert = "insert"
+	opSet    = "set"
+	opUnset  = "unset"
+	opClear  = "clear"
+	opTrim   = "trim" // usunięcie kluczy z pozycji < pos (retencja)
+	opCut    = "cut"  // usunięcie kluczy z pozycji >= pos (pozycje, których nie ma w mapie - Reconcile)
+)
+
+// checkpointMin - minimalna liczba operacji w logu przed checkpointem
+// (checkpoint po max(checkpointMin, liczba wpisów z kluczami) operacjach).
+var checkpointMin = 1024
+
+type logOp struct {
+	Op   string            `json:"op"`
+	Pos  uint64            `json:"pos"`
+	Name string            `json:"name,omitempty"`
+	Key  string            `json:"key,omitempty"`
+	Keys map[string]string `json:"keys,omitempty"`
+}
+
+type logHeader struct {
+	Gen uint64 `json:"gen"`
+}
+
+type checkpointEntry struct {
+	Pos  uint64            `json:"pos"`
+	Keys map[string]string `json:"keys"`
+}
+
+type checkpointPayload struct {
+	Gen     uint64            `json:"gen"`
+	Length  uint64            `json:"length"`
+	Trimmed uint64            `json:"trimmed,omitempty"`
+	Entries []checkpointEntry `json:"entries"`
+	Keys    []string          `json:"keys,omitempty"` // stary format
+}
+
+type store struct {
+	log     *os.File
+	logSize int64
+	logOps  int
+	gen     uint64
+}
+
+func logPath(path string) string { return path + "log" }
+
+func removeFiles(path string) error {
+	for _, p := range []string{path, logPath(path)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tableIndex) load() error {
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(t.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		var payload checkpointPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return fmt.Errorf("incindex %s: %w", t.path, err)
+		}
+		t.gen = payload.Gen
+		for i, key := range payload.Keys {
+			if key != "" {
+				t.setKey(uint64(i), DefaultName, key)
+			}
+		}
+		for _, e := range payload.Entries {
+			for name, value := range e.Keys {
+				t.setKey(e.Pos, name, value)
+			}
+		}
+		t.ensureLength(payload.Length)
+		t.trimmed = payload.Trimmed
+	}
+
+	return t.openLog()
+}
+
+// openLog odtwarza log (jeżeli jest nowszy niż checkpoint) i otwiera go do dopisywania.
+func (t *tableIndex) openLog() error {
+	path := logPath(t.path)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// brak logu albo log już zawarty w checkpoincie - nowy log zostanie założony przy pierwszej zmianie
+	nl := bytes.IndexByte(data, '\n')
+	var header logHeader
+	if nl < 0 || json.Unmarshal(data[:nl], &header) != nil || header.Gen <= t.gen {
+		return nil
+	}
+
+	valid := int64(nl + 1)
+	sc := bufio.NewScanner(bytes.NewReader(data[nl+1:]))
+	sc.Buffer(make([]byte, 0, 64*1024), len(data))
+	for sc.Scan() {
+		line := sc.Bytes()
+		// linia bez '\n' na końcu to niedokończony zapis
+		if valid+int64(len(line)) >= int64(len(data)) {
+			break
+		}
+		var op logOp
+		if err := json.Unmarshal(line, &op); err != nil || !t.replay(op) {
+			break
+		}
+		valid += int64(len(line)) + 1
+		t.logOps++
+	}
+
+	t.log, err = os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	if valid != int64(len(data)) {
+		if err := t.log.Truncate(valid); err != nil {
+			return err
+		}
+	}
+	t.logSize = valid
+	return nil
+}
+
+// resetLog zakłada pusty log o gen = gen checkpointu + 1.
+func (t *tableIndex) resetLog() error {
+	t.closeLog()
+	path := logPath(t.path)
+	header, _ := json.Marshal(logHeader{Gen: t.gen + 1})
+	header = append(header, '\n')
+
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, header); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	t.log = f
+	t.logSize = int64(len(header))
+	t.logOps = 0
+	return nil
+}
+
+func (t *tableIndex) closeLog() {
+	if t.log != nil {
+		t.log.Close()
+		t.log = nil
+	}
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replay wykonuje operację w pamięci; false = operacja niepoprawna (uszkodzony log).
+func (t *tableIndex) replay(op logOp) bool {
+	switch op.Op {
+	case opInsert:
+		for name, value := range op.Keys {
+			if _, exists := t.owner(name, value); exists && value != "" {
+				return false
+			}
+		}
+		t.insertAt(op.Pos)
+		for name, value := range op.Keys {
+			if value != "" {
+				t.setKey(op.Pos, name, value)
+			}
+		}
+	case opSet:
+		if _, exists := t.owner(op.Name, op.Key); exists {
+			return false
+		}
+		t.setKey(op.Pos, op.Name, op.Key)
+	case opUnset:
+		t.unsetKey(op.Pos, op.Name)
+	case opClear:
+		t.clearAt(op.Pos)
+	case opTrim:
+		t.clearBefore(op.Pos)
+	case opCut:
+		t.clearFrom(op.Pos)
+	default:
+		return false
+	}
+	return true
+}
+
+// apply dopisuje operację do logu, a dopiero potem zmienia indeks w pamięci.
+func (t *tableIndex) apply(op logOp) error {
+	if t.log == nil {
+		if err := t.resetLog(); err != nil {
+			return err
+		}
+	}
+	line, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := t.log.WriteAt(line, t.logSize); err != nil {
+		return err
+	}
+	t.logSize += int64(len(line))
+	t.logOps++
+	t.replay(op)
+
+	if t.logOps >= checkpointMin && t.logOps >= t.keyed {
+		return t.checkpoint()
+	}
+	return nil
+}
+
+// checkpoint zapisuje cały indeks (tylko pozycje z kluczami) i zaczyna nowy log.
+func (t *tableIndex) checkpoint() error {
+	payload := checkpointPayload{
+		Gen:     t.gen + 1,
+		Length:  t.length,
+		Trimmed: t.trimmed,
+		Entries: make([]checkpointEntry, 0, t.keyed),
+	}
+	pos := uint64(0)
+	for _, c := range t.chunks {
+		for _, e := range c.entries {
+			if e != nil {
+				payload.Entries = append(payload.Entries, checkpointEntry{Pos: pos, Keys: e.keys})
+			}
+			pos++
+		}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	tmp := t.path + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return err
+	}
+	t.gen++
+	return t.resetLog()
+}
@@ -13,7 +13,8 @@ Layout (total = entrySize + 3 bytes):
 byte 0:
   bit0 -> skipBit (1=skip/DELETED)
   bit1 -> nextEntryPointerBit (valid only if skipBit==1)
-  bit2..bit7 -> 0
+  bit2 -> overflowBit (valid only if skipBit==0): payload = referencja do pliku overflow
+  bit3..bit7 -> 0
 
 bytes 1 .. total-1:
   if skipBit == 1:
@@ -25,8 +26,14 @@ bytes 1 .. total-1:
      [pos+1..]  -> payload bytes (len<=entrySize)
 
 pos = total - len(payload) - 1
+
+overflow (payload większy niż entrySize, wymaga entrySize >= IncOverflowRefSize):
+  payload = [uint64 startPtr LE][uint64 endPtr LE] - zakres payloadu w pliku overflow tabeli
 */
 
+// IncOverflowRefSize - rozmiar referencji do payloadu zapisanego poza rekordem.
+const IncOverflowRefSize = 16
+
 // EncodeIncEntry koduje pojedynczy wpis inc-table do stałej długości (entrySize+3).
 // Jeśli body ma długość 0..entrySize -> skipBit=0, marker 0x01 przed danymi.
 // Jeśli chcesz trwale „usunąć” (skip), wywołaj z body=nil oraz skip=true (patrz SetSkipIncEntry).
@@ -55,6 +62,28 @@ func EncodeIncEntry(entrySize uint64, body []byte) []byte {
 	return buf
 }
 
+// EncodeIncOverflowEntry koduje rekord wskazujący na payload w pliku overflow ([startPtr, endPtr)).
+// Zwraca nil, gdy referencja nie mieści się w entrySize.
+func EncodeIncOverflowEntry(entrySize uint64, startPtr, endPtr int64) []byte {
+	if entrySize < IncOverflowRefSize {
+		return nil
+	}
+	ref := make([]byte, IncOverflowRefSize)
+	binary.LittleEndian.PutUint64(ref[0:8], uint64(startPtr))
+	binary.LittleEndian.PutUint64(ref[8:16], uint64(endPtr))
+	buf := EncodeIncEntry(entrySize, ref)
+	buf[0] |= 0b0000_0100
+	return buf
+}
+
+// IncOverflowRef zwraca zakres payloadu w pliku overflow; ok=false dla zwykłych i usuniętych wpisów.
+func IncOverflowRef(body types.IncTableBody) (startPtr, endPtr int64, ok bool) {
+	if body.SkipBit || !body.Overflow || len(body.Data) != IncOverflowRefSize {
+		return 0, 0, false
+	}
+	return int64(binary.LittleEndian.Uint64(body.Data[0:8])), int64(binary.LittleEndian.Uint64(body.Data[8:16])), true
+}
+
 // SetSkipIncEntry ustawia wpis jako „skip” (usunięty).
 // Jeżeli chcesz wskazać skok do następnego „nieskipowanego” wpisu, podaj nextEntryPointer>0.
 // Funkcja modyfikuje bufor zwrócony przez EncodeIncEntry (albo przygotowany zera/placeholder).
@@ -88,6 +117,7 @@ func DecodeIncEntry(entrySize uint64, raw []byte) (types.IncTableBody, error) {
 	first := raw[0]
 	skip := (first & 0b0000_0001) != 0
 	nextPtrBit := (first & 0b0000_0010) != 0
+	overflow := !skip && (first&0b0000_0100) != 0
 
 	if skip {
 		var ptr uint64
@@ -127,5 +157,19 @@ func DecodeIncEntry(entrySize uint64, raw []byte) (types.IncTableBody, error) {
 		EntrySize:        entrySize,
 		SkipBit:          false,
 		NextEntryPointer: 0,
+		Overflow:         overflow,
 	}, nil
 }
+
+// PeekIncEntry czyta tylko bajt sterujący wpisu (bez dekodowania danych).
+// next > 0 tylko gdy wpis jest skip i ma ustawiony nextEntryPointer.
+func PeekIncEntry(raw []byte) (skip bool, next uint64) {
+	if len(raw) == 0 {
+		return false, 0
+	}
+	skip = raw[0]&0b0000_0001 != 0
+	if skip && raw[0]&0b0000_0010 != 0 && len(raw) >= 9 {
+		next = binary.LittleEndian.Uint64(raw[1:9])
+	}
+	return skip, next
+}
@@ -3,6 +3,9 @@ package TsuClient
 import (
 	fileSystem_v1 "github.com/PAW122/TsunamiDB/data/fileSystem/v1"
 	export "github.com/PAW122/TsunamiDB/lib/export"
+	audit "github.com/PAW122/TsunamiDB/servers/audit"
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+	config "github.com/PAW122/TsunamiDB/servers/config"
 	debug "github.com/PAW122/TsunamiDB/servers/debug"
 	networkmanager "github.com/PAW122/TsunamiDB/servers/network-manager"
 	public_api_v1 "github.com/PAW122/TsunamiDB/servers/public-api/v1"
@@ -11,7 +14,9 @@ import (
 
 func Save(key, table string, data []byte) error {
 	defer debug.MeasureTime("[lib.dbclient] [save]")()
-	return export.Save(key, table, data)
+	err := export.Save(key, table, data)
+	logAudit("save", key, table, len(data), err)
+	return err
 }
 
 func Read(key, table string) ([]byte, error) {
@@ -21,12 +26,16 @@ func Read(key, table string) ([]byte, error) {
 
 func Free(key, table string) error {
 	defer debug.MeasureTime("[lib.dbclient] [free]")()
-	return export.Free(key, table)
+	err := export.Free(key, table)
+	logAudit("free", key, table, 0, err)
+	return err
 }
 
 func SaveEncrypted(key, table, encryption_key string, data []byte) error {
 	defer debug.MeasureTime("[lib.dbclient] [save-encrypted]")()
-	return export.SaveEncrypted(key, table, encryption_key, data)
+	err := export.SaveEncrypted(key, table, encryption_key, data)
+	logAudit("save_encrypted", key, table, len(data), err)
+	return err
 }
 
 func ReadEncrypted(key, table, encryption_key string) ([]byte, error) {
@@ -34,6 +43,24 @@ func ReadEncrypted(key, table, encryption_key string) ([]byte, error) {
 	return export.ReadEncrypted(key, table, encryption_key)
 }
 
+func logAudit(op, key, table string, size int, err error) {
+	audit.Log(audit.Record{
+		Identity: auth.LocalIdentity,
+		Source:   audit.SourceDBClient,
+		Table:    table,
+		Key:      key,
+		Op:       op,
+		Size:     int64(size),
+		Outcome:  audit.Outcome(err),
+	})
+}
+
+// LoadConfig wczytuje config.json (TLS itd.) przed uruchomieniem serwerów.
+func LoadConfig(path string) {
+	defer debug.Log("[lib.dbclient] [Load-Config]")
+	config.LoadConfig(path)
+}
+
 func InitNetworkManager(port int, knownPeers []string) {
 	defer debug.Log("[lib.dbclient] [Init-Network-Manager]")
 	go networkmanager.StartNetworkManager(port, knownPeers)
@@ -60,8 +87,34 @@ func EnableSubscription(keys []string) (string, error) {
 	return subServer.EnableSubscriptionInternal(keys)
 }
 
+// EnableTableSubscription - auth_key dla kluczy, prefiksów ("" = cała tabela) i regexów kluczy w tabeli.
+func EnableTableSubscription(table string, keys, prefixes, patterns []string) (string, error) {
+	defer debug.MeasureTime("[lib.dbclient] [EnableTableSubscription]")()
+	return subServer.EnableTableSubscriptionInternal(table, keys, prefixes, patterns)
+}
+
 func DisableSubscription(key string) error {
 	defer debug.MeasureTime("[lib.dbclient] [DisableSubscription]")()
 	_, error := subServer.DisableSubscriptionInternal(key)
 	return error
 }
+
+// DisableTableSubscription odłącza klucz tylko w podanej tabeli.
+func DisableTableSubscription(table, key string) error {
+	defer debug.MeasureTime("[lib.dbclient] [DisableTableSubscription]")()
+	_, err := subServer.DisableTableSubscriptionInternal(table, key)
+	return err
+}
+
+// Publish wysyła wiadomość subskrybentom kanału bez zapisu do bazy (presence, "pisze...").
+func Publish(channel string, payload []byte) error {
+	defer debug.MeasureTime("[lib.dbclient] [Publish]")()
+	_, err := subServer.PublishChannel(channel, payload)
+	return err
+}
+
+// RevokeSubscriptions unieważnia auth_key i zamyka połączenia danej identity.
+func RevokeSubscriptions(identity string) (int, int) {
+	defer debug.MeasureTime("[lib.dbclient] [RevokeSubscriptions]")()
+	return subServer.RevokeIdentity(identity)
+}
@@ -2,7 +2,12 @@ package TsuClient_test
 
 import (
 	"bytes"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -68,6 +73,55 @@ func TestClient_SaveReadEncrypted(t *testing.T) {
 	assert.Error(t, err, "oczekiwano błędu po usunięciu klucza")
 }
 
+func TestRemoteClient_SaveReadFree(t *testing.T) {
+	client, err := TsuClient.NewRemoteClient("http://127.0.0.1:5844", TsuClient.RemoteOptions{})
+	assert.NoError(t, err)
+
+	table := "test_table"
+	key := "remote_test_key"
+	data := []byte("remote payload")
+
+	assert.NoError(t, client.Save(key, table, data))
+
+	read, err := client.Read(key, table)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, read), "odczytane dane nie są zgodne z zapisanymi")
+
+	assert.NoError(t, client.Free(key, table))
+	_, err = client.Read(key, table)
+	assert.Error(t, err, "oczekiwano błędu po usunięciu klucza")
+}
+
+func TestRemoteClient_HTTPSWithCABundle(t *testing.T) {
+	var stored []byte
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			stored, _ = io.ReadAll(r.Body)
+			w.Write([]byte("save"))
+			return
+		}
+		w.Write(stored)
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(caFile, caPEM, 0o644))
+
+	// bez CA bundle certyfikat serwera nie jest zaufany
+	untrusted, err := TsuClient.NewRemoteClient(srv.URL, TsuClient.RemoteOptions{})
+	assert.NoError(t, err)
+	assert.Error(t, untrusted.Save("k", "t", []byte("x")))
+
+	client, err := TsuClient.NewRemoteClient(srv.URL, TsuClient.RemoteOptions{CAFile: caFile})
+	assert.NoError(t, err)
+	assert.NoError(t, client.Save("k", "t", []byte("over tls")))
+
+	read, err := client.Read("k", "t")
+	assert.NoError(t, err)
+	assert.Equal(t, "over tls", string(read))
+}
+
 // func TestClient_PersistenceAfterRestart(t *testing.T) {
 // 	table := "test_table"
 // 	key := "persist_test_key"
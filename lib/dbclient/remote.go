@@ -0,0 +1,141 @@
+package TsuClient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PAW122/TsunamiDB/errors"
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+// RemoteOptions configures a client talking to the Public API of another process.
+type RemoteOptions struct {
+	// CAFile - PEM bundle used to verify the server certificate (system roots when empty)
+	CAFile string
+	// CertFile / KeyFile - optional client certificate
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name used for certificate verification
+	ServerName string
+	// APIKey is sent as `api_key` header when the server has api_auth enabled
+	APIKey  string
+	Timeout time.Duration
+}
+
+// RemoteClient wraps the HTTP(S) Public API (/save, /read, /free).
+type RemoteClient struct {
+	BaseURL string
+	HTTP    *http.Client
+	APIKey  string
+}
+
+// NewRemoteClient creates a client for baseURL, e.g. "https://db.local:5844".
+func NewRemoteClient(baseURL string, opts RemoteOptions) (*RemoteClient, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if u.Scheme == "https" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: opts.ServerName}
+		if opts.CAFile != "" {
+			pool, err := config.LoadCAPool(opts.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if opts.CertFile != "" || opts.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("cannot load client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	return &RemoteClient{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    &http.Client{Transport: transport, Timeout: timeout},
+		APIKey:  opts.APIKey,
+	}, nil
+}
+
+func (c *RemoteClient) endpoint(op, table, key string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", c.BaseURL, op, url.PathEscape(table), url.PathEscape(key))
+}
+
+func (c *RemoteClient) do(req *http.Request) ([]byte, error) {
+	if c.APIKey != "" {
+		req.Header.Set("api_key", c.APIKey)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func (c *RemoteClient) Save(key, table string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.endpoint("save", table, key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
+func (c *RemoteClient) Read(key, table string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint("read", table, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+func (c *RemoteClient) Free(key, table string) error {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint("free", table, key), nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
+// Publish wysyła wiadomość subskrybentom kanału (POST /publish/<kanał>), bez zapisu do bazy.
+func (c *RemoteClient) Publish(channel string, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/publish/"+url.PathEscape(channel), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
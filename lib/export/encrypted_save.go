@@ -7,6 +7,7 @@ import (
 	defragmanager "github.com/PAW122/TsunamiDB/data/defragmentationManager"
 	fileSystem_v1 "github.com/PAW122/TsunamiDB/data/fileSystem/v1"
 	encoder_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+	limits "github.com/PAW122/TsunamiDB/servers/limits"
 	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
 )
 
@@ -18,6 +19,11 @@ func SaveEncrypted(key, table, encryption_key string, data []byte) error {
 	}
 
 	encoded, _ := encoder_v1.Encode(encrypted_data)
+	release, err := limits.ReserveSave(table, key, int64(len(encoded)))
+	if err != nil {
+		return err
+	}
+	defer release()
 
 	// save to file
 	startPtr, endPtr, err := dataManager_v2.SaveDataToFileAsync(encoded, table)
@@ -39,6 +45,6 @@ func SaveEncrypted(key, table, encryption_key string, data []byte) error {
 		}
 	}
 
-	go subServer.NotifySubscribers(key, data)
+	go subServer.NotifyEncryptedSubscribers(table, key, data)
 	return nil
 }
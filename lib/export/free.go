@@ -15,6 +15,6 @@ func Free(key, table string) error {
 	}
 	fileSystem_v1.RemoveElementByKey(table, key)
 	defragmentationManager.MarkAsFree(key, table, int64(fs_data.StartPtr), int64(fs_data.EndPtr))
-	go subServer.NotifyDeleteAndRemove(key)
+	go subServer.NotifyDeleteAndRemove(table, key)
 	return nil
 }
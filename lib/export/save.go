@@ -7,6 +7,7 @@ import (
 	defragManager "github.com/PAW122/TsunamiDB/data/defragmentationManager"
 	fileSystem_v1 "github.com/PAW122/TsunamiDB/data/fileSystem/v1"
 	encoder_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+	limits "github.com/PAW122/TsunamiDB/servers/limits"
 	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
 )
 
@@ -17,6 +18,11 @@ func Save(key, table string, data []byte) error {
 	}
 
 	encoded, _ := encoder_v1.Encode(data)
+	release, err := limits.ReserveSave(table, key, int64(len(encoded)))
+	if err != nil {
+		return err
+	}
+	defer release()
 	startPtr, endPtr, err := dataManager_v2.SaveDataToFileAsync(encoded, table)
 	if err != nil {
 		return err
@@ -33,6 +39,6 @@ func Save(key, table string, data []byte) error {
 			fileSystem_v1.RecordDefragSkip()
 		}
 	}
-	go subServer.NotifySubscribers(key, data)
+	go subServer.NotifySubscribers(table, key, data)
 	return nil
 }
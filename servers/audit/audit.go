@@ -0,0 +1,317 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+/*
+Append-only audit log operacji zmieniających dane.
+
+	./db/audit/audit-<unix_nano pierwszego wpisu>.log  (JSON lines)
+
+Pliki są tylko dopisywane (O_APPEND); retencja usuwa wyłącznie całe, zamknięte segmenty.
+*/
+
+const defaultSegmentBytes = 16 << 20
+
+// źródła operacji
+const (
+	SourceHTTP     = "http"
+	SourceDBClient = "dbclient"
+	SourceNetwork  = "network"
+)
+
+type Record struct {
+	Time     time.Time `json:"ts"`
+	Identity string    `json:"identity"`
+	Source   string    `json:"source"`
+	Table    string    `json:"table"`
+	Key      string    `json:"key"`
+	Op       string    `json:"op"`
+	Size     int64     `json:"size"`
+	Outcome  string    `json:"outcome"` // "ok" | "error: ..."
+}
+
+type segment struct {
+	path  string
+	start time.Time
+	size  int64
+}
+
+var (
+	baseDir = filepath.Join(".", "db", "audit")
+
+	mu       sync.Mutex
+	loaded   bool
+	current  *os.File
+	segments []segment // posortowane po start; ostatni = aktualnie dopisywany
+
+	retentionOnce sync.Once
+)
+
+func Outcome(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return "error: " + err.Error()
+}
+
+// Log dopisuje rekord do audit logu (błędy zapisu są tylko logowane).
+func Log(rec Record) {
+	cfg := config.Get().Audit
+	if cfg.Disabled {
+		return
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Println("audit: marshal error:", err)
+		return
+	}
+	line = append(line, '\n')
+
+	retentionOnce.Do(func() { go retentionWorker() })
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := loadLocked(); err != nil {
+		log.Println("audit: cannot load segments:", err)
+		return
+	}
+
+	segmentBytes := cfg.SegmentBytes
+	if segmentBytes <= 0 {
+		segmentBytes = defaultSegmentBytes
+	}
+	if current == nil || segments[len(segments)-1].size+int64(len(line)) > segmentBytes {
+		if err := rotateLocked(rec.Time); err != nil {
+			log.Println("audit: cannot rotate segment:", err)
+			return
+		}
+		enforceRetentionLocked(cfg, time.Now())
+	}
+
+	n, err := current.Write(line)
+	segments[len(segments)-1].size += int64(n)
+	if err != nil {
+		log.Println("audit: write error:", err)
+		return
+	}
+	if cfg.Sync {
+		_ = current.Sync()
+	}
+}
+
+func segmentName(start time.Time) string {
+	return fmt.Sprintf("audit-%020d.log", start.UnixNano())
+}
+
+func loadLocked() error {
+	if loaded {
+		return nil
+	}
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return err
+	}
+	segments = segments[:0]
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, "audit-") || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		ns, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, "audit-"), ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{path: filepath.Join(baseDir, name), start: time.Unix(0, ns).UTC(), size: info.Size()})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].start.Before(segments[j].start) })
+
+	// kontynuujemy dopisywanie do ostatniego segmentu
+	if len(segments) > 0 {
+		f, err := os.OpenFile(segments[len(segments)-1].path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		current = f
+	}
+	loaded = true
+	return nil
+}
+
+func rotateLocked(start time.Time) error {
+	if n := len(segments); n > 0 && !start.After(segments[n-1].start) {
+		// nazwy segmentów muszą rosnąć
+		start = segments[n-1].start.Add(time.Nanosecond)
+	}
+	path := filepath.Join(baseDir, segmentName(start))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		_ = current.Close()
+	}
+	current = f
+	segments = append(segments, segment{path: path, start: start})
+	return nil
+}
+
+// enforceRetentionLocked usuwa najstarsze zamknięte segmenty (nigdy aktualny).
+func enforceRetentionLocked(cfg config.Audit, now time.Time) {
+	if cfg.MaxAgeHours > 0 {
+		cutoff := now.Add(-time.Duration(cfg.MaxAgeHours) * time.Hour)
+		// segment i kończy się tam, gdzie zaczyna się i+1
+		for len(segments) > 1 && segments[1].start.Before(cutoff) {
+			removeOldestLocked()
+		}
+	}
+	if cfg.MaxBytes > 0 {
+		var total int64
+		for _, s := range segments {
+			total += s.size
+		}
+		for len(segments) > 1 && total > cfg.MaxBytes {
+			total -= segments[0].size
+			removeOldestLocked()
+		}
+	}
+}
+
+func removeOldestLocked() {
+	if err := os.Remove(segments[0].path); err != nil && !os.IsNotExist(err) {
+		log.Println("audit: cannot remove segment:", err)
+	}
+	segments = segments[1:]
+}
+
+func retentionWorker() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		mu.Lock()
+		if loaded {
+			enforceRetentionLocked(config.Get().Audit, time.Now())
+		}
+		mu.Unlock()
+	}
+}
+
+// Query - puste pola nie filtrują.
+type Query struct {
+	Table    string
+	Key      string
+	Identity string
+	Op       string
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+func (q Query) matches(rec Record) bool {
+	if q.Table != "" && rec.Table != q.Table {
+		return false
+	}
+	if q.Key != "" && rec.Key != q.Key {
+		return false
+	}
+	if q.Identity != "" && rec.Identity != q.Identity {
+		return false
+	}
+	if q.Op != "" && rec.Op != q.Op {
+		return false
+	}
+	if !q.From.IsZero() && rec.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && rec.Time.After(q.To) {
+		return false
+	}
+	return true
+}
+
+// Search zwraca pasujące rekordy w kolejności zapisu (najstarsze pierwsze).
+func Search(q Query) ([]Record, error) {
+	if q.Limit <= 0 {
+		q.Limit = 1000
+	}
+
+	mu.Lock()
+	if err := loadLocked(); err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	snapshot := append([]segment(nil), segments...)
+	mu.Unlock()
+
+	out := make([]Record, 0)
+	for i, seg := range snapshot {
+		// pomijamy segmenty spoza zakresu czasu
+		if !q.To.IsZero() && seg.start.After(q.To) {
+			break
+		}
+		if !q.From.IsZero() && i+1 < len(snapshot) && snapshot[i+1].start.Before(q.From) {
+			continue
+		}
+
+		f, err := os.Open(seg.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // usunięty przez retencję w międzyczasie
+			}
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 4<<20)
+		for scanner.Scan() {
+			var rec Record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				continue
+			}
+			if q.matches(rec) {
+				out = append(out, rec)
+				if len(out) >= q.Limit {
+					f.Close()
+					return out, nil
+				}
+			}
+		}
+		f.Close()
+	}
+	return out, nil
+}
+
+func ResetForTests() {
+	mu.Lock()
+	defer mu.Unlock()
+	if current != nil {
+		_ = current.Close()
+	}
+	current = nil
+	segments = nil
+	loaded = false
+	_ = os.RemoveAll(baseDir)
+}
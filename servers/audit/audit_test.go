@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+func setupAuditTest(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	prevDir := baseDir
+	baseDir = t.TempDir()
+	config.Set(cfg)
+	ResetForTests()
+	t.Cleanup(func() {
+		ResetForTests()
+		baseDir = prevDir
+		config.Set(nil)
+	})
+}
+
+func TestLogAndSearchFilters(t *testing.T) {
+	setupAuditTest(t, &config.Config{})
+
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	Log(Record{Time: base, Identity: "alice", Source: SourceHTTP, Table: "users", Key: "u1", Op: "save", Size: 10, Outcome: "ok"})
+	Log(Record{Time: base.Add(time.Minute), Identity: "bob", Source: SourceDBClient, Table: "users", Key: "u2", Op: "free", Outcome: "ok"})
+	Log(Record{Time: base.Add(2 * time.Minute), Identity: "alice", Source: SourceNetwork, Table: "orders", Key: "o1", Op: "save_inc", Size: 5, Outcome: "ok"})
+
+	all, err := Search(Query{})
+	if err != nil || len(all) != 3 {
+		t.Fatalf("expected 3 records, got %d (%v)", len(all), err)
+	}
+	if got, _ := Search(Query{Identity: "alice"}); len(got) != 2 {
+		t.Fatalf("identity filter: expected 2, got %d", len(got))
+	}
+	if got, _ := Search(Query{Table: "users", Key: "u2"}); len(got) != 1 || got[0].Op != "free" {
+		t.Fatalf("table/key filter returned %v", got)
+	}
+	got, _ := Search(Query{From: base.Add(30 * time.Second), To: base.Add(90 * time.Second)})
+	if len(got) != 1 || got[0].Identity != "bob" {
+		t.Fatalf("time range filter returned %v", got)
+	}
+	if got, _ := Search(Query{Limit: 1}); len(got) != 1 || got[0].Key != "u1" {
+		t.Fatalf("limit returned %v", got)
+	}
+}
+
+func TestRotationAndRetentionByBytes(t *testing.T) {
+	setupAuditTest(t, &config.Config{Audit: config.Audit{SegmentBytes: 200, MaxBytes: 600}})
+
+	for i := 0; i < 40; i++ {
+		Log(Record{Identity: "svc", Table: "t", Key: strings.Repeat("k", 20), Op: "save", Outcome: "ok"})
+	}
+
+	entries, _ := os.ReadDir(baseDir)
+	if len(entries) < 2 {
+		t.Fatalf("expected rotation into multiple segments, got %d", len(entries))
+	}
+	var total int64
+	for _, e := range entries {
+		info, _ := e.Info()
+		total += info.Size()
+	}
+	if total > 600+200 {
+		t.Fatalf("retention did not trim old segments: %d bytes on disk", total)
+	}
+
+	recs, _ := Search(Query{})
+	if len(recs) == 0 || len(recs) >= 40 {
+		t.Fatalf("expected only the newest records to survive, got %d", len(recs))
+	}
+}
+
+func TestMiddlewareRecordsIdentityAndOutcome(t *testing.T) {
+	setupAuditTest(t, &config.Config{Api_auth: config.Api_auth{Keys: []config.Api_key{
+		{Key: "writer-key", Identity: "writer", Tables: map[string]string{"users": "rw"}},
+	}}})
+
+	handler := auth.Middleware(auth.AccessWrite, Middleware("save", func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 64)
+		n, _ := r.Body.Read(buf)
+		if n == 0 {
+			http.Error(w, "empty", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, body := range []string{"hello", ""} {
+		req := httptest.NewRequest(http.MethodPost, "/save/users/u1", strings.NewReader(body))
+		req.Header.Set("api_key", "writer-key")
+		handler(httptest.NewRecorder(), req)
+	}
+
+	recs, _ := Search(Query{Table: "users", Key: "u1"})
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %v", recs)
+	}
+	if recs[0].Identity != "writer" || recs[0].Size != 5 || recs[0].Outcome != "ok" || recs[0].Source != SourceHTTP {
+		t.Fatalf("unexpected first record %+v", recs[0])
+	}
+	if !strings.HasPrefix(recs[1].Outcome, "error") {
+		t.Fatalf("expected failed outcome, got %+v", recs[1])
+	}
+}
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+)
+
+// statusRecorder zapamiętuje kod odpowiedzi handlera.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// countingBody liczy bajty body przeczytane przez handler.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Middleware zapisuje rekord audytu dla operacji /<op>/<table>/<key>.
+// Musi być wywołany po auth.Middleware, żeby znać identity.
+func Middleware(op string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next(rec, r)
+
+		identity := "unknown"
+		if id := auth.FromRequest(r); id != nil {
+			identity = id.Name
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		outcome := "ok"
+		if status >= 400 {
+			outcome = fmt.Sprintf("error: http %d", status)
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+		var table, key string
+		if len(parts) > 1 {
+			table = parts[1]
+		}
+		if len(parts) > 2 {
+			key = parts[2]
+		}
+
+		Log(Record{
+			Identity: identity,
+			Source:   SourceHTTP,
+			Table:    table,
+			Key:      key,
+			Op:       op,
+			Size:     body.n,
+			Outcome:  outcome,
+		})
+	}
+}
+
+func parseTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+		return t, nil
+	}
+	sec, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (RFC3339 or unix seconds)", raw)
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// HandleQuery - GET /audit?table=&key=&identity=&op=&from=&to=&limit= (tylko admin)
+func HandleQuery(w http.ResponseWriter, r *http.Request, _ *http.Client) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if id := auth.FromRequest(r); id == nil || !id.Admin {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	params := r.URL.Query()
+	q := Query{
+		Table:    params.Get("table"),
+		Key:      params.Get("key"),
+		Identity: params.Get("identity"),
+		Op:       params.Get("op"),
+	}
+	var err error
+	if q.From, err = parseTime(params.Get("from")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.To, err = parseTime(params.Get("to")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if raw := params.Get("limit"); raw != "" {
+		if q.Limit, err = strconv.Atoi(raw); err != nil || q.Limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := Search(q)
+	if err != nil {
+		http.Error(w, "audit query failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"records": records,
+		"count":   len(records),
+	})
+}
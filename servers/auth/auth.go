@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+type Access uint8
+
+const (
+	AccessNone  Access = 0
+	AccessRead  Access = 1
+	AccessWrite Access = 2
+)
+
+const (
+	// identity używana, gdy api_auth nie ma żadnych kluczy
+	AnonymousIdentity = "anonymous"
+	// identity wywołań in-process (lib/dbclient)
+	LocalIdentity = "local"
+)
+
+var (
+	ErrMissingKey = errors.New("auth: missing api key")
+	ErrInvalidKey = errors.New("auth: invalid api key")
+)
+
+// Identity to uwierzytelniony wywołujący Public API.
+type Identity struct {
+	Name   string
+	Admin  bool
+	tables map[string]Access
+	all    bool
+}
+
+type ctxKey struct{}
+
+func parseAccess(raw string) Access {
+	var a Access
+	raw = strings.ToLower(raw)
+	if strings.Contains(raw, "r") {
+		a |= AccessRead
+	}
+	if strings.Contains(raw, "w") {
+		a |= AccessWrite
+	}
+	return a
+}
+
+// Enabled - auth jest aktywny, gdy config ma co najmniej jeden klucz.
+func Enabled() bool {
+	return len(config.Get().Api_auth.Keys) > 0
+}
+
+func fullAccess(name string) *Identity {
+	return &Identity{Name: name, Admin: true, all: true}
+}
+
+// Local zwraca identity dla operacji wykonywanych in-process.
+func Local() *Identity {
+	return fullAccess(LocalIdentity)
+}
+
+func keyFromRequest(r *http.Request) string {
+	if k := r.Header.Get("api_key"); k != "" {
+		return k
+	}
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	return ""
+}
+
+// Authenticate sprawdza klucz API z requestu.
+func Authenticate(r *http.Request) (*Identity, error) {
+	keys := config.Get().Api_auth.Keys
+	if len(keys) == 0 {
+		return fullAccess(AnonymousIdentity), nil
+	}
+
+	provided := keyFromRequest(r)
+	if provided == "" {
+		return nil, ErrMissingKey
+	}
+
+	for i, k := range keys {
+		if k.Key == "" || subtle.ConstantTimeCompare([]byte(k.Key), []byte(provided)) != 1 {
+			continue
+		}
+		id := &Identity{Name: keyName(i, k), Admin: k.Admin, tables: make(map[string]Access, len(k.Tables))}
+		for table, raw := range k.Tables {
+			id.tables[table] = parseAccess(raw)
+		}
+		return id, nil
+	}
+	return nil, ErrInvalidKey
+}
+
+func keyName(i int, k config.Api_key) string {
+	if k.Identity == "" {
+		return fmt.Sprintf("key-%d", i)
+	}
+	return k.Identity
+}
+
+// ByName odtwarza identity z aktualnego configu (np. dla połączenia subskrypcji, które
+// zna tylko nazwę); uprawnienia kilku kluczy o tej samej nazwie są sumowane.
+// nil = w configu nie ma już klucza tej identity.
+func ByName(name string) *Identity {
+	keys := config.Get().Api_auth.Keys
+	if len(keys) == 0 || name == LocalIdentity {
+		return fullAccess(name)
+	}
+	var id *Identity
+	for i, k := range keys {
+		if k.Key == "" || keyName(i, k) != name {
+			continue
+		}
+		if id == nil {
+			id = &Identity{Name: name, tables: make(map[string]Access)}
+		}
+		id.Admin = id.Admin || k.Admin
+		for table, raw := range k.Tables {
+			id.tables[table] |= parseAccess(raw)
+		}
+	}
+	return id
+}
+
+// Can sprawdza uprawnienia do tabeli ("" = wszystkie tabele, wymaga wpisu "*").
+func (id *Identity) Can(table string, want Access) bool {
+	if id == nil {
+		return false
+	}
+	if id.all {
+		return true
+	}
+	if table != "" {
+		if a, ok := id.tables[table]; ok {
+			return a&want == want
+		}
+	}
+	if a, ok := id.tables["*"]; ok {
+		return a&want == want
+	}
+	return false
+}
+
+func (id *Identity) CanRead(table string) bool  { return id.Can(table, AccessRead) }
+func (id *Identity) CanWrite(table string) bool { return id.Can(table, AccessWrite) }
+
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromRequest zwraca identity zapisaną przez middleware.
+// Gdy request nie przeszedł przez middleware (np. testy), a auth jest wyłączony,
+// zwracany jest anonimowy użytkownik z pełnym dostępem.
+func FromRequest(r *http.Request) *Identity {
+	if id, ok := r.Context().Value(ctxKey{}).(*Identity); ok && id != nil {
+		return id
+	}
+	if !Enabled() {
+		return fullAccess(AnonymousIdentity)
+	}
+	return nil
+}
+
+// TableFromPath wyciąga <table> z /endpoint/<table>/<key>.
+func TableFromPath(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// Middleware uwierzytelnia request i (dla access != AccessNone) sprawdza
+// uprawnienia do tabeli z path /endpoint/<table>/...
+func Middleware(access Access, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="TsunamiDB"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if access != AccessNone && !id.Can(TableFromPath(r.URL.Path), access) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(WithIdentity(r.Context(), id)))
+	}
+}
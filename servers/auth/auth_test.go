@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+func setupAuthTest(t *testing.T, keys ...config.Api_key) {
+	t.Helper()
+	config.Set(&config.Config{Api_auth: config.Api_auth{Keys: keys}})
+	t.Cleanup(func() { config.Set(nil) })
+}
+
+func request(path string, header ...string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return req
+}
+
+func TestAuthenticate(t *testing.T) {
+	setupAuthTest(t)
+	// bez kluczy w configu auth jest wyłączony
+	if id, err := Authenticate(request("/read/t/k")); err != nil || id.Name != AnonymousIdentity || !id.CanWrite("t") {
+		t.Fatalf("expected anonymous full access, got %+v %v", id, err)
+	}
+
+	setupAuthTest(t,
+		config.Api_key{Key: "chat-key", Identity: "chat", Tables: map[string]string{"messages": "rw", "users": "r"}},
+		config.Api_key{Key: "unnamed-key", Tables: map[string]string{"*": "r"}},
+		config.Api_key{Key: "", Identity: "disabled"},
+	)
+	if _, err := Authenticate(request("/read/t/k")); !errors.Is(err, ErrMissingKey) {
+		t.Fatalf("expected missing key, got %v", err)
+	}
+	if _, err := Authenticate(request("/read/t/k", "api_key", "nope")); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected invalid key, got %v", err)
+	}
+
+	id, err := Authenticate(request("/read/t/k", "api_key", "chat-key"))
+	if err != nil || id.Name != "chat" || id.Admin {
+		t.Fatalf("unexpected identity %+v %v", id, err)
+	}
+	if !id.CanWrite("messages") || !id.CanRead("users") || id.CanWrite("users") || id.CanRead("other") {
+		t.Fatalf("unexpected permissions %+v", id.tables)
+	}
+	// Authorization: Bearer, klucz bez identity dostaje nazwę od pozycji w configu
+	if id, err := Authenticate(request("/read/t/k", "Authorization", "Bearer unnamed-key")); err != nil || id.Name != "key-1" {
+		t.Fatalf("bearer key: %+v %v", id, err)
+	}
+}
+
+func TestByNameMergesKeysWithSameIdentity(t *testing.T) {
+	setupAuthTest(t,
+		config.Api_key{Key: "reader", Identity: "svc", Tables: map[string]string{"logs": "r"}},
+		config.Api_key{Key: "writer", Identity: "svc", Admin: true, Tables: map[string]string{"logs": "w", "jobs": "rw"}},
+		config.Api_key{Key: "other", Identity: "other", Tables: map[string]string{"*": "rw"}},
+	)
+
+	id := ByName("svc")
+	if id == nil || !id.Admin {
+		t.Fatalf("expected merged admin identity, got %+v", id)
+	}
+	if !id.CanRead("logs") || !id.CanWrite("logs") || !id.CanWrite("jobs") || id.CanRead("other") {
+		t.Fatalf("permissions not merged: %+v", id.tables)
+	}
+	if ByName("gone") != nil {
+		t.Fatalf("expected nil for identity without keys")
+	}
+	if id := ByName(LocalIdentity); id == nil || !id.CanWrite("anything") {
+		t.Fatalf("local identity should have full access, got %+v", id)
+	}
+}
+
+func TestCanFallbackAndAllTables(t *testing.T) {
+	id := &Identity{Name: "x", tables: map[string]Access{"*": AccessRead, "own": AccessRead | AccessWrite, "hidden": AccessNone}}
+
+	if !id.CanWrite("own") || !id.CanRead("other") || id.CanWrite("other") {
+		t.Fatalf("unexpected table / * fallback")
+	}
+	// wpis tabeli wygrywa z "*"
+	if id.CanRead("hidden") {
+		t.Fatalf("explicit entry should override *")
+	}
+	// "" = wszystkie tabele, decyduje tylko "*"
+	if !id.Can("", AccessRead) || id.Can("", AccessWrite) {
+		t.Fatalf("unexpected access for all tables")
+	}
+	noStar := &Identity{Name: "y", tables: map[string]Access{"own": AccessRead | AccessWrite}}
+	if noStar.Can("", AccessRead) || noStar.CanRead("other") {
+		t.Fatalf("without * only listed tables are allowed")
+	}
+	var none *Identity
+	if none.CanRead("own") {
+		t.Fatalf("nil identity must not have access")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	setupAuthTest(t, config.Api_key{Key: "chat-key", Identity: "chat", Tables: map[string]string{"messages": "r"}})
+
+	var seen *Identity
+	handler := Middleware(AccessRead, func(w http.ResponseWriter, r *http.Request) {
+		seen = FromRequest(r)
+		w.WriteHeader(http.StatusOK)
+	})
+	call := func(path string, header ...string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, request(path, header...))
+		return rr
+	}
+
+	if rr := call("/read/messages/k"); rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected 401 with WWW-Authenticate, got %d", rr.Code)
+	}
+	if rr := call("/read/messages/k", "api_key", "nope"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for invalid key, got %d", rr.Code)
+	}
+	if rr := call("/read/other/k", "api_key", "chat-key"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for table without access, got %d", rr.Code)
+	}
+	if rr := call("/read/messages/k", "api_key", "chat-key"); rr.Code != http.StatusOK || seen == nil || seen.Name != "chat" {
+		t.Fatalf("expected 200 with identity in context, got %d %+v", rr.Code, seen)
+	}
+
+	// AccessNone: tylko uwierzytelnienie, tabele sprawdza handler
+	handler = Middleware(AccessNone, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	if rr := call("/subscriptions/enable", "api_key", "chat-key"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for AccessNone, got %d", rr.Code)
+	}
+}
@@ -1,11 +1,114 @@
 package config
 
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+)
+
 type Config struct {
 	Tsu_network_config Tsu_network_config `json:"tsu_network_config"`
+	Tls                Tls_config         `json:"tls"`
+	Api_auth           Api_auth           `json:"api_auth"`
+	Subscriptions      Subscriptions      `json:"subscriptions"`
+	Audit              Audit              `json:"audit"`
+	Limits             Limits             `json:"limits"`
+}
+
+/*
+audit - append-only log operacji zmieniających dane (./db/audit/*.log)
+
+	disabled       - wyłącza audit log
+	max_age_hours  - segmenty starsze niż N godzin są usuwane (0 = bez limitu)
+	max_bytes      - łączny limit rozmiaru segmentów (0 = bez limitu)
+	segment_bytes  - rozmiar segmentu po którym następuje rotacja (domyślnie 16 MiB)
+	sync           - fsync po każdym wpisie
+*/
+type Audit struct {
+	Disabled     bool  `json:"disabled"`
+	MaxAgeHours  int   `json:"max_age_hours"`
+	MaxBytes     int64 `json:"max_bytes"`
+	SegmentBytes int64 `json:"segment_bytes"`
+	Sync         bool  `json:"sync"`
+}
+
+/*
+api_auth.keys - klucze API dla Public API (header `api_key` albo `Authorization: Bearer <key>`)
+tables: nazwa tabeli (albo "*") -> "r" | "w" | "rw"
+admin: dostęp do operacji administracyjnych (np. revoke subskrypcji innych identity)
+
+pusta lista kluczy = auth wyłączony (wszyscy mają pełny dostęp)
+*/
+type Api_auth struct {
+	Keys []Api_key `json:"keys"`
+}
+
+type Api_key struct {
+	Key      string            `json:"key"`
+	Identity string            `json:"identity"`
+	Tables   map[string]string `json:"tables"`
+	Admin    bool              `json:"admin"`
+}
+
+type Subscriptions struct {
+	// dozwolone nagłówki Origin dla /sub ("*" albo pusta lista = wszystkie)
+	AllowedOrigins []string      `json:"allowed_origins"`
+	EventLog       Sub_event_log `json:"event_log"`
+	Queue          Sub_queue     `json:"queue"`
+	Webhooks       Sub_webhooks  `json:"webhooks"`
+}
+
+/*
+subscriptions.event_log - log zdarzeń subskrypcji do wznawiania po last_seq (./db/subscriptions/*.log)
+
+	disabled       - zdarzenia dostają seq, ale nie są zapisywane (wznawianie niedostępne)
+	max_bytes      - łączny limit rozmiaru segmentów (domyślnie 64 MiB)
+	segment_bytes  - rozmiar segmentu po którym następuje rotacja (domyślnie 4 MiB)
+	sync           - fsync po każdym wpisie
+*/
+type Sub_event_log struct {
+	Disabled     bool  `json:"disabled"`
+	MaxBytes     int64 `json:"max_bytes"`
+	SegmentBytes int64 `json:"segment_bytes"`
+	Sync         bool  `json:"sync"`
+}
+
+/*
+subscriptions.queue - kolejka wychodząca każdego subskrybenta (WS, SSE, long-poll)
+
+	size   - maks. ilość ramek w kolejce (domyślnie 1024)
+	policy - co zrobić ze zdarzeniem, gdy kolejka jest pełna:
+	         "disconnect" (domyślnie), "drop_oldest" albo "coalesce"
+*/
+type Sub_queue struct {
+	Size   int    `json:"size"`
+	Policy string `json:"policy"`
+}
+
+/*
+subscriptions.webhooks - dostarczanie zdarzeń POSTem na zarejestrowane URL (./db/subscriptions/webhooks.json)
+
+	max_attempts       - ilość prób dostarczenia jednego zdarzenia (domyślnie 8)
+	initial_backoff_ms - przerwa po pierwszej nieudanej próbie, podwajana co próbę (domyślnie 1000)
+	max_backoff_ms     - maks. przerwa między próbami (domyślnie 60000)
+	timeout_ms         - timeout pojedynczego POST (domyślnie 10000)
+	dead_letters       - ile nieudanych dostarczeń pamiętać per webhook (domyślnie 1000)
+	allowed_networks   - adresy wewnętrzne (CIDR albo IP), na które wolno wysyłać webhooki;
+	                     domyślnie loopback, sieci prywatne i link-local są zablokowane
+*/
+type Sub_webhooks struct {
+	MaxAttempts      int      `json:"max_attempts"`
+	InitialBackoffMs int      `json:"initial_backoff_ms"`
+	MaxBackoffMs     int      `json:"max_backoff_ms"`
+	TimeoutMs        int      `json:"timeout_ms"`
+	DeadLetters      int      `json:"dead_letters"`
+	AllowedNetworks  []string `json:"allowed_networks"`
 }
 
 type Tsu_network_config struct {
-	Servers []Server `json:"servers"`
+	Servers []Server  `json:"servers"`
+	Auth    Peer_auth `json:"auth"`
 }
 
 /*
@@ -22,8 +125,43 @@ type Server struct {
 	Type string `json:"type"`
 }
 
+var (
+	current   = &Config{}
+	currentMu sync.RWMutex
+)
+
+// LoadConfig wczytuje config.json; brak pliku oznacza konfigurację domyślną.
 func LoadConfig(dir string) {
+	data, err := os.ReadFile(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Cannot read config:", err)
+		}
+		return
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.Fatalf("Invalid config %s: %v", dir, err)
+	}
+	Set(&cfg)
+}
+
+// Get returns the currently loaded configuration (never nil).
+func Get() *Config {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
 
+// Set replaces the active configuration, used by LoadConfig and tests.
+func Set(cfg *Config) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	currentMu.Lock()
+	current = cfg
+	currentMu.Unlock()
 }
 
 /*
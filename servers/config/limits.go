@@ -0,0 +1,56 @@
+package config
+
+/*
+limits - limity requestów i quota tabel
+
+	"limits": {
+		"per_key":   { "*": {"rate": 100, "burst": 200}, "chat": {"rate": 20, "burst": 40} },
+		"per_table": { "messages": {"rate": 500, "burst": 1000} },
+		"quotas":    { "*": {"max_bytes": 1073741824}, "messages": {"max_keys": 100000} }
+	}
+
+per_key   - identity klucza API -> token bucket ("*" = domyślny dla identity bez wpisu)
+per_table - tabela -> token bucket (wspólny dla wszystkich klientów)
+quotas    - tabela -> limity zapisanych danych, sprawdzane przed zapisem
+rate <= 0 albo brak wpisu = bez limitu; 0 w quota = bez limitu
+*/
+type Limits struct {
+	PerKey   map[string]Rate_limit  `json:"per_key"`
+	PerTable map[string]Rate_limit  `json:"per_table"`
+	Quotas   map[string]Table_quota `json:"quotas"`
+}
+
+type Rate_limit struct {
+	Rate  float64 `json:"rate"`  // requestów na sekundę
+	Burst int     `json:"burst"` // pojemność kubełka (domyślnie max(1, rate))
+}
+
+type Table_quota struct {
+	MaxBytes int64 `json:"max_bytes"`
+	MaxKeys  int64 `json:"max_keys"`
+}
+
+// lookupRate zwraca wpis dla name albo "*".
+func lookupRate(m map[string]Rate_limit, name string) (Rate_limit, bool) {
+	if rl, ok := m[name]; ok {
+		return rl, rl.Rate > 0
+	}
+	rl, ok := m["*"]
+	return rl, ok && rl.Rate > 0
+}
+
+func (l Limits) KeyLimit(identity string) (Rate_limit, bool) {
+	return lookupRate(l.PerKey, identity)
+}
+
+func (l Limits) TableLimit(table string) (Rate_limit, bool) {
+	return lookupRate(l.PerTable, table)
+}
+
+func (l Limits) Quota(table string) (Table_quota, bool) {
+	if q, ok := l.Quotas[table]; ok {
+		return q, q.MaxBytes > 0 || q.MaxKeys > 0
+	}
+	q, ok := l.Quotas["*"]
+	return q, ok && (q.MaxBytes > 0 || q.MaxKeys > 0)
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+/*
+	"tsu_network_config": {
+		"auth": {
+			"node_id": "node-a",
+			"cluster_secret": "...",                 // shared secret (HMAC)
+			"private_key_file": "keys/node-a.pem",   // albo para kluczy ed25519 (PKCS8 PEM)
+			"trusted_peers": { "node-b": "keys/node-b.pub.pem" }
+		}
+	}
+
+bez cluster_secret i private_key_file peery nie są uwierzytelniane (tryb legacy)
+*/
+type Peer_auth struct {
+	NodeID         string            `json:"node_id"`
+	ClusterSecret  string            `json:"cluster_secret"`
+	PrivateKeyFile string            `json:"private_key_file"`
+	TrustedPeers   map[string]string `json:"trusted_peers"`
+}
+
+const (
+	PeerAuthDisabled      = "disabled"
+	PeerAuthClusterSecret = "cluster_secret"
+	PeerAuthKeyPair       = "ed25519"
+)
+
+func (a Peer_auth) Mode() string {
+	switch {
+	case a.PrivateKeyFile != "":
+		return PeerAuthKeyPair
+	case a.ClusterSecret != "":
+		return PeerAuthClusterSecret
+	default:
+		return PeerAuthDisabled
+	}
+}
+
+// LoadPrivateKey wczytuje klucz ed25519 w formacie PKCS8 PEM
+// (np. `openssl genpkey -algorithm ed25519`).
+func (a Peer_auth) LoadPrivateKey() (ed25519.PrivateKey, error) {
+	block, err := readPEM(a.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("peer auth: invalid private key: %w", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("peer auth: private key is not ed25519")
+	}
+	return priv, nil
+}
+
+// LoadTrustedPeers wczytuje klucze publiczne (PKIX PEM) zaufanych node'ów.
+func (a Peer_auth) LoadTrustedPeers() (map[string]ed25519.PublicKey, error) {
+	out := make(map[string]ed25519.PublicKey, len(a.TrustedPeers))
+	for nodeID, path := range a.TrustedPeers {
+		block, err := readPEM(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("peer auth: invalid public key for %s: %w", nodeID, err)
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("peer auth: public key for %s is not ed25519", nodeID)
+		}
+		out[nodeID] = pub
+	}
+	return out, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("peer auth: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("peer auth: no PEM data in %s", path)
+	}
+	return block, nil
+}
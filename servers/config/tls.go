@@ -0,0 +1,125 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+/*
+	"tls": {
+		"public_api":    { "cert_file": "...", "key_file": "..." },
+		"subscriptions": { "cert_file": "...", "key_file": "..." },
+		"network": {
+			"cert_file": "...", "key_file": "...",
+			"ca_file": "peers-ca.pem",   // bundle used to verify other nodes
+			"mutual_tls": true           // require and present client certificates on /ws
+		}
+	}
+
+listener without cert_file/key_file stays plaintext (http / ws)
+*/
+type Tls_config struct {
+	PublicApi     Tls_listener `json:"public_api"`
+	Subscriptions Tls_listener `json:"subscriptions"`
+	Network       Tls_network  `json:"network"`
+}
+
+type Tls_listener struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+type Tls_network struct {
+	Tls_listener
+	CAFile    string `json:"ca_file"`
+	MutualTLS bool   `json:"mutual_tls"`
+}
+
+func (l Tls_listener) Enabled() bool {
+	return l.CertFile != "" && l.KeyFile != ""
+}
+
+func (l Tls_listener) loadCertificate() (tls.Certificate, error) {
+	if !l.Enabled() {
+		return tls.Certificate{}, errors.New("tls: cert_file and key_file are required")
+	}
+	cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("tls: cannot load key pair: %w", err)
+	}
+	return cert, nil
+}
+
+// LoadCAPool wczytuje bundle PEM z certyfikatami CA.
+func LoadCAPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: cannot read ca bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls: no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// ServerTLSConfig builds the listener config for the public API or /sub server.
+// Returns nil when TLS is not configured for the listener.
+func (l Tls_listener) ServerTLSConfig() (*tls.Config, error) {
+	if !l.Enabled() {
+		return nil, nil
+	}
+	cert, err := l.loadCertificate()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ServerTLSConfig for the network manager additionally verifies peer client
+// certificates against ca_file when mutual_tls is enabled.
+func (n Tls_network) ServerTLSConfig() (*tls.Config, error) {
+	cfg, err := n.Tls_listener.ServerTLSConfig()
+	if err != nil || cfg == nil {
+		return cfg, err
+	}
+	if n.MutualTLS {
+		if n.CAFile == "" {
+			return nil, errors.New("tls: mutual_tls requires ca_file")
+		}
+		pool, err := LoadCAPool(n.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// ClientTLSConfig is used when dialing other nodes over wss://.
+// ca_file (if set) replaces system roots, mutual_tls presents our own certificate.
+func (n Tls_network) ClientTLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if n.CAFile != "" {
+		pool, err := LoadCAPool(n.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if n.MutualTLS {
+		cert, err := n.loadCertificate()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
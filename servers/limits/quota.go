@@ -0,0 +1,118 @@
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	dataManager_v2 "github.com/PAW122/TsunamiDB/data/dataManager/v2"
+	fileSystem_v1 "github.com/PAW122/TsunamiDB/data/fileSystem/v1"
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// reservation - zapisy w toku: sprawdzone względem quota, ale jeszcze niewidoczne w TableUsage
+// ani w rozmiarze pliku inc table. Liczone razem z użyciem tabeli, więc równoległe zapisy
+// nie przechodzą sprawdzenia na tym samym stanie.
+type reservation struct {
+	keys  int64
+	bytes int64
+}
+
+var (
+	reserveMu sync.Mutex
+	reserved  = make(map[string]reservation) // table -> suma rezerwacji w toku
+)
+
+func noRelease() {}
+
+// ReserveSave sprawdza quota tabeli przed zapisem klucza o (zakodowanym) rozmiarze size
+// i rezerwuje miejsce do wywołania release (po zapisie danych i metadanych, również przy błędzie).
+// Nadpisanie istniejącego klucza liczy się tylko jako różnica rozmiarów.
+func ReserveSave(table, key string, size int64) (release func(), err error) {
+	q, ok := config.Get().Limits.Quota(table)
+	if !ok {
+		return noRelease, nil
+	}
+	return reserve(table, q, func() (int64, int64, int64, int64, error) {
+		keys, bytes, err := fileSystem_v1.TableUsage(table)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+		newKeys, delta := int64(1), size
+		if prev, err := fileSystem_v1.GetElementByKey(table, key); err == nil && prev != nil {
+			newKeys = 0
+			delta = size - int64(prev.EndPtr-prev.StartPtr)
+		}
+		return keys, bytes, newKeys, delta, nil
+	})
+}
+
+// ReserveIncAppend sprawdza quota przed dopisaniem rekordu do inc table i rezerwuje miejsce (jak ReserveSave).
+// Do bajtów tabeli doliczany jest aktualny rozmiar pliku inc table razem z jej plikiem overflow,
+// a recordSize obejmuje też payload, który trafi do overflow.
+func ReserveIncAppend(table, incFile string, recordSize int64) (release func(), err error) {
+	q, ok := config.Get().Limits.Quota(table)
+	if !ok || q.MaxBytes <= 0 {
+		return noRelease, nil
+	}
+	return reserve(table, config.Table_quota{MaxBytes: q.MaxBytes}, func() (int64, int64, int64, int64, error) {
+		_, bytes, err := fileSystem_v1.TableUsage(table)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+		incBytes, err := dataManager_v2.GetIncTableSize(incFile)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+		return 0, bytes + incBytes, 0, recordSize, nil
+	})
+}
+
+// reserve liczy użycie tabeli i sprawdza quota pod reserveMu, razem z rezerwacjami w toku.
+// usage zwraca obecne klucze/bajty tabeli oraz przyrost kluczy/bajtów zapisu.
+func reserve(table string, q config.Table_quota, usage func() (keys, bytes, addKeys, addBytes int64, err error)) (func(), error) {
+	reserveMu.Lock()
+	defer reserveMu.Unlock()
+
+	keys, bytes, addKeys, addBytes, err := usage()
+	if err != nil {
+		return noRelease, err
+	}
+	r := reserved[table]
+	if err := check(table, q, keys+r.keys+addKeys, bytes+r.bytes+addBytes); err != nil {
+		return noRelease, err
+	}
+	// zmniejszenie (nadpisanie mniejszą wartością) nie zwalnia miejsca przed zapisem
+	add := reservation{keys: addKeys, bytes: max(addBytes, 0)}
+	r.keys += add.keys
+	r.bytes += add.bytes
+	reserved[table] = r
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			reserveMu.Lock()
+			defer reserveMu.Unlock()
+			r := reserved[table]
+			r.keys -= add.keys
+			r.bytes -= add.bytes
+			if r == (reservation{}) {
+				delete(reserved, table)
+			} else {
+				reserved[table] = r
+			}
+		})
+	}, nil
+}
+
+func check(table string, q config.Table_quota, keys, bytes int64) error {
+	if q.MaxKeys > 0 && keys > q.MaxKeys {
+		return fmt.Errorf("%w: table %s would have %d keys (max %d)", ErrQuotaExceeded, table, keys, q.MaxKeys)
+	}
+	if q.MaxBytes > 0 && bytes > q.MaxBytes {
+		return fmt.Errorf("%w: table %s would use %d bytes (max %d)", ErrQuotaExceeded, table, bytes, q.MaxBytes)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package limits
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+// bucket - token bucket; tokeny uzupełniane leniwie przy każdym take.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  config.Rate_limit
+}
+
+func burstOf(rl config.Rate_limit) float64 {
+	if rl.Burst > 0 {
+		return float64(rl.Burst)
+	}
+	return math.Max(1, rl.Rate)
+}
+
+// take zużywa token albo zwraca czas do pojawienia się następnego.
+func (b *bucket) take(now time.Time) (bool, time.Duration) {
+	burst := burstOf(b.limit)
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+var (
+	bucketsMu sync.Mutex
+	buckets   = make(map[string]*bucket) // "key:<identity>" | "table:<table>"
+
+	now = time.Now
+)
+
+// allow sprawdza kubełek name; zmiana limitu w configu resetuje kubełek.
+func allow(name string, rl config.Rate_limit) (bool, time.Duration) {
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+
+	t := now()
+	b, ok := buckets[name]
+	if !ok || b.limit != rl {
+		b = &bucket{tokens: burstOf(rl), last: t, limit: rl}
+		buckets[name] = b
+	}
+	return b.take(t)
+}
+
+func reject(w http.ResponseWriter, scope string, wait time.Duration) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", secs))
+	http.Error(w, "rate limit exceeded ("+scope+")", http.StatusTooManyRequests)
+}
+
+// Middleware egzekwuje limity per klucz API i (gdy perTable) per tabela z path.
+// Musi być wywołany po auth.Middleware, żeby znać identity.
+func Middleware(perTable bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := config.Get().Limits
+
+		identity := auth.AnonymousIdentity
+		if id := auth.FromRequest(r); id != nil {
+			identity = id.Name
+		}
+		if rl, ok := cfg.KeyLimit(identity); ok {
+			if allowed, wait := allow("key:"+identity, rl); !allowed {
+				reject(w, "key", wait)
+				return
+			}
+		}
+
+		if perTable {
+			if table := auth.TableFromPath(r.URL.Path); table != "" {
+				if rl, ok := cfg.TableLimit(table); ok {
+					if allowed, wait := allow("table:"+table, rl); !allowed {
+						reject(w, "table", wait)
+						return
+					}
+				}
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+func ResetForTests() {
+	bucketsMu.Lock()
+	buckets = make(map[string]*bucket)
+	bucketsMu.Unlock()
+	now = time.Now
+}
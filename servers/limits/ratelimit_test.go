@@ -0,0 +1,86 @@
+package limits
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+func setupLimitsTest(t *testing.T, cfg *config.Config) *time.Time {
+	t.Helper()
+	ResetForTests()
+	config.Set(cfg)
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() {
+		ResetForTests()
+		config.Set(nil)
+	})
+	return &clock
+}
+
+func call(handler http.HandlerFunc, path, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	if apiKey != "" {
+		req.Header.Set("api_key", apiKey)
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+func TestPerKeyBucketReturns429WithRetryAfter(t *testing.T) {
+	clock := setupLimitsTest(t, &config.Config{
+		Api_auth: config.Api_auth{Keys: []config.Api_key{
+			{Key: "noisy-key", Identity: "noisy", Tables: map[string]string{"*": "rw"}},
+			{Key: "calm-key", Identity: "calm", Tables: map[string]string{"*": "rw"}},
+		}},
+		Limits: config.Limits{PerKey: map[string]config.Rate_limit{"noisy": {Rate: 0.5, Burst: 2}}},
+	})
+	handler := auth.Middleware(auth.AccessWrite, Middleware(true, okHandler))
+
+	for i := 0; i < 2; i++ {
+		if rr := call(handler, "/save/t/k", "noisy-key"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d within burst got %d", i, rr.Code)
+		}
+	}
+	rr := call(handler, "/save/t/k", "noisy-key")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("expected Retry-After 2, got %q", got)
+	}
+	// inne identity nie są dotknięte
+	if rr := call(handler, "/save/t/k", "calm-key"); rr.Code != http.StatusOK {
+		t.Fatalf("other identity throttled: %d", rr.Code)
+	}
+
+	*clock = clock.Add(2 * time.Second)
+	if rr := call(handler, "/save/t/k", "noisy-key"); rr.Code != http.StatusOK {
+		t.Fatalf("bucket should refill, got %d", rr.Code)
+	}
+}
+
+func TestPerTableBucketIsSharedAcrossClients(t *testing.T) {
+	setupLimitsTest(t, &config.Config{
+		Limits: config.Limits{PerTable: map[string]config.Rate_limit{"hot": {Rate: 1, Burst: 1}}},
+	})
+	handler := auth.Middleware(auth.AccessWrite, Middleware(true, okHandler))
+
+	if rr := call(handler, "/save/hot/a", ""); rr.Code != http.StatusOK {
+		t.Fatalf("first request got %d", rr.Code)
+	}
+	if rr := call(handler, "/save/hot/b", ""); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected table bucket to throttle, got %d", rr.Code)
+	}
+	if rr := call(handler, "/save/cold/a", ""); rr.Code != http.StatusOK {
+		t.Fatalf("other table throttled: %d", rr.Code)
+	}
+}
@@ -0,0 +1,215 @@
+package networkmanager
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	config "github.com/PAW122/TsunamiDB/servers/config"
+	types "github.com/PAW122/TsunamiDB/types"
+)
+
+/*
+Handshake peerów (przed dodaniem do NetworkManager.peers):
+
+	acceptor -> dialer   {task:"auth_challenge", args:[acceptorID, nonceA]}
+	dialer   -> acceptor {task:"auth_response",  args:[dialerID, proof(nonceA), nonceB]}
+	acceptor -> dialer   {task:"auth_ok",        args:[acceptorID, proof(nonceB)]}  | {task:"auth_failed"}
+
+proof = "hmac:<hex>"     HMAC-SHA256(cluster_secret, msg)
+        "ed25519:<b64>"  podpis kluczem node'a, weryfikowany przez trusted_peers[nodeID]
+msg   = "tsunamidb-peer-auth|<role>|<nodeID>|<nonce>"
+*/
+
+const handshakeTimeout = 10 * time.Second
+
+var (
+	ErrPeerAuthFailed = errors.New("peer authentication failed")
+)
+
+type peerAuthenticator struct {
+	mode    string
+	nodeID  string
+	secret  []byte
+	private ed25519.PrivateKey
+	trusted map[string]ed25519.PublicKey
+}
+
+func newPeerAuthenticator(cfg config.Peer_auth, defaultNodeID string) (*peerAuthenticator, error) {
+	a := &peerAuthenticator{
+		mode:   cfg.Mode(),
+		nodeID: cfg.NodeID,
+		secret: []byte(cfg.ClusterSecret),
+	}
+	if a.nodeID == "" {
+		a.nodeID = defaultNodeID
+	}
+
+	if a.mode == config.PeerAuthKeyPair {
+		priv, err := cfg.LoadPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		trusted, err := cfg.LoadTrustedPeers()
+		if err != nil {
+			return nil, err
+		}
+		a.private = priv
+		a.trusted = trusted
+	}
+	return a, nil
+}
+
+func (a *peerAuthenticator) enabled() bool {
+	return a != nil && a.mode != config.PeerAuthDisabled
+}
+
+func authMessage(role, nodeID, nonce string) []byte {
+	return []byte("tsunamidb-peer-auth|" + role + "|" + nodeID + "|" + nonce)
+}
+
+func newNonce() string {
+	buf := make([]byte, 32)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (a *peerAuthenticator) prove(role, nonce string) string {
+	msg := authMessage(role, a.nodeID, nonce)
+	if a.mode == config.PeerAuthKeyPair {
+		return "ed25519:" + base64.StdEncoding.EncodeToString(ed25519.Sign(a.private, msg))
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(msg)
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *peerAuthenticator) verify(role, nodeID, nonce, proof string) error {
+	if nodeID == "" {
+		return fmt.Errorf("%w: missing node id", ErrPeerAuthFailed)
+	}
+	msg := authMessage(role, nodeID, nonce)
+
+	switch {
+	case strings.HasPrefix(proof, "ed25519:") && a.mode == config.PeerAuthKeyPair:
+		pub, ok := a.trusted[nodeID]
+		if !ok {
+			return fmt.Errorf("%w: node %s is not trusted", ErrPeerAuthFailed, nodeID)
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(proof, "ed25519:"))
+		if err != nil || !ed25519.Verify(pub, msg, sig) {
+			return fmt.Errorf("%w: bad signature from %s", ErrPeerAuthFailed, nodeID)
+		}
+		return nil
+	case strings.HasPrefix(proof, "hmac:") && a.mode == config.PeerAuthClusterSecret:
+		got, err := hex.DecodeString(strings.TrimPrefix(proof, "hmac:"))
+		if err != nil {
+			return fmt.Errorf("%w: malformed proof", ErrPeerAuthFailed)
+		}
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write(msg)
+		if !hmac.Equal(got, mac.Sum(nil)) {
+			return fmt.Errorf("%w: bad cluster secret proof from %s", ErrPeerAuthFailed, nodeID)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported proof type", ErrPeerAuthFailed)
+	}
+}
+
+func writeAuthMsg(conn *websocket.Conn, task string, args ...string) error {
+	msg, err := json.Marshal(types.NMmessage{Task: task, Args: args})
+	if err != nil {
+		return err
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetWriteDeadline(time.Time{})
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// readAuthMsg czyta kolejną wiadomość handshake (heartbeat i inne ramki są pomijane).
+func readAuthMsg(conn *websocket.Conn, task string, minArgs int) (types.NMmessage, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			return types.NMmessage{}, err
+		}
+		var msg types.NMmessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			continue
+		}
+		if msg.Task == "auth_failed" {
+			return msg, fmt.Errorf("%w: rejected by remote node", ErrPeerAuthFailed)
+		}
+		if msg.Task != task {
+			continue
+		}
+		if len(msg.Args) < minArgs {
+			return msg, fmt.Errorf("%w: malformed %s", ErrPeerAuthFailed, task)
+		}
+		return msg, nil
+	}
+}
+
+// authenticateIncoming wykonuje handshake po stronie serwera /ws i zwraca node id peera.
+func (a *peerAuthenticator) authenticateIncoming(conn *websocket.Conn) (string, error) {
+	nonceA := newNonce()
+	if err := writeAuthMsg(conn, "auth_challenge", a.nodeID, nonceA); err != nil {
+		return "", err
+	}
+
+	resp, err := readAuthMsg(conn, "auth_response", 3)
+	if err != nil {
+		return "", err
+	}
+	peerID, proof, nonceB := resp.Args[0], resp.Args[1], resp.Args[2]
+
+	if err := a.verify("dialer", peerID, nonceA, proof); err != nil {
+		_ = writeAuthMsg(conn, "auth_failed")
+		return "", err
+	}
+
+	if err := writeAuthMsg(conn, "auth_ok", a.nodeID, a.prove("acceptor", nonceB)); err != nil {
+		return "", err
+	}
+	return peerID, nil
+}
+
+// authenticateOutgoing wykonuje handshake po stronie łączącej się (connectToPeer).
+func (a *peerAuthenticator) authenticateOutgoing(conn *websocket.Conn) (string, error) {
+	challenge, err := readAuthMsg(conn, "auth_challenge", 2)
+	if err != nil {
+		return "", err
+	}
+	peerID, nonceA := challenge.Args[0], challenge.Args[1]
+
+	nonceB := newNonce()
+	if err := writeAuthMsg(conn, "auth_response", a.nodeID, a.prove("dialer", nonceA), nonceB); err != nil {
+		return "", err
+	}
+
+	ok, err := readAuthMsg(conn, "auth_ok", 2)
+	if err != nil {
+		return "", err
+	}
+	if ok.Args[0] != peerID {
+		return "", fmt.Errorf("%w: node id changed during handshake", ErrPeerAuthFailed)
+	}
+	if err := a.verify("acceptor", peerID, nonceB, ok.Args[1]); err != nil {
+		return "", err
+	}
+	return peerID, nil
+}
@@ -0,0 +1,100 @@
+package networkmanager
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+// runHandshake łączy dialer i acceptor przez prawdziwy WebSocket i zwraca wyniki obu stron.
+func runHandshake(t *testing.T, acceptor, dialer *peerAuthenticator) (string, error, string, error) {
+	t.Helper()
+
+	type result struct {
+		id  string
+		err error
+	}
+	serverRes := make(chan result, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			serverRes <- result{err: err}
+			return
+		}
+		defer conn.Close()
+		id, err := acceptor.authenticateIncoming(conn)
+		serverRes <- result{id, err}
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	clientID, clientErr := dialer.authenticateOutgoing(conn)
+	conn.Close()
+	res := <-serverRes
+	return res.id, res.err, clientID, clientErr
+}
+
+func TestPeerHandshakeClusterSecret(t *testing.T) {
+	a, _ := newPeerAuthenticator(config.Peer_auth{NodeID: "node-a", ClusterSecret: "s3cret"}, "")
+	b, _ := newPeerAuthenticator(config.Peer_auth{NodeID: "node-b", ClusterSecret: "s3cret"}, "")
+
+	serverSaw, serverErr, clientSaw, clientErr := runHandshake(t, a, b)
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("handshake failed: server=%v client=%v", serverErr, clientErr)
+	}
+	if serverSaw != "node-b" || clientSaw != "node-a" {
+		t.Fatalf("unexpected identities: server saw %q, client saw %q", serverSaw, clientSaw)
+	}
+}
+
+func TestPeerHandshakeRejectsWrongSecret(t *testing.T) {
+	a, _ := newPeerAuthenticator(config.Peer_auth{NodeID: "node-a", ClusterSecret: "s3cret"}, "")
+	intruder, _ := newPeerAuthenticator(config.Peer_auth{NodeID: "node-x", ClusterSecret: "guess"}, "")
+
+	_, serverErr, _, clientErr := runHandshake(t, a, intruder)
+	if serverErr == nil {
+		t.Fatalf("expected acceptor to reject wrong secret")
+	}
+	if clientErr == nil {
+		t.Fatalf("expected dialer to see the rejection")
+	}
+}
+
+func TestPeerHandshakeKeyPairs(t *testing.T) {
+	pubA, privA, _ := ed25519.GenerateKey(rand.Reader)
+	pubB, privB, _ := ed25519.GenerateKey(rand.Reader)
+	_, privX, _ := ed25519.GenerateKey(rand.Reader)
+
+	a := &peerAuthenticator{mode: config.PeerAuthKeyPair, nodeID: "node-a", private: privA,
+		trusted: map[string]ed25519.PublicKey{"node-b": pubB}}
+	b := &peerAuthenticator{mode: config.PeerAuthKeyPair, nodeID: "node-b", private: privB,
+		trusted: map[string]ed25519.PublicKey{"node-a": pubA}}
+
+	serverSaw, serverErr, clientSaw, clientErr := runHandshake(t, a, b)
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("handshake failed: server=%v client=%v", serverErr, clientErr)
+	}
+	if serverSaw != "node-b" || clientSaw != "node-a" {
+		t.Fatalf("unexpected identities: server saw %q, client saw %q", serverSaw, clientSaw)
+	}
+
+	// klucz spoza trusted_peers podszywający się pod node-b
+	impostor := &peerAuthenticator{mode: config.PeerAuthKeyPair, nodeID: "node-b", private: privX,
+		trusted: map[string]ed25519.PublicKey{"node-a": pubA}}
+	if _, serverErr, _, _ := runHandshake(t, a, impostor); serverErr == nil {
+		t.Fatalf("expected impostor signature to be rejected")
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"log"
 
+	audit "github.com/PAW122/TsunamiDB/servers/audit"
 	tasks "github.com/PAW122/TsunamiDB/servers/network-manager/tasks"
+	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
 	types "github.com/PAW122/TsunamiDB/types"
 
 	"github.com/gorilla/websocket"
@@ -34,8 +36,13 @@ func handleMsg(peerAddr string, message []byte, nm *NetworkManager, conn *websoc
 		res = tasks.Read(req)
 	case "save":
 		res = tasks.Save(req)
+		auditTask(nm, peerAddr, req, res)
 	case "free":
 		res = tasks.Free(req)
+		auditTask(nm, peerAddr, req, res)
+	case "auth_challenge", "auth_response", "auth_ok", "auth_failed":
+		// spóźnione ramki handshake - połączenie jest już uwierzytelnione
+		return
 	default:
 		log.Println("📌 Nieznane zadanie:", req.Task)
 		return
@@ -60,3 +67,50 @@ func handleMsg(peerAddr string, message []byte, nm *NetworkManager, conn *websoc
 		log.Println("📌 Błąd wysyłania do", peerAddr, ":", err)
 	}
 }
+
+// auditTask zapisuje operację zmieniającą dane wykonaną na zlecenie peera.
+func auditTask(nm *NetworkManager, peerAddr string, req, res types.NMmessage) {
+	rec := audit.Record{
+		Identity: "peer:" + nm.PeerIdentity(peerAddr),
+		Source:   audit.SourceNetwork,
+		Op:       req.Task,
+		Size:     int64(len(req.Content)),
+		Outcome:  "ok",
+	}
+	if len(req.Args) > 0 {
+		rec.Table = req.Args[0]
+	}
+	if len(req.Args) > 1 {
+		rec.Key = req.Args[1]
+	}
+	if !res.Finished {
+		rec.Outcome = "error"
+	}
+	audit.Log(rec)
+}
+
+// handleSubEvent publikuje zdarzenie subskrypcji od peera lokalnie; subServer przekazuje je
+// dalej pozostałym peerom i odrzuca duplikaty.
+func handleSubEvent(peerAddr string, msg types.NMmessage) {
+	var ev subServer.ClusterEvent
+	if err := json.Unmarshal(msg.Content, &ev); err != nil {
+		log.Println("📌 Błąd parsowania sub_event od", peerAddr, ":", err)
+		return
+	}
+	subServer.PublishRemote(ev, peerAddr)
+}
+
+// forwardSubEvent wysyła zdarzenie subskrypcji do wszystkich peerów poza from.
+func (nm *NetworkManager) forwardSubEvent(ev subServer.ClusterEvent, from string) {
+	content, err := json.Marshal(ev)
+	if err != nil {
+		log.Println("📌 Błąd serializacji sub_event:", err)
+		return
+	}
+	msg, err := json.Marshal(types.NMmessage{Task: "sub_event", Content: content, ReqSendBy: nm.ServerIP})
+	if err != nil {
+		log.Println("📌 Błąd serializacji sub_event:", err)
+		return
+	}
+	nm.BroadcastMessage(from, msg)
+}
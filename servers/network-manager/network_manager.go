@@ -8,11 +8,14 @@ import (
 	"net/http"
 	"net/url"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 
+	config "github.com/PAW122/TsunamiDB/servers/config"
+	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
 	types "github.com/PAW122/TsunamiDB/types"
 )
 
@@ -20,6 +23,7 @@ import (
 type Peer struct {
 	Conn       *websocket.Conn
 	Address    string
+	Identity   string // node id potwierdzony handshake'iem ("" w trybie bez auth)
 	LastActive time.Time
 }
 
@@ -31,6 +35,7 @@ type NetworkManager struct {
 	ServerIP         string
 	upgrader         websocket.Upgrader
 	responseChannels map[string]chan types.NMmessage
+	auth             *peerAuthenticator
 }
 
 type Stats struct {
@@ -39,6 +44,9 @@ type Stats struct {
 	ConnectedPeers   int      `json:"connected_peers"`
 	PeerAddresses    []string `json:"peer_addresses"`
 	PendingResponses int      `json:"pending_responses"`
+	AuthMode         string   `json:"auth_mode"`
+	// adres peera -> node id potwierdzony podczas handshake
+	PeerIdentities map[string]string `json:"peer_identities,omitempty"`
 }
 
 var nmInstance *NetworkManager
@@ -81,16 +89,28 @@ func StartNetworkManager(port int, knownPeers []string) {
 	// }
 	localIP := GetOutboundIP().String()
 
+	auth, err := newPeerAuthenticator(config.Get().Tsu_network_config.Auth, fmt.Sprintf("%s:%d", localIP, port))
+	if err != nil {
+		log.Fatal("Nie można załadować konfiguracji auth peerów: ", err)
+	}
+	if !auth.enabled() {
+		log.Println("📌 UWAGA: uwierzytelnianie peerów wyłączone - każdy, kto ma dostęp do portu", port, "może zapisywać i usuwać dane")
+	}
+
 	nmInstance = &NetworkManager{
 		peers:            make(map[string]*Peer),
 		responseChannels: make(map[string]chan types.NMmessage),
 		port:             port,
 		ServerIP:         localIP,
+		auth:             auth,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
 	}
 
+	// zdarzenia subskrypcji z tego node'a trafiają do subskrybentów na pozostałych
+	subServer.SetClusterForwarder(auth.nodeID, nmInstance.forwardSubEvent)
+
 	// Start serwera WebSocket
 	go nmInstance.startServer()
 
@@ -132,10 +152,51 @@ func SetInstanceForTests(nm *NetworkManager) {
 func (nm *NetworkManager) startServer() {
 	http.HandleFunc("/ws", nm.handleConnection)
 	addr := fmt.Sprintf(":%d", nm.port)
+
+	tlsConfig, err := config.Get().Tls.Network.ServerTLSConfig()
+	if err != nil {
+		log.Fatal("Nie można załadować TLS dla network managera: ", err)
+	}
+	if tlsConfig != nil {
+		server := &http.Server{Addr: addr, TLSConfig: tlsConfig}
+		log.Println("Serwer WebSocket (wss) działa na", addr)
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+
 	log.Println("Serwer WebSocket działa na", addr)
 	log.Fatal(http.ListenAndServe(addr, nil))
 }
 
+// peerURL zamienia adres peera na URL /ws.
+// "wss://host:port" / "ws://host:port" wymuszają schemat, sam "host:port"
+// używa wss gdy network manager ma skonfigurowany TLS.
+func peerURL(peerAddr string) url.URL {
+	scheme := "ws"
+	if config.Get().Tls.Network.Enabled() {
+		scheme = "wss"
+	}
+	host := peerAddr
+	if strings.HasPrefix(peerAddr, "wss://") {
+		scheme, host = "wss", strings.TrimPrefix(peerAddr, "wss://")
+	} else if strings.HasPrefix(peerAddr, "ws://") {
+		scheme, host = "ws", strings.TrimPrefix(peerAddr, "ws://")
+	}
+	return url.URL{Scheme: scheme, Host: host, Path: "/ws"}
+}
+
+func peerDialer(u url.URL) (*websocket.Dialer, error) {
+	if u.Scheme != "wss" {
+		return websocket.DefaultDialer, nil
+	}
+	tlsConfig, err := config.Get().Tls.Network.ClientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+	return &dialer, nil
+}
+
 // handleConnection obsługuje nowe połączenia WebSocket
 func (nm *NetworkManager) handleConnection(w http.ResponseWriter, r *http.Request) {
 	conn, err := nm.upgrader.Upgrade(w, r, nil)
@@ -147,6 +208,18 @@ func (nm *NetworkManager) handleConnection(w http.ResponseWriter, r *http.Reques
 	peerAddr := conn.RemoteAddr().String()
 	log.Println("Nowe połączenie:", peerAddr)
 
+	// handshake przed dodaniem do nm.peers - nieuwierzytelniony peer nie wykona żadnego taska
+	var identity string
+	if nm.auth.enabled() {
+		identity, err = nm.auth.authenticateIncoming(conn)
+		if err != nil {
+			log.Println("📌 Odrzucono peera", peerAddr, ":", err)
+			conn.Close()
+			return
+		}
+		log.Println("📌 Uwierzytelniono peera", peerAddr, "jako", identity)
+	}
+
 	/*
 		assign connect server Ip to new conected server in the network
 	*/
@@ -166,7 +239,7 @@ func (nm *NetworkManager) handleConnection(w http.ResponseWriter, r *http.Reques
 	// log.Println("Przekazano IP klientowi:", peerIP)
 
 	nm.Lock()
-	nm.peers[peerAddr] = &Peer{Conn: conn, Address: peerAddr, LastActive: time.Now()}
+	nm.peers[peerAddr] = &Peer{Conn: conn, Address: peerAddr, Identity: identity, LastActive: time.Now()}
 	nm.Unlock()
 
 	go nm.listenForMessages(peerAddr, conn)
@@ -174,17 +247,32 @@ func (nm *NetworkManager) handleConnection(w http.ResponseWriter, r *http.Reques
 
 // connectToPeer łączy się do znanego serwera
 func (nm *NetworkManager) connectToPeer(peerAddr string) {
-	u := url.URL{Scheme: "ws", Host: peerAddr, Path: "/ws"}
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	u := peerURL(peerAddr)
+	dialer, err := peerDialer(u)
+	if err != nil {
+		log.Println("📌 Błąd konfiguracji TLS dla:", peerAddr, ":", err)
+		return
+	}
+	conn, _, err := dialer.Dial(u.String(), nil)
 	if err != nil {
 		log.Println("📌 Nie można połączyć z:", peerAddr, ":", err)
 		return
 	}
 
+	var identity string
+	if nm.auth.enabled() {
+		identity, err = nm.auth.authenticateOutgoing(conn)
+		if err != nil {
+			log.Println("📌 Handshake z", peerAddr, "nieudany:", err)
+			conn.Close()
+			return
+		}
+	}
+
 	log.Println("📌 Połączono z:", peerAddr)
 
 	nm.Lock()
-	nm.peers[peerAddr] = &Peer{Conn: conn, Address: peerAddr, LastActive: time.Now()}
+	nm.peers[peerAddr] = &Peer{Conn: conn, Address: peerAddr, Identity: identity, LastActive: time.Now()}
 	nm.Unlock()
 
 	log.Println("📌 Aktualna lista peerów po połączeniu:", nm.listPeers())
@@ -227,6 +315,12 @@ func (nm *NetworkManager) listenForMessages(peerAddr string, conn *websocket.Con
 			continue
 		}
 
+		// 🔹 Zdarzenia subskrypcji obsługujemy po kolei (kolejność zdarzeń z jednego peera)
+		if response.Task == "sub_event" {
+			handleSubEvent(peerAddr, response)
+			continue
+		}
+
 		// 🔹 Obsługujemy nowe żądania
 		go handleMsg(peerAddr, message, nm, conn)
 	}
@@ -242,18 +336,42 @@ func (nm *NetworkManager) Snapshot() Stats {
 	defer nm.Unlock()
 
 	peers := make([]string, 0, len(nm.peers))
-	for addr := range nm.peers {
+	identities := make(map[string]string)
+	for addr, peer := range nm.peers {
 		peers = append(peers, addr)
+		if peer.Identity != "" {
+			identities[addr] = peer.Identity
+		}
 	}
 	sort.Strings(peers)
 
-	return Stats{
+	authMode := config.PeerAuthDisabled
+	if nm.auth != nil {
+		authMode = nm.auth.mode
+	}
+
+	stats := Stats{
 		ServerIP:         nm.ServerIP,
 		Port:             nm.port,
 		ConnectedPeers:   len(peers),
 		PeerAddresses:    peers,
 		PendingResponses: len(nm.responseChannels),
+		AuthMode:         authMode,
+	}
+	if len(identities) > 0 {
+		stats.PeerIdentities = identities
+	}
+	return stats
+}
+
+// PeerIdentity zwraca node id peera (albo jego adres, gdy auth jest wyłączony).
+func (nm *NetworkManager) PeerIdentity(peerAddr string) string {
+	nm.Lock()
+	defer nm.Unlock()
+	if peer, ok := nm.peers[peerAddr]; ok && peer.Identity != "" {
+		return peer.Identity
 	}
+	return peerAddr
 }
 
 // BroadcastMessage relays messages to all connected peers
@@ -3,6 +3,7 @@ package tasks
 import (
 	defragmentationManager "github.com/PAW122/TsunamiDB/data/defragmentationManager"
 	fileSystem_v1 "github.com/PAW122/TsunamiDB/data/fileSystem/v1"
+	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
 	types "github.com/PAW122/TsunamiDB/types"
 )
 
@@ -25,6 +26,7 @@ func Free(req types.NMmessage) types.NMmessage {
 	}
 	fileSystem_v1.RemoveElementByKey(file, key)
 	defragmentationManager.MarkAsFree(key, file, int64(fs_data.StartPtr), int64(fs_data.EndPtr))
+	go subServer.NotifyDeleteAndRemove(file, key)
 
 	req.Finished = true
 	return req
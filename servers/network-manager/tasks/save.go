@@ -5,6 +5,8 @@ import (
 	defragManager "github.com/PAW122/TsunamiDB/data/defragmentationManager"
 	fileSystem_v1 "github.com/PAW122/TsunamiDB/data/fileSystem/v1"
 	encoder_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+	limits "github.com/PAW122/TsunamiDB/servers/limits"
+	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
 	types "github.com/PAW122/TsunamiDB/types"
 )
 
@@ -19,6 +21,13 @@ func Save(req types.NMmessage) types.NMmessage {
 	}
 
 	encoded, _ := encoder_v1.Encode(req.Content)
+	release, err := limits.ReserveSave(file, key, int64(len(encoded)))
+	if err != nil {
+		return types.NMmessage{
+			Finished: false,
+		}
+	}
+	defer release()
 	startPtr, endPtr, err := dataManager_v1.SaveDataToFile(encoded, file)
 	if err != nil {
 		// w.WriteHeader(http.StatusInternalServerError)
@@ -44,6 +53,9 @@ func Save(req types.NMmessage) types.NMmessage {
 		}
 	}
 
+	// subskrybenci na tym node'cie (i przez fan-out na pozostałych)
+	go subServer.NotifySubscribers(file, key, req.Content)
+
 	//saved
 	req.Finished = true
 	req.Content = nil // no need to process extra data
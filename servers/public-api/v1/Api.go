@@ -7,6 +7,10 @@ import (
 	"net/http"
 	"time"
 
+	audit "github.com/PAW122/TsunamiDB/servers/audit"
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+	config "github.com/PAW122/TsunamiDB/servers/config"
+	limits "github.com/PAW122/TsunamiDB/servers/limits"
 	metrics "github.com/PAW122/TsunamiDB/servers/public-api/v1/metrics"
 	routes "github.com/PAW122/TsunamiDB/servers/public-api/v1/routes"
 	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
@@ -43,25 +47,52 @@ func withClient(fn func(http.ResponseWriter, *http.Request, *http.Client)) http.
 	}
 }
 
+// route = auth (klucz API + uprawnienia do tabeli z path) + rate limit + wstrzyknięty klient
+func route(access auth.Access, fn func(http.ResponseWriter, *http.Request, *http.Client)) http.HandlerFunc {
+	return auth.Middleware(access, limits.Middleware(access != auth.AccessNone, withClient(fn)))
+}
+
+// audited = route + wpis w audit logu (operacje zmieniające dane)
+func audited(op string, fn func(http.ResponseWriter, *http.Request, *http.Client)) http.HandlerFunc {
+	return auth.Middleware(auth.AccessWrite, limits.Middleware(true, audit.Middleware(op, withClient(fn))))
+}
+
 func RunPublicApi_v1(port int) {
 	mux := http.NewServeMux()
 
+	// replay wpisów inc table dla subskrypcji z last_seen
+	subServer.SetIncReplaySource(routes.ReadIncReplayPage)
+
 	// —— zapisy / odczyty ——
-	mux.HandleFunc("/save/", withClient(routes.AsyncSave))
-	mux.HandleFunc("/read/", withClient(routes.AsyncRead))
-	mux.HandleFunc("/free/", withClient(routes.Free))
-	mux.HandleFunc("/save_encrypted/", withClient(routes.SaveEncrypted))
-	mux.HandleFunc("/read_encrypted/", withClient(routes.ReadEncrypted))
-	mux.HandleFunc("/subscriptions/enable", withClient(subServer.HandleEnableSubscription))
-	mux.HandleFunc("/subscriptions/disable", withClient(subServer.HandleDisableSubscription))
-	mux.HandleFunc("/save_inc/", withClient(routes.SaveIncremental))
-	mux.HandleFunc("/read_inc/", withClient(routes.ReadIncremental))
-	mux.HandleFunc("/delete_inc/", withClient(routes.DeleteIncremental))
+	mux.HandleFunc("/save/", audited("save", routes.AsyncSave))
+	mux.HandleFunc("/read/", route(auth.AccessRead, routes.AsyncRead))
+	mux.HandleFunc("/free/", audited("free", routes.Free))
+	mux.HandleFunc("/save_encrypted/", audited("save_encrypted", routes.SaveEncrypted))
+	mux.HandleFunc("/read_encrypted/", route(auth.AccessRead, routes.ReadEncrypted))
+	// uprawnienia do tabel sprawdzane w handlerach (tabela w body)
+	mux.HandleFunc("/subscriptions/enable", route(auth.AccessNone, subServer.HandleEnableSubscription))
+	mux.HandleFunc("/subscriptions/disable", route(auth.AccessNone, subServer.HandleDisableSubscription))
+	mux.HandleFunc("/subscriptions/revoke", route(auth.AccessNone, subServer.HandleRevokeSubscriptions))
+	mux.HandleFunc("/subscriptions/webhooks", route(auth.AccessNone, subServer.HandleWebhooks))
+	mux.HandleFunc("/subscriptions/webhooks/dead_letters", route(auth.AccessNone, subServer.HandleWebhookDeadLetters))
+	// kanały bez zapisu: uprawnienie zapisu do "#<kanał>" sprawdzane w handlerze
+	mux.HandleFunc("/publish/", route(auth.AccessNone, subServer.HandlePublish))
+	// SSE / long-poll: uwierzytelnienie przez auth_key z /subscriptions/enable (jak /sub),
+	// limity per klucz API liczone dla identity, która wygenerowała auth_key
+	mux.HandleFunc("/subscriptions/stream", subServer.WithAuthKeyIdentity(limits.Middleware(false, withClient(subServer.HandleSSE))))
+	mux.HandleFunc("/subscriptions/poll", subServer.WithAuthKeyIdentity(limits.Middleware(false, withClient(subServer.HandlePoll))))
+	mux.HandleFunc("/save_inc/", audited("save_inc", routes.SaveIncremental))
+	mux.HandleFunc("/read_inc/", route(auth.AccessRead, routes.ReadIncremental))
+	mux.HandleFunc("/delete_inc/", audited("delete_inc", routes.DeleteIncremental))
+	mux.HandleFunc("/compact_inc/", audited("compact_inc", routes.CompactIncremental))
+	mux.HandleFunc("/resize_inc/", audited("resize_inc", routes.ResizeIncremental))
+	mux.HandleFunc("/retention_inc/", audited("retention_inc", routes.RetentionIncremental))
 
 	// —— operacje meta ——
-	mux.HandleFunc("/sql", withClient(routes.SQL_api))
-	mux.HandleFunc("/key_by_regex/", withClient(routes.GetKeysByRegex))
+	mux.HandleFunc("/sql", route(auth.AccessWrite, routes.SQL_api))
+	mux.HandleFunc("/key_by_regex/", route(auth.AccessRead, routes.GetKeysByRegex))
 	mux.HandleFunc("/health", withClient(routes.Health))
+	mux.HandleFunc("/audit", route(auth.AccessNone, audit.HandleQuery))
 
 	// ------- serwer HTTP --------
 	server := &http.Server{
@@ -73,14 +104,25 @@ func RunPublicApi_v1(port int) {
 		MaxHeaderBytes: 1 << 20, // 1 MB
 	}
 
+	tlsConfig, err := config.Get().Tls.PublicApi.ServerTLSConfig()
+	if err != nil {
+		log.Fatalf("Nie można załadować TLS dla Public API: %v", err)
+	}
+	server.TLSConfig = tlsConfig
+
 	listener, err := net.Listen("tcp", server.Addr)
 	if err != nil {
 		log.Fatalf("Nie można uruchomić listenera: %v", err)
 	}
 
-	fmt.Printf("Public API v1 nasłuchuje na :%d (keep-alive, HTTP/2 włączone jeśli TLS)\n", port)
-
-	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+	if tlsConfig != nil {
+		fmt.Printf("Public API v1 nasłuchuje na :%d (HTTPS, HTTP/2)\n", port)
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		fmt.Printf("Public API v1 nasłuchuje na :%d (keep-alive, HTTP/2 włączone jeśli TLS)\n", port)
+		err = server.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Błąd serwera: %v", err)
 	}
 }
@@ -38,6 +38,6 @@ func Free(w http.ResponseWriter, r *http.Request, c *http.Client) {
 	fileSystem_v1.RemoveElementByKey(file, key)
 	defragmentationManager.MarkAsFree(key, file, int64(fs_data.StartPtr), int64(fs_data.EndPtr))
 
-	go subServer.NotifyDeleteAndRemove(key)
+	go subServer.NotifyDeleteAndRemove(file, key)
 	fmt.Fprint(w, "free")
 }
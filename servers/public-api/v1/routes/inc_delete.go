@@ -1,8 +1,12 @@
 package routes
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	dataManager_v2 "github.com/PAW122/TsunamiDB/data/dataManager/v2"
 	defragmentationManager "github.com/PAW122/TsunamiDB/data/defragmentationManager"
@@ -11,13 +15,46 @@ import (
 	encoder_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
 	debug "github.com/PAW122/TsunamiDB/servers/debug"
 	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
+	types "github.com/PAW122/TsunamiDB/types"
 )
 
-// DeleteIncremental removes the incremental table file and drops its KV metadata entry.
+// readIncTableInfo wczytuje deskryptor inc table zapisany w KV pod <file>/<key>.
+func readIncTableInfo(file, key string) (*fileSystem_v1.GetElement_output, types.IncTableEntryData, error) {
+	fsData, err := fileSystem_v1.GetElementByKey(file, key)
+	if err != nil {
+		return nil, types.IncTableEntryData{}, err
+	}
+	data, err := dataManager_v2.ReadDataFromFileAsync(
+		file,
+		int64(fsData.StartPtr),
+		int64(fsData.EndPtr),
+	)
+	if err != nil {
+		return fsData, types.IncTableEntryData{}, fmt.Errorf("cannot read inc table metadata: %w", err)
+	}
+	decoded := encoder_v1.Decode(data)
+	incInfo, err := BytesToStructBinary([]byte(decoded.Data))
+	if err != nil {
+		return fsData, types.IncTableEntryData{}, fmt.Errorf("cannot decode inc table metadata: %w", err)
+	}
+	return fsData, incInfo, nil
+}
+
+/*
+GET /delete_inc/<table>/<key>     - usuwa cały plik inc table i deskryptor z KV
+DELETE /delete_inc/<table>/<key>  - usuwa pojedynczy wpis (skip bit)
+headers (DELETE):
+
+	id = <uint64> | entry_key = <string>
+	*key_name = <string> [default entry_key] (nazwa klucza dla entry_key)
+	*count_from = top | bottom [default bottom] (tylko dla id)
+
+response (DELETE): 200 {"id": "<id liczone od najstarszego>"} | 404
+*/
 func DeleteIncremental(w http.ResponseWriter, r *http.Request, client *http.Client) {
 	defer debug.MeasureTime("> api [DeleteInc]")()
 
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
@@ -32,33 +69,26 @@ func DeleteIncremental(w http.ResponseWriter, r *http.Request, client *http.Clie
 	file := pathParts[2]
 	key := pathParts[3]
 
-	fsData, err := fileSystem_v1.GetElementByKey(file, key)
-	if err != nil {
+	defer lockIncTable(key, false)()
+
+	fsData, incInfo, err := readIncTableInfo(file, key)
+	if fsData == nil {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprint(w, "Key not found")
 		return
 	}
-
-	data, err := dataManager_v2.ReadDataFromFileAsync(
-		file,
-		int64(fsData.StartPtr),
-		int64(fsData.EndPtr),
-	)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "Cannot read inc table metadata: "+err.Error())
+		fmt.Fprint(w, err.Error())
 		return
 	}
 
-	decoded := encoder_v1.Decode(data)
-	incInfo, err := BytesToStructBinary([]byte(decoded.Data))
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "Cannot decode inc table metadata: "+err.Error())
+	if r.Method == http.MethodDelete {
+		deleteIncEntry(w, r, file, key, incInfo)
 		return
 	}
 
-	if err := dataManager_v2.DeleteIncTableFile(incInfo.TableFileName); err != nil {
+	if err := dataManager_v2.DeleteIncTableFile(incInfo.TableFileName, incInfo.EntrySize); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, "Cannot delete inc table file: "+err.Error())
 		return
@@ -70,8 +100,124 @@ func DeleteIncremental(w http.ResponseWriter, r *http.Request, client *http.Clie
 
 	fileSystem_v1.RemoveElementByKey(file, key)
 	defragmentationManager.MarkAsFree(key, file, int64(fsData.StartPtr), int64(fsData.EndPtr))
-	go subServer.NotifyDeleteAndRemove(key)
+	go subServer.NotifyDeleteAndRemove(file, key)
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, "delete_inc")
 }
+
+func deleteIncEntry(w http.ResponseWriter, r *http.Request, file, key string, incInfo types.IncTableEntryData) {
+	var entryID uint64
+	countFrom := r.Header.Get("count_from")
+	if countFrom != "top" {
+		countFrom = "bottom"
+	}
+
+	if entryKey := r.Header.Get("entry_key"); entryKey != "" {
+		keyName := incindex.DefaultName
+		if name := r.Header.Get("key_name"); name != "" {
+			keyName = strings.ToLower(name)
+		}
+		if err := reconcileIncIndex(incInfo); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Index lookup error: "+err.Error())
+			return
+		}
+		pos, ok, err := incindex.LookupKey(incInfo.TableFileName, keyName, entryKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Index lookup error: "+err.Error())
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "Entry not found")
+			return
+		}
+		entryID = pos
+		countFrom = "bottom"
+	} else {
+		raw := r.Header.Get("id")
+		if raw == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Missing id or entry_key header")
+			return
+		}
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid header value", http.StatusBadRequest)
+			return
+		}
+		entryID = id
+	}
+
+	id, _, err := dataManager_v2.DeleteIncEntry(incInfo.TableFileName, incInfo.EntrySize, entryID, countFrom)
+	if err != nil {
+		if errors.Is(err, dataManager_v2.ErrIncEntryNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "Entry not found")
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Error deleting inc entry: "+err.Error())
+		return
+	}
+
+	if _, err := incindex.RemoveAt(incInfo.TableFileName, id); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Index update error: "+err.Error())
+		return
+	}
+
+	go subServer.NotifyIncTableSubscribers(file, key, "delete", id, nil)
+
+	respondWithIncID(w, id, "")
+}
+
+/*
+POST /compact_inc/<table>/<key>
+fizycznie usuwa wpisy oznaczone jako usunięte; id pozostałych wpisów się nie zmieniają
+
+response: 200 {"removed": <ilość usuniętych rekordów>, "total": <ilość rekordów po kompakcji>}
+*/
+func CompactIncremental(w http.ResponseWriter, r *http.Request, client *http.Client) {
+	defer debug.MeasureTime("> api [CompactInc]")()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	pathParts := ParseArgs(r.URL.Path, "compact_inc")
+	if len(pathParts) < 4 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid url args")
+		return
+	}
+
+	defer lockIncTable(pathParts[3], false)()
+
+	fsData, incInfo, err := readIncTableInfo(pathParts[2], pathParts[3])
+	if fsData == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Key not found")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	removed, total, err := dataManager_v2.CompactIncTable(incInfo.TableFileName, incInfo.EntrySize)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Error compacting inc table: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]uint64{
+		"removed": uint64(len(removed)),
+		"total":   total,
+	})
+}
@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+Filtr dla read_inc read_type = "filter" (wykonywany w workerze pliku, na zdekodowanym payloadzie):
+
+- filter_mode: "contains" | "regex" | "json_eq"
+	- contains: payload zawiera {filter_value}
+	- regex: payload pasuje do wyrażenia {filter_value} (składnia Go RE2)
+	- json_eq: payload jest JSONem, a wartość pod {filter_field} (ścieżka z kropkami,
+	  np. "user.id" albo "items.0.name") jest równa {filter_value}.
+	  {filter_value} jest parsowane jako JSON (42, true, "x", {"a":1}); jeżeli nie jest
+	  poprawnym JSONem, porównywane jest jako string.
+- scan_limit: maks. ilość sprawdzonych wpisów w jednym zapytaniu (default 10000, max 1000000).
+  Zapytanie kończy się po amount_to_read dopasowaniach albo po scan_limit sprawdzonych wpisach;
+  next_cursor pozwala kontynuować skanowanie od pierwszego nieprzejrzanego wpisu.
+*/
+
+const (
+	defaultScanLimit = 10000
+	maxScanLimit     = 1000000
+)
+
+func parseScanLimit(raw string) (uint64, error) {
+	if raw == "" {
+		return defaultScanLimit, nil
+	}
+	limit, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil || limit == 0 {
+		return 0, errors.New("Invalid scan_limit header")
+	}
+	if limit > maxScanLimit {
+		limit = maxScanLimit
+	}
+	return limit, nil
+}
+
+// parseIncFilter buduje predykat z nagłówków filter_mode / filter_value / filter_field.
+func parseIncFilter(r *http.Request) (func(data []byte) bool, error) {
+	value := r.Header.Get("filter_value")
+
+	switch strings.ToLower(r.Header.Get("filter_mode")) {
+	case "contains":
+		if value == "" {
+			return nil, errors.New("Missing filter_value header")
+		}
+		needle := []byte(value)
+		return func(data []byte) bool { return bytes.Contains(data, needle) }, nil
+
+	case "regex":
+		if value == "" {
+			return nil, errors.New("Missing filter_value header")
+		}
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter_value regex: %v", err)
+		}
+		return re.Match, nil
+
+	case "json_eq":
+		field := r.Header.Get("filter_field")
+		if field == "" {
+			return nil, errors.New("Missing filter_field header")
+		}
+		var want any = value
+		var parsed any
+		if json.Unmarshal([]byte(value), &parsed) == nil {
+			want = parsed
+		}
+		path := strings.Split(field, ".")
+		return func(data []byte) bool {
+			var doc any
+			if json.Unmarshal(data, &doc) != nil {
+				return false
+			}
+			got, ok := jsonLookup(doc, path)
+			return ok && reflect.DeepEqual(got, want)
+		}, nil
+
+	case "":
+		return nil, errors.New("Missing filter_mode header")
+	default:
+		return nil, fmt.Errorf("Unsupported filter_mode: %s", r.Header.Get("filter_mode"))
+	}
+}
+
+// jsonLookup schodzi po ścieżce (klucze obiektów albo indeksy tablic).
+func jsonLookup(doc any, path []string) (any, bool) {
+	cur := doc
+	for _, part := range path {
+		switch node := cur.(type) {
+		case map[string]any:
+			next, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
@@ -5,26 +5,37 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	dataManager_v2 "github.com/PAW122/TsunamiDB/data/dataManager/v2"
 	fileSystem_v1 "github.com/PAW122/TsunamiDB/data/fileSystem/v1"
 	incindex "github.com/PAW122/TsunamiDB/data/incIndex"
 	encoder_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
 	encoding_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+	types "github.com/PAW122/TsunamiDB/types"
 )
 
 /*
 
 GET /read_inc/{file}/{key}
 Params:
-- read_type: "by_id" | "last_entries" | "first_entries" | "by_key" (default: by_id)
+- read_type: "by_id" | "last_entries" | "first_entries" | "by_key" | "range" | "filter" | "time_range" (default: by_id)
 	- by_id: {id}
 	- last_entries: {amount_to_read}
 	- first_entries: {amount_to_read}
-	- by_key: {entry_key}
+	- by_key: {entry_key}, *{key_name} [default entry_key] (nazwa klucza z entry_key_<name> przy zapisie)
+	- range: {amount_to_read}, *{start_id} [default 0], *{count_from} = top | bottom [default bottom]
+		> bottom: start_id liczone od najstarszego, wpisy od starszych do nowszych
+		> top: start_id liczone od najnowszego (0 = najnowszy), wpisy od nowszych do starszych
+	- filter: jak range + {filter_mode}, {filter_value}, *{filter_field}, *{scan_limit} [default 10000] (patrz inc_filter.go)
+	- time_range: jak range + *{from_time} (włącznie), *{to_time} (wyłącznie) - RFC 3339 albo unix ms, min. jeden z nich
 
 Response:
 - 200 OK + JsonList:{decoded entries}
+- range, time_range: 200 OK + {"entries":[{"id","data","timestamp"}], "next_cursor": <start_id kolejnej strony | brak>, "total": <ilość rekordów>}
+- filter: jak range + "scanned": <ilość sprawdzonych wpisów>
+- każdy wpis ma "timestamp" (RFC 3339 UTC) - czas zapisu albo podany przez klienta przy save_inc
+  (brak dla wpisów zapisanych przed wprowadzeniem znaczników czasu)
 */
 
 func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
@@ -32,6 +43,12 @@ func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
 	var read_type_int uint8 // 0 = by id, 1 = last N entries, 2 = first N entries
 	var amount_to_read uint64
 	var requestedKey string
+	keyName := incindex.DefaultName
+	var start_id uint64
+	var count_from string
+	var scan_limit uint64
+	var match func(data []byte) bool
+	var from_time, to_time int64
 
 	if r.Method != "GET" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -91,6 +108,62 @@ func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
 		} else {
 			read_type_int = 1
 		}
+	case "range", "filter", "time_range":
+		raw_amount := r.Header.Get("amount_to_read")
+		if raw_amount == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Missing amount_to_read header")
+			return
+		}
+		amount, err := strconv.ParseUint(raw_amount, 10, 64)
+		if err != nil || amount == 0 {
+			http.Error(w, "Invalid header value", http.StatusBadRequest)
+			return
+		}
+		amount_to_read = amount
+
+		if raw_start := r.Header.Get("start_id"); raw_start != "" {
+			start, err := strconv.ParseUint(raw_start, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid header value", http.StatusBadRequest)
+				return
+			}
+			start_id = start
+		}
+
+		count_from = r.Header.Get("count_from")
+		if count_from != "top" && count_from != "bottom" {
+			count_from = "bottom"
+		}
+		read_type_int = 4
+
+		if read_type == "filter" {
+			scan_limit, err = parseScanLimit(r.Header.Get("scan_limit"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			match, err = parseIncFilter(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			read_type_int = 5
+		}
+		if read_type == "time_range" {
+			if from_time, err = parseIncTime("from_time", r.Header.Get("from_time")); err == nil {
+				to_time, err = parseIncTime("to_time", r.Header.Get("to_time"))
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if from_time == 0 && to_time == 0 {
+				http.Error(w, "Missing from_time or to_time header", http.StatusBadRequest)
+				return
+			}
+			read_type_int = 6
+		}
 	case "by_key":
 		requestedKey = r.Header.Get("entry_key")
 		if requestedKey == "" {
@@ -98,6 +171,9 @@ func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
 			fmt.Fprint(w, "Missing entry_key header")
 			return
 		}
+		if name := r.Header.Get("key_name"); name != "" {
+			keyName = strings.ToLower(name)
+		}
 		read_type_int = 3
 	default:
 		w.WriteHeader(http.StatusBadRequest)
@@ -105,6 +181,8 @@ func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
 		return
 	}
 
+	defer lockIncTable(key, false)()
+
 	fsData, err := fileSystem_v1.GetElementByKey(file, key)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -128,7 +206,12 @@ func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
 	}
 
 	if read_type_int == 3 {
-		pos, ok, err := incindex.Lookup(raw_table_data.TableFileName, requestedKey)
+		if err := reconcileIncIndex(raw_table_data); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Index lookup error: "+err.Error())
+			return
+		}
+		pos, ok, err := incindex.LookupKey(raw_table_data.TableFileName, keyName, requestedKey)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprint(w, "Index lookup error: "+err.Error())
@@ -143,9 +226,24 @@ func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
 		read_type_int = 0
 	}
 
+	if read_type_int == 4 {
+		respondIncRange(w, raw_table_data, start_id, amount_to_read, count_from)
+		return
+	}
+	if read_type_int == 5 {
+		rng, err := dataManager_v2.ReadIncDataFromFileAsync_Filter(raw_table_data.TableFileName, start_id, amount_to_read, scan_limit, raw_table_data.EntrySize, count_from, match)
+		writeIncRange(w, raw_table_data, rng, err, true)
+		return
+	}
+	if read_type_int == 6 {
+		rng, err := dataManager_v2.ReadIncDataFromFileAsync_TimeRange(raw_table_data.TableFileName, from_time, to_time, start_id, amount_to_read, raw_table_data.EntrySize, count_from)
+		writeIncRange(w, raw_table_data, rng, err, false)
+		return
+	}
+
 	// req odczytania danych z inc_table
 	if read_type_int == 0 {
-		raw, err := dataManager_v2.ReadIncDataFromFileAsync_ById(raw_table_data.TableFileName, read_id, raw_table_data.EntrySize)
+		raw, ts, err := dataManager_v2.ReadIncEntryFromFileAsync_ById(raw_table_data.TableFileName, read_id, raw_table_data.EntrySize)
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			fmt.Fprint(w, "Entry not found")
@@ -162,12 +260,27 @@ func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
 			fmt.Fprint(w, "Error decoding entry: "+err.Error())
 			return
 		}
+		if entry.SkipBit {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "Entry not found")
+			return
+		}
+		payload, err := dataManager_v2.ResolveIncPayload(raw_table_data.TableFileName, entry)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Error reading overflow payload: "+err.Error())
+			return
+		}
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, `{"data":"%s"}`, entry.Data)
+		if ts := formatIncTime(ts); ts != "" {
+			fmt.Fprintf(w, `{"data":"%s","timestamp":"%s"}`, payload, ts)
+		} else {
+			fmt.Fprintf(w, `{"data":"%s"}`, payload)
+		}
 		return
 		// raw ma długość (entrySize+3). Zdekodujesz przez DecodeIncEntry(entrySize, raw).
 	} else if read_type_int == 2 {
-		raw, err := dataManager_v2.ReadIncDataFromFileAsync_FirstEntries(
+		rng, err := dataManager_v2.ReadIncDataFromFileAsync_FirstEntries(
 			raw_table_data.TableFileName,
 			amount_to_read,
 			raw_table_data.EntrySize,
@@ -178,50 +291,21 @@ func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
 			return
 		}
 
-		recordSize := int(raw_table_data.EntrySize) + 3
-		if recordSize <= 0 {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "Invalid entry size")
-			return
-		}
-		if len(raw)%recordSize != 0 {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Corrupted read: data len=%d not divisible by recordSize=%d", len(raw), recordSize)
-			return
-		}
-
-		// Struktura odpowiedzi (ID + dane; []byte zserializuje się do base64 w JSON)
+		// Struktura odpowiedzi (ID + dane)
 		type IncEntryJSON struct {
-			ID   uint64 `json:"id"`
-			Data string `json:"data"`
-			// jeśli chcesz debug: Skip/Next:
-			// Skip bool   `json:"skip"`
-			// Next uint64 `json:"next,omitempty"`
+			ID        uint64 `json:"id"`
+			Data      string `json:"data"`
+			Timestamp string `json:"timestamp,omitempty"`
 		}
 
-		entries := make([]IncEntryJSON, 0, len(raw)/recordSize)
-
-		// Pierwsze N rekordów → ich ID to 0..N-1
-		for i := 0; i < len(raw)/recordSize; i++ {
-			chunk := raw[i*recordSize : (i+1)*recordSize]
-
-			dec, err := encoding_v1.DecodeIncEntry(raw_table_data.EntrySize, chunk)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				fmt.Fprint(w, "DecodeIncEntry error: "+err.Error())
-				return
-			}
-			if dec.SkipBit {
-				// zgodnie z założeniem: pomijamy wpisy oznaczone skip
-				continue
-			}
-
-			entries = append(entries, IncEntryJSON{
-				ID:   uint64(i),
-				Data: string(dec.Data),
-				// Skip: dec.SkipBit,
-				// Next: dec.NextEntryPointer,
-			})
+		entries := make([]IncEntryJSON, 0, len(rng.IDs))
+		// worker zwraca tylko żywe wpisy (skip pominięte) razem z ich id
+		if err := decodeIncRecords(raw_table_data, rng, func(i int, data []byte) {
+			entries = append(entries, IncEntryJSON{ID: rng.IDs[i], Data: string(data), Timestamp: formatIncTime(rng.Times[i])})
+		}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -231,7 +315,7 @@ func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
 			return
 		}
 	} else {
-		raw, err := dataManager_v2.ReadIncDataFromFileAsync_LastEntries(
+		rng, err := dataManager_v2.ReadIncDataFromFileAsync_LastEntries(
 			raw_table_data.TableFileName,
 			amount_to_read,
 			raw_table_data.EntrySize,
@@ -242,49 +326,25 @@ func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
 			return
 		}
 
-		recordSize := int(raw_table_data.EntrySize) + 3
-		if recordSize <= 0 {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "Invalid entry size")
-			return
-		}
-		if len(raw)%recordSize != 0 {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Corrupted read: data len=%d not divisible by recordSize=%d", len(raw), recordSize)
-			return
-		}
-
 		type IncEntryJSON struct {
-			ID   uint64 `json:"id"`   // 0 = najnowszy w tej odpowiedzi
-			Data string `json:"data"` // []byte → base64 w JSON
-			// Skip bool   `json:"skip"`
-			// Next uint64 `json:"next,omitempty"`
+			ID        uint64 `json:"id"`   // 0 = najnowszy w tej odpowiedzi
+			Data      string `json:"data"` // []byte → base64 w JSON
+			Timestamp string `json:"timestamp,omitempty"`
 		}
 
-		count := len(raw) / recordSize
-		entries := make([]IncEntryJSON, 0, count)
+		entries := make([]IncEntryJSON, 0, len(rng.IDs))
 
 		// Worker zwraca newest→oldest, więc i=0 to najnowszy rekord w buforze.
-		for i := 0; i < count; i++ {
-			chunk := raw[i*recordSize : (i+1)*recordSize]
-
-			dec, err := encoding_v1.DecodeIncEntry(raw_table_data.EntrySize, chunk)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				fmt.Fprint(w, "DecodeIncEntry error: "+err.Error())
-				return
-			}
-			if dec.SkipBit {
-				// Pomijamy wpisy oznaczone jako skip
-				continue
-			}
-
+		if err := decodeIncRecords(raw_table_data, rng, func(i int, data []byte) {
 			entries = append(entries, IncEntryJSON{
-				ID:   uint64(i), // lokalny indeks: 0 = najnowszy
-				Data: string(dec.Data),
-				// Skip: dec.SkipBit,
-				// Next: dec.NextEntryPointer,
+				ID:        uint64(i), // lokalny indeks: 0 = najnowszy
+				Data:      string(data),
+				Timestamp: formatIncTime(rng.Times[i]),
 			})
+		}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -297,3 +357,71 @@ func ReadIncremental(w http.ResponseWriter, r *http.Request, c *http.Client) {
 	// 200 OK + JSON list
 
 }
+
+type incRangeEntryJSON struct {
+	ID        uint64 `json:"id"` // id liczone od najstarszego (jak w by_id)
+	Data      string `json:"data"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+type incRangeResponse struct {
+	Entries    []incRangeEntryJSON `json:"entries"`
+	NextCursor *uint64             `json:"next_cursor,omitempty"`
+	Total      uint64              `json:"total"`
+	Scanned    *uint64             `json:"scanned,omitempty"` // tylko read_type = filter
+}
+
+// decodeIncRecords dekoduje rekordy z IncRange (tylko żywe wpisy, payload overflow doczytany) i woła fn(i, data).
+func decodeIncRecords(table types.IncTableEntryData, rng dataManager_v2.IncRange, fn func(i int, data []byte)) error {
+	entrySize := table.EntrySize
+	recordSize := int(entrySize) + 3
+	if len(rng.Data)%recordSize != 0 || len(rng.Data)/recordSize != len(rng.IDs) {
+		return fmt.Errorf("Corrupted read: data len=%d not divisible by recordSize=%d", len(rng.Data), recordSize)
+	}
+	for i := range rng.IDs {
+		dec, err := encoding_v1.DecodeIncEntry(entrySize, rng.Data[i*recordSize:(i+1)*recordSize])
+		if err != nil {
+			return fmt.Errorf("DecodeIncEntry error: %w", err)
+		}
+		payload, err := dataManager_v2.ResolveIncPayload(table.TableFileName, dec)
+		if err != nil {
+			return fmt.Errorf("overflow payload of id %d: %w", rng.IDs[i], err)
+		}
+		fn(i, payload)
+	}
+	return nil
+}
+
+func respondIncRange(w http.ResponseWriter, table types.IncTableEntryData, start, amount uint64, countFrom string) {
+	rng, err := dataManager_v2.ReadIncDataFromFileAsync_Range(table.TableFileName, start, amount, table.EntrySize, countFrom)
+	writeIncRange(w, table, rng, err, false)
+}
+
+func writeIncRange(w http.ResponseWriter, table types.IncTableEntryData, rng dataManager_v2.IncRange, err error, withScanned bool) {
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Error reading entries: "+err.Error())
+		return
+	}
+
+	resp := incRangeResponse{Entries: make([]incRangeEntryJSON, 0, len(rng.IDs)), Total: rng.Total}
+	// usunięte wpisy są pomijane przez worker; kursor wskazuje za ostatni przeczytany rekord
+	if err := decodeIncRecords(table, rng, func(i int, data []byte) {
+		resp.Entries = append(resp.Entries, incRangeEntryJSON{ID: rng.IDs[i], Data: string(data), Timestamp: formatIncTime(rng.Times[i])})
+	}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if rng.HasMore {
+		next := rng.Next
+		resp.NextCursor = &next
+	}
+	if withScanned {
+		scanned := rng.Scanned
+		resp.Scanned = &scanned
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
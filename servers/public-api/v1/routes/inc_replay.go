@@ -0,0 +1,29 @@
+package routes
+
+import (
+	dataManager_v2 "github.com/PAW122/TsunamiDB/data/dataManager/v2"
+	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
+)
+
+// ReadIncReplayPage - źródło replay dla subskrypcji inc table (subServer.SetIncReplaySource):
+// maks. amount wpisów od id start (liczone od najstarszego), usunięte wpisy pominięte.
+func ReadIncReplayPage(file, key string, start, amount uint64) (subServer.IncReplayPage, error) {
+	defer lockIncTable(key, false)()
+
+	_, info, err := readIncTableInfo(file, key)
+	if err != nil {
+		return subServer.IncReplayPage{}, err
+	}
+	rng, err := dataManager_v2.ReadIncDataFromFileAsync_Range(info.TableFileName, start, amount, info.EntrySize, "bottom")
+	if err != nil {
+		return subServer.IncReplayPage{}, err
+	}
+
+	page := subServer.IncReplayPage{IDs: rng.IDs, Data: make([][]byte, 0, len(rng.IDs)), Next: rng.Next, HasMore: rng.HasMore}
+	if err := decodeIncRecords(info, rng, func(i int, data []byte) {
+		page.Data = append(page.Data, data)
+	}); err != nil {
+		return subServer.IncReplayPage{}, err
+	}
+	return page, nil
+}
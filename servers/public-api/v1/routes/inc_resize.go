@@ -0,0 +1,161 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	dataManager_v2 "github.com/PAW122/TsunamiDB/data/dataManager/v2"
+	defragmentationManager "github.com/PAW122/TsunamiDB/data/defragmentationManager"
+	fileSystem_v1 "github.com/PAW122/TsunamiDB/data/fileSystem/v1"
+	encoder_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
+	debug "github.com/PAW122/TsunamiDB/servers/debug"
+	limits "github.com/PAW122/TsunamiDB/servers/limits"
+	types "github.com/PAW122/TsunamiDB/types"
+)
+
+// incTableLocks - key -> *sync.RWMutex. Zwykłe operacje na inc table biorą RLock od odczytu
+// deskryptora do końca operacji; resize_inc bierze Lock, więc żaden zapis nie użyje starego EntrySize.
+var incTableLocks sync.Map
+
+func lockIncTable(key string, exclusive bool) func() {
+	v, _ := incTableLocks.LoadOrStore(key, &sync.RWMutex{})
+	mu := v.(*sync.RWMutex)
+	if exclusive {
+		mu.Lock()
+		return mu.Unlock
+	}
+	mu.RLock()
+	return mu.RUnlock
+}
+
+// saveIncTableInfo zapisuje deskryptor inc table w KV (jak przy tworzeniu tabeli w SaveIncremental).
+func saveIncTableInfo(file, key string, info types.IncTableEntryData) error {
+	byte_body, err := StructToBytesBinary(info)
+	if err != nil {
+		return err
+	}
+	encoded, _ := encoder_v1.Encode(byte_body)
+	startPtr, endPtr, err := dataManager_v2.SaveDataToFileAsync(encoded, file)
+	if err != nil {
+		return err
+	}
+	prevMeta, existed, err := fileSystem_v1.SaveElementByKey(file, key, int(startPtr), int(endPtr))
+	if err != nil {
+		return err
+	}
+	if existed {
+		if prevMeta.FileName != file || prevMeta.StartPtr != int(startPtr) || prevMeta.EndPtr != int(endPtr) {
+			defragmentationManager.MarkAsFree(prevMeta.Key, prevMeta.FileName, int64(prevMeta.StartPtr), int64(prevMeta.EndPtr))
+			fileSystem_v1.RecordDefragFree()
+		} else {
+			fileSystem_v1.RecordDefragSkip()
+		}
+	}
+	return nil
+}
+
+/*
+POST /resize_inc/<table>/<key>
+headers:
+
+	max_entry_size = <uint64> (nowy rozmiar wpisu)
+
+Przepisuje plik inc table na nowy rozmiar wpisu i aktualizuje deskryptor w KV.
+Id wpisów i pozycje entry_key się nie zmieniają. Zmniejszenie jest możliwe tylko,
+gdy wszystkie wpisy mieszczą się w nowym rozmiarze. Inne operacje na tej inc table czekają do końca.
+
+response:
+
+	200 {"entry_size": <nowy>, "previous_entry_size": <stary>, "entries": <ilość rekordów>}
+	400 Bad Request
+	404 Key not found
+	409 wpis większy niż nowy rozmiar
+	507 quota tabeli
+*/
+func ResizeIncremental(w http.ResponseWriter, r *http.Request, client *http.Client) {
+	defer debug.MeasureTime("> api [ResizeInc]")()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	pathParts := ParseArgs(r.URL.Path, "resize_inc")
+	if len(pathParts) < 4 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid url args")
+		return
+	}
+	file := pathParts[2]
+	key := pathParts[3]
+
+	newSize, err := strconv.ParseUint(r.Header.Get("max_entry_size"), 10, 64)
+	if err != nil || newSize == 0 {
+		http.Error(w, "Invalid max_entry_size header", http.StatusBadRequest)
+		return
+	}
+
+	defer lockIncTable(key, true)()
+
+	fsData, incInfo, err := readIncTableInfo(file, key)
+	if fsData == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Key not found")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	oldSize := incInfo.EntrySize
+
+	// powiększenie rośnie plik o (newSize-oldSize) na każdy rekord, zmniejszenie może przenieść
+	// wpisy do pliku overflow - oba liczą się do quota tabeli
+	growth, err := dataManager_v2.GetIncResizeGrowth(incInfo.TableFileName, oldSize, newSize)
+	if err != nil {
+		http.Error(w, "Error reading inc table: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if growth > 0 {
+		release, err := limits.ReserveIncAppend(file, incInfo.TableFileName, growth)
+		if err != nil {
+			quotaError(w, err)
+			return
+		}
+		defer release()
+	}
+
+	count, err := dataManager_v2.ResizeIncTable(incInfo.TableFileName, oldSize, newSize)
+	if err != nil {
+		if errors.Is(err, dataManager_v2.ErrIncEntryTooLarge) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Error resizing inc table: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if newSize != oldSize {
+		incInfo.EntrySize = newSize
+		if err := saveIncTableInfo(file, key, incInfo); err != nil {
+			// plik ma już nowy rozmiar - przywróć stary, żeby zgadzał się z deskryptorem
+			if _, rbErr := dataManager_v2.ResizeIncTable(incInfo.TableFileName, newSize, oldSize); rbErr != nil {
+				err = fmt.Errorf("%v (rollback failed: %v)", err, rbErr)
+			}
+			http.Error(w, "Error saving inc table metadata: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]uint64{
+		"entry_size":          newSize,
+		"previous_entry_size": oldSize,
+		"entries":             count,
+	})
+}
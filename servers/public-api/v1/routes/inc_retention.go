@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	dataManager_v2 "github.com/PAW122/TsunamiDB/data/dataManager/v2"
+	incindex "github.com/PAW122/TsunamiDB/data/incIndex"
+	debug "github.com/PAW122/TsunamiDB/servers/debug"
+	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
+	types "github.com/PAW122/TsunamiDB/types"
+)
+
+// enforceIncRetention obcina najstarsze wpisy ponad limity z deskryptora i usuwa ich klucze z incIndex.
+// Subskrybenci dostają zdarzenie "trim" z id pierwszego zachowanego wpisu.
+func enforceIncRetention(file, key string, info types.IncTableEntryData) error {
+	if !info.Retention.Enabled() {
+		return nil
+	}
+	_, _, err := trimIncTable(file, key, info)
+	return err
+}
+
+// trimIncTable zwraca zakres obciętych id [from, to) (to = pierwsze nieobcięte id).
+func trimIncTable(file, key string, info types.IncTableEntryData) (uint64, uint64, error) {
+	from, to, err := dataManager_v2.TrimIncTable(info.TableFileName, info.EntrySize, info.Retention)
+	if err != nil || to <= from {
+		return from, to, err
+	}
+	if err := incindex.ClearBefore(info.TableFileName, to); err != nil {
+		return from, to, err
+	}
+	go subServer.NotifyIncTableSubscribers(file, key, "trim", to, nil)
+	return from, to, nil
+}
+
+func joinWarning(warning, msg string) string {
+	if warning == "" {
+		return msg
+	}
+	return warning + "; " + msg
+}
+
+// parseMaxAge - liczba sekund albo czas w formacie Go ("168h", "30m").
+func parseMaxAge(raw string) (uint64, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.ParseUint(raw, 10, 64); err == nil {
+		return secs, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("Invalid max_age header")
+	}
+	return uint64(d / time.Second), nil
+}
+
+/*
+POST /retention_inc/<table>/<key>
+headers (brak headera = brak limitu; bez żadnego headera retencja jest wyłączana):
+
+	*max_entries = <uint64> (maks. ilość żywych wpisów)
+	*max_bytes = <uint64> (maks. wpisy * (max_entry_size + 3); co najmniej jeden rekord)
+	*max_age = <sekundy> | <czas Go, np. 168h> (wpisy starsze niż max_age od ostatniego zapisu)
+
+Zapisuje retencję w deskryptorze inc table i od razu obcina najstarsze wpisy. Później retencja
+egzekwowana jest po każdym zapisie do tabeli. Id pozostałych wpisów się nie zmieniają.
+
+response:
+
+	200 {"retention": {...}, "first_id": <pierwsze nieobcięte id>, "trimmed": <ilość obciętych pozycji>}
+	400 Bad Request
+	404 Key not found
+*/
+func RetentionIncremental(w http.ResponseWriter, r *http.Request, client *http.Client) {
+	defer debug.MeasureTime("> api [RetentionInc]")()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	pathParts := ParseArgs(r.URL.Path, "retention_inc")
+	if len(pathParts) < 4 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid url args")
+		return
+	}
+	file := pathParts[2]
+	key := pathParts[3]
+
+	var retention types.IncRetention
+	for header, dst := range map[string]*uint64{"max_entries": &retention.MaxEntries, "max_bytes": &retention.MaxBytes} {
+		if raw := r.Header.Get(header); raw != "" {
+			v, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid "+header+" header", http.StatusBadRequest)
+				return
+			}
+			*dst = v
+		}
+	}
+	maxAge, err := parseMaxAge(r.Header.Get("max_age"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	retention.MaxAgeSeconds = maxAge
+
+	// zmiana deskryptora - jak resize, żaden zapis nie może użyć starej wersji w trakcie
+	defer lockIncTable(key, true)()
+
+	fsData, incInfo, err := readIncTableInfo(file, key)
+	if fsData == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Key not found")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if retention.MaxBytes > 0 && retention.MaxBytes < incInfo.EntrySize+3 {
+		http.Error(w, fmt.Sprintf("max_bytes must be at least one record (%d bytes)", incInfo.EntrySize+3), http.StatusBadRequest)
+		return
+	}
+
+	if incInfo.Retention != retention {
+		incInfo.Retention = retention
+		if err := saveIncTableInfo(file, key, incInfo); err != nil {
+			http.Error(w, "Error saving inc table metadata: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// bez limitów nic nie jest obcinane, ale odpowiedź i tak zawiera aktualne first_id
+	from, to, err := trimIncTable(file, key, incInfo)
+	if err != nil {
+		http.Error(w, "Error trimming inc table: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"retention": retention,
+		"first_id":  to,
+		"trimmed":   to - from,
+	})
+}
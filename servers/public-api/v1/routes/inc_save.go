@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	dataManager_v2 "github.com/PAW122/TsunamiDB/data/dataManager/v2"
 	defragmentationManager "github.com/PAW122/TsunamiDB/data/defragmentationManager"
@@ -16,6 +17,7 @@ import (
 	incindex "github.com/PAW122/TsunamiDB/data/incIndex"
 	encoder_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
 	debug "github.com/PAW122/TsunamiDB/servers/debug"
+	limits "github.com/PAW122/TsunamiDB/servers/limits"
 	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
 	types "github.com/PAW122/TsunamiDB/types"
 )
@@ -42,6 +44,15 @@ func StructToBytesBinary(s types.IncTableEntryData) ([]byte, error) {
 		return nil, err
 	}
 
+	// 3) opcjonalnie retencja (brak = deskryptor w starym formacie)
+	if s.Retention.Enabled() {
+		for _, v := range []uint64{s.Retention.MaxEntries, s.Retention.MaxBytes, s.Retention.MaxAgeSeconds} {
+			if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return buf.Bytes(), nil
 }
 
@@ -68,6 +79,15 @@ func BytesToStructBinary(b []byte) (types.IncTableEntryData, error) {
 	}
 	out.TableFileName = string(nameBytes)
 
+	// 3) retencja (tylko w nowszych deskryptorach)
+	if reader.Len() >= 24 {
+		for _, v := range []*uint64{&out.Retention.MaxEntries, &out.Retention.MaxBytes, &out.Retention.MaxAgeSeconds} {
+			if err := binary.Read(reader, binary.LittleEndian, v); err != nil {
+				return out, err
+			}
+		}
+	}
+
 	return out, nil
 }
 
@@ -76,12 +96,14 @@ POST /save_inc/<table>/<key>
 body = []bytes r.Body
 headers:
 
-	max_entry_size = <uint64>
+	max_entry_size = <uint64> (body większe niż max_entry_size trafia do pliku overflow; wymaga max_entry_size >= 16)
 	*id = <uint64> (id służy do nadpisania wpisu o danym id; jeżeli nie istnieje, zwróci błąd)
 	*mode = append | overwrite
 	*count_from = top | bottom [default = top]
 		> switching to "bottom" allows you to use for example id 1 instead of some high number
 	*entry_key = <string> (stable identifier stored in an auxiliary index for fast lookups)
+	*entry_key_<name> = <string> (dodatkowe nazwane klucze wpisu, np. entry_key_email; nazwy małymi literami)
+	*timestamp = <RFC 3339 | unix ms> (czas wpisu; domyślnie czas serwera, overwrite bez headera zachowuje stary czas)
 
 response:
 
@@ -162,7 +184,13 @@ func SaveIncremental(w http.ResponseWriter, r *http.Request, client *http.Client
 		count_from_header = "top"
 	}
 
-	entryKey := r.Header.Get("entry_key")
+	entryKeys := incEntryKeys(r)
+
+	entry_ts, err := parseIncTime("timestamp", r.Header.Get("timestamp"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -171,6 +199,8 @@ func SaveIncremental(w http.ResponseWriter, r *http.Request, client *http.Client
 		return
 	}
 
+	defer lockIncTable(key, false)()
+
 	// czy table istnieje?
 	fsData, err := fileSystem_v1.GetElementByKey(file, key)
 	if err != nil {
@@ -192,6 +222,12 @@ func SaveIncremental(w http.ResponseWriter, r *http.Request, client *http.Client
 			return
 		}
 		encoded, _ := encoder_v1.Encode(byte_body)
+		release, err := limits.ReserveSave(file, key, int64(len(encoded)))
+		if err != nil {
+			quotaError(w, err)
+			return
+		}
+		defer release()
 		startPtr, endPtr, saveErr = dataManager_v2.SaveDataToFileAsync(encoded, file)
 
 		if saveErr != nil {
@@ -244,60 +280,93 @@ func SaveIncremental(w http.ResponseWriter, r *http.Request, client *http.Client
 		inc_table_data = raw_table_data
 		entry_size = inc_table_data.EntrySize
 		if headerProvided && requestedEntrySize != inc_table_data.EntrySize {
-			warningMsg = fmt.Sprintf("max_entry_size header (%d) does not match existing table (%d); header ignored", requestedEntrySize, inc_table_data.EntrySize)
+			warningMsg = fmt.Sprintf("max_entry_size header (%d) does not match existing table (%d); header ignored (use /resize_inc to change it)", requestedEntrySize, inc_table_data.EntrySize)
 		}
 	}
 
-	needIndexInsert := entryKey != "" && (!user_custom_id || mode_header != "overwrite")
-	if needIndexInsert {
-		if _, exists, err := incindex.Lookup(inc_table_data.TableFileName, entryKey); err != nil {
+	// klucze są rezerwowane przed zapisem wpisu: z dwóch równoległych zapisów z tym samym
+	// kluczem drugi dostaje 409, zanim zapisze wpis albo powiadomi subskrybentów
+	if err := reconcileIncIndex(inc_table_data); err != nil {
+		incIndexError(w, err)
+		return
+	}
+	var keyReservation *incindex.Reservation
+	if !user_custom_id || mode_header != "overwrite" {
+		res, err := incindex.ReserveKeys(inc_table_data.TableFileName, entryKeys)
+		if err != nil {
+			var dup *incindex.DuplicateKeyError
+			if errors.As(err, &dup) {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprint(w, dup.Error())
+				return
+			}
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprint(w, "Index lookup error: "+err.Error())
 			return
-		} else if exists {
-			w.WriteHeader(http.StatusConflict)
-			fmt.Fprint(w, "entry_key already exists")
-			return
 		}
+		keyReservation = res
+		// po Insert nic nie robi
+		defer keyReservation.Release()
 	}
-	needIndexOverwrite := entryKey != "" && user_custom_id && mode_header == "overwrite"
 
 	// mamy już dane o inc_table
 	// req o zapisanie danych w inc_table_data
 	// jeżeli istnieje to czy rozmiar się zgadza?
 
-	if len(body) > int(entry_size) {
+	// większy payload trafia do pliku overflow, rekord trzyma tylko referencję (min. 16 B entry size)
+	overflow := len(body) > int(entry_size)
+	if overflow && entry_size < encoder_v1.IncOverflowRefSize {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprint(w, "Body size exceeds entry size")
 		return
 	}
 
+	// overwrite nie powiększa pliku inc table (ale payload overflow zajmuje miejsce)
+	var growth int64
+	if !user_custom_id || mode_header != "overwrite" {
+		growth = int64(entry_size) + 3
+	}
+	if overflow {
+		growth += int64(len(body))
+	}
+	if growth > 0 {
+		release, err := limits.ReserveIncAppend(file, inc_table_data.TableFileName, growth)
+		if err != nil {
+			quotaError(w, err)
+			return
+		}
+		defer release()
+	}
+
 	// req o zapisanie danych w inc_table
-	encoded_inc_body := encoder_v1.EncodeIncEntry(entry_size, body)
+	encoded_inc_body, _, err := dataManager_v2.EncodeIncPayload(inc_table_data.TableFileName, entry_size, body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Error saving inc overflow payload: "+err.Error())
+		return
+	}
 
 	if user_custom_id == false {
-		id, err := dataManager_v2.SaveIncDataToFileAsync(encoded_inc_body, inc_table_data.TableFileName, entry_size)
+		id, err := dataManager_v2.SaveIncDataToFileAsync(encoded_inc_body, inc_table_data.TableFileName, entry_size, entry_ts)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprint(w, "Error saving inc entry: "+err.Error())
 			return
 		}
 
-		go subServer.NotifyIncTableSubscribers(key, "add", id, body)
-
-		if entryKey != "" {
-			if err := incindex.Insert(inc_table_data.TableFileName, id, entryKey); err != nil {
-				if errors.Is(err, incindex.ErrDuplicateKey) {
-					w.WriteHeader(http.StatusConflict)
-					fmt.Fprint(w, "entry_key already exists")
-				} else {
-					w.WriteHeader(http.StatusInternalServerError)
-					fmt.Fprint(w, "Index update error: "+err.Error())
-				}
+		if len(entryKeys) > 0 {
+			if err := keyReservation.Insert(id); err != nil {
+				incIndexError(w, err)
 				return
 			}
 		}
 
+		go subServer.NotifyIncTableSubscribers(file, key, "add", id, body)
+
+		if err := enforceIncRetention(file, key, inc_table_data); err != nil {
+			warningMsg = joinWarning(warningMsg, "retention: "+err.Error())
+		}
+
 		// zwrócenie id
 		respondWithIncID(w, id, warningMsg)
 
@@ -305,52 +374,43 @@ func SaveIncremental(w http.ResponseWriter, r *http.Request, client *http.Client
 
 		// mode 1
 		if mode_header == "overwrite" {
-			id, err := dataManager_v2.SaveIncDataToFileAsync_OverWrite(encoded_inc_body, inc_table_data.TableFileName, entry_size, entry_id, count_from_header)
+			id, err := dataManager_v2.SaveIncDataToFileAsync_OverWrite(encoded_inc_body, inc_table_data.TableFileName, entry_size, entry_id, count_from_header, entry_ts)
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				fmt.Fprint(w, "Error saving inc entry: "+err.Error())
 				return
 			}
 
-			go subServer.NotifyIncTableSubscribers(key, "overwrite", id, body)
-
-			if needIndexOverwrite {
-				if err := incindex.Set(inc_table_data.TableFileName, id, entryKey); err != nil {
-					if errors.Is(err, incindex.ErrDuplicateKey) {
-						w.WriteHeader(http.StatusConflict)
-						fmt.Fprint(w, "entry_key already exists")
-					} else {
-						w.WriteHeader(http.StatusInternalServerError)
-						fmt.Fprint(w, "Index update error: "+err.Error())
-					}
+			for name, value := range entryKeys {
+				if err := incindex.SetKey(inc_table_data.TableFileName, id, name, value); err != nil {
+					incIndexError(w, err)
 					return
 				}
 			}
 
+			go subServer.NotifyIncTableSubscribers(file, key, "overwrite", id, body)
+
 			// zwrócenie id
 			respondWithIncID(w, id, warningMsg)
 
 		} else { // append mode [0]
-			id, err := dataManager_v2.SaveIncDataToFileAsync_Put(encoded_inc_body, inc_table_data.TableFileName, entry_size, entry_id, count_from_header)
+			id, err := dataManager_v2.SaveIncDataToFileAsync_Put(encoded_inc_body, inc_table_data.TableFileName, entry_size, entry_id, count_from_header, entry_ts)
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				fmt.Fprint(w, "Error saving inc entry: "+err.Error())
 				return
 			}
 
-			go subServer.NotifyIncTableSubscribers(key, "insert", id, body)
-
-			if entryKey != "" {
-				if err := incindex.Insert(inc_table_data.TableFileName, id, entryKey); err != nil {
-					if errors.Is(err, incindex.ErrDuplicateKey) {
-						w.WriteHeader(http.StatusConflict)
-						fmt.Fprint(w, "entry_key already exists")
-					} else {
-						w.WriteHeader(http.StatusInternalServerError)
-						fmt.Fprint(w, "Index update error: "+err.Error())
-					}
-					return
-				}
+			// insert przesuwa pozycje kolejnych wpisów również w indeksie (także bez kluczy)
+			if err := keyReservation.Insert(id); err != nil {
+				incIndexError(w, err)
+				return
+			}
+
+			go subServer.NotifyIncTableSubscribers(file, key, "insert", id, body)
+
+			if err := enforceIncRetention(file, key, inc_table_data); err != nil {
+				warningMsg = joinWarning(warningMsg, "retention: "+err.Error())
 			}
 
 			// zwrócenie id
@@ -361,6 +421,43 @@ func SaveIncremental(w http.ResponseWriter, r *http.Request, client *http.Client
 
 }
 
+// incEntryKeys zbiera klucze wpisu z headerów: entry_key oraz entry_key_<name>.
+func incEntryKeys(r *http.Request) map[string]string {
+	keys := make(map[string]string)
+	for header, values := range r.Header {
+		name := strings.ToLower(header)
+		switch prefix := incindex.DefaultName + "_"; {
+		case name == incindex.DefaultName:
+		case strings.HasPrefix(name, prefix) && len(name) > len(prefix):
+			name = name[len(prefix):]
+		default:
+			continue
+		}
+		if len(values) > 0 && values[0] != "" {
+			keys[name] = values[0]
+		}
+	}
+	return keys
+}
+
+// incIndexError - 409 dla zajętego klucza, 500 dla pozostałych błędów indeksu.
+// reconcileIncIndex porównuje indeks kluczy z mapą inc table przy pierwszym użyciu tabeli (incindex.Reconcile).
+func reconcileIncIndex(info types.IncTableEntryData) error {
+	return incindex.Reconcile(info.TableFileName, func() (uint64, error) {
+		return dataManager_v2.GetIncEntryCount(info.TableFileName, info.EntrySize)
+	})
+}
+
+func incIndexError(w http.ResponseWriter, err error) {
+	if errors.Is(err, incindex.ErrDuplicateKey) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, "entry_key already exists")
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprint(w, "Index update error: "+err.Error())
+}
+
 func respondWithIncID(w http.ResponseWriter, id uint64, warning string) {
 	w.Header().Set("Content-Type", "application/json")
 	resp := map[string]string{
@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// parseIncTime - czas w formacie RFC 3339 ("2025-01-02T18:00:00+01:00") albo unix milisekundy.
+// Zwraca unix nano (0 = brak headera).
+func parseIncTime(header, raw string) (int64, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseInt(raw, 10, 64); err == nil && ms > 0 {
+		return time.UnixMilli(ms).UnixNano(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil || t.UnixNano() <= 0 {
+		return 0, fmt.Errorf("Invalid %s header (use RFC 3339 or unix milliseconds)", header)
+	}
+	return t.UnixNano(), nil
+}
+
+// formatIncTime - znacznik czasu wpisu w odpowiedziach (RFC 3339 UTC z nanosekundami).
+func formatIncTime(ts int64) string {
+	if ts <= 0 {
+		return ""
+	}
+	return time.Unix(0, ts).UTC().Format(time.RFC3339Nano)
+}
@@ -2,17 +2,24 @@ package routes
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	dataManager_v2 "github.com/PAW122/TsunamiDB/data/dataManager/v2"
 	defrag "github.com/PAW122/TsunamiDB/data/defragmentationManager"
 	fileSystem_v1 "github.com/PAW122/TsunamiDB/data/fileSystem/v1"
 	incindex "github.com/PAW122/TsunamiDB/data/incIndex"
+	config "github.com/PAW122/TsunamiDB/servers/config"
 	networkmanager "github.com/PAW122/TsunamiDB/servers/network-manager"
 	metrics "github.com/PAW122/TsunamiDB/servers/public-api/v1/metrics"
 )
@@ -24,6 +31,8 @@ func setupRoutesTest(t *testing.T) {
 	defrag.ResetForTests()
 	_ = os.RemoveAll("./db/data")
 	_ = os.RemoveAll("./db/inc_tables")
+	// indeksy tabel z poprzednich uruchomień wskazywałyby na usunięte dane
+	_ = os.RemoveAll("./db/maps")
 	dataManager_v2.EnsureDirsForTests()
 	networkmanager.SetInstanceForTests(&networkmanager.NetworkManager{ServerIP: "127.0.0.1"})
 	metrics.ResetForTests()
@@ -37,6 +46,7 @@ func setupRoutesTest(t *testing.T) {
 		incindex.ResetForTests()
 		_ = os.RemoveAll("./db/data")
 		_ = os.RemoveAll("./db/inc_tables")
+		_ = os.RemoveAll("./db/maps")
 	})
 }
 
@@ -273,3 +283,644 @@ func TestIncrementalReadByKey(t *testing.T) {
 		t.Fatalf("expected 404 for missing key, got %d", notFound.Code)
 	}
 }
+
+func TestSaveIncDuplicateKeyUnderConcurrency(t *testing.T) {
+	setupRoutesTest(t)
+	if resp := perform(SaveIncremental, http.MethodPost, "/save_inc/table/dup", bytes.NewBufferString("seed"), map[string]string{"max_entry_size": "16"}); resp.Code != http.StatusOK {
+		t.Fatalf("seed status: %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	// klucz zarezerwowany przez trwający zapis - 409 bez zapisu wpisu
+	res, err := incindex.ReserveKeys("inc_table_dup.tbl", map[string]string{incindex.DefaultName: "held"})
+	if err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if resp := perform(SaveIncremental, http.MethodPost, "/save_inc/table/dup", bytes.NewBufferString("v"), map[string]string{"entry_key": "held"}); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for reserved key, got %d", resp.Code)
+	}
+	res.Release()
+
+	const writers = 16
+	codes := make([]int, writers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			codes[i] = perform(SaveIncremental, http.MethodPost, "/save_inc/table/dup", bytes.NewBufferString("v"), map[string]string{"entry_key": "same"}).Code
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	ok := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			ok++
+		case http.StatusConflict:
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	resp := perform(ReadIncremental, http.MethodGet, "/read_inc/table/dup", nil, map[string]string{"read_type": "range", "start_id": "0", "amount_to_read": "100"})
+	var page struct {
+		Total uint64 `json:"total"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &page); err != nil || ok != 1 || page.Total != 2 {
+		t.Fatalf("duplicate entry_key stored: accepted=%d total=%d err=%v", ok, page.Total, err)
+	}
+}
+
+func TestSaveRespectsTableQuota(t *testing.T) {
+	setupRoutesTest(t)
+	config.Set(&config.Config{Limits: config.Limits{Quotas: map[string]config.Table_quota{
+		"quota_table": {MaxKeys: 1, MaxBytes: 256},
+	}}})
+	t.Cleanup(func() { config.Set(nil) })
+
+	if resp := perform(AsyncSave, http.MethodPost, "/save/quota_table/a", bytes.NewBufferString("one"), nil); resp.Code != http.StatusOK {
+		t.Fatalf("first save status: %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := perform(AsyncSave, http.MethodPost, "/save/quota_table/b", bytes.NewBufferString("two"), nil); resp.Code != http.StatusInsufficientStorage {
+		t.Fatalf("expected 507 for key quota, got %d", resp.Code)
+	}
+	// nadpisanie istniejącego klucza nie zwiększa liczby kluczy
+	if resp := perform(AsyncSave, http.MethodPost, "/save/quota_table/a", bytes.NewBufferString("uno"), nil); resp.Code != http.StatusOK {
+		t.Fatalf("overwrite status: %d body=%s", resp.Code, resp.Body.String())
+	}
+	big := bytes.Repeat([]byte("x"), 512)
+	if resp := perform(AsyncSave, http.MethodPost, "/save/quota_table/a", bytes.NewReader(big), nil); resp.Code != http.StatusInsufficientStorage {
+		t.Fatalf("expected 507 for byte quota, got %d", resp.Code)
+	}
+	if resp := perform(AsyncRead, http.MethodGet, "/read/quota_table/a", nil, nil); resp.Body.String() != "uno" {
+		t.Fatalf("rejected save must not change data, got %q", resp.Body.String())
+	}
+}
+
+func TestSaveQuotaHoldsUnderConcurrentSaves(t *testing.T) {
+	setupRoutesTest(t)
+	config.Set(&config.Config{Limits: config.Limits{Quotas: map[string]config.Table_quota{
+		"quota_race": {MaxKeys: 5},
+	}}})
+	t.Cleanup(func() { config.Set(nil) })
+
+	// wszystkie zapisy sprawdzają quota zanim którykolwiek trafi do mapy
+	const writers = 32
+	codes := make([]int, writers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			path := fmt.Sprintf("/save/quota_race/k%d", i)
+			codes[i] = perform(AsyncSave, http.MethodPost, path, bytes.NewBufferString("v"), nil).Code
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	ok := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			ok++
+		case http.StatusInsufficientStorage:
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	keys, _, err := fileSystem_v1.TableUsage("quota_race")
+	if err != nil || ok != 5 || keys != 5 {
+		t.Fatalf("quota exceeded under concurrency: accepted=%d keys=%d err=%v", ok, keys, err)
+	}
+}
+
+func TestSaveIncRespectsByteQuota(t *testing.T) {
+	setupRoutesTest(t)
+	config.Set(&config.Config{Limits: config.Limits{Quotas: map[string]config.Table_quota{
+		"inc_quota": {MaxBytes: 200},
+	}}})
+	t.Cleanup(func() { config.Set(nil) })
+
+	headers := map[string]string{"max_entry_size": "32"}
+	var lastCode int
+	for i := 0; i < 10 && lastCode != http.StatusInsufficientStorage; i++ {
+		resp := perform(SaveIncremental, http.MethodPost, "/save_inc/inc_quota/log", bytes.NewBufferString("entry"), headers)
+		lastCode = resp.Code
+	}
+	if lastCode != http.StatusInsufficientStorage {
+		t.Fatalf("expected inc appends to hit the byte quota, last status %d", lastCode)
+	}
+}
+
+func TestReadIncRangePaging(t *testing.T) {
+	setupRoutesTest(t)
+	headers := map[string]string{"max_entry_size": "16"}
+	for _, msg := range []string{"m0", "m1", "m2", "m3", "m4"} {
+		if resp := perform(SaveIncremental, http.MethodPost, "/save_inc/table/chat", bytes.NewBufferString(msg), headers); resp.Code != http.StatusOK {
+			t.Fatalf("save_inc %s: %d", msg, resp.Code)
+		}
+	}
+
+	type page struct {
+		Entries []struct {
+			ID   uint64 `json:"id"`
+			Data string `json:"data"`
+		} `json:"entries"`
+		NextCursor *uint64 `json:"next_cursor"`
+		Total      uint64  `json:"total"`
+	}
+	read := func(start, countFrom string) page {
+		t.Helper()
+		head := map[string]string{"read_type": "range", "start_id": start, "amount_to_read": "2", "count_from": countFrom}
+		resp := perform(ReadIncremental, http.MethodGet, "/read_inc/table/chat", nil, head)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("range status: %d body=%s", resp.Code, resp.Body.String())
+		}
+		var p page
+		if err := json.Unmarshal(resp.Body.Bytes(), &p); err != nil {
+			t.Fatalf("decode page: %v", err)
+		}
+		return p
+	}
+
+	// historia od najnowszych: m4 m3 | m2 m1 | m0
+	var seen []string
+	cursor := "0"
+	for {
+		p := read(cursor, "top")
+		for _, e := range p.Entries {
+			seen = append(seen, fmt.Sprintf("%d:%s", e.ID, e.Data))
+		}
+		if p.NextCursor == nil {
+			break
+		}
+		cursor = fmt.Sprint(*p.NextCursor)
+	}
+	if got := strings.Join(seen, ","); got != "4:m4,3:m3,2:m2,1:m1,0:m0" {
+		t.Fatalf("unexpected top paging: %s", got)
+	}
+
+	p := read("3", "bottom")
+	if len(p.Entries) != 2 || p.Entries[0].ID != 3 || p.Entries[1].Data != "m4" || p.NextCursor != nil || p.Total != 5 {
+		t.Fatalf("unexpected bottom page: %+v", p)
+	}
+}
+
+func TestReadIncFilter(t *testing.T) {
+	setupRoutesTest(t)
+	headers := map[string]string{"max_entry_size": "64"}
+	msgs := []string{
+		`{"user":{"id":1},"text":"hello"}`,
+		`{"user":{"id":2},"text":"error: disk"}`,
+		`plain log line`,
+		`{"user":{"id":1},"text":"error: net"}`,
+	}
+	for _, msg := range msgs {
+		if resp := perform(SaveIncremental, http.MethodPost, "/save_inc/table/logs", bytes.NewBufferString(msg), headers); resp.Code != http.StatusOK {
+			t.Fatalf("save_inc: %d", resp.Code)
+		}
+	}
+
+	type page struct {
+		Entries []struct {
+			ID   uint64 `json:"id"`
+			Data string `json:"data"`
+		} `json:"entries"`
+		NextCursor *uint64 `json:"next_cursor"`
+		Scanned    uint64  `json:"scanned"`
+	}
+	filter := func(head map[string]string) (int, page) {
+		t.Helper()
+		head["read_type"] = "filter"
+		if head["amount_to_read"] == "" {
+			head["amount_to_read"] = "10"
+		}
+		resp := perform(ReadIncremental, http.MethodGet, "/read_inc/table/logs", nil, head)
+		var p page
+		if resp.Code == http.StatusOK {
+			if err := json.Unmarshal(resp.Body.Bytes(), &p); err != nil {
+				t.Fatalf("decode page: %v", err)
+			}
+		}
+		return resp.Code, p
+	}
+	ids := func(p page) string {
+		var out []string
+		for _, e := range p.Entries {
+			out = append(out, fmt.Sprint(e.ID))
+		}
+		return strings.Join(out, ",")
+	}
+
+	if code, p := filter(map[string]string{"filter_mode": "contains", "filter_value": "error"}); code != http.StatusOK || ids(p) != "1,3" || p.Scanned != 4 {
+		t.Fatalf("contains: %d %+v", code, p)
+	}
+	if code, p := filter(map[string]string{"filter_mode": "regex", "filter_value": "^plain", "count_from": "top"}); code != http.StatusOK || ids(p) != "2" {
+		t.Fatalf("regex: %d %+v", code, p)
+	}
+	code, p := filter(map[string]string{"filter_mode": "json_eq", "filter_field": "user.id", "filter_value": "1", "count_from": "top", "amount_to_read": "1"})
+	if code != http.StatusOK || ids(p) != "3" || p.NextCursor == nil || *p.NextCursor != 1 {
+		t.Fatalf("json_eq: %d %+v", code, p)
+	}
+	if _, p := filter(map[string]string{"filter_mode": "json_eq", "filter_field": "user.id", "filter_value": "1", "count_from": "top", "start_id": "1"}); ids(p) != "0" {
+		t.Fatalf("json_eq resumed: %+v", p)
+	}
+
+	if code, _ := filter(map[string]string{"filter_mode": "regex", "filter_value": "("}); code != http.StatusBadRequest {
+		t.Fatalf("invalid regex should be 400, got %d", code)
+	}
+	if code, _ := filter(map[string]string{"filter_mode": "glob", "filter_value": "x"}); code != http.StatusBadRequest {
+		t.Fatalf("unknown mode should be 400, got %d", code)
+	}
+}
+
+func TestDeleteIncEntryAndCompact(t *testing.T) {
+	setupRoutesTest(t)
+	basePath := "/save_inc/table/events"
+	for i, key := range []string{"k0", "k1", "k2", "k3"} {
+		headers := map[string]string{"max_entry_size": "16", "entry_key": key}
+		if resp := perform(SaveIncremental, http.MethodPost, basePath, bytes.NewBufferString(fmt.Sprintf("v%d", i)), headers); resp.Code != http.StatusOK {
+			t.Fatalf("save %s: %d body=%s", key, resp.Code, resp.Body.String())
+		}
+	}
+
+	delPath := "/delete_inc/table/events"
+	if resp := perform(DeleteIncremental, http.MethodDelete, delPath, nil, map[string]string{"id": "1"}); resp.Code != http.StatusOK {
+		t.Fatalf("delete by id: %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := perform(DeleteIncremental, http.MethodDelete, delPath, nil, map[string]string{"entry_key": "k2"}); resp.Code != http.StatusOK {
+		t.Fatalf("delete by key: %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := perform(DeleteIncremental, http.MethodDelete, delPath, nil, map[string]string{"id": "1"}); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for deleted id, got %d", resp.Code)
+	}
+	if resp := perform(DeleteIncremental, http.MethodDelete, delPath, nil, map[string]string{"entry_key": "k2"}); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for removed entry_key, got %d", resp.Code)
+	}
+
+	readPath := "/read_inc/table/events"
+	if resp := perform(ReadIncremental, http.MethodGet, readPath, nil, map[string]string{"read_type": "by_id", "id": "1"}); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 reading deleted id, got %d", resp.Code)
+	}
+
+	if resp := perform(CompactIncremental, http.MethodPost, "/compact_inc/table/events", nil, nil); resp.Code != http.StatusOK {
+		t.Fatalf("compact: %d body=%s", resp.Code, resp.Body.String())
+	} else {
+		var out struct{ Removed, Total uint64 }
+		if err := json.Unmarshal(resp.Body.Bytes(), &out); err != nil || out.Removed != 2 || out.Total != 2 {
+			t.Fatalf("unexpected compact response: %s", resp.Body.String())
+		}
+	}
+
+	// kompakcja nie zmienia id - k3 nadal ma id 3, usunięte id zostają 404
+	resp := perform(ReadIncremental, http.MethodGet, readPath, nil, map[string]string{"read_type": "by_key", "entry_key": "k3"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("read k3: %d body=%s", resp.Code, resp.Body.String())
+	}
+	if !strings.Contains(resp.Body.String(), "v3") {
+		t.Fatalf("unexpected k3 after compact: %s", resp.Body.String())
+	}
+	resp = perform(ReadIncremental, http.MethodGet, readPath, nil, map[string]string{"read_type": "by_id", "id": "3"})
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "v3") {
+		t.Fatalf("read id 3 after compact: %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := perform(ReadIncremental, http.MethodGet, readPath, nil, map[string]string{"read_type": "by_id", "id": "1"}); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 reading compacted id, got %d", resp.Code)
+	}
+}
+
+func TestResizeIncTable(t *testing.T) {
+	setupRoutesTest(t)
+	basePath := "/save_inc/table/resized"
+	for i, msg := range []string{"short", "a bit longer"} {
+		headers := map[string]string{"max_entry_size": "16", "entry_key": fmt.Sprintf("k%d", i)}
+		if resp := perform(SaveIncremental, http.MethodPost, basePath, bytes.NewBufferString(msg), headers); resp.Code != http.StatusOK {
+			t.Fatalf("save %s: %d", msg, resp.Code)
+		}
+	}
+	long := strings.Repeat("x", 40)
+	// tabela za mała na referencję overflow - za duży wpis jest odrzucany
+	tiny := map[string]string{"max_entry_size": "8"}
+	if resp := perform(SaveIncremental, http.MethodPost, "/save_inc/table/tiny", bytes.NewBufferString(long), tiny); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for oversized entry in tiny table, got %d", resp.Code)
+	}
+
+	resize := func(size string) *httptest.ResponseRecorder {
+		return perform(ResizeIncremental, http.MethodPost, "/resize_inc/table/resized", nil, map[string]string{"max_entry_size": size})
+	}
+	if resp := resize("64"); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"entry_size":64`) {
+		t.Fatalf("grow: %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := perform(SaveIncremental, http.MethodPost, basePath, bytes.NewBufferString(long), nil); resp.Code != http.StatusOK {
+		t.Fatalf("save after grow: %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := resize("8"); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for shrink below entry size, got %d", resp.Code)
+	}
+
+	resp := perform(ReadIncremental, http.MethodGet, "/read_inc/table/resized", nil, map[string]string{"read_type": "by_key", "entry_key": "k1"})
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "a bit longer") {
+		t.Fatalf("read by key after resize: %d body=%s", resp.Code, resp.Body.String())
+	}
+	resp = perform(ReadIncremental, http.MethodGet, "/read_inc/table/resized", nil, map[string]string{"read_type": "by_id", "id": "2"})
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), long) {
+		t.Fatalf("read id 2 after resize: %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := resize("0"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for zero size, got %d", resp.Code)
+	}
+	if resp := perform(ResizeIncremental, http.MethodPost, "/resize_inc/table/missing", nil, map[string]string{"max_entry_size": "8"}); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing table, got %d", resp.Code)
+	}
+}
+
+func TestResizeIncRespectsByteQuota(t *testing.T) {
+	setupRoutesTest(t)
+	t.Cleanup(func() { config.Set(nil) })
+	payload := strings.Repeat("x", 60)
+	for i := 0; i < 4; i++ {
+		if resp := perform(SaveIncremental, http.MethodPost, "/save_inc/resize_quota/log", bytes.NewBufferString(payload), map[string]string{"max_entry_size": "64"}); resp.Code != http.StatusOK {
+			t.Fatalf("save %d: %d", i, resp.Code)
+		}
+	}
+	_, kvBytes, err := fileSystem_v1.TableUsage("resize_quota")
+	if err != nil {
+		t.Fatalf("table usage: %v", err)
+	}
+	incBytes, err := dataManager_v2.GetIncTableSize("inc_table_log.tbl")
+	if err != nil {
+		t.Fatalf("inc table size: %v", err)
+	}
+	setQuota := func(max int64) {
+		config.Set(&config.Config{Limits: config.Limits{Quotas: map[string]config.Table_quota{
+			"resize_quota": {MaxBytes: max},
+		}}})
+	}
+	resize := func(size string) *httptest.ResponseRecorder {
+		return perform(ResizeIncremental, http.MethodPost, "/resize_inc/resize_quota/log", nil, map[string]string{"max_entry_size": size})
+	}
+
+	// zmniejszenie przenosi wszystkie payloady do overflow - plik tabeli maleje mniej, niż rośnie overflow
+	setQuota(kvBytes + incBytes + 20)
+	if resp := resize("16"); resp.Code != http.StatusInsufficientStorage {
+		t.Fatalf("expected 507 for shrink spilling past quota, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := resize("128"); resp.Code != http.StatusInsufficientStorage {
+		t.Fatalf("expected 507 for grow past quota, got %d", resp.Code)
+	}
+	resp := perform(ReadIncremental, http.MethodGet, "/read_inc/resize_quota/log", nil, map[string]string{"read_type": "by_id", "id": "3"})
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), payload) {
+		t.Fatalf("read after rejected resize: %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	setQuota(kvBytes + incBytes + 200)
+	if resp := resize("16"); resp.Code != http.StatusOK {
+		t.Fatalf("shrink within quota: %d body=%s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestIncNamedKeysAndInsertShift(t *testing.T) {
+	setupRoutesTest(t)
+	basePath := "/save_inc/table/users"
+	save := func(body string, headers map[string]string) {
+		t.Helper()
+		if resp := perform(SaveIncremental, http.MethodPost, basePath, bytes.NewBufferString(body), headers); resp.Code != http.StatusOK {
+			t.Fatalf("save %s: %d body=%s", body, resp.Code, resp.Body.String())
+		}
+	}
+	save("ann", map[string]string{"max_entry_size": "16", "entry_key": "u1", "entry_key_email": "ann@x"})
+	save("bob", map[string]string{"entry_key": "u2", "entry_key_email": "bob@x"})
+	// insert bez kluczy na początku przesuwa pozycje w indeksie
+	save("first", map[string]string{"id": "0", "count_from": "bottom"})
+
+	if resp := perform(SaveIncremental, http.MethodPost, basePath, bytes.NewBufferString("dup"), map[string]string{"entry_key_email": "bob@x"}); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for duplicate named key, got %d", resp.Code)
+	}
+
+	read := func(headers map[string]string) string {
+		t.Helper()
+		headers["read_type"] = "by_key"
+		resp := perform(ReadIncremental, http.MethodGet, "/read_inc/table/users", nil, headers)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("read %v: %d body=%s", headers, resp.Code, resp.Body.String())
+		}
+		return resp.Body.String()
+	}
+	if body := read(map[string]string{"entry_key": "u2"}); !strings.Contains(body, "bob") {
+		t.Fatalf("by entry_key after insert: %s", body)
+	}
+	if body := read(map[string]string{"entry_key": "ann@x", "key_name": "email"}); !strings.Contains(body, "ann") {
+		t.Fatalf("by email: %s", body)
+	}
+
+	if resp := perform(DeleteIncremental, http.MethodDelete, "/delete_inc/table/users", nil, map[string]string{"entry_key": "bob@x", "key_name": "email"}); resp.Code != http.StatusOK {
+		t.Fatalf("delete by email: %d body=%s", resp.Code, resp.Body.String())
+	}
+	// usunięcie wpisu zwalnia wszystkie jego klucze
+	resp := perform(ReadIncremental, http.MethodGet, "/read_inc/table/users", nil, map[string]string{"read_type": "by_key", "entry_key": "u2"})
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for deleted entry's entry_key, got %d", resp.Code)
+	}
+}
+
+func TestIncRetention(t *testing.T) {
+	setupRoutesTest(t)
+	basePath := "/save_inc/table/capped"
+	save := func(i int) {
+		t.Helper()
+		headers := map[string]string{"max_entry_size": "16", "entry_key": fmt.Sprintf("k%d", i)}
+		if resp := perform(SaveIncremental, http.MethodPost, basePath, bytes.NewBufferString(fmt.Sprintf("v%d", i)), headers); resp.Code != http.StatusOK {
+			t.Fatalf("save %d: %d body=%s", i, resp.Code, resp.Body.String())
+		}
+	}
+	for i := 0; i < 5; i++ {
+		save(i)
+	}
+
+	retention := func(headers map[string]string) *httptest.ResponseRecorder {
+		return perform(RetentionIncremental, http.MethodPost, "/retention_inc/table/capped", nil, headers)
+	}
+	resp := retention(map[string]string{"max_entries": "3"})
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"first_id":2`) || !strings.Contains(resp.Body.String(), `"trimmed":2`) {
+		t.Fatalf("retention: %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	// kolejne zapisy egzekwują limit, id dalej rosną
+	save(5)
+	save(6)
+	read := func(head map[string]string) *httptest.ResponseRecorder {
+		return perform(ReadIncremental, http.MethodGet, "/read_inc/table/capped", nil, head)
+	}
+	if resp := read(map[string]string{"read_type": "by_id", "id": "3"}); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for trimmed id, got %d", resp.Code)
+	}
+	if resp := read(map[string]string{"read_type": "by_key", "entry_key": "k1"}); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for trimmed key, got %d", resp.Code)
+	}
+	resp = read(map[string]string{"read_type": "first_entries", "amount_to_read": "10"})
+	for _, want := range []string{`"id":4`, `"id":5`, `"id":6`} {
+		if !strings.Contains(resp.Body.String(), want) {
+			t.Fatalf("missing %s in %s", want, resp.Body.String())
+		}
+	}
+	if strings.Contains(resp.Body.String(), `"id":3`) {
+		t.Fatalf("trimmed entry still listed: %s", resp.Body.String())
+	}
+	if resp := read(map[string]string{"read_type": "by_key", "entry_key": "k6"}); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "v6") {
+		t.Fatalf("read k6: %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	if resp := retention(map[string]string{"max_bytes": "10"}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for max_bytes below one record, got %d", resp.Code)
+	}
+	if resp := retention(map[string]string{"max_age": "soon"}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid max_age, got %d", resp.Code)
+	}
+	if resp := perform(RetentionIncremental, http.MethodPost, "/retention_inc/table/missing", nil, map[string]string{"max_entries": "1"}); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing table, got %d", resp.Code)
+	}
+
+	// wyłączenie retencji - zapisy przestają obcinać
+	if resp := retention(nil); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"first_id":4`) {
+		t.Fatalf("disable retention: %d body=%s", resp.Code, resp.Body.String())
+	}
+	save(7)
+	if resp := read(map[string]string{"read_type": "by_id", "id": "4"}); resp.Code != http.StatusOK {
+		t.Fatalf("entry 4 should survive with retention disabled, got %d", resp.Code)
+	}
+}
+
+func TestSaveIncOverflow(t *testing.T) {
+	setupRoutesTest(t)
+	basePath := "/save_inc/table/big"
+	long := strings.Repeat("y", 300)
+	for i, msg := range []string{"short", long} {
+		headers := map[string]string{"max_entry_size": "16", "entry_key": fmt.Sprintf("k%d", i)}
+		if resp := perform(SaveIncremental, http.MethodPost, basePath, bytes.NewBufferString(msg), headers); resp.Code != http.StatusOK {
+			t.Fatalf("save %d: %d body=%s", i, resp.Code, resp.Body.String())
+		}
+	}
+
+	read := func(head map[string]string) string {
+		t.Helper()
+		resp := perform(ReadIncremental, http.MethodGet, "/read_inc/table/big", nil, head)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("read %v: %d body=%s", head, resp.Code, resp.Body.String())
+		}
+		return resp.Body.String()
+	}
+	if body := read(map[string]string{"read_type": "by_id", "id": "1"}); !strings.Contains(body, long) {
+		t.Fatalf("by_id did not return overflow payload: %s", body)
+	}
+	if body := read(map[string]string{"read_type": "by_key", "entry_key": "k1"}); !strings.Contains(body, long) {
+		t.Fatalf("by_key did not return overflow payload: %s", body)
+	}
+	if body := read(map[string]string{"read_type": "first_entries", "amount_to_read": "5"}); !strings.Contains(body, long) || !strings.Contains(body, "short") {
+		t.Fatalf("first_entries: %s", body)
+	}
+	if body := read(map[string]string{"read_type": "filter", "filter_mode": "contains", "filter_value": "yyyy", "amount_to_read": "5"}); !strings.Contains(body, `"id":1`) || strings.Contains(body, `"id":0`) {
+		t.Fatalf("filter on overflow payload: %s", body)
+	}
+
+	overwrite := map[string]string{"id": "0", "mode": "overwrite", "count_from": "bottom"}
+	if resp := perform(SaveIncremental, http.MethodPost, basePath, bytes.NewBufferString(long+"!"), overwrite); resp.Code != http.StatusOK {
+		t.Fatalf("overwrite with overflow: %d body=%s", resp.Code, resp.Body.String())
+	}
+	if body := read(map[string]string{"read_type": "by_id", "id": "0"}); !strings.Contains(body, long+"!") {
+		t.Fatalf("overwritten entry: %s", body)
+	}
+}
+
+func TestReadIncTimeRange(t *testing.T) {
+	setupRoutesTest(t)
+	basePath := "/save_inc/table/timed"
+	stamps := []string{"2025-01-02T17:00:00Z", "2025-01-02T18:00:00Z", "1735841700000", "2025-01-02T19:30:00+01:00"}
+	for i, ts := range stamps {
+		headers := map[string]string{"max_entry_size": "16", "timestamp": ts}
+		if resp := perform(SaveIncremental, http.MethodPost, basePath, bytes.NewBufferString(fmt.Sprintf("m%d", i)), headers); resp.Code != http.StatusOK {
+			t.Fatalf("save %d: %d body=%s", i, resp.Code, resp.Body.String())
+		}
+	}
+	if resp := perform(SaveIncremental, http.MethodPost, basePath, bytes.NewBufferString("bad"), map[string]string{"timestamp": "yesterday"}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid timestamp, got %d", resp.Code)
+	}
+
+	read := func(head map[string]string) *httptest.ResponseRecorder {
+		return perform(ReadIncremental, http.MethodGet, "/read_inc/table/timed", nil, head)
+	}
+	resp := read(map[string]string{"read_type": "by_id", "id": "2"})
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"timestamp":"2025-01-02T18:15:00Z"`) {
+		t.Fatalf("by_id timestamp: %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	var page struct {
+		Entries []struct {
+			ID        uint64 `json:"id"`
+			Data      string `json:"data"`
+			Timestamp string `json:"timestamp"`
+		} `json:"entries"`
+		NextCursor *uint64 `json:"next_cursor"`
+	}
+	resp = read(map[string]string{"read_type": "time_range", "from_time": "2025-01-02T18:00:00Z", "amount_to_read": "10"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("time_range: %d body=%s", resp.Code, resp.Body.String())
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// 19:30+01:00 == 18:30Z
+	if len(page.Entries) != 3 || page.Entries[0].Data != "m1" || page.Entries[2].Timestamp != "2025-01-02T18:30:00Z" || page.NextCursor != nil {
+		t.Fatalf("unexpected time_range page: %+v", page)
+	}
+
+	resp = read(map[string]string{"read_type": "time_range", "to_time": "2025-01-02T18:15:00Z", "count_from": "top", "amount_to_read": "1"})
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"data":"m1"`) || !strings.Contains(resp.Body.String(), `"next_cursor":3`) {
+		t.Fatalf("time_range top: %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	if resp := read(map[string]string{"read_type": "time_range", "amount_to_read": "10"}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without time bounds, got %d", resp.Code)
+	}
+	if resp := read(map[string]string{"read_type": "time_range", "from_time": "soon", "amount_to_read": "10"}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid from_time, got %d", resp.Code)
+	}
+}
+
+func TestSaveEncryptedNotInEventLog(t *testing.T) {
+	setupRoutesTest(t)
+	key := fmt.Sprintf("enc-%d", time.Now().UnixNano())
+	headers := map[string]string{"encryption_key": "secret"}
+	if resp := perform(SaveEncrypted, http.MethodPost, "/save_encrypted/table/"+key, bytes.NewBufferString("top-secret-value"), headers); resp.Code != http.StatusOK {
+		t.Fatalf("save_encrypted status: %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	// powiadomienie idzie w tle - czekamy na wpis klucza w logu zdarzeń (log jest współdzielony
+	// z innymi testami, więc liczą się tylko linie tego klucza)
+	var logged []byte
+	deadline := time.Now().Add(2 * time.Second)
+	for len(logged) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("event for %s not logged", key)
+		}
+		time.Sleep(10 * time.Millisecond)
+		segments, _ := filepath.Glob("./db/subscriptions/events-*.log")
+		for _, seg := range segments {
+			raw, _ := os.ReadFile(seg)
+			for _, line := range bytes.Split(raw, []byte{'\n'}) {
+				if bytes.Contains(line, []byte(`"key":"`+key+`"`)) {
+					logged = append(logged, line...)
+				}
+			}
+		}
+	}
+	plain := []byte("top-secret-value")
+	if bytes.Contains(logged, plain) || bytes.Contains(logged, []byte(base64.StdEncoding.EncodeToString(plain))) {
+		t.Fatal("encrypted save stored in plaintext in the event log")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	fileSystem_v1 "github.com/PAW122/TsunamiDB/data/fileSystem/v1"
 	encoder_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
 	debug "github.com/PAW122/TsunamiDB/servers/debug"
+	limits "github.com/PAW122/TsunamiDB/servers/limits"
 	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
 )
 
@@ -43,6 +44,13 @@ func AsyncSave(w http.ResponseWriter, r *http.Request, c *http.Client) {
 	// -2- kodowanie (funkcja NIE zwraca error)
 	encoded, _ := encoder_v1.Encode(body)
 
+	release, err := limits.ReserveSave(file, key, int64(len(encoded)))
+	if err != nil {
+		quotaError(w, err)
+		return
+	}
+	defer release()
+
 	debug.MeasureBlock("save data & map [save_api]", func() {
 		startPtr, endPtr, saveErr = dataManager_v2.SaveDataToFileAsync(encoded, file)
 	})
@@ -70,7 +78,7 @@ func AsyncSave(w http.ResponseWriter, r *http.Request, c *http.Client) {
 		}
 	}
 
-	go subServer.NotifySubscribers(key, body)
+	go subServer.NotifySubscribers(file, key, body)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("save"))
@@ -10,6 +10,7 @@ import (
 	fileSystem_v1 "github.com/PAW122/TsunamiDB/data/fileSystem/v1"
 	encoder_v1 "github.com/PAW122/TsunamiDB/encoding/v1"
 	debug "github.com/PAW122/TsunamiDB/servers/debug"
+	limits "github.com/PAW122/TsunamiDB/servers/limits"
 	subServer "github.com/PAW122/TsunamiDB/servers/subscriptions"
 )
 
@@ -64,6 +65,12 @@ func SaveEncrypted(w http.ResponseWriter, r *http.Request, c *http.Client) {
 	}
 
 	encoded, _ := encoder_v1.Encode(encrypted_data)
+	release, err := limits.ReserveSave(file, key, int64(len(encoded)))
+	if err != nil {
+		quotaError(w, err)
+		return
+	}
+	defer release()
 	// save to file
 	startPtr, endPtr, err := dataManager_v1.SaveDataToFile(encoded, file)
 	if err != nil {
@@ -88,8 +95,8 @@ func SaveEncrypted(w http.ResponseWriter, r *http.Request, c *http.Client) {
 		}
 	}
 
-	// sends "plain text data" (not encrypted)
-	go subServer.NotifySubscribers(key, body)
+	// sends "plain text data" (not encrypted), only to live connections
+	go subServer.NotifyEncryptedSubscribers(file, key, body)
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, "save")
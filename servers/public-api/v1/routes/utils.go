@@ -1,8 +1,12 @@
 package routes
 
 import (
+	"errors"
+	"net/http"
 	"strings"
 	"sync"
+
+	limits "github.com/PAW122/TsunamiDB/servers/limits"
 )
 
 var saveWG sync.WaitGroup
@@ -14,3 +18,12 @@ func ParseArgs(path, endpoint string) []string {
 	prefix := []string{"", endpoint}
 	return append(prefix, parts...)
 }
+
+// quotaError - 507 dla przekroczonej quota tabeli, 500 dla pozostałych błędów.
+func quotaError(w http.ResponseWriter, err error) {
+	if errors.Is(err, limits.ErrQuotaExceeded) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	http.Error(w, "Quota check failed: "+err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,180 @@
+package subscriptions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+)
+
+/*
+Kanały pub/sub bez zapisu (presence, "pisze...").
+
+	POST /publish/<kanał>                      body = wiadomość (maks. maxChannelMessage)
+	enable / subscribe z "channels": ["room-7"]
+
+Subskrybenci kanału dostają {"event":"message","channel":"room-7","data":"..."} (data w kodowaniu
+połączenia, jak w "updated"). Wiadomość nie przechodzi przez dataManager, indeks ani log zdarzeń:
+nie ma seq, nie da się jej wznowić przez last_seq i nie trafia do webhooków. Przy pełnej kolejce
+klienta przepada jak zdarzenie z seq (queue.go). W klastrze idzie do peerów jak zdarzenia (cluster.go).
+
+Uprawnienia: kanał "x" to w kluczach API tabela "#x" - subskrypcja wymaga odczytu, publikacja zapisu.
+*/
+
+const (
+	maxChannelName    = 256
+	maxChannelMessage = 64 << 10
+)
+
+var (
+	ErrInvalidChannel         = errors.New("invalid channel")
+	ErrChannelMessageTooLarge = errors.New("channel message too large")
+
+	channelMessages atomic.Uint64
+)
+
+func channelTarget(channel string) subTarget {
+	return subTarget{kind: targetChannel, expr: channel}
+}
+
+// channelTable - nazwa "tabeli" kanału w uprawnieniach kluczy API.
+func channelTable(channel string) string {
+	return "#" + channel
+}
+
+func validateChannel(channel string) error {
+	if channel == "" || len(channel) > maxChannelName {
+		return ErrInvalidChannel
+	}
+	return nil
+}
+
+func validateChannels(channels []string) error {
+	for _, ch := range channels {
+		if validateChannel(ch) != nil {
+			return errors.New("invalid channel " + ch)
+		}
+	}
+	return nil
+}
+
+// matchedByLocked - czy cel obejmuje zdarzenie; wiadomość kanału pasuje tylko do celu kanału (pod mu).
+func (ev bufferedEvent) matchedByLocked(t subTarget) bool {
+	if ev.channel != "" || t.kind == targetChannel {
+		return t.kind == targetChannel && t.expr == ev.channel
+	}
+	return t.matches(patternTargets[t], ev.table, ev.key)
+}
+
+func channelEvent(channel string, payload []byte) bufferedEvent {
+	return bufferedEvent{
+		// klucz tylko dla pamięci changed() w filtrach
+		key:     channelTable(channel),
+		channel: channel,
+		data:    payload,
+		payload: map[string]any{
+			"event":   "message",
+			"channel": channel,
+		},
+	}
+}
+
+// PublishChannel wysyła wiadomość subskrybentom kanału (i peerom), bez zapisu.
+// Zwraca ilość subskrybentów na tym node.
+func PublishChannel(channel string, payload []byte) (int, error) {
+	if err := validateChannel(channel); err != nil {
+		return 0, err
+	}
+	if len(payload) > maxChannelMessage {
+		return 0, ErrChannelMessageTooLarge
+	}
+	return publishMessage(channelEvent(channel, bytes.Clone(payload))), nil
+}
+
+// publishMessage - jak publish, ale bez seq i logu zdarzeń.
+func publishMessage(ev bufferedEvent) int {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	local := ev.origin == ""
+	if node := localNodeID(); local && node != "" {
+		ev.payload["origin"] = node
+	}
+	if local {
+		forwardMessage(ev)
+	}
+	channelMessages.Add(1)
+
+	mu.Lock()
+	filters := newFilterEval(ev, true)
+	conns := make([]*client, 0, len(activeSubs[channelTarget(ev.channel)]))
+	encodings := make(map[*client]string, cap(conns))
+	for c := range activeSubs[channelTarget(ev.channel)] {
+		if !filters.allowsLocked(c) {
+			continue
+		}
+		conns = append(conns, c)
+		encodings[c] = connEncodingLocked(c)
+	}
+	mu.Unlock()
+	filters.finish()
+
+	frames := make(map[string]outMsg)
+	for _, c := range conns {
+		enc := encodings[c]
+		f, ok := frames[enc]
+		if !ok {
+			msgType, data, err := renderEvent(ev, enc)
+			if err != nil {
+				log.Println("channel message encode failed:", err)
+				continue
+			}
+			f = outMsg{msgType: msgType, data: data, transient: true}
+			frames[enc] = f
+		}
+		if !c.push(f) {
+			slowConsumer(c)
+		}
+	}
+	return len(conns)
+}
+
+// HandlePublish: POST /publish/<kanał>, body = wiadomość.
+func HandlePublish(w http.ResponseWriter, r *http.Request, _ *http.Client) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	channel := strings.TrimPrefix(r.URL.Path, "/publish/")
+	if validateChannel(channel) != nil {
+		http.Error(w, ErrInvalidChannel.Error(), http.StatusBadRequest)
+		return
+	}
+	id := auth.FromRequest(r)
+	if id == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !id.CanWrite(channelTable(channel)) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxChannelMessage))
+	if err != nil {
+		http.Error(w, ErrChannelMessageTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	delivered := publishMessage(channelEvent(channel, payload))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"channel":   channel,
+		"delivered": delivered,
+	})
+}
@@ -0,0 +1,109 @@
+package subscriptions
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Subskrybent niezależny od transportu: WebSocket (/sub), Server-Sent Events
+(/subscriptions/stream) albo long-poll (/subscriptions/poll, patrz http_stream.go).
+Rejestr (activeSubs, replay, resume, kodowania) trzyma *client; transport dostaje gotowe
+ramki (outMsg) od jednego writera klienta (kolejka wychodząca, patrz queue.go).
+*/
+
+const writeTimeout = 10 * time.Second
+
+var errClientClosed = errors.New("subscriber closed")
+
+type transport interface {
+	send(m outMsg) error
+	ping() error
+	close()
+	binary() bool // ramki binarne (encoding "binary") - tylko WebSocket
+}
+
+type outMsg struct {
+	msgType     int // websocket.TextMessage / websocket.BinaryMessage
+	data        []byte
+	seq         uint64 // seq zdarzenia (0 = zdarzenie kontrolne)
+	coalesceKey string // "" = nie do scalenia (polityka coalesce)
+	transient   bool   // wiadomość kanału (channels.go): bez seq, ale może przepaść jak zdarzenie
+}
+
+// droppable - zdarzenie na żywo, które pełna kolejka może usunąć (reszta to ramki kontrolne).
+func (m outMsg) droppable() bool {
+	return m.seq > 0 || m.transient
+}
+
+type client struct {
+	t transport
+	q outQueue
+}
+
+// registerClient zakłada kanał done (stop dla goroutine keepalive) i uruchamia writer klienta.
+func registerClient(t transport) (*client, chan struct{}) {
+	c := &client{t: t, q: newOutQueue()}
+	done := make(chan struct{})
+	mu.Lock()
+	connDone[c] = done
+	mu.Unlock()
+	go c.writeLoop()
+	return c, done
+}
+
+func writeJSON(c *client, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeMessage(c, outMsg{msgType: websocket.TextMessage, data: data})
+}
+
+// writeMessage dopisuje ramkę do kolejki klienta (błąd = klient zamknięty albo nie odbiera).
+func writeMessage(c *client, m outMsg) error {
+	return c.enqueue(m)
+}
+
+// keepAlive wysyła ping co interval; błąd (zerwane połączenie, wygasła sesja long-poll)
+// sprząta klienta.
+func keepAlive(c *client, interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.t.ping(); err != nil {
+				cleanupClient(c)
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// ---------------------------
+// WebSocket
+// ---------------------------
+
+type wsTransport struct {
+	conn *websocket.Conn
+}
+
+func (t wsTransport) send(m outMsg) error {
+	_ = t.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return t.conn.WriteMessage(m.msgType, m.data)
+}
+
+func (t wsTransport) ping() error {
+	// WriteControl może iść równolegle z writerem (krótkie ramki, deadline)
+	return t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
+
+func (t wsTransport) close() { _ = t.conn.Close() }
+
+func (t wsTransport) binary() bool { return true }
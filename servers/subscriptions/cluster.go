@@ -0,0 +1,210 @@
+package subscriptions
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Fan-out zdarzeń między node'ami (network manager, bez importu tutaj - patrz SetClusterForwarder).
+
+Każde zdarzenie opublikowane lokalnie (updated, deleted, inc_table_update) dostaje "origin"
+(node id) i jest wysyłane do peerów jako ClusterEvent o id "<origin>:<seq na origin>".
+Peer publikuje je swoim subskrybentom (z własnym seq i w swoim logu zdarzeń) i przekazuje dalej
+pozostałym peerom, więc zdarzenie dociera do całej sieci także przy niepełnej siatce połączeń.
+Duplikaty (kilka ścieżek, powrót do origin) są odrzucane po id.
+Zapisy szyfrowane idą bez wartości (tylko table, key i "encrypted"), bo połączenie między
+peerami nie musi być szyfrowane - subskrybenci innych node'ów dostają je bez "data".
+Wiadomości kanałów (channels.go) nie mają seq - ich id to "<origin>:m<start procesu>-<licznik>".
+*/
+
+const (
+	// ile ostatnich id zdarzeń pamiętamy do odrzucania duplikatów
+	clusterSeenMax = 16384
+	// kolejka zdarzeń do wysłania peerom; przepełnienie = zdarzenie nie trafi do innych node'ów
+	clusterOutboxMax = 4096
+)
+
+// ClusterEvent - zdarzenie przekazywane między node'ami.
+type ClusterEvent struct {
+	ID          string          `json:"id"`     // "<origin>:<seq na origin>"
+	Origin      string          `json:"origin"` // node id, na którym nastąpił zapis
+	Table       string          `json:"table"`
+	Key         string          `json:"key"`
+	ChangeType  string          `json:"change_type,omitempty"`
+	EntryID     uint64          `json:"entry_id,omitempty"`
+	Event       json.RawMessage `json:"event"`          // pola zdarzenia bez wartości i bez seq
+	Data        []byte          `json:"data,omitempty"` // bez wartości zapisów szyfrowanych
+	RemoveExact bool            `json:"remove_exact,omitempty"`
+	Channel     string          `json:"channel,omitempty"` // wiadomość kanału zamiast zdarzenia o kluczu
+}
+
+// ClusterForwarder wysyła zdarzenie do peerów z pominięciem from ("" = do wszystkich).
+type ClusterForwarder func(ev ClusterEvent, from string)
+
+type clusterOut struct {
+	ev   ClusterEvent
+	from string
+}
+
+var (
+	clusterMu        sync.Mutex
+	clusterNodeID    string
+	clusterForwarder ClusterForwarder
+	clusterOutbox    chan clusterOut
+	clusterSeen      = make(map[string]struct{})
+	clusterSeenRing  []string
+
+	clusterDropped atomic.Uint64
+
+	// id wiadomości kanałów muszą się różnić po restarcie (peery pamiętają widziane id)
+	clusterMsgEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	clusterMsgCount atomic.Uint64
+)
+
+// SetClusterForwarder włącza fan-out (network manager); nil wyłącza.
+func SetClusterForwarder(nodeID string, fwd ClusterForwarder) {
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+	clusterNodeID = nodeID
+	clusterForwarder = fwd
+	if fwd != nil && clusterOutbox == nil {
+		clusterOutbox = make(chan clusterOut, clusterOutboxMax)
+		go runClusterOutbox(clusterOutbox)
+	}
+}
+
+// runClusterOutbox - jeden wysyłający, więc peery dostają zdarzenia w kolejności publikacji.
+func runClusterOutbox(outbox chan clusterOut) {
+	for out := range outbox {
+		clusterMu.Lock()
+		fwd := clusterForwarder
+		clusterMu.Unlock()
+		if fwd != nil {
+			fwd(out.ev, out.from)
+		}
+	}
+}
+
+func localNodeID() string {
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+	return clusterNodeID
+}
+
+// markSeenLocked - false = id już było (pod clusterMu).
+func markSeenLocked(id string) bool {
+	if _, ok := clusterSeen[id]; ok {
+		return false
+	}
+	clusterSeen[id] = struct{}{}
+	clusterSeenRing = append(clusterSeenRing, id)
+	if len(clusterSeenRing) > clusterSeenMax {
+		delete(clusterSeen, clusterSeenRing[0])
+		clusterSeenRing = clusterSeenRing[1:]
+	}
+	return true
+}
+
+// forwardLocal - zdarzenie z tego node'a (po appendEvent, więc ev.seq jest znany) do peerów.
+func forwardLocal(ev bufferedEvent, removeExact bool) {
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+	if clusterForwarder == nil || clusterNodeID == "" {
+		return
+	}
+	id := clusterNodeID + ":" + strconv.FormatUint(ev.seq, 10)
+	markSeenLocked(id)
+	enqueueClusterLocked(clusterEventFrom(ev, id, clusterNodeID, removeExact), "")
+}
+
+func clusterEventFrom(ev bufferedEvent, id, origin string, removeExact bool) ClusterEvent {
+	fields := make(map[string]any, len(ev.payload))
+	for k, v := range ev.payload {
+		if k != "seq" {
+			fields[k] = v
+		}
+	}
+	raw, _ := json.Marshal(fields)
+	return ClusterEvent{
+		ID:          id,
+		Origin:      origin,
+		Table:       ev.table,
+		Key:         ev.key,
+		ChangeType:  ev.changeType,
+		EntryID:     ev.id,
+		Event:       raw,
+		Data:        ev.storedData(),
+		RemoveExact: removeExact,
+	}
+}
+
+// forwardMessage - wiadomość kanału z tego node'a do peerów (pod publishMu, jak forwardLocal).
+func forwardMessage(ev bufferedEvent) {
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+	if clusterForwarder == nil || clusterNodeID == "" {
+		return
+	}
+	id := clusterNodeID + ":m" + clusterMsgEpoch + "-" + strconv.FormatUint(clusterMsgCount.Add(1), 10)
+	markSeenLocked(id)
+	raw, _ := json.Marshal(ev.payload)
+	enqueueClusterLocked(ClusterEvent{
+		ID:      id,
+		Origin:  clusterNodeID,
+		Event:   raw,
+		Data:    ev.data,
+		Channel: ev.channel,
+	}, "")
+}
+
+func enqueueClusterLocked(ev ClusterEvent, from string) {
+	select {
+	case clusterOutbox <- clusterOut{ev: ev, from: from}:
+	default:
+		clusterDropped.Add(1)
+		log.Println("subscriptions: cluster outbox full, event not forwarded:", ev.ID)
+	}
+}
+
+// PublishRemote publikuje zdarzenie od peera from lokalnym subskrybentom i przekazuje je
+// pozostałym peerom. false = duplikat albo własne zdarzenie, które wróciło.
+func PublishRemote(ev ClusterEvent, from string) bool {
+	clusterMu.Lock()
+	if ev.ID == "" || ev.Origin == clusterNodeID || !markSeenLocked(ev.ID) {
+		clusterMu.Unlock()
+		return false
+	}
+	if clusterForwarder != nil {
+		enqueueClusterLocked(ev, from)
+	}
+	clusterMu.Unlock()
+
+	var payload map[string]any
+	if err := json.Unmarshal(ev.Event, &payload); err != nil || payload == nil {
+		log.Println("subscriptions: invalid cluster event", ev.ID, err)
+		return false
+	}
+	payload["origin"] = ev.Origin
+	if ev.Channel != "" {
+		msg := channelEvent(ev.Channel, ev.Data)
+		msg.payload, msg.origin = payload, ev.Origin
+		publishMessage(msg)
+		return true
+	}
+	publish(bufferedEvent{
+		table:      ev.Table,
+		key:        ev.Key,
+		changeType: ev.ChangeType,
+		id:         ev.EntryID,
+		payload:    payload,
+		data:       ev.Data,
+		origin:     ev.Origin,
+		encrypted:  payload["encrypted"] == true,
+	}, ev.RemoveExact)
+	return true
+}
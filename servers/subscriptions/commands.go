@@ -0,0 +1,198 @@
+package subscriptions
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+)
+
+/*
+Zarządzanie subskrypcjami przez otwarty WebSocket (bez nowego auth_key).
+
+	{"op":"subscribe","id":"1","table":"t","keys":["a"],"prefixes":["user:"],"patterns":["^x"],"filter":"temp > 30"}
+	{"op":"subscribe","id":"4","channels":["room-7"]}
+	{"op":"unsubscribe","id":"2","table":"t","keys":["a"]}
+	{"op":"list","id":"3"}
+
+Odpowiedź to {"event":"ack","op":"...","id":"1",...} albo {"event":"error","op":"...","id":"1","message":"..."};
+"id" (dowolna wartość JSON) jest odsyłane bez zmian. Komendy dotyczą tylko tego połączenia.
+subscribe wymaga wcześniej użytego auth_key: uprawnienia do tabeli sprawdzane są dla identity,
+które dołączyły auth_key do połączenia (aktualny config kluczy API); kanał "x" jak tabela "#x".
+*/
+
+type wsCommand struct {
+	Op       string          `json:"op"`
+	ID       json.RawMessage `json:"id"`
+	Table    string          `json:"table"`
+	Keys     []string        `json:"keys"`
+	Prefixes []string        `json:"prefixes"`
+	Patterns []string        `json:"patterns"`
+	Channels []string        `json:"channels"`
+	Filter   string          `json:"filter"` // filter.go, tylko subscribe
+}
+
+// targets - cele komendy, w kolejności jak pendingTargets.
+func (cmd wsCommand) targets() []subTarget {
+	return pendingTargets(&Pending{Table: cmd.Table, Keys: cmd.Keys, Prefixes: cmd.Prefixes, Patterns: cmd.Patterns, Channels: cmd.Channels})
+}
+
+func handleCommand(c *client, cmd wsCommand) {
+	var reply map[string]any
+	var code string
+	switch cmd.Op {
+	case "subscribe":
+		reply, code = subscribeCommand(c, cmd)
+	case "unsubscribe":
+		reply, code = unsubscribeCommand(c, cmd)
+	case "list":
+		reply = listCommand(c)
+	default:
+		code = "unknown_op"
+	}
+	if code != "" {
+		reply = map[string]any{"event": "error", "message": code}
+	} else {
+		reply["event"] = "ack"
+	}
+	reply["op"] = cmd.Op
+	if len(cmd.ID) > 0 {
+		reply["id"] = cmd.ID
+	}
+	_ = writeJSON(c, reply)
+}
+
+// canReadLocked - któraś z identity połączenia może czytać tabelę (pod mu).
+func canReadLocked(c *client, table string) (bool, string) {
+	ids := connIdentities[c]
+	if len(ids) == 0 {
+		return false, "not_authenticated"
+	}
+	for name := range ids {
+		if auth.ByName(name).CanRead(table) {
+			return true, ""
+		}
+	}
+	return false, "forbidden"
+}
+
+func subscribeCommand(c *client, cmd wsCommand) (map[string]any, string) {
+	tableTargets := len(cmd.Keys) + len(cmd.Prefixes) + len(cmd.Patterns)
+	if tableTargets+len(cmd.Channels) == 0 {
+		return nil, "invalid_request"
+	}
+	if validatePatterns(cmd.Patterns) != nil {
+		return nil, "invalid_pattern"
+	}
+	if validateChannels(cmd.Channels) != nil {
+		return nil, "invalid_channel"
+	}
+	filter, err := parseFilter(cmd.Filter)
+	if err != nil {
+		return nil, "invalid_filter"
+	}
+
+	mu.Lock()
+	if tableTargets > 0 {
+		if ok, code := canReadLocked(c, cmd.Table); !ok {
+			mu.Unlock()
+			return nil, code
+		}
+	}
+	for _, ch := range cmd.Channels {
+		if ok, code := canReadLocked(c, channelTable(ch)); !ok {
+			mu.Unlock()
+			return nil, code
+		}
+	}
+	added := 0
+	for _, t := range cmd.targets() {
+		if addTargetLocked(c, t) {
+			added++
+		}
+		// ponowny subscribe tego samego celu zmienia filtr
+		setFilterLocked(c, t, filter)
+	}
+	mu.Unlock()
+
+	reply := map[string]any{
+		"table":    cmd.Table,
+		"keys":     cmd.Keys,
+		"prefixes": cmd.Prefixes,
+		"patterns": cmd.Patterns,
+		"channels": cmd.Channels,
+		"added":    added,
+		"seq":      strconv.FormatUint(currentSeq(), 10),
+	}
+	if filter != nil {
+		reply["filter"] = filter.expr
+	}
+	return reply, ""
+}
+
+// unsubscribeCommand zdejmuje cele tylko temu połączeniu (bez "unsubscribed" - wystarczy ack).
+func unsubscribeCommand(c *client, cmd wsCommand) (map[string]any, string) {
+	if len(cmd.Keys)+len(cmd.Prefixes)+len(cmd.Patterns)+len(cmd.Channels) == 0 {
+		return nil, "invalid_request"
+	}
+
+	mu.Lock()
+	removed := 0
+	for _, t := range cmd.targets() {
+		if _, ok := connToTargets[c][t]; !ok {
+			continue
+		}
+		removeTargetLocked(c, t)
+		// przerwany replay / resume tego celu nie wysyła już buforowanych zdarzeń
+		delete(replaying[c], t)
+		if rs := resuming[c]; rs != nil {
+			delete(rs.targets, t)
+		}
+		removed++
+	}
+	mu.Unlock()
+
+	return map[string]any{
+		"table":    cmd.Table,
+		"keys":     cmd.Keys,
+		"prefixes": cmd.Prefixes,
+		"patterns": cmd.Patterns,
+		"channels": cmd.Channels,
+		"removed":  removed,
+	}, ""
+}
+
+// listCommand - aktywne cele połączenia, posortowane po tabeli i wyrażeniu.
+func listCommand(c *client) map[string]any {
+	mu.Lock()
+	targets := make([]subTarget, 0, len(connToTargets[c]))
+	filters := make(map[subTarget]string)
+	for t := range connToTargets[c] {
+		targets = append(targets, t)
+		if f := connFilters[c][t]; f != nil {
+			filters[t] = f.expr
+		}
+	}
+	mu.Unlock()
+
+	sort.Slice(targets, func(i, j int) bool {
+		a, b := targets[i], targets[j]
+		if a.table != b.table {
+			return a.table < b.table
+		}
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		return a.expr < b.expr
+	})
+	subs := make([]map[string]string, 0, len(targets))
+	for _, t := range targets {
+		sub := t.fields()
+		if expr, ok := filters[t]; ok {
+			sub["filter"] = expr
+		}
+		subs = append(subs, sub)
+	}
+	return map[string]any{"subscriptions": subs}
+}
@@ -0,0 +1,156 @@
+package subscriptions
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"maps"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Kodowanie wartości w zdarzeniach (negocjowane przy auth_key: {"auth_key":"...","encoding":"..."}).
+
+	text   - "data": string(value) (domyślne, psuje wartości spoza UTF-8)
+	base64 - "data": base64(value)
+	json   - "data": <value> osadzone wprost, gdy jest poprawnym JSON; inaczej base64
+	         z "data_encoding":"base64" (pusta wartość = null)
+	binary - ramka binarna WS: [1B wersja=1][4B BE długość metadanych][metadane JSON][value]
+
+Dotyczy zdarzeń z wartością: updated, inc_table_update (data.new_data.data), inc_table_replay
+(entries[].data; w binary wpisy mają "size", a wartości są sklejone po metadanych). deleted
+w binary to ramka bez wartości. Zdarzenia kontrolne (subscribed, error, ...) są zawsze tekstowym JSON.
+*/
+
+const (
+	EncodingText   = "text"
+	EncodingBase64 = "base64"
+	EncodingJSON   = "json"
+	EncodingBinary = "binary"
+
+	binaryFrameVersion = 1
+)
+
+// conn -> wynegocjowane kodowanie (chronione przez mu; brak = text)
+var connEncodings = make(map[*client]string)
+
+func validEncoding(enc string) bool {
+	switch enc {
+	case EncodingText, EncodingBase64, EncodingJSON, EncodingBinary:
+		return true
+	}
+	return false
+}
+
+func connEncodingLocked(c *client) string {
+	if enc, ok := connEncodings[c]; ok {
+		return enc
+	}
+	return EncodingText
+}
+
+// setValue wstawia wartość do pól zdarzenia w kodowaniu JSON (text, base64, json).
+func setValue(enc string, dst map[string]any, value []byte) {
+	switch enc {
+	case EncodingBase64:
+		dst["data"] = base64.StdEncoding.EncodeToString(value)
+	case EncodingJSON:
+		switch {
+		case len(value) == 0:
+			dst["data"] = nil
+		case json.Valid(value):
+			dst["data"] = json.RawMessage(value)
+		default:
+			dst["data"] = base64.StdEncoding.EncodeToString(value)
+			dst["data_encoding"] = EncodingBase64
+		}
+	default:
+		dst["data"] = string(value)
+	}
+}
+
+// binaryFrame skleja nagłówek, metadane i wartość w ramkę binarną.
+func binaryFrame(meta map[string]any, value []byte) ([]byte, error) {
+	rawMeta, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 5, 5+len(rawMeta)+len(value))
+	frame[0] = binaryFrameVersion
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(rawMeta)))
+	frame = append(frame, rawMeta...)
+	return append(frame, value...), nil
+}
+
+// renderEvent buduje ramkę WS zdarzenia (ev.payload bez wartości + ev.data) w danym kodowaniu.
+func renderEvent(ev bufferedEvent, enc string) (int, []byte, error) {
+	out := maps.Clone(ev.payload)
+	// miejsce wartości zależy od typu zdarzenia
+	var dst map[string]any
+	switch out["event"] {
+	case "updated", "message":
+		dst = out
+	case "inc_table_update":
+		if data, ok := out["data"].(map[string]any); ok {
+			data = maps.Clone(data)
+			out["data"] = data
+			if nd, ok := data["new_data"].(map[string]any); ok {
+				dst = maps.Clone(nd)
+				data["new_data"] = dst
+			}
+		}
+	}
+
+	if enc == EncodingBinary {
+		frame, err := binaryFrame(out, ev.data)
+		return websocket.BinaryMessage, frame, err
+	}
+	// zapis szyfrowany z logu albo od peera - bez "data" zamiast pustej wartości
+	if dst != nil && !(ev.encrypted && ev.data == nil) {
+		setValue(enc, dst, ev.data)
+	}
+	frame, err := json.Marshal(out)
+	return websocket.TextMessage, frame, err
+}
+
+// renderReplayPage - strona "inc_table_replay" w danym kodowaniu.
+func renderReplayPage(table, key string, page IncReplayPage, enc string) (int, []byte, error) {
+	entries := make([]map[string]any, 0, len(page.IDs))
+	var values []byte
+	for i, id := range page.IDs {
+		entry := map[string]any{"id": strconv.FormatUint(id, 10)}
+		if enc == EncodingBinary {
+			entry["size"] = len(page.Data[i])
+			values = append(values, page.Data[i]...)
+		} else {
+			setValue(enc, entry, page.Data[i])
+		}
+		entries = append(entries, entry)
+	}
+	meta := map[string]any{
+		"event": "inc_table_replay",
+		"table": table,
+		"key":   key,
+		"data":  map[string]any{"entries": entries},
+	}
+	if enc == EncodingBinary {
+		frame, err := binaryFrame(meta, values)
+		return websocket.BinaryMessage, frame, err
+	}
+	frame, err := json.Marshal(meta)
+	return websocket.TextMessage, frame, err
+}
+
+// writeEvent wysyła zdarzenie w kodowaniu połączenia.
+func writeEvent(c *client, ev bufferedEvent) error {
+	mu.Lock()
+	enc := connEncodingLocked(c)
+	mu.Unlock()
+	msgType, frame, err := renderEvent(ev, enc)
+	if err != nil {
+		return err
+	}
+	return writeMessage(c, outMsg{msgType: msgType, data: frame, seq: ev.seq})
+}
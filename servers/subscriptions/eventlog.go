@@ -0,0 +1,313 @@
+package subscriptions
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+/*
+Trwały strumień zmian.
+
+Każde zdarzenie o kluczu (updated, deleted, inc_table_update) dostaje rosnący numer "seq"
+i jest dopisywane do ograniczonego logu na dysku:
+
+	./db/subscriptions/events-<seq pierwszego wpisu>.log  (JSON lines)
+
+Klient wznawiający połączenie wysyła na /sub {"auth_key":"...","last_seq":"N"} i dostaje
+najpierw zapisane zdarzenia z seq > N pasujące do subskrypcji z auth_key (resume.go),
+a potem zdarzenia na żywo. Retencja (subscriptions.event_log.max_bytes) usuwa całe
+najstarsze segmenty, nigdy aktualny - seq nie zaczyna się od nowa po restarcie.
+*/
+
+const (
+	defaultEventLogMaxBytes     = 64 << 20
+	defaultEventLogSegmentBytes = 4 << 20
+)
+
+var (
+	ErrOffsetTrimmed = errors.New("offset_trimmed")
+	ErrOffsetAhead   = errors.New("offset_ahead")
+	ErrEventLogOff   = errors.New("event_log_disabled")
+)
+
+type logRecord struct {
+	Seq   uint64          `json:"seq"`
+	Table string          `json:"table"`
+	Key   string          `json:"key"`
+	Event json.RawMessage `json:"event"`          // pola zdarzenia bez wartości
+	Data  []byte          `json:"data,omitempty"` // bez wartości zapisów szyfrowanych
+}
+
+// event odtwarza zdarzenie z wpisu logu (kodowanie wartości jak na żywo, encoding.go).
+func (rec logRecord) event() (bufferedEvent, error) {
+	ev := bufferedEvent{seq: rec.Seq, table: rec.Table, key: rec.Key, data: rec.Data}
+	err := json.Unmarshal(rec.Event, &ev.payload)
+	ev.encrypted = ev.payload["encrypted"] == true
+	return ev, err
+}
+
+type logSegment struct {
+	path  string
+	start uint64 // seq pierwszego wpisu
+	size  int64
+}
+
+var (
+	eventLogDir = filepath.Join(".", "db", "subscriptions")
+
+	logMu       sync.Mutex
+	logLoaded   bool
+	logCurrent  *os.File
+	logSegments []logSegment // posortowane po start; ostatni = aktualnie dopisywany
+	lastSeq     uint64
+)
+
+// appendEvent nadaje zdarzeniu kolejny seq (ev.seq i pole "seq" w payload) i dopisuje je do logu.
+// Błędy zapisu są tylko logowane - zdarzenie i tak idzie do subskrybentów.
+func appendEvent(ev *bufferedEvent) {
+	cfg := config.Get().Subscriptions.EventLog
+
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	loadErr := loadEventLogLocked()
+	if loadErr != nil {
+		log.Println("subscriptions: cannot load event log:", loadErr)
+	}
+	lastSeq++
+	seq := lastSeq
+	ev.seq = seq
+	ev.payload["seq"] = strconv.FormatUint(seq, 10)
+	if cfg.Disabled || loadErr != nil {
+		return
+	}
+
+	event, err := json.Marshal(ev.payload)
+	if err != nil {
+		log.Println("subscriptions: event marshal error:", err)
+		return
+	}
+	line, err := json.Marshal(logRecord{Seq: seq, Table: ev.table, Key: ev.key, Event: event, Data: ev.storedData()})
+	if err != nil {
+		log.Println("subscriptions: event marshal error:", err)
+		return
+	}
+	line = append(line, '\n')
+
+	segmentBytes := cfg.SegmentBytes
+	if segmentBytes <= 0 {
+		segmentBytes = defaultEventLogSegmentBytes
+	}
+	if logCurrent == nil || logSegments[len(logSegments)-1].size+int64(len(line)) > segmentBytes {
+		if err := rotateEventLogLocked(seq); err != nil {
+			log.Println("subscriptions: cannot rotate event log:", err)
+			return
+		}
+		enforceEventLogRetentionLocked(cfg)
+	}
+
+	n, err := logCurrent.Write(line)
+	logSegments[len(logSegments)-1].size += int64(n)
+	if err != nil {
+		log.Println("subscriptions: event log write error:", err)
+		return
+	}
+	if cfg.Sync {
+		_ = logCurrent.Sync()
+	}
+}
+
+// currentSeq - seq ostatniego nadanego zdarzenia (0 = jeszcze żadnego).
+func currentSeq() uint64 {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if err := loadEventLogLocked(); err != nil {
+		log.Println("subscriptions: cannot load event log:", err)
+	}
+	return lastSeq
+}
+
+func eventSegmentName(start uint64) string {
+	return fmt.Sprintf("events-%020d.log", start)
+}
+
+func loadEventLogLocked() error {
+	if logLoaded {
+		return nil
+	}
+	if err := os.MkdirAll(eventLogDir, 0o755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(eventLogDir)
+	if err != nil {
+		return err
+	}
+	logSegments = logSegments[:0]
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, "events-") || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		start, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "events-"), ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		logSegments = append(logSegments, logSegment{path: filepath.Join(eventLogDir, name), start: start, size: info.Size()})
+	}
+	sort.Slice(logSegments, func(i, j int) bool { return logSegments[i].start < logSegments[j].start })
+
+	// kontynuujemy dopisywanie do ostatniego segmentu i numerację od jego ostatniego wpisu
+	if n := len(logSegments); n > 0 {
+		seq, size, err := recoverSegmentTail(logSegments[n-1])
+		if err != nil {
+			return err
+		}
+		logSegments[n-1].size = size
+		lastSeq = max(lastSeq, seq)
+		f, err := os.OpenFile(logSegments[n-1].path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		logCurrent = f
+	}
+	logLoaded = true
+	return nil
+}
+
+// recoverSegmentTail obcina niedokończoną ostatnią linię (awaria w trakcie zapisu) i zwraca
+// seq ostatniego pełnego wpisu oraz nowy rozmiar segmentu.
+func recoverSegmentTail(seg logSegment) (uint64, int64, error) {
+	data, err := os.ReadFile(seg.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end < len(data) {
+		if err := os.Truncate(seg.path, int64(end)); err != nil {
+			return 0, 0, err
+		}
+	}
+	last := seg.start - min(seg.start, 1)
+	lines := bytes.Split(data[:end], []byte{'\n'})
+	for i := len(lines) - 1; i >= 0; i-- {
+		var rec logRecord
+		if json.Unmarshal(lines[i], &rec) == nil {
+			last = rec.Seq
+			break
+		}
+	}
+	return last, int64(end), nil
+}
+
+func rotateEventLogLocked(start uint64) error {
+	path := filepath.Join(eventLogDir, eventSegmentName(start))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if logCurrent != nil {
+		_ = logCurrent.Close()
+	}
+	logCurrent = f
+	logSegments = append(logSegments, logSegment{path: path, start: start})
+	return nil
+}
+
+// enforceEventLogRetentionLocked usuwa najstarsze zamknięte segmenty ponad max_bytes (nigdy aktualny).
+func enforceEventLogRetentionLocked(cfg config.Sub_event_log) {
+	maxBytes := cfg.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultEventLogMaxBytes
+	}
+	var total int64
+	for _, s := range logSegments {
+		total += s.size
+	}
+	for len(logSegments) > 1 && total > maxBytes {
+		total -= logSegments[0].size
+		if err := os.Remove(logSegments[0].path); err != nil && !os.IsNotExist(err) {
+			log.Println("subscriptions: cannot remove event log segment:", err)
+		}
+		logSegments = logSegments[1:]
+	}
+}
+
+// oldestSeqLocked - najmniejszy seq dostępny w logu (lastSeq+1 gdy log jest pusty).
+func oldestSeqLocked() uint64 {
+	if len(logSegments) > 0 {
+		return logSegments[0].start
+	}
+	return lastSeq + 1
+}
+
+// readEventLog wywołuje fn dla zapisanych zdarzeń o seq > after, od najstarszego, aż do końca logu.
+// ErrOffsetTrimmed - część zdarzeń po after została już usunięta przez retencję.
+func readEventLog(after uint64, fn func(rec logRecord) error) error {
+	if config.Get().Subscriptions.EventLog.Disabled {
+		return ErrEventLogOff
+	}
+	logMu.Lock()
+	if err := loadEventLogLocked(); err != nil {
+		logMu.Unlock()
+		return err
+	}
+	if after > lastSeq {
+		logMu.Unlock()
+		return ErrOffsetAhead
+	}
+	if after+1 < oldestSeqLocked() {
+		logMu.Unlock()
+		return ErrOffsetTrimmed
+	}
+	snapshot := append([]logSegment(nil), logSegments...)
+	logMu.Unlock()
+
+	for i, seg := range snapshot {
+		// segment i kończy się tam, gdzie zaczyna się i+1
+		if i+1 < len(snapshot) && snapshot[i+1].start <= after+1 {
+			continue
+		}
+		f, err := os.Open(seg.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return ErrOffsetTrimmed // usunięty przez retencję w trakcie odczytu
+			}
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64<<20)
+		for scanner.Scan() {
+			var rec logRecord
+			// niedokończona ostatnia linia (trwa zapis) - to zdarzenie dotrze na żywo
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Seq <= after {
+				continue
+			}
+			if err := fn(rec); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+package subscriptions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+Filtry zdarzeń (enable / subscribe z "filter"), liczone na nowej wartości JSON klucza.
+
+	status == "active" && temp >= 30
+	exists(user.email) || !(tags[0] == "muted")
+	changed(price)
+
+Ścieżka: pola po kropce, indeksy tablic w [n]. Porównania: == != > >= < <= z liczbą, "stringiem"
+(JSON), true, false albo null; > >= < <= tylko dla dwóch liczb albo dwóch stringów.
+Brakujące pole nie spełnia żadnego porównania (także !=) - do tego jest exists().
+changed(path) - pole jest inne niż w poprzedniej wartości klucza (nieznana poprzednia = zmiana).
+
+Wiadomości kanałów (channels.go) są filtrowane jak "updated". Zdarzenia bez wartości
+(deleted, inc delete / trim) przechodzą zawsze. Wartość, która nie jest
+poprawnym JSON, nie spełnia filtra. Każdy filtr jest liczony raz na zdarzenie (filterEval).
+*/
+
+const (
+	maxFilterLen = 1024
+	// ile ostatnich wartości kluczy pamiętamy dla changed()
+	filterPrevMax = 10000
+)
+
+var (
+	ErrInvalidFilter = errors.New("invalid filter")
+
+	// conn -> cel -> filtr (chronione przez mu; brak = bez filtra)
+	connFilters = make(map[*client]map[subTarget]*eventFilter)
+
+	// poprzednie wartości kluczy dla changed() (table\x00key -> wartość)
+	filterPrevMu   sync.Mutex
+	filterPrev     = make(map[string][]byte)
+	filterPrevRing []string
+)
+
+type eventFilter struct {
+	expr    string
+	root    filterNode
+	changed bool // używa changed() - wymaga poprzedniej wartości
+}
+
+type filterNode interface {
+	eval(cur, prev any, prevKnown bool) bool
+}
+
+type (
+	andNode     struct{ l, r filterNode }
+	orNode      struct{ l, r filterNode }
+	notNode     struct{ n filterNode }
+	existsNode  struct{ path []any }
+	changedNode struct{ path []any }
+	cmpNode     struct {
+		path []any
+		op   string
+		lit  any
+	}
+)
+
+func (n andNode) eval(cur, prev any, known bool) bool {
+	return n.l.eval(cur, prev, known) && n.r.eval(cur, prev, known)
+}
+
+func (n orNode) eval(cur, prev any, known bool) bool {
+	return n.l.eval(cur, prev, known) || n.r.eval(cur, prev, known)
+}
+
+func (n notNode) eval(cur, prev any, known bool) bool { return !n.n.eval(cur, prev, known) }
+
+func (n existsNode) eval(cur, _ any, _ bool) bool {
+	_, ok := lookupPath(cur, n.path)
+	return ok
+}
+
+func (n changedNode) eval(cur, prev any, known bool) bool {
+	if !known {
+		return true
+	}
+	a, okA := lookupPath(cur, n.path)
+	b, okB := lookupPath(prev, n.path)
+	return okA != okB || !reflect.DeepEqual(a, b)
+}
+
+func (n cmpNode) eval(cur, _ any, _ bool) bool {
+	v, ok := lookupPath(cur, n.path)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(v, n.lit)
+	case "!=":
+		return !reflect.DeepEqual(v, n.lit)
+	}
+	var c int
+	switch a := v.(type) {
+	case float64:
+		b, ok := n.lit.(float64)
+		if !ok {
+			return false
+		}
+		c = compareFloat(a, b)
+	case string:
+		b, ok := n.lit.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(a, b)
+	default:
+		return false
+	}
+	switch n.op {
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	default:
+		return c <= 0
+	}
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// lookupPath - element ścieżki to string (pole) albo int (indeks tablicy).
+func lookupPath(v any, path []any) (any, bool) {
+	for _, p := range path {
+		switch p := p.(type) {
+		case string:
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[p]; !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := v.([]any)
+			if !ok || p >= len(arr) {
+				return nil, false
+			}
+			v = arr[p]
+		}
+	}
+	return v, true
+}
+
+// ---------------------------
+// Parser
+// ---------------------------
+
+type filterParser struct {
+	src     string
+	pos     int
+	changed bool
+}
+
+// parseFilter kompiluje wyrażenie filtra; "" = brak filtra (nil, nil).
+func parseFilter(expr string) (*eventFilter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	if len(expr) > maxFilterLen {
+		return nil, fmt.Errorf("%w: longer than %d bytes", ErrInvalidFilter, maxFilterLen)
+	}
+	p := &filterParser{src: expr}
+	root, err := p.parseOr()
+	if err == nil {
+		p.skipSpace()
+		if p.pos < len(p.src) {
+			err = p.errorf("unexpected %q", p.src[p.pos:])
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &eventFilter{expr: expr, root: root, changed: p.changed}, nil
+}
+
+func (p *filterParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w at %d: %s", ErrInvalidFilter, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *filterParser) skipSpace() {
+	for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept przesuwa za tok, jeśli jest następny.
+func (p *filterParser) accept(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right filterNode
+		if right, err = p.parseAnd(); err == nil {
+			left = orNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.accept("&&") {
+		var right filterNode
+		if right, err = p.parseUnary(); err == nil {
+			left = andNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	p.skipSpace()
+	// "!" ale nie "!="
+	if strings.HasPrefix(p.src[p.pos:], "!") && !strings.HasPrefix(p.src[p.pos:], "!=") {
+		p.pos++
+		n, err := p.parseUnary()
+		return notNode{n}, err
+	}
+	if p.accept("(") {
+		n, err := p.parseOr()
+		if err == nil && !p.accept(")") {
+			err = p.errorf("missing )")
+		}
+		return n, err
+	}
+	for _, fn := range []string{"exists", "changed"} {
+		if !p.accept(fn + "(") {
+			continue
+		}
+		path, err := p.parsePath()
+		if err == nil && !p.accept(")") {
+			err = p.errorf("missing )")
+		}
+		if fn == "changed" {
+			p.changed = true
+			return changedNode{path}, err
+		}
+		return existsNode{path}, err
+	}
+
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	op := ""
+	for _, candidate := range []string{"==", "!=", ">=", "<=", ">", "<"} {
+		if p.accept(candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return nil, p.errorf("expected comparison operator")
+	}
+	lit, err := p.parseLiteral()
+	return cmpNode{path: path, op: op, lit: lit}, err
+}
+
+func isIdentByte(b byte, first bool) bool {
+	return b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || !first && (b == '-' || b >= '0' && b <= '9')
+}
+
+func (p *filterParser) parseIdent() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && isIdentByte(p.src[p.pos], p.pos == start) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected field name")
+	}
+	return p.src[start:p.pos], nil
+}
+
+func (p *filterParser) parsePath() ([]any, error) {
+	first, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	path := []any{first}
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '.':
+			p.pos++
+			field, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, field)
+		case '[':
+			end := strings.IndexByte(p.src[p.pos:], ']')
+			if end < 0 {
+				return nil, p.errorf("missing ]")
+			}
+			idx, err := strconv.Atoi(p.src[p.pos+1 : p.pos+end])
+			if err != nil || idx < 0 {
+				return nil, p.errorf("invalid index")
+			}
+			p.pos += end + 1
+			path = append(path, idx)
+		default:
+			return path, nil
+		}
+	}
+	return path, nil
+}
+
+func (p *filterParser) parseLiteral() (any, error) {
+	p.skipSpace()
+	// literał JSON: czytamy jedną wartość dekoderem i przesuwamy pozycję o jej długość
+	dec := json.NewDecoder(strings.NewReader(p.src[p.pos:]))
+	var lit any
+	if err := dec.Decode(&lit); err != nil {
+		return nil, p.errorf("invalid value")
+	}
+	switch lit.(type) {
+	case map[string]any, []any:
+		return nil, p.errorf("objects and arrays cannot be compared")
+	}
+	p.pos += int(dec.InputOffset())
+	return lit, nil
+}
+
+// ---------------------------
+// Ewaluacja przy publish
+// ---------------------------
+
+// filterEval - filtry jednego zdarzenia; wynik każdego wyrażenia liczony raz.
+type filterEval struct {
+	ev       bufferedEvent
+	decoded  bool
+	valid    bool
+	cur      any
+	prev     any
+	prevOK   bool
+	results  map[string]bool
+	sawPrev  bool // któryś filtr użył changed() - zapamiętaj nową wartość
+	usePrevs bool // false = changed() zawsze true (resume z logu)
+}
+
+func newFilterEval(ev bufferedEvent, usePrevs bool) *filterEval {
+	return &filterEval{ev: ev, usePrevs: usePrevs, results: make(map[string]bool)}
+}
+
+// hasValue - zdarzenia bez wartości (deleted, delete / trim w inc table) nie są filtrowane.
+func (fe *filterEval) hasValue() bool {
+	switch fe.ev.payload["event"] {
+	case "updated", "message":
+		return true
+	case "inc_table_update":
+		return fe.ev.changeType != "delete" && fe.ev.changeType != "trim"
+	}
+	return false
+}
+
+func (fe *filterEval) match(f *eventFilter) bool {
+	if r, ok := fe.results[f.expr]; ok {
+		return r
+	}
+	if !fe.decoded {
+		fe.decoded = true
+		fe.valid = json.Unmarshal(fe.ev.data, &fe.cur) == nil
+	}
+	if f.changed && fe.usePrevs && !fe.sawPrev {
+		fe.sawPrev = true
+		var raw []byte
+		raw, fe.prevOK = loadFilterPrev(fe.ev.table, fe.ev.key)
+		if fe.prevOK {
+			fe.prevOK = json.Unmarshal(raw, &fe.prev) == nil
+		}
+	}
+	r := fe.valid && f.root.eval(fe.cur, fe.prev, fe.prevOK)
+	fe.results[f.expr] = r
+	return r
+}
+
+// allowsLocked - czy c dostaje zdarzenie: któryś pasujący cel c jest bez filtra albo filtr pasuje (pod mu).
+func (fe *filterEval) allowsLocked(c *client) bool {
+	filters := connFilters[c]
+	if len(filters) == 0 || !fe.hasValue() {
+		return true
+	}
+	for t := range connToTargets[c] {
+		if !fe.ev.matchedByLocked(t) {
+			continue
+		}
+		f := filters[t]
+		if f == nil || fe.match(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// finish zapamiętuje wartość klucza dla changed() (tylko gdy któryś filtr jej potrzebował).
+func (fe *filterEval) finish() {
+	if !fe.sawPrev || !fe.hasValue() {
+		return
+	}
+	storeFilterPrev(fe.ev.table, fe.ev.key, bytes.Clone(fe.ev.data))
+}
+
+func loadFilterPrev(table, key string) ([]byte, bool) {
+	filterPrevMu.Lock()
+	defer filterPrevMu.Unlock()
+	v, ok := filterPrev[table+"\x00"+key]
+	return v, ok
+}
+
+func storeFilterPrev(table, key string, value []byte) {
+	k := table + "\x00" + key
+	filterPrevMu.Lock()
+	defer filterPrevMu.Unlock()
+	if _, ok := filterPrev[k]; !ok {
+		filterPrevRing = append(filterPrevRing, k)
+		if len(filterPrevRing) > filterPrevMax {
+			delete(filterPrev, filterPrevRing[0])
+			filterPrevRing = filterPrevRing[1:]
+		}
+	}
+	filterPrev[k] = value
+}
+
+// setFilterLocked przypisuje filtr do celu połączenia (nil = bez filtra); ostatni wygrywa (pod mu).
+func setFilterLocked(c *client, t subTarget, f *eventFilter) {
+	if f == nil {
+		if m := connFilters[c]; m != nil {
+			delete(m, t)
+			if len(m) == 0 {
+				delete(connFilters, c)
+			}
+		}
+		return
+	}
+	if connFilters[c] == nil {
+		connFilters[c] = make(map[subTarget]*eventFilter)
+	}
+	connFilters[c][t] = f
+}
@@ -0,0 +1,448 @@
+package subscriptions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+)
+
+/*
+Subskrypcje przez HTTP (Public API) dla klientów, którym proxy blokuje WebSocket.
+Ten sam auth_key z /subscriptions/enable, te same zdarzenia i rejestr co /sub.
+
+Server-Sent Events:
+
+	GET /subscriptions/stream?auth_key=...&encoding=json&last_seq=N
+
+	każde zdarzenie to "data: <JSON>"; zdarzenia z seq mają "id: <seq>", więc
+	Last-Event-ID (zamiast last_seq) wznawia strumień z logu zdarzeń
+
+Long-poll:
+
+	GET|POST /subscriptions/poll?auth_key=...&encoding=...&last_seq=N     - nowa sesja
+	GET|POST /subscriptions/poll?session=...&cursor=N&timeout=25&limit=100 - kolejna partia
+
+	odpowiedź {"session":"...","cursor":"M","events":[...]}; cursor to pozycja ostatniego
+	zdarzenia w sesji. Zdarzenia po cursor są trzymane do następnego pollowania z wyższym
+	cursor, więc powtórzony request (zgubiona odpowiedź) dostaje tę samą partię.
+	Sesja z auth_key powiązanym z IP (client_ip) działa dalej tylko z tego IP.
+
+Limity per klucz API (servers/limits) liczone są dla identity, która wygenerowała auth_key
+(WithAuthKeyIdentity).
+
+Kodowanie "binary" nie jest dostępne dla SSE ani long-poll.
+*/
+
+const (
+	sseKeepAlive       = 15 * time.Second
+	pollSessionTTL     = 60 * time.Second
+	pollDefaultTimeout = 25 * time.Second
+	pollMaxTimeout     = 55 * time.Second
+	pollDefaultLimit   = 100
+	pollMaxLimit       = 1000
+	// maks. ilość nieodebranych zdarzeń sesji long-poll; przepełnienie zamyka sesję
+	pollQueueMax = 10000
+)
+
+var (
+	errPollQueueFull = errors.New("poll queue full")
+	errPollExpired   = errors.New("poll session expired")
+
+	// session -> klient long-poll (chronione przez mu)
+	pollSessions = make(map[string]*client)
+)
+
+// allowOrigin - subscriptions.allowed_origins jak dla /sub; dozwolony Origin dostaje nagłówek CORS.
+func allowOrigin(w http.ResponseWriter, r *http.Request) bool {
+	if !checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	return true
+}
+
+func attachStatus(code string) int {
+	if code == "invalid_or_expired_auth_key" {
+		return http.StatusUnauthorized
+	}
+	return http.StatusBadRequest
+}
+
+// WithAuthKeyIdentity ustawia w requeście identity właściciela auth_key (albo sesji long-poll),
+// żeby limits.Middleware liczył /subscriptions/stream i /subscriptions/poll na jego klucz API.
+func WithAuthKeyIdentity(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if name := authKeyIdentity(r.URL.Query()); name != "" {
+			r = r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{Name: name}))
+		}
+		next(w, r)
+	}
+}
+
+func authKeyIdentity(q url.Values) string {
+	mu.Lock()
+	defer mu.Unlock()
+	if pend := pendingAuthKeys[q.Get("auth_key")]; pend != nil {
+		return pend.Identity
+	}
+	if c := pollSessions[q.Get("session")]; c != nil {
+		if pt, ok := c.t.(*pollTransport); ok {
+			return pt.identity
+		}
+	}
+	return ""
+}
+
+// ---------------------------
+// Server-Sent Events
+// ---------------------------
+
+type sseTransport struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	closed bool
+	done   chan struct{}
+}
+
+func (t *sseTransport) write(frame []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return errClientClosed
+	}
+	_ = t.rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := t.w.Write(frame); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+func (t *sseTransport) send(m outMsg) error {
+	var buf bytes.Buffer
+	if m.seq > 0 {
+		fmt.Fprintf(&buf, "id: %d\n", m.seq)
+	}
+	for _, line := range bytes.Split(m.data, []byte{'\n'}) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return t.write(buf.Bytes())
+}
+
+func (t *sseTransport) ping() error { return t.write([]byte(": ping\n\n")) }
+
+func (t *sseTransport) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.done)
+	}
+}
+
+func (t *sseTransport) binary() bool { return false }
+
+// HandleSSE - strumień zdarzeń jako text/event-stream (auth_key zamiast klucza API, jak /sub).
+func HandleSSE(w http.ResponseWriter, r *http.Request, _ *http.Client) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !allowOrigin(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	req := attachRequest{AuthKey: q.Get("auth_key"), LastSeq: q.Get("last_seq"), Encoding: q.Get("encoding")}
+	if req.LastSeq == "" {
+		req.LastSeq = r.Header.Get("Last-Event-ID")
+	}
+	if req.AuthKey == "" {
+		http.Error(w, "missing auth_key", http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// strumień żyje dłużej niż WriteTimeout serwera; deadline ustawiany per zapis
+	_ = rc.SetWriteDeadline(time.Time{})
+	st := &sseTransport{w: w, rc: rc, done: make(chan struct{})}
+	c, done := registerClient(st)
+	defer func() {
+		cleanupClient(c)
+		// writer nie może pisać do w po powrocie z handlera
+		<-c.q.done
+	}()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if code := attach(c, remoteIP, req); code != "" {
+		http.Error(w, code, attachStatus(code))
+		return
+	}
+
+	go keepAlive(c, sseKeepAlive, done)
+	select {
+	case <-r.Context().Done():
+	case <-st.done:
+	}
+}
+
+// ---------------------------
+// Long-poll
+// ---------------------------
+
+type pollItem struct {
+	pos  uint64
+	data json.RawMessage
+}
+
+type pollTransport struct {
+	id       string
+	identity string // właściciel auth_key, z którym założono sesję (limity)
+	mu       sync.Mutex
+	boundIP  string // IP, z którego wolno pollować ("" = dowolne)
+	items    []pollItem
+	nextPos  uint64
+	wake     chan struct{} // zamykany (i wymieniany) przy nowym zdarzeniu albo zamknięciu
+	closed   bool
+	lastPoll time.Time
+}
+
+func newPollTransport() *pollTransport {
+	return &pollTransport{id: uuid.NewString(), wake: make(chan struct{}), lastPoll: time.Now()}
+}
+
+func (t *pollTransport) send(m outMsg) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return errClientClosed
+	}
+	if len(t.items) >= pollQueueMax {
+		return errPollQueueFull
+	}
+	t.nextPos++
+	t.items = append(t.items, pollItem{pos: t.nextPos, data: m.data})
+	close(t.wake)
+	t.wake = make(chan struct{})
+	return nil
+}
+
+// ping - sesja bez pollowania dłużej niż pollSessionTTL wygasa.
+func (t *pollTransport) ping() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.lastPoll) > pollSessionTTL {
+		return errPollExpired
+	}
+	return nil
+}
+
+// close - nieodebrane zdarzenia (np. "revoked") zostają do pobrania jeszcze przez pollSessionTTL.
+func (t *pollTransport) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	close(t.wake)
+	time.AfterFunc(pollSessionTTL, func() { dropPollSession(t.id) })
+}
+
+func (t *pollTransport) binary() bool { return false }
+
+// bind - auth_key powiązany z IP wiąże z nim całą sesję.
+func (t *pollTransport) bind(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.boundIP == "" {
+		t.boundIP = ip
+	}
+}
+
+func (t *pollTransport) allowedFrom(ip string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.boundIP == "" || sameIP(t.boundIP, ip)
+}
+
+// poll zwraca zdarzenia po cursor (czeka do timeout, gdy brak); drained = sesja zamknięta i pusta.
+func (t *pollTransport) poll(ctx context.Context, cursor uint64, limit int, timeout time.Duration) (items []pollItem, drained bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		t.mu.Lock()
+		t.lastPoll = time.Now()
+		// wszystko do cursor klient już przetworzył
+		n := 0
+		for n < len(t.items) && t.items[n].pos <= cursor {
+			n++
+		}
+		t.items = t.items[n:]
+		if len(t.items) > 0 || t.closed {
+			items = append(items, t.items[:min(limit, len(t.items))]...)
+			drained = t.closed && len(t.items) == 0
+			t.mu.Unlock()
+			return items, drained
+		}
+		wake := t.wake
+		t.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-timer.C:
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+func dropPollSession(id string) {
+	mu.Lock()
+	delete(pollSessions, id)
+	mu.Unlock()
+}
+
+// HandlePoll - long-poll; nowa sesja z auth_key albo kolejna partia istniejącej sesji.
+func HandlePoll(w http.ResponseWriter, r *http.Request, _ *http.Client) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !allowOrigin(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	req := attachRequest{AuthKey: q.Get("auth_key"), LastSeq: q.Get("last_seq"), Encoding: q.Get("encoding")}
+
+	session := q.Get("session")
+	var c *client
+	if session == "" {
+		if req.AuthKey == "" {
+			http.Error(w, "missing auth_key or session", http.StatusBadRequest)
+			return
+		}
+		pt := newPollTransport()
+		mu.Lock()
+		if pend := pendingAuthKeys[req.AuthKey]; pend != nil {
+			pt.identity = pend.Identity
+			if pend.ClientIP != "" {
+				pt.bind(remoteIP)
+			}
+		}
+		mu.Unlock()
+		var done chan struct{}
+		c, done = registerClient(pt)
+		if code := attach(c, remoteIP, req); code != "" {
+			cleanupClient(c)
+			http.Error(w, code, attachStatus(code))
+			return
+		}
+		mu.Lock()
+		pollSessions[pt.id] = c
+		mu.Unlock()
+		go keepAlive(c, pollSessionTTL/4, done)
+		// nowa sesja odpowiada od razu, gdy writer przekaże "subscribed"
+		writePollBatch(w, r, c, 0, pollDefaultLimit, writeTimeout)
+		return
+	}
+
+	mu.Lock()
+	c = pollSessions[session]
+	mu.Unlock()
+	if c == nil {
+		http.Error(w, "unknown_session", http.StatusNotFound)
+		return
+	}
+	pt := c.t.(*pollTransport)
+	if !pt.allowedFrom(remoteIP) {
+		http.Error(w, "session_ip_mismatch", http.StatusForbidden)
+		return
+	}
+	if req.AuthKey != "" {
+		// kolejny auth_key dołączany do istniejącej sesji
+		mu.Lock()
+		bound := pendingAuthKeys[req.AuthKey] != nil && pendingAuthKeys[req.AuthKey].ClientIP != ""
+		mu.Unlock()
+		if code := attach(c, remoteIP, req); code != "" {
+			http.Error(w, code, attachStatus(code))
+			return
+		}
+		if bound {
+			pt.bind(remoteIP)
+		}
+	}
+
+	cursor, err := strconv.ParseUint(q.Get("cursor"), 10, 64)
+	if err != nil && q.Get("cursor") != "" {
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+	limit := pollDefaultLimit
+	if raw := q.Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, pollMaxLimit)
+	}
+	timeout := pollDefaultTimeout
+	if raw := q.Get("timeout"); raw != "" {
+		secs, err := strconv.Atoi(raw)
+		if err != nil || secs < 0 {
+			http.Error(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+		timeout = min(time.Duration(secs)*time.Second, pollMaxTimeout)
+	}
+	writePollBatch(w, r, c, cursor, limit, timeout)
+}
+
+func writePollBatch(w http.ResponseWriter, r *http.Request, c *client, cursor uint64, limit int, timeout time.Duration) {
+	pt := c.t.(*pollTransport)
+	// oczekiwanie może być dłuższe niż WriteTimeout serwera
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + writeTimeout))
+
+	items, drained := pt.poll(r.Context(), cursor, limit, timeout)
+	if drained {
+		dropPollSession(pt.id)
+		http.Error(w, "session_closed", http.StatusGone)
+		return
+	}
+	events := make([]json.RawMessage, 0, len(items))
+	for _, it := range items {
+		events = append(events, it.data)
+		cursor = it.pos
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"session": pt.id,
+		"cursor":  strconv.FormatUint(cursor, 10),
+		"events":  events,
+	})
+}
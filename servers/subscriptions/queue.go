@@ -0,0 +1,272 @@
+package subscriptions
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+/*
+Kolejka wychodząca klienta.
+
+Każdy klient ma ograniczoną kolejkę ramek i jedną goroutine writera, więc publish nie czeka
+na wolnego subskrybenta. Zdarzenia na żywo (publish) przy pełnej kolejce podlegają
+subscriptions.queue.policy:
+
+	disconnect  - (domyślnie) klient dostaje "error" "slow_consumer" i jest rozłączany
+	drop_oldest - usuwane jest najstarsze zdarzenie z seq, klient dostaje "events_dropped"
+	coalesce    - starsze "updated"/"deleted" tego samego (table, key) jest zastępowane nowym;
+	              gdy takiego nie ma - jak drop_oldest
+
+Zdarzenia kontrolne (seq 0, poza wiadomościami kanałów) nie są usuwane. Ramki spoza publish (kontrolne, replay, resume)
+czekają na miejsce maks. writeTimeout i zajmują najwyżej połowę kolejki - reszta zostaje
+dla zdarzeń na żywo. Przy zamknięciu klienta writer wysyła jeszcze zaległe zdarzenia
+kontrolne (np. "revoked") i dopiero wtedy zamyka transport.
+*/
+
+const (
+	QueuePolicyDisconnect = "disconnect"
+	QueuePolicyDropOldest = "drop_oldest"
+	QueuePolicyCoalesce   = "coalesce"
+
+	defaultQueueSize = 1024
+)
+
+var (
+	errSlowConsumer = errors.New("slow consumer")
+
+	droppedMessages   atomic.Uint64
+	coalescedMessages atomic.Uint64
+	slowDisconnects   atomic.Uint64
+)
+
+type outQueue struct {
+	mu      sync.Mutex
+	msgs    []outMsg
+	dropped int // usunięte od ostatniego "events_dropped"
+	closed  bool
+	wake    chan struct{} // (cap 1) nowa ramka albo zamknięcie
+	space   chan struct{} // zamykany (i wymieniany) po zdjęciu ramki
+	done    chan struct{} // writer zakończony, transport zamknięty
+}
+
+func newOutQueue() outQueue {
+	return outQueue{
+		wake:  make(chan struct{}, 1),
+		space: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+func queueConfig() (int, string) {
+	cfg := config.Get().Subscriptions.Queue
+	size := cfg.Size
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	switch cfg.Policy {
+	case QueuePolicyDropOldest, QueuePolicyCoalesce:
+		return size, cfg.Policy
+	}
+	return size, QueuePolicyDisconnect
+}
+
+func (q *outQueue) signalLocked() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dropOldestLocked usuwa najstarszą ramkę na żywo; false = w kolejce są same kontrolne.
+func (q *outQueue) dropOldestLocked() bool {
+	for i, m := range q.msgs {
+		if m.droppable() {
+			q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+			q.dropped++
+			return true
+		}
+	}
+	return false
+}
+
+// coalesceLocked usuwa starszą ramkę tego samego klucza co m (nowa idzie na koniec, kolejność seq zostaje).
+func (q *outQueue) coalesceLocked(m outMsg) bool {
+	if m.coalesceKey == "" {
+		return false
+	}
+	for i, old := range q.msgs {
+		if old.coalesceKey == m.coalesceKey {
+			q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// push - zdarzenie z publish; przy pełnej kolejce stosuje politykę. false = wolny klient do rozłączenia.
+func (c *client) push(m outMsg) bool {
+	size, policy := queueConfig()
+	if _, ok := c.t.(*webhook); ok && policy == QueuePolicyDisconnect {
+		// webhook nie jest rozłączany (rejestracja jest trwała, webhook.go)
+		policy = QueuePolicyDropOldest
+	}
+	q := &c.q
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return true // klient jest już sprzątany
+	}
+	if len(q.msgs) >= size {
+		switch {
+		case policy == QueuePolicyCoalesce && q.coalesceLocked(m):
+			coalescedMessages.Add(1)
+		case policy != QueuePolicyDisconnect && q.dropOldestLocked():
+			droppedMessages.Add(1)
+		default:
+			return false
+		}
+	}
+	q.msgs = append(q.msgs, m)
+	q.signalLocked()
+	return true
+}
+
+// enqueue - ramka spoza publish; czeka na miejsce w pierwszej połowie kolejki maks. writeTimeout.
+func (c *client) enqueue(m outMsg) error {
+	size, _ := queueConfig()
+	limit := max(size/2, 1)
+	q := &c.q
+	var timer *time.Timer
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return errClientClosed
+		}
+		if len(q.msgs) < limit {
+			q.msgs = append(q.msgs, m)
+			q.signalLocked()
+			q.mu.Unlock()
+			return nil
+		}
+		space := q.space
+		q.mu.Unlock()
+
+		if timer == nil {
+			timer = time.NewTimer(writeTimeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-space:
+		case <-timer.C:
+			return errSlowConsumer
+		}
+	}
+}
+
+// stop zamyka kolejkę; writer wyśle zaległe zdarzenia kontrolne i zamknie transport.
+func (c *client) stop() {
+	q := &c.q
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.space)
+	q.signalLocked()
+}
+
+// slowConsumer - pełna kolejka przy polityce disconnect: zaległe ramki przepadają,
+// klient dostaje "slow_consumer" i jest rozłączany.
+func slowConsumer(c *client) {
+	q := &c.q
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	droppedMessages.Add(uint64(len(q.msgs)))
+	q.msgs = nil
+	q.dropped = 0
+	if data, err := json.Marshal(map[string]string{"event": "error", "message": "slow_consumer"}); err == nil {
+		q.msgs = append(q.msgs, outMsg{msgType: websocket.TextMessage, data: data})
+	}
+	q.mu.Unlock()
+
+	slowDisconnects.Add(1)
+	log.Println("subscriber queue full -> disconnect")
+	cleanupClient(c)
+}
+
+// writeLoop - jedyny writer ramek klienta (ping idzie osobno, transporty go na to pozwalają).
+func (c *client) writeLoop() {
+	q := &c.q
+	defer close(q.done)
+	defer c.t.close()
+	for {
+		q.mu.Lock()
+		for len(q.msgs) == 0 && q.dropped == 0 && !q.closed {
+			q.mu.Unlock()
+			<-q.wake
+			q.mu.Lock()
+		}
+		if q.closed {
+			final := make([]outMsg, 0, len(q.msgs))
+			for _, m := range q.msgs {
+				if !m.droppable() {
+					final = append(final, m)
+				}
+			}
+			q.msgs = nil
+			q.mu.Unlock()
+			for _, m := range final {
+				if c.t.send(m) != nil {
+					break
+				}
+			}
+			return
+		}
+
+		var m outMsg
+		if q.dropped > 0 {
+			// luka w seq - klient może wznowić po last_seq przy następnym połączeniu
+			data, _ := json.Marshal(map[string]any{"event": "events_dropped", "count": q.dropped})
+			m = outMsg{msgType: websocket.TextMessage, data: data}
+			q.dropped = 0
+		} else {
+			m = q.msgs[0]
+			q.msgs[0] = outMsg{}
+			q.msgs = q.msgs[1:]
+			close(q.space)
+			q.space = make(chan struct{})
+		}
+		q.mu.Unlock()
+
+		if err := c.t.send(m); err != nil {
+			log.Println("subscriber write failed -> cleanup:", err)
+			cleanupClient(c)
+			return
+		}
+	}
+}
+
+// queueStatsLocked - suma i maks. długość kolejek klientów (pod mu).
+func queueStatsLocked() (total, deepest int) {
+	for c := range connDone {
+		c.q.mu.Lock()
+		n := len(c.q.msgs)
+		c.q.mu.Unlock()
+		total += n
+		deepest = max(deepest, n)
+	}
+	return total, deepest
+}
@@ -0,0 +1,211 @@
+package subscriptions
+
+import (
+	"log"
+	"strconv"
+	"sync"
+)
+
+/*
+Replay inc table przy subskrypcji.
+
+Enable z "last_seen": {"<key>": <id>} (-1 = od początku tabeli) powoduje, że po użyciu auth_key
+klient dostaje najpierw wszystkie wpisy o id > last_seen z pliku (paczkami "inc_table_replay"),
+potem "inc_table_replay_done" z ostatnim wysłanym id, a dopiero potem zdarzenia na żywo.
+
+Bez luki i duplikatów: połączenie jest dopisywane do activeSubs zanim zacznie się odczyt pliku,
+a zdarzenia na żywo dla (table, key) są w tym czasie buforowane (także te, które pasują przez wzorzec). Po replay bufor jest wysyłany,
+z pominięciem "add" o id <= ostatniego wpisu z replay (te wpisy klient już dostał z pliku).
+Powiadomienia idą z zapisów asynchronicznie, więc spóźnione "add" może przyjść już po replay -
+dlatego ostatnie id z replay zostaje (replayedTo) i takie "add" są pomijane do końca subskrypcji.
+Id to pozycje w tabeli, więc replay zakłada tabelę, do której głównie się dopisuje
+(insert w środek / compact przesuwa id).
+*/
+
+const (
+	replayPageSize = 256
+	// maks. ilość zdarzeń buforowanych podczas replay; przepełnienie zamyka połączenie
+	replayBufferMax = 10000
+)
+
+// IncReplayPage - strona wpisów inc table (id liczone od najstarszego + zdekodowane dane).
+type IncReplayPage struct {
+	IDs     []uint64
+	Data    [][]byte
+	Next    uint64
+	HasMore bool
+}
+
+// IncReplaySource czyta maks. amount wpisów inc table od id start.
+type IncReplaySource func(table, key string, start, amount uint64) (IncReplayPage, error)
+
+type bufferedEvent struct {
+	seq        uint64
+	table      string
+	key        string
+	changeType string // "" = zdarzenie spoza inc table (updated, deleted)
+	id         uint64
+	payload    map[string]any // pola zdarzenia bez wartości (encoding.go)
+	data       []byte         // wartość (updated, inc_table_update)
+	origin     string         // node id zdarzenia od peera ("" = zapis na tym node, cluster.go)
+	channel    string         // wiadomość kanału (channels.go), bez seq i table
+	encrypted  bool           // zapis szyfrowany: wartość tylko dla połączeń na żywo (NotifyEncryptedSubscribers)
+}
+
+// storedData - wartość zdarzenia poza połączeniami na żywo (log zdarzeń, peery, webhooki);
+// dla zapisu szyfrowanego nil, żeby plaintext nie opuszczał pamięci.
+func (ev bufferedEvent) storedData() []byte {
+	if ev.encrypted {
+		return nil
+	}
+	return ev.data
+}
+
+type replayState struct {
+	events   []bufferedEvent
+	overflow bool
+}
+
+var (
+	incReplaySource IncReplaySource
+	replayMu        sync.RWMutex
+
+	// conn -> (table, key) -> zdarzenia buforowane w trakcie replay (chronione przez mu)
+	replaying = make(map[*client]map[subTarget]*replayState)
+	// conn -> (table, key) -> ostatnie id wysłane w zakończonym replay (chronione przez mu)
+	replayedTo = make(map[*client]map[subTarget]int64)
+)
+
+// SetIncReplaySource ustawia źródło odczytu inc table (Public API, bez importu routes tutaj).
+func SetIncReplaySource(src IncReplaySource) {
+	replayMu.Lock()
+	incReplaySource = src
+	replayMu.Unlock()
+}
+
+func getIncReplaySource() IncReplaySource {
+	replayMu.RLock()
+	defer replayMu.RUnlock()
+	return incReplaySource
+}
+
+// startReplayLocked oznacza klucz jako odtwarzany; wywoływane pod mu, przed dodaniem conn do activeSubs.
+func startReplayLocked(conn *client, t subTarget) {
+	if _, ok := replaying[conn]; !ok {
+		replaying[conn] = make(map[subTarget]*replayState)
+	}
+	replaying[conn][t] = &replayState{}
+}
+
+// bufferIfReplayingLocked - true = zdarzenie trafiło do bufora replay (pod mu).
+func bufferIfReplayingLocked(conn *client, t subTarget, ev bufferedEvent) bool {
+	st := replaying[conn][t]
+	if st == nil {
+		return false
+	}
+	if len(st.events) >= replayBufferMax {
+		st.overflow = true
+		return true
+	}
+	st.events = append(st.events, ev)
+	return true
+}
+
+// replayedLocked - "add" o id <= ostatniego wpisu z replay klient już dostał z pliku (pod mu).
+func replayedLocked(conn *client, t subTarget, ev bufferedEvent) bool {
+	last, ok := replayedTo[conn][t]
+	return ok && ev.changeType == "add" && int64(ev.id) <= last
+}
+
+// replayInc wysyła wpisy o id > lastSeen, a potem przełącza klucz na zdarzenia na żywo.
+func replayInc(conn *client, table, key string, lastSeen int64) {
+	last := lastSeen
+	src := getIncReplaySource()
+	if src == nil {
+		_ = writeJSON(conn, map[string]string{"event": "error", "table": table, "key": key, "message": "replay_unavailable"})
+	} else {
+		start := uint64(lastSeen + 1)
+		for {
+			page, err := src(table, key, start, replayPageSize)
+			if err != nil {
+				_ = writeJSON(conn, map[string]string{"event": "error", "table": table, "key": key, "message": "replay_failed: " + err.Error()})
+				break
+			}
+			if len(page.IDs) > 0 {
+				mu.Lock()
+				enc := connEncodingLocked(conn)
+				mu.Unlock()
+				msgType, frame, err := renderReplayPage(table, key, page, enc)
+				if err == nil {
+					err = writeMessage(conn, outMsg{msgType: msgType, data: frame})
+				}
+				if err != nil {
+					log.Println("replay write failed -> cleanup:", err)
+					cleanupClient(conn)
+					return
+				}
+				last = int64(page.IDs[len(page.IDs)-1])
+			}
+			if !page.HasMore {
+				break
+			}
+			start = page.Next
+		}
+	}
+
+	if err := writeJSON(conn, map[string]any{
+		"event":   "inc_table_replay_done",
+		"table":   table,
+		"key":     key,
+		"last_id": strconv.FormatInt(last, 10),
+	}); err != nil {
+		cleanupClient(conn)
+		return
+	}
+	finishReplay(conn, keyTarget(table, key), last)
+}
+
+// finishReplay opróżnia bufor; klucz przechodzi na wysyłkę bezpośrednią dopiero, gdy bufor
+// jest pusty (pod mu), więc kolejność zdarzeń na żywo jest zachowana.
+func finishReplay(conn *client, t subTarget, last int64) {
+	for {
+		mu.Lock()
+		st := replaying[conn][t]
+		if st == nil {
+			mu.Unlock()
+			return
+		}
+		if st.overflow {
+			mu.Unlock()
+			_ = writeJSON(conn, map[string]string{"event": "error", "table": t.table, "key": t.expr, "message": "replay_buffer_overflow"})
+			cleanupClient(conn)
+			return
+		}
+		if len(st.events) == 0 {
+			delete(replaying[conn], t)
+			if len(replaying[conn]) == 0 {
+				delete(replaying, conn)
+			}
+			if replayedTo[conn] == nil {
+				replayedTo[conn] = make(map[subTarget]int64)
+			}
+			replayedTo[conn][t] = last
+			mu.Unlock()
+			return
+		}
+		events := st.events
+		st.events = nil
+		mu.Unlock()
+
+		for _, ev := range events {
+			if ev.changeType == "add" && int64(ev.id) <= last {
+				continue // już wysłane w replay
+			}
+			if err := writeEvent(conn, ev); err != nil {
+				log.Println("notify write failed -> cleanup:", err)
+				cleanupClient(conn)
+				return
+			}
+		}
+	}
+}
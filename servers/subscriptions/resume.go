@@ -0,0 +1,171 @@
+package subscriptions
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+/*
+Wznawianie po last_seq (log zdarzeń, patrz eventlog.go).
+
+Tak jak w replay inc table: cele z auth_key są dopisywane do activeSubs zanim zacznie się
+odczyt logu, a zdarzenia na żywo pasujące do tych celów są w tym czasie buforowane. Po odczycie
+bufor jest wysyłany z pominięciem seq <= ostatniego zdarzenia z logu. Zdarzenia dla celów,
+które połączenie miało już wcześniej, idą na żywo bez zmian (nie są wysyłane drugi raz z logu).
+*/
+
+type resumeState struct {
+	replayState
+	targets map[subTarget]*regexp.Regexp // nowe cele z auth_key
+	skip    map[subTarget]*regexp.Regexp // cele, które połączenie miało przed wznowieniem
+}
+
+// conn -> stan wznawiania (chronione przez mu)
+var resuming = make(map[*client]*resumeState)
+
+// covers - zdarzenie (table, key) należy do wznawianych celów.
+func (st *resumeState) covers(table, key string) bool {
+	for t, re := range st.skip {
+		if t.matches(re, table, key) {
+			return false
+		}
+	}
+	for t, re := range st.targets {
+		if t.matches(re, table, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// startResumeLocked zapamiętuje cele połączenia sprzed auth_key; wywoływane pod mu przed addTargetLocked.
+func startResumeLocked(conn *client) *resumeState {
+	st := &resumeState{
+		targets: make(map[subTarget]*regexp.Regexp),
+		skip:    make(map[subTarget]*regexp.Regexp),
+	}
+	for t := range connToTargets[conn] {
+		st.skip[t] = patternTargets[t]
+	}
+	resuming[conn] = st
+	return st
+}
+
+// addResumeTargetLocked - cel dodany przez wznawiany auth_key (pod mu, po addTargetLocked).
+func (st *resumeState) addResumeTargetLocked(t subTarget) {
+	st.targets[t] = patternTargets[t]
+}
+
+// bufferIfResumingLocked - true = zdarzenie trafiło do bufora wznawiania (pod mu).
+func bufferIfResumingLocked(conn *client, ev bufferedEvent) bool {
+	st := resuming[conn]
+	if st == nil || !st.covers(ev.table, ev.key) {
+		return false
+	}
+	if len(st.events) >= replayBufferMax {
+		st.overflow = true
+		return true
+	}
+	st.events = append(st.events, ev)
+	return true
+}
+
+// resumeEvents wysyła zdarzenia z logu o seq > after, potem "resume_done" i bufor zdarzeń na żywo.
+func resumeEvents(conn *client, after uint64) {
+	mu.Lock()
+	st := resuming[conn]
+	mu.Unlock()
+	if st == nil {
+		return
+	}
+
+	last := after
+	errWrite := errors.New("write failed")
+	err := readEventLog(after, func(rec logRecord) error {
+		if !st.covers(rec.Table, rec.Key) {
+			return nil
+		}
+		ev, err := rec.event()
+		if err != nil {
+			return nil // uszkodzony wpis
+		}
+		// filtry jak na żywo; bez poprzedniej wartości changed() przepuszcza
+		mu.Lock()
+		allowed := newFilterEval(ev, false).allowsLocked(conn)
+		mu.Unlock()
+		if !allowed {
+			return nil
+		}
+		if err := writeEvent(conn, ev); err != nil {
+			log.Println("resume write failed -> cleanup:", err)
+			return errWrite
+		}
+		last = rec.Seq
+		return nil
+	})
+	switch {
+	case errors.Is(err, errWrite):
+		cleanupClient(conn)
+		return
+	case errors.Is(err, ErrOffsetTrimmed):
+		logMu.Lock()
+		oldest := oldestSeqLocked()
+		logMu.Unlock()
+		_ = writeJSON(conn, map[string]string{
+			"event":      "error",
+			"message":    ErrOffsetTrimmed.Error(),
+			"oldest_seq": strconv.FormatUint(oldest, 10),
+		})
+	case err != nil:
+		_ = writeJSON(conn, map[string]string{"event": "error", "message": err.Error()})
+	}
+
+	if err := writeJSON(conn, map[string]string{
+		"event":    "resume_done",
+		"last_seq": strconv.FormatUint(last, 10),
+	}); err != nil {
+		cleanupClient(conn)
+		return
+	}
+	finishResume(conn, last)
+}
+
+// finishResume opróżnia bufor wznawiania; połączenie przechodzi na wysyłkę bezpośrednią
+// dopiero, gdy bufor jest pusty (pod mu), więc kolejność zdarzeń jest zachowana.
+func finishResume(conn *client, last uint64) {
+	for {
+		mu.Lock()
+		st := resuming[conn]
+		if st == nil {
+			mu.Unlock()
+			return
+		}
+		if st.overflow {
+			mu.Unlock()
+			_ = writeJSON(conn, map[string]string{"event": "error", "message": "resume_buffer_overflow"})
+			cleanupClient(conn)
+			return
+		}
+		if len(st.events) == 0 {
+			delete(resuming, conn)
+			mu.Unlock()
+			return
+		}
+		events := st.events
+		st.events = nil
+		mu.Unlock()
+
+		for _, ev := range events {
+			if ev.seq <= last {
+				continue // już wysłane z logu
+			}
+			if err := writeEvent(conn, ev); err != nil {
+				log.Println("notify write failed -> cleanup:", err)
+				cleanupClient(conn)
+				return
+			}
+		}
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+	config "github.com/PAW122/TsunamiDB/servers/config"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
@@ -19,7 +23,15 @@ import (
 
 type Pending struct {
 	Keys      []string
+	Prefixes  []string // prefiksy kluczy w Table ("" = cała tabela), patrz targets.go
+	Patterns  []string // regexy kluczy w Table
+	Channels  []string // kanały pub/sub (channels.go), niezależne od Table
+	Filter    string   // filtr zdarzeń dla wszystkich celów (filter.go), "" = bez filtra
 	ExpiresAt time.Time
+	Identity  string // kto wygenerował auth_key (auth.Identity.Name)
+	ClientIP  string // opcjonalnie: auth_key działa tylko z tego IP
+	Table     string
+	LastSeen  map[string]int64 // key -> ostatnie id widziane przez klienta (replay inc table, patrz replay.go)
 }
 
 var (
@@ -27,25 +39,60 @@ var (
 	ErrNoKeyArg = errors.New("disable subscription: empty key")
 )
 
+const pendingTTL = 60 * time.Second
+
 type Stats struct {
-	ActiveClients       int `json:"active_clients"`
-	KeysWithSubscribers int `json:"keys_with_subscribers"`
-	ActiveSubscriptions int `json:"active_subscriptions"`
-	PendingAuthKeys     int `json:"pending_auth_keys"`
+	ActiveClients           int    `json:"active_clients"`
+	KeysWithSubscribers     int    `json:"keys_with_subscribers"`
+	PatternsWithSubscribers int    `json:"patterns_with_subscribers"`
+	ChannelsWithSubscribers int    `json:"channels_with_subscribers"`
+	ActiveSubscriptions     int    `json:"active_subscriptions"`
+	PendingAuthKeys         int    `json:"pending_auth_keys"`
+	LastSeq                 uint64 `json:"last_seq"`
+	// kolejki wychodzące (queue.go)
+	QueuedMessages          int    `json:"queued_messages"`
+	MaxQueueDepth           int    `json:"max_queue_depth"`
+	DroppedMessages         uint64 `json:"dropped_messages"`
+	CoalescedMessages       uint64 `json:"coalesced_messages"`
+	SlowConsumerDisconnects uint64 `json:"slow_consumer_disconnects"`
+	// zdarzenia niewysłane do peerów (przepełniona kolejka, cluster.go)
+	ClusterForwardDropped uint64 `json:"cluster_forward_dropped"`
+	// wiadomości kanałów opublikowane na tym node albo od peerów (channels.go)
+	ChannelMessages uint64 `json:"channel_messages"`
+	// webhooki (webhook.go)
+	Webhooks           int    `json:"webhooks"`
+	WebhookDeadLetters uint64 `json:"webhook_dead_letters"`
 }
 
 func StatsSnapshot() Stats {
+	seq := currentSeq()
 	mu.Lock()
 	defer mu.Unlock()
 
 	stats := Stats{
-		ActiveClients:       len(connToKeys),
-		KeysWithSubscribers: len(activeSubs),
-		PendingAuthKeys:     len(pendingAuthKeys),
+		ActiveClients:           len(connToTargets),
+		KeysWithSubscribers:     len(activeSubs) - len(patternTargets),
+		PatternsWithSubscribers: len(patternTargets),
+		PendingAuthKeys:         len(pendingAuthKeys),
+		LastSeq:                 seq,
+		DroppedMessages:         droppedMessages.Load(),
+		CoalescedMessages:       coalescedMessages.Load(),
+		SlowConsumerDisconnects: slowDisconnects.Load(),
+		ClusterForwardDropped:   clusterDropped.Load(),
+		ChannelMessages:         channelMessages.Load(),
+		Webhooks:                len(webhooks),
+		WebhookDeadLetters:      webhookDeadLetters.Load(),
+	}
+	stats.QueuedMessages, stats.MaxQueueDepth = queueStatsLocked()
+	for t := range activeSubs {
+		if t.kind == targetChannel {
+			stats.ChannelsWithSubscribers++
+		}
 	}
+	stats.KeysWithSubscribers -= stats.ChannelsWithSubscribers
 
 	totalSubs := 0
-	for _, keys := range connToKeys {
+	for _, keys := range connToTargets {
 		totalSubs += len(keys)
 	}
 	stats.ActiveSubscriptions = totalSubs
@@ -54,73 +101,51 @@ func StatsSnapshot() Stats {
 }
 
 var (
-	// key -> set(conn)
-
-	activeSubs = make(map[string]map[*websocket.Conn]struct{})
-	// conn -> set(key)
-	connToKeys = make(map[*websocket.Conn]map[string]struct{})
+	// (table, key | prefiks | regex) -> set(conn)
+	activeSubs = make(map[subTarget]map[*client]struct{})
+	// conn -> set(cel)
+	connToTargets = make(map[*client]map[subTarget]struct{})
 	// auth_key -> pending keys (TTL)
 	pendingAuthKeys = make(map[string]*Pending)
 
-	// per-connection write lock (serializacja zapisów do jednego conn)
-	connLocks = make(map[*websocket.Conn]*sync.Mutex)
 	// kanał stop dla ping goroutine
-	connDone = make(map[*websocket.Conn]chan struct{})
+	connDone = make(map[*client]chan struct{})
+	// conn -> identity, których auth_key zostały użyte na tym połączeniu
+	connIdentities = make(map[*client]map[string]struct{})
 
 	mu sync.Mutex
+	// kolejność publikacji zdarzeń = kolejność seq (patrz publish)
+	publishMu sync.Mutex
 
 	upgrader = websocket.Upgrader{
-		// W PROD rozważ restrykcję origin
-		CheckOrigin: func(r *http.Request) bool { return true },
+		CheckOrigin: checkOrigin,
 	}
 )
 
-// ---------------------------
-// Narzędzia do zapisu z per-conn lockiem
-// ---------------------------
-
-func getConnLock(c *websocket.Conn) *sync.Mutex {
-	mu.Lock()
-	defer mu.Unlock()
-	return connLocks[c]
-}
-
-func writeJSON(c *websocket.Conn, v any) error {
-	lock := getConnLock(c)
-	if lock != nil {
-		lock.Lock()
-		defer lock.Unlock()
+// checkOrigin - subscriptions.allowed_origins z configu; pusta lista albo "*" = wszystkie.
+// Klienci spoza przeglądarki (brak nagłówka Origin) są przepuszczani.
+func checkOrigin(r *http.Request) bool {
+	allowed := config.Get().Subscriptions.AllowedOrigins
+	if len(allowed) == 0 {
+		return true
 	}
-	_ = c.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	return c.WriteJSON(v)
-}
-
-func writeMessage(c *websocket.Conn, msgType int, payload []byte) error {
-	lock := getConnLock(c)
-	if lock != nil {
-		lock.Lock()
-		defer lock.Unlock()
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
 	}
-	_ = c.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	return c.WriteMessage(msgType, payload)
-}
-
-func writePing(c *websocket.Conn) error {
-	lock := getConnLock(c)
-	if lock != nil {
-		lock.Lock()
-		defer lock.Unlock()
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return true
+		}
 	}
-	deadline := time.Now().Add(10 * time.Second)
-	// Ping najlepiej przez WriteControl (krótkie ramki, deadline)
-	return c.WriteControl(websocket.PingMessage, nil, deadline)
+	return false
 }
 
 // ---------------------------
 // Sprzątanie połączenia
 // ---------------------------
 
-func cleanupConn(conn *websocket.Conn) {
+func cleanupClient(conn *client) {
 	// Zatrzymaj ping goroutine (jeśli jest)
 	mu.Lock()
 	if done, ok := connDone[conn]; ok {
@@ -134,24 +159,20 @@ func cleanupConn(conn *websocket.Conn) {
 	}
 
 	// Usuń conn z odwrotnej mapy i z activeSubs
-	if keys, ok := connToKeys[conn]; ok {
-		for k := range keys {
-			if set, ok := activeSubs[k]; ok {
-				delete(set, conn)
-				if len(set) == 0 {
-					delete(activeSubs, k)
-				}
-			}
-		}
-		delete(connToKeys, conn)
+	for t := range connToTargets[conn] {
+		removeTargetLocked(conn, t)
 	}
 
-	// Usuń per-conn lock
-	delete(connLocks, conn)
+	// Usuń bufory replay
+	delete(replaying, conn)
+	delete(replayedTo, conn)
+	delete(resuming, conn)
+	delete(connEncodings, conn)
+	delete(connIdentities, conn)
 	mu.Unlock()
 
-	// Zamknij socket (może już być zamknięty)
-	_ = conn.Close()
+	// Writer wyśle zaległe zdarzenia kontrolne i zamknie socket / strumień
+	conn.stop()
 }
 
 // ---------------------------
@@ -160,52 +181,132 @@ func cleanupConn(conn *websocket.Conn) {
 
 func HandleEnableSubscription(w http.ResponseWriter, r *http.Request, _ *http.Client) {
 	var req struct {
-		Keys []string `json:"keys"`
+		Keys     []string         `json:"keys"`
+		Prefixes []string         `json:"prefixes"`
+		Patterns []string         `json:"patterns"`
+		Channels []string         `json:"channels"`
+		Table    string           `json:"table"`
+		ClientIP string           `json:"client_ip"`
+		LastSeen map[string]int64 `json:"last_seen"`
+		Filter   string           `json:"filter"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Keys) == 0 {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Keys)+len(req.Prefixes)+len(req.Patterns)+len(req.Channels) == 0 {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
 
-	authKey := uuid.NewString()
-
-	mu.Lock()
-	pendingAuthKeys[authKey] = &Pending{
-		Keys:      req.Keys,
-		ExpiresAt: time.Now().Add(60 * time.Second),
+	id := auth.FromRequest(r)
+	if id == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
 	}
-	mu.Unlock()
-
-	// TTL czyszczenie
-	go func(k string) {
-		time.Sleep(60 * time.Second)
-		mu.Lock()
-		if p, ok := pendingAuthKeys[k]; ok && time.Now().After(p.ExpiresAt) {
-			delete(pendingAuthKeys, k)
+	// bez "table" wymagany jest odczyt wszystkich tabel ("*"); same kanały nie dotyczą tabel
+	if len(req.Keys)+len(req.Prefixes)+len(req.Patterns) > 0 && !id.CanRead(req.Table) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := validateChannels(req.Channels); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, ch := range req.Channels {
+		if !id.CanRead(channelTable(ch)) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
 		}
-		mu.Unlock()
-	}(authKey)
+	}
+	if req.ClientIP != "" && net.ParseIP(req.ClientIP) == nil {
+		http.Error(w, "invalid client_ip", http.StatusBadRequest)
+		return
+	}
+	if err := validatePatterns(req.Patterns); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateLastSeen(req.Keys, req.Table, req.LastSeen); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := parseFilter(req.Filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	authKey := registerPending(&Pending{
+		Keys:     append([]string(nil), req.Keys...),
+		Prefixes: append([]string(nil), req.Prefixes...),
+		Patterns: append([]string(nil), req.Patterns...),
+		Channels: append([]string(nil), req.Channels...),
+		Identity: id.Name,
+		ClientIP: req.ClientIP,
+		Table:    req.Table,
+		LastSeen: req.LastSeen,
+		Filter:   req.Filter,
+	})
 
 	_ = json.NewEncoder(w).Encode(map[string]string{"auth_key": authKey})
 }
 
 func EnableSubscriptionInternal(keys []string) (string, error) {
-	if len(keys) == 0 {
+	return EnableTableSubscriptionInternal("", keys, nil, nil)
+}
+
+// EnableTableSubscriptionInternal - auth_key dla kluczy, prefiksów i regexów w tabeli ("" = dowolna).
+func EnableTableSubscriptionInternal(table string, keys, prefixes, patterns []string) (string, error) {
+	if len(keys)+len(prefixes)+len(patterns) == 0 {
 		return "", ErrNoKeys
 	}
+	if err := validatePatterns(patterns); err != nil {
+		return "", err
+	}
+
+	return registerPending(&Pending{
+		Keys:     append([]string(nil), keys...),
+		Prefixes: append([]string(nil), prefixes...),
+		Patterns: append([]string(nil), patterns...),
+		Identity: auth.LocalIdentity,
+		Table:    table,
+	}), nil
+}
 
+// validateLastSeen - replay wymaga tabeli, a klucze w last_seen muszą być subskrybowane.
+func validateLastSeen(keys []string, table string, lastSeen map[string]int64) error {
+	if len(lastSeen) == 0 {
+		return nil
+	}
+	if table == "" {
+		return errors.New("last_seen requires table")
+	}
+	for key, id := range lastSeen {
+		if id < -1 {
+			return errors.New("invalid last_seen id for key " + key)
+		}
+		found := false
+		for _, k := range keys {
+			if k == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("last_seen key not in keys: " + key)
+		}
+	}
+	return nil
+}
+
+// registerPending zapisuje auth_key z TTL i zwraca jego wartość.
+func registerPending(p *Pending) string {
 	authKey := uuid.NewString()
+	p.ExpiresAt = time.Now().Add(pendingTTL)
 
 	mu.Lock()
-	pendingAuthKeys[authKey] = &Pending{
-		Keys:      append([]string(nil), keys...),
-		ExpiresAt: time.Now().Add(60 * time.Second),
-	}
+	pendingAuthKeys[authKey] = p
 	mu.Unlock()
 
 	// TTL czyszczenie
 	go func(k string) {
-		timer := time.NewTimer(60 * time.Second)
+		timer := time.NewTimer(pendingTTL)
 		defer timer.Stop()
 		<-timer.C
 		mu.Lock()
@@ -215,88 +316,157 @@ func EnableSubscriptionInternal(keys []string) (string, error) {
 		mu.Unlock()
 	}(authKey)
 
-	return authKey, nil
+	return authKey
 }
 
 func HandleDisableSubscription(w http.ResponseWriter, r *http.Request, _ *http.Client) {
 	var req struct {
-		Key string `json:"key"`
+		Key     string  `json:"key"`
+		Prefix  *string `json:"prefix"` // "" = subskrypcja całej tabeli
+		Pattern string  `json:"pattern"`
+		Channel string  `json:"channel"`
+		Table   string  `json:"table"`
 	}
 	_ = json.NewDecoder(r.Body).Decode(&req)
-	if req.Key == "" {
+	match, ok := disableMatcher(req.Table, req.Key, req.Prefix, req.Pattern)
+	if req.Channel != "" {
+		match, ok = func(t subTarget) bool { return t == channelTarget(req.Channel) }, true
+	}
+	if !ok {
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = w.Write([]byte("missing key"))
 		return
 	}
 
-	// Snapshot połączeń, sprzątamy mapy pod lockiem
-	mu.Lock()
-	set := activeSubs[req.Key]
-	conns := make([]*websocket.Conn, 0, len(set))
-	for c := range set {
-		conns = append(conns, c)
-		// usuń odwrotne mapowanie
-		if m := connToKeys[c]; m != nil {
-			delete(m, req.Key)
-			if len(m) == 0 {
-				delete(connToKeys, c)
-			}
-		}
+	id := auth.FromRequest(r)
+	if id == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
 	}
-	delete(activeSubs, req.Key)
-	mu.Unlock()
-
-	// Wysyłka poza lockiem
-	for _, c := range conns {
-		if err := writeJSON(c, map[string]string{
-			"event": "unsubscribed",
-			"key":   req.Key,
-		}); err != nil {
-			log.Println("unsub notify write failed -> cleanup:", err)
-			cleanupConn(c)
-		}
+	// admin odłącza klucz wszystkim klientom, pozostali tylko połączeniom z własnym auth_key
+	owner := id.Name
+	if id.Admin {
+		owner = ""
 	}
 
+	disableTargets(match, owner)
 	w.WriteHeader(http.StatusOK)
 }
 
 func DisableSubscriptionInternal(key string) (int, error) {
-	if key == "" {
+	return DisableTableSubscriptionInternal("", key)
+}
+
+// DisableTableSubscriptionInternal odłącza klucz w tabeli ("" = we wszystkich tabelach).
+func DisableTableSubscriptionInternal(table, key string) (int, error) {
+	match, ok := disableMatcher(table, key, nil, "")
+	if !ok {
 		return 0, ErrNoKeyArg
 	}
+	return disableTargets(match, ""), nil
+}
 
-	// Snapshot połączeń + sprzątanie map pod lockiem
-	mu.Lock()
-	set := activeSubs[key]
-	conns := make([]*websocket.Conn, 0, len(set))
-	for c := range set {
-		conns = append(conns, c)
-		// usuń odwrotne mapowanie
-		if m := connToKeys[c]; m != nil {
-			delete(m, key)
-			if len(m) == 0 {
-				delete(connToKeys, c)
-			}
-		}
+// disableMatcher - cel wybrany przez key, prefix albo pattern; pusta tabela = każda tabela.
+func disableMatcher(table, key string, prefix *string, pattern string) (func(subTarget) bool, bool) {
+	var kind targetKind
+	var expr string
+	switch {
+	case key != "":
+		kind, expr = targetKey, key
+	case pattern != "":
+		kind, expr = targetRegex, pattern
+	case prefix != nil:
+		kind, expr = targetPrefix, *prefix
+	default:
+		return nil, false
 	}
-	delete(activeSubs, key)
+	return func(t subTarget) bool {
+		return t.kind == kind && t.expr == expr && (table == "" || t.table == table)
+	}, true
+}
+
+// disableTargets zdejmuje pasujące cele i wysyła "unsubscribed"; zwraca ilość powiadomień.
+// disableTargets odłącza pasujące cele klientom identity owner ("" = wszystkim klientom).
+func disableTargets(match func(subTarget) bool, owner string) int {
+	// Snapshot połączeń, sprzątamy mapy pod lockiem
+	mu.Lock()
+	dropped := dropTargetsLocked(match, owner)
 	mu.Unlock()
 
 	// Wysyłka poza lockiem
 	notified := 0
-	for _, c := range conns {
-		if err := writeJSON(c, map[string]string{
-			"event": "unsubscribed",
-			"key":   key,
-		}); err != nil {
+	for _, d := range dropped {
+		ev := d.target.fields()
+		ev["event"] = "unsubscribed"
+		if err := writeJSON(d.conn, ev); err != nil {
 			log.Println("unsub notify write failed -> cleanup:", err)
-			cleanupConn(c)
+			cleanupClient(d.conn)
 			continue
 		}
 		notified++
 	}
+	return notified
+}
+
+// HandleRevokeSubscriptions: POST {"identity":"..."} - unieważnia wszystkie auth_key
+// i zamyka połączenia, które użyły auth_key tej identity. Bez "identity" -> własne.
+func HandleRevokeSubscriptions(w http.ResponseWriter, r *http.Request, _ *http.Client) {
+	var req struct {
+		Identity string `json:"identity"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	id := auth.FromRequest(r)
+	if id == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if req.Identity == "" {
+		req.Identity = id.Name
+	}
+	if req.Identity != id.Name && !id.Admin {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	pending, conns := RevokeIdentity(req.Identity)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"identity":           req.Identity,
+		"revoked_auth_keys":  pending,
+		"closed_connections": conns,
+	})
+}
+
+// RevokeIdentity usuwa niewykorzystane auth_key danej identity i zamyka jej połączenia.
+// Webhooki tej identity są usuwane (także z dysku).
+func RevokeIdentity(identity string) (int, int) {
+	deleteWebhooksOf(identity)
+
+	mu.Lock()
+	revokedPending := 0
+	for k, p := range pendingAuthKeys {
+		if p.Identity == identity {
+			delete(pendingAuthKeys, k)
+			revokedPending++
+		}
+	}
+	conns := make([]*client, 0)
+	for c, ids := range connIdentities {
+		if _, ok := ids[identity]; ok {
+			conns = append(conns, c)
+		}
+	}
+	mu.Unlock()
 
-	return notified, nil
+	for _, c := range conns {
+		_ = writeJSON(c, map[string]string{
+			"event":    "revoked",
+			"identity": identity,
+		})
+		cleanupClient(c)
+	}
+	return revokedPending, len(conns)
 }
 
 // WebSocket endpoint: klient po połączeniu wysyła {"auth_key":"..."} aby dołączyć suby.
@@ -306,19 +476,12 @@ func HandleWS(w http.ResponseWriter, r *http.Request) {
 		log.Println("Upgrade error:", err)
 		return
 	}
+	// Zarejestruj per-conn lock + kanał done
+	c, localDone := registerClient(wsTransport{conn: conn})
 	// Od teraz każde wyjście -> sprzątamy
-	defer cleanupConn(conn)
+	defer cleanupClient(c)
 
-	// Zarejestruj per-conn lock + kanał done
-	mu.Lock()
-	if _, exists := connLocks[conn]; !exists {
-		connLocks[conn] = &sync.Mutex{}
-	}
-	if _, exists := connDone[conn]; !exists {
-		connDone[conn] = make(chan struct{})
-	}
-	localDone := connDone[conn]
-	mu.Unlock()
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
 
 	// Limity i keepalive
 	conn.SetReadLimit(1 << 20) // np. 1MB
@@ -328,23 +491,8 @@ func HandleWS(w http.ResponseWriter, r *http.Request) {
 		return conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	})
 
-	// Ping goroutine
-	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				if err := writePing(conn); err != nil {
-					// Zamykamy połączenie -> reader dostanie błąd i posprząta
-					_ = conn.Close()
-					return
-				}
-			case <-localDone:
-				return
-			}
-		}
-	}()
+	// Ping goroutine; błąd zamyka połączenie -> reader dostanie błąd i posprząta
+	go keepAlive(c, 30*time.Second, localDone)
 
 	// Reader (jeden goroutine na conn)
 	for {
@@ -354,53 +502,148 @@ func HandleWS(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
-		// Oczekujemy JSON: {"auth_key":"..."}
+		// Oczekujemy JSON: {"auth_key":"..."} (opcjonalnie "last_seq" - wznowienie z logu zdarzeń)
+		// albo komendy {"op":"subscribe|unsubscribe|list",...} (commands.go)
 		var req struct {
-			AuthKey string `json:"auth_key"`
+			wsCommand
+			AuthKey  string          `json:"auth_key"`
+			LastSeq  json.RawMessage `json:"last_seq"` // "N" albo N
+			Encoding string          `json:"encoding"` // kodowanie wartości, patrz encoding.go
 		}
-		if err := json.Unmarshal(msg, &req); err != nil || req.AuthKey == "" {
+		if err := json.Unmarshal(msg, &req); err != nil || req.AuthKey == "" && req.Op == "" {
 			log.Println("Invalid message:", string(msg))
 			continue
 		}
+		if req.Op != "" {
+			handleCommand(c, req.wsCommand)
+			continue
+		}
+		lastSeq := ""
+		if len(req.LastSeq) > 0 && string(req.LastSeq) != "null" {
+			lastSeq = strings.Trim(string(req.LastSeq), `"`)
+		}
+		if code := attach(c, remoteIP, attachRequest{AuthKey: req.AuthKey, LastSeq: lastSeq, Encoding: req.Encoding}); code != "" {
+			_ = writeJSON(c, map[string]string{"event": "error", "message": code})
+		}
+	}
+}
 
-		// Zastosuj pending auth key
-		mu.Lock()
-		pend, ok := pendingAuthKeys[req.AuthKey]
-		if ok {
-			// initialized odwrotna mapa dla conn
-			if _, ok := connToKeys[conn]; !ok {
-				connToKeys[conn] = make(map[string]struct{})
-			}
-			// Dla każdego key: dodaj do setów (idempotentnie)
-			for _, key := range pend.Keys {
-				if _, ok := activeSubs[key]; !ok {
-					activeSubs[key] = make(map[*websocket.Conn]struct{})
-				}
-				// jeśli już zasubskrybowane przez ten conn, nic nie robi
-				if _, already := connToKeys[conn][key]; !already {
-					activeSubs[key][conn] = struct{}{}
-					connToKeys[conn][key] = struct{}{}
-				}
-			}
-			// Jednorazowo konsumuj auth_key
-			delete(pendingAuthKeys, req.AuthKey)
-			mu.Unlock()
-
-			// Możesz opcjonalnie odesłać potwierdzenie
-			_ = writeJSON(conn, map[string]any{
-				"event": "subscribed",
-				"keys":  pend.Keys,
-			})
-			// log.Println("Subscribed on:", pend.Keys)
-		} else {
-			mu.Unlock()
-			_ = writeJSON(conn, map[string]string{
-				"event":   "error",
-				"message": "invalid_or_expired_auth_key",
-			})
-			// log.Println("Invalid or expired auth_key:", req.AuthKey)
+// attachRequest - auth_key dołączany do klienta (WS: wiadomość JSON, SSE / long-poll: query).
+type attachRequest struct {
+	AuthKey  string
+	LastSeq  string // "" = bez wznawiania z logu zdarzeń
+	Encoding string // "" = bez zmiany kodowania klienta
+}
+
+// attach stosuje auth_key do klienta i wysyła "subscribed"; zwraca kod błędu ("" = OK).
+// Przy błędzie auth_key nie jest konsumowany.
+func attach(c *client, remoteIP string, req attachRequest) string {
+	if req.Encoding != "" && (!validEncoding(req.Encoding) || req.Encoding == EncodingBinary && !c.t.binary()) {
+		return "invalid_encoding"
+	}
+	resume := req.LastSeq != ""
+	var lastSeq uint64
+	if resume {
+		var err error
+		if lastSeq, err = strconv.ParseUint(req.LastSeq, 10, 64); err != nil {
+			return "invalid_last_seq"
 		}
 	}
+
+	// Zastosuj pending auth key
+	mu.Lock()
+	pend, ok := pendingAuthKeys[req.AuthKey]
+	if !ok || pend.ClientIP != "" && !sameIP(pend.ClientIP, remoteIP) {
+		// auth_key nieznany albo powiązany z innym IP - nie konsumujemy go
+		mu.Unlock()
+		return "invalid_or_expired_auth_key"
+	}
+	if resume && len(pend.LastSeen) > 0 {
+		mu.Unlock()
+		return "last_seq_with_last_seen"
+	}
+	if resume && resuming[c] != nil {
+		mu.Unlock()
+		return "resume_in_progress"
+	}
+
+	if req.Encoding != "" {
+		// kodowanie dotyczy całego klienta, ostatni auth_key wygrywa
+		connEncodings[c] = req.Encoding
+	}
+	encoding := connEncodingLocked(c)
+	var rs *resumeState
+	if resume {
+		// zdarzenia nowych celów buforowane od teraz, zanim resume przeczyta log
+		rs = startResumeLocked(c)
+	}
+	if _, ok := connIdentities[c]; !ok {
+		connIdentities[c] = make(map[string]struct{})
+	}
+	connIdentities[c][pend.Identity] = struct{}{}
+	// Dla każdego celu: dodaj do setów (idempotentnie)
+	replayKeys := make(map[string]int64)
+	filter, _ := parseFilter(pend.Filter) // walidowany w enable
+	for _, t := range pendingTargets(pend) {
+		// jeśli już zasubskrybowane przez ten conn, nic nie robi (poza filtrem - ostatni wygrywa)
+		added := addTargetLocked(c, t)
+		setFilterLocked(c, t, filter)
+		if !added {
+			continue
+		}
+		if rs != nil {
+			rs.addResumeTargetLocked(t)
+		}
+		if t.kind != targetKey {
+			continue
+		}
+		if lastSeen, ok := pend.LastSeen[t.expr]; ok {
+			// zdarzenia buforowane od teraz, zanim replay przeczyta plik
+			startReplayLocked(c, t)
+			replayKeys[t.expr] = lastSeen
+		}
+	}
+	// Jednorazowo konsumuj auth_key
+	delete(pendingAuthKeys, req.AuthKey)
+	mu.Unlock()
+
+	// Możesz opcjonalnie odesłać potwierdzenie
+	ack := map[string]any{
+		"event":    "subscribed",
+		"table":    pend.Table,
+		"keys":     pend.Keys,
+		"encoding": encoding,
+	}
+	if !resume {
+		// punkt startowy dla last_seq przy następnym połączeniu
+		ack["seq"] = strconv.FormatUint(currentSeq(), 10)
+	}
+	if len(pend.Prefixes) > 0 {
+		ack["prefixes"] = pend.Prefixes
+	}
+	if len(pend.Patterns) > 0 {
+		ack["patterns"] = pend.Patterns
+	}
+	if len(pend.Channels) > 0 {
+		ack["channels"] = pend.Channels
+	}
+	if pend.Filter != "" {
+		ack["filter"] = pend.Filter
+	}
+	_ = writeJSON(c, ack)
+	// replay poza readerem (reader obsługuje pongi i kolejne auth_key)
+	if rs != nil {
+		go resumeEvents(c, lastSeq)
+	}
+	for key, lastSeen := range replayKeys {
+		go replayInc(c, pend.Table, key, lastSeen)
+	}
+	return ""
+}
+
+func sameIP(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	return ipA != nil && ipB != nil && ipA.Equal(ipB)
 }
 
 // ---------------------------
@@ -409,6 +652,19 @@ func HandleWS(w http.ResponseWriter, r *http.Request) {
 
 func StartWSServer(port string) error {
 	http.HandleFunc("/sub", HandleWS)
+	// zapisane webhooki działają od startu, nie od pierwszego requestu do API
+	loadWebhooks()
+
+	tlsConfig, err := config.Get().Tls.Subscriptions.ServerTLSConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		server := &http.Server{Addr: ":" + port, TLSConfig: tlsConfig}
+		log.Println("WebSocket (wss) listening on port", port)
+		return server.ListenAndServeTLS("", "")
+	}
+
 	log.Println("WebSocket listening on port", port)
 	return http.ListenAndServe(":"+port, nil)
 }
@@ -417,80 +673,132 @@ func StartWSServer(port string) error {
 // Powiadomienia do subskrybentów
 // ---------------------------
 
-func NotifySubscribers(key string, data []byte) {
-	notifySubscribersWithPayload(key, map[string]any{
+func NotifySubscribers(table, key string, data []byte) {
+	publish(bufferedEvent{table: table, key: key, data: data, payload: map[string]any{
 		"event": "updated",
+		"table": table,
 		"key":   key,
-		"data":  string(data),
-	})
+	}}, false)
 }
 
-func NotifyIncTableSubscribers(key string, changeType string, entryID uint64, entryData []byte) {
-	notifySubscribersWithPayload(key, map[string]any{
+// NotifyEncryptedSubscribers - jak NotifySubscribers dla /save_encrypted. Połączenia na żywo
+// dostają odszyfrowaną wartość, ale log zdarzeń, peery i webhooki tylko table, key i seq
+// z "encrypted": true (bufferedEvent.storedData).
+func NotifyEncryptedSubscribers(table, key string, data []byte) {
+	publish(bufferedEvent{table: table, key: key, data: data, encrypted: true, payload: map[string]any{
+		"event":     "updated",
+		"table":     table,
+		"key":       key,
+		"encrypted": true,
+	}}, false)
+}
+
+func NotifyIncTableSubscribers(table, key string, changeType string, entryID uint64, entryData []byte) {
+	publish(bufferedEvent{table: table, key: key, changeType: changeType, id: entryID, data: entryData, payload: map[string]any{
 		"event": "inc_table_update",
+		"table": table,
 		"key":   key,
 		"data": map[string]any{
-			"type": changeType,
-			"new_data": map[string]string{
-				"id":   strconv.FormatUint(entryID, 10),
-				"data": string(entryData),
-			},
+			"type":     changeType,
+			"new_data": map[string]any{"id": strconv.FormatUint(entryID, 10)},
 		},
-	})
+	}}, false)
 }
 
-func notifySubscribersWithPayload(key string, payload any) {
-	conns := snapshotSubscribers(key)
-	if len(conns) == 0 {
-		return
-	}
-
-	for _, c := range conns {
-		if err := writeJSON(c, payload); err != nil {
-			log.Println("notify write failed -> cleanup:", err)
-			cleanupConn(c)
-		}
-	}
+// NotifyDeleteAndRemove wysyła "deleted" do wszystkich pasujących połączeń i zdejmuje
+// dokładne subskrypcje (table, key); wzorce i subskrypcje bez tabeli zostają.
+func NotifyDeleteAndRemove(table, key string) {
+	publish(bufferedEvent{table: table, key: key, payload: map[string]any{
+		"event": "deleted",
+		"table": table,
+		"key":   key,
+	}}, true)
 }
 
-func snapshotSubscribers(key string) []*websocket.Conn {
-	mu.Lock()
-	defer mu.Unlock()
-
-	set := activeSubs[key]
-	conns := make([]*websocket.Conn, 0, len(set))
-	for c := range set {
-		conns = append(conns, c)
+// publish nadaje zdarzeniu seq, dopisuje je do logu zdarzeń i wysyła do pasujących połączeń.
+// Publikacje są serializowane, więc każde połączenie dostaje zdarzenia w kolejności seq.
+// Połączenia w trakcie wznawiania (resume.go) albo replay inc table (replay.go) dostaną
+// zdarzenie z bufora po zakończeniu odczytu. Zdarzenia z tego node'a idą też do peerów (cluster.go),
+// a pasujące zdarzenia (także od peerów) do webhooków (webhook.go).
+func publish(ev bufferedEvent, removeExact bool) {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	local := ev.origin == ""
+	if node := localNodeID(); local && node != "" {
+		ev.payload["origin"] = node
+	}
+	appendEvent(&ev)
+	if local {
+		// kolejność forward = kolejność seq (pod publishMu)
+		forwardLocal(ev, removeExact)
 	}
-	return conns
-}
 
-func NotifyDeleteAndRemove(key string) {
-	// Snapshot i sprzątanie map
 	mu.Lock()
-	set := activeSubs[key]
-	conns := make([]*websocket.Conn, 0, len(set))
-	for c := range set {
+	matched := matchingConnsLocked(ev.table, ev.key)
+	conns := make([]*client, 0, len(matched))
+	encodings := make(map[*client]string, len(matched))
+	filters := newFilterEval(ev, true)
+	for _, c := range matched {
+		if !filters.allowsLocked(c) {
+			continue
+		}
+		if bufferIfResumingLocked(c, ev) {
+			continue
+		}
+		if ev.changeType != "" && bufferIfReplayingLocked(c, keyTarget(ev.table, ev.key), ev) {
+			continue
+		}
+		if ev.changeType != "" && replayedLocked(c, keyTarget(ev.table, ev.key), ev) {
+			continue
+		}
 		conns = append(conns, c)
-		// usuń odwrotne mapowanie
-		if m := connToKeys[c]; m != nil {
-			delete(m, key)
-			if len(m) == 0 {
-				delete(connToKeys, c)
-			}
+		encodings[c] = connEncodingLocked(c)
+	}
+	hooks := matchingWebhooksLocked(ev, filters)
+	for _, c := range hooks {
+		encodings[c] = connEncodingLocked(c)
+	}
+	if removeExact {
+		target := keyTarget(ev.table, ev.key)
+		for c := range activeSubs[target] {
+			removeTargetLocked(c, target)
 		}
 	}
-	delete(activeSubs, key)
 	mu.Unlock()
+	filters.finish()
 
-	// Wysyłka poza lockiem
+	// updated / deleted niosą pełny stan klucza, więc przy pełnej kolejce mogą być scalane
+	coalesceKey := ""
+	if ev.changeType == "" {
+		coalesceKey = ev.table + "\x00" + ev.key
+	}
+
+	deliverEvent(ev, conns, encodings, coalesceKey)
+	// webhooki (i ich dead letters) bez wartości zapisów szyfrowanych
+	hookEv := ev
+	hookEv.data = ev.storedData()
+	deliverEvent(hookEv, hooks, encodings, coalesceKey)
+}
+
+// deliverEvent wrzuca zdarzenie do kolejek klientów poza lockiem (bez czekania na zapis),
+// każde kodowanie renderowane raz.
+func deliverEvent(ev bufferedEvent, conns []*client, encodings map[*client]string, coalesceKey string) {
+	frames := make(map[string]outMsg)
 	for _, c := range conns {
-		if err := writeJSON(c, map[string]string{
-			"event": "deleted",
-			"key":   key,
-		}); err != nil {
-			log.Println("delete notify write failed -> cleanup:", err)
-			cleanupConn(c)
+		enc := encodings[c]
+		f, ok := frames[enc]
+		if !ok {
+			msgType, data, err := renderEvent(ev, enc)
+			if err != nil {
+				log.Println("notify encode failed:", err)
+				continue
+			}
+			f = outMsg{msgType: msgType, data: data, seq: ev.seq, coalesceKey: coalesceKey}
+			frames[enc] = f
+		}
+		if !c.push(f) {
+			slowConsumer(c)
 		}
 	}
 }
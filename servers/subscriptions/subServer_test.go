@@ -0,0 +1,1441 @@
+package subscriptions
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+	config "github.com/PAW122/TsunamiDB/servers/config"
+)
+
+func setupSubTest(t *testing.T, cfg *config.Config) *httptest.Server {
+	t.Helper()
+	config.Set(cfg)
+	mu.Lock()
+	pendingAuthKeys = make(map[string]*Pending)
+	mu.Unlock()
+	useTempEventLog(t)
+	srv := httptest.NewServer(http.HandlerFunc(HandleWS))
+	t.Cleanup(func() {
+		srv.Close()
+		config.Set(nil)
+	})
+	return srv
+}
+
+// useTempEventLog - log zdarzeń w katalogu testu, seq od zera.
+func useTempEventLog(t *testing.T) {
+	t.Helper()
+	logMu.Lock()
+	defer logMu.Unlock()
+	if logCurrent != nil {
+		_ = logCurrent.Close()
+	}
+	logCurrent, logSegments, logLoaded, lastSeq = nil, nil, false, 0
+	eventLogDir = t.TempDir()
+}
+
+func dialSub(t *testing.T, srv *httptest.Server, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ev map[string]any
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	return ev
+}
+
+// enable wywołuje handler przez auth middleware, tak jak Public API.
+func enable(t *testing.T, apiKey string, body map[string]any) *httptest.ResponseRecorder {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/subscriptions/enable", bytes.NewReader(raw))
+	if apiKey != "" {
+		req.Header.Set("api_key", apiKey)
+	}
+	rr := httptest.NewRecorder()
+	auth.Middleware(auth.AccessNone, func(w http.ResponseWriter, r *http.Request) {
+		HandleEnableSubscription(w, r, nil)
+	})(rr, req)
+	return rr
+}
+
+func authKeyFrom(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	var out map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil || out["auth_key"] == "" {
+		t.Fatalf("missing auth_key: %d %s", rr.Code, rr.Body.String())
+	}
+	return out["auth_key"]
+}
+
+func authConfig() *config.Config {
+	return &config.Config{Api_auth: config.Api_auth{Keys: []config.Api_key{
+		{Key: "chat-key", Identity: "chat", Tables: map[string]string{"messages": "r"}},
+		{Key: "admin-key", Identity: "ops", Admin: true, Tables: map[string]string{"*": "rw"}},
+	}}}
+}
+
+func TestEnableRespectsTablePermissions(t *testing.T) {
+	setupSubTest(t, authConfig())
+
+	if rr := enable(t, "", map[string]any{"keys": []string{"a"}, "table": "messages"}); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without api key, got %d", rr.Code)
+	}
+	if rr := enable(t, "chat-key", map[string]any{"keys": []string{"a"}, "table": "billing"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for table without read access, got %d", rr.Code)
+	}
+	if rr := enable(t, "chat-key", map[string]any{"keys": []string{"a"}}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without table for non-wildcard identity, got %d", rr.Code)
+	}
+	rr := enable(t, "chat-key", map[string]any{"keys": []string{"a"}, "table": "messages"})
+	key := authKeyFrom(t, rr)
+
+	mu.Lock()
+	identity := pendingAuthKeys[key].Identity
+	mu.Unlock()
+	if identity != "chat" {
+		t.Fatalf("auth_key not bound to caller identity: %q", identity)
+	}
+}
+
+func TestAuthKeyBoundToClientIP(t *testing.T) {
+	srv := setupSubTest(t, &config.Config{})
+
+	wrongIP := authKeyFrom(t, enable(t, "", map[string]any{"keys": []string{"ip-key"}, "client_ip": "203.0.113.9"}))
+	conn := dialSub(t, srv, nil)
+	_ = conn.WriteJSON(map[string]string{"auth_key": wrongIP})
+	if ev := readEvent(t, conn); ev["event"] != "error" {
+		t.Fatalf("expected error for foreign IP, got %v", ev)
+	}
+
+	rightIP := authKeyFrom(t, enable(t, "", map[string]any{"keys": []string{"ip-key"}, "client_ip": "127.0.0.1"}))
+	_ = conn.WriteJSON(map[string]string{"auth_key": rightIP})
+	if ev := readEvent(t, conn); ev["event"] != "subscribed" {
+		t.Fatalf("expected subscribed, got %v", ev)
+	}
+}
+
+func TestRevokeIdentityClosesConnections(t *testing.T) {
+	srv := setupSubTest(t, authConfig())
+
+	key := authKeyFrom(t, enable(t, "chat-key", map[string]any{"keys": []string{"room"}, "table": "messages"}))
+	unused := authKeyFrom(t, enable(t, "chat-key", map[string]any{"keys": []string{"room"}, "table": "messages"}))
+
+	conn := dialSub(t, srv, nil)
+	_ = conn.WriteJSON(map[string]string{"auth_key": key})
+	if ev := readEvent(t, conn); ev["event"] != "subscribed" {
+		t.Fatalf("expected subscribed, got %v", ev)
+	}
+
+	pending, closed := RevokeIdentity("chat")
+	if pending != 1 || closed != 1 {
+		t.Fatalf("unexpected revoke result: pending=%d closed=%d", pending, closed)
+	}
+	if ev := readEvent(t, conn); ev["event"] != "revoked" {
+		t.Fatalf("expected revoked event, got %v", ev)
+	}
+
+	mu.Lock()
+	_, stillPending := pendingAuthKeys[unused]
+	mu.Unlock()
+	if stillPending {
+		t.Fatalf("unused auth_key should be revoked")
+	}
+}
+
+// disable wywołuje handler przez auth middleware, tak jak Public API.
+func disable(apiKey string, body map[string]any) *httptest.ResponseRecorder {
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/subscriptions/disable", bytes.NewReader(raw))
+	req.Header.Set("api_key", apiKey)
+	rr := httptest.NewRecorder()
+	auth.Middleware(auth.AccessNone, func(w http.ResponseWriter, r *http.Request) {
+		HandleDisableSubscription(w, r, nil)
+	})(rr, req)
+	return rr
+}
+
+func TestDisableLimitedToOwnIdentity(t *testing.T) {
+	srv := setupSubTest(t, authConfig())
+
+	subscribe := func(apiKey string) *websocket.Conn {
+		t.Helper()
+		conn := dialSub(t, srv, nil)
+		_ = conn.WriteJSON(map[string]string{"auth_key": authKeyFrom(t, enable(t, apiKey, map[string]any{"keys": []string{"room"}, "table": "messages"}))})
+		if ev := readEvent(t, conn); ev["event"] != "subscribed" {
+			t.Fatalf("expected subscribed, got %v", ev)
+		}
+		return conn
+	}
+	chat, ops := subscribe("chat-key"), subscribe("admin-key")
+
+	// zwykły klucz odłącza tylko połączenia z własnym auth_key
+	if rr := disable("chat-key", map[string]any{"key": "room", "table": "messages"}); rr.Code != http.StatusOK {
+		t.Fatalf("disable by owner: %d %s", rr.Code, rr.Body.String())
+	}
+	if ev := readEvent(t, chat); ev["event"] != "unsubscribed" {
+		t.Fatalf("expected own socket unsubscribed, got %v", ev)
+	}
+	NotifySubscribers("messages", "room", []byte("still here"))
+	if ev := readEvent(t, ops); ev["event"] != "updated" || ev["data"] != "still here" {
+		t.Fatalf("other identity should stay subscribed, got %v", ev)
+	}
+
+	// admin odłącza wszystkich
+	chat = subscribe("chat-key")
+	if rr := disable("admin-key", map[string]any{"key": "room", "table": "messages"}); rr.Code != http.StatusOK {
+		t.Fatalf("disable by admin: %d", rr.Code)
+	}
+	for _, conn := range []*websocket.Conn{chat, ops} {
+		if ev := readEvent(t, conn); ev["event"] != "unsubscribed" {
+			t.Fatalf("expected unsubscribed for every client, got %v", ev)
+		}
+	}
+	if rr := disable("nope", map[string]any{"key": "room"}); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a valid key, got %d", rr.Code)
+	}
+}
+
+func TestCheckOriginPolicy(t *testing.T) {
+	srv := setupSubTest(t, &config.Config{Subscriptions: config.Subscriptions{
+		AllowedOrigins: []string{"https://app.example.com"},
+	}})
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	if _, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example.com"}}); err == nil {
+		t.Fatalf("expected foreign origin to be rejected")
+	}
+	dialSub(t, srv, http.Header{"Origin": {"https://app.example.com"}})
+}
+
+func TestIncReplayNoGapNoDuplicate(t *testing.T) {
+	srv := setupSubTest(t, authConfig())
+
+	var storeMu sync.Mutex
+	store := []string{"m0", "m1", "m2", "m3", "m4", "m5"}
+	calls := 0
+	SetIncReplaySource(func(table, key string, start, amount uint64) (IncReplayPage, error) {
+		if table != "messages" || key != "room" {
+			t.Errorf("unexpected replay source args: %s/%s", table, key)
+		}
+		storeMu.Lock()
+		page := IncReplayPage{}
+		for id := start; id < uint64(len(store)) && uint64(len(page.IDs)) < amount; id++ {
+			page.IDs = append(page.IDs, id)
+			page.Data = append(page.Data, []byte(store[id]))
+		}
+		calls++
+		first := calls == 1
+		if first {
+			// zapis po odczycie strony + spóźnione powiadomienie o wpisie, który jest już w stronie
+			store = append(store, "m6")
+		}
+		storeMu.Unlock()
+		if first {
+			NotifyIncTableSubscribers("messages", "room", "add", 6, []byte("m6"))
+			NotifyIncTableSubscribers("messages", "room", "add", 5, []byte("m5"))
+		}
+		return page, nil
+	})
+	t.Cleanup(func() { SetIncReplaySource(nil) })
+
+	if rr := enable(t, "admin-key", map[string]any{"keys": []string{"room"}, "last_seen": map[string]int64{"room": 1}}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("last_seen without table should be 400, got %d", rr.Code)
+	}
+	key := authKeyFrom(t, enable(t, "chat-key", map[string]any{"keys": []string{"room"}, "table": "messages", "last_seen": map[string]int64{"room": 1}}))
+
+	conn := dialSub(t, srv, nil)
+	_ = conn.WriteJSON(map[string]string{"auth_key": key})
+	if ev := readEvent(t, conn); ev["event"] != "subscribed" {
+		t.Fatalf("expected subscribed, got %v", ev)
+	}
+
+	var got []string
+	for {
+		ev := readEvent(t, conn)
+		if ev["event"] == "inc_table_replay_done" {
+			if ev["last_id"] != "5" {
+				t.Fatalf("unexpected last_id: %v", ev)
+			}
+			break
+		}
+		if ev["event"] != "inc_table_replay" {
+			t.Fatalf("expected replay page, got %v", ev)
+		}
+		for _, e := range ev["data"].(map[string]any)["entries"].([]any) {
+			got = append(got, e.(map[string]any)["id"].(string))
+		}
+	}
+	if strings.Join(got, ",") != "2,3,4,5" {
+		t.Fatalf("unexpected replay ids: %v", got)
+	}
+
+	NotifyIncTableSubscribers("messages", "room", "add", 7, []byte("m7"))
+	for _, want := range []string{"6", "7"} {
+		ev := readEvent(t, conn)
+		newData := ev["data"].(map[string]any)["new_data"].(map[string]any)
+		if ev["event"] != "inc_table_update" || newData["id"] != want {
+			t.Fatalf("expected live add %s, got %v", want, ev)
+		}
+	}
+
+	// spóźnione powiadomienie o wpisie z replay, już po inc_table_replay_done
+	NotifyIncTableSubscribers("messages", "room", "add", 4, []byte("m4"))
+	NotifyIncTableSubscribers("messages", "room", "add", 8, []byte("m8"))
+	ev := readEvent(t, conn)
+	if newData := ev["data"].(map[string]any)["new_data"].(map[string]any); newData["id"] != "8" {
+		t.Fatalf("duplicate of replayed entry delivered: %v", ev)
+	}
+}
+
+func TestTableScopedAndPatternSubscriptions(t *testing.T) {
+	srv := setupSubTest(t, authConfig())
+
+	if rr := enable(t, "admin-key", map[string]any{"table": "a", "patterns": []string{"("}}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid regex should be 400, got %d", rr.Code)
+	}
+
+	exact := dialSub(t, srv, nil)
+	_ = exact.WriteJSON(map[string]string{"auth_key": authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"config"}, "table": "a"}))})
+	if ev := readEvent(t, exact); ev["event"] != "subscribed" || ev["table"] != "a" {
+		t.Fatalf("expected subscribed to table a, got %v", ev)
+	}
+
+	// prefiks "user:" i regex pasujący do tego samego klucza - zdarzenie ma przyjść raz
+	pattern := dialSub(t, srv, nil)
+	_ = pattern.WriteJSON(map[string]string{"auth_key": authKeyFrom(t, enable(t, "admin-key", map[string]any{
+		"table": "b", "prefixes": []string{"user:"}, "patterns": []string{`^user:\d+$`, `^cfg-`},
+	}))})
+	if ev := readEvent(t, pattern); ev["event"] != "subscribed" {
+		t.Fatalf("expected subscribed, got %v", ev)
+	}
+
+	whole := dialSub(t, srv, nil)
+	_ = whole.WriteJSON(map[string]string{"auth_key": authKeyFrom(t, enable(t, "admin-key", map[string]any{"table": "b", "prefixes": []string{""}}))})
+	if ev := readEvent(t, whole); ev["event"] != "subscribed" {
+		t.Fatalf("expected subscribed, got %v", ev)
+	}
+
+	NotifySubscribers("b", "config", []byte("other table"))
+	NotifySubscribers("a", "config", []byte("v1"))
+	if ev := readEvent(t, exact); ev["table"] != "a" || ev["data"] != "v1" {
+		t.Fatalf("expected only table a update, got %v", ev)
+	}
+	if ev := readEvent(t, whole); ev["table"] != "b" || ev["key"] != "config" {
+		t.Fatalf("whole-table subscriber should get b/config, got %v", ev)
+	}
+
+	NotifySubscribers("b", "user:7", []byte("u7"))
+	NotifyIncTableSubscribers("b", "cfg-x", "add", 0, []byte("c"))
+	if ev := readEvent(t, pattern); ev["key"] != "user:7" || ev["table"] != "b" {
+		t.Fatalf("expected user:7 once, got %v", ev)
+	}
+	if ev := readEvent(t, pattern); ev["event"] != "inc_table_update" || ev["key"] != "cfg-x" {
+		t.Fatalf("expected cfg-x via regex (no duplicate user:7), got %v", ev)
+	}
+
+	// /free zdejmuje dokładną subskrypcję, wzorce zostają
+	NotifyDeleteAndRemove("a", "config")
+	NotifyDeleteAndRemove("b", "user:7")
+	if ev := readEvent(t, exact); ev["event"] != "deleted" || ev["table"] != "a" {
+		t.Fatalf("expected deleted, got %v", ev)
+	}
+	if ev := readEvent(t, pattern); ev["event"] != "deleted" || ev["key"] != "user:7" {
+		t.Fatalf("expected deleted via prefix, got %v", ev)
+	}
+	NotifySubscribers("a", "config", []byte("v2"))
+	NotifySubscribers("b", "user:8", []byte("u8"))
+	if ev := readEvent(t, pattern); ev["key"] != "user:8" {
+		t.Fatalf("pattern subscription should survive delete, got %v", ev)
+	}
+
+	if n := disableTargets(func(t subTarget) bool { return t.table == "b" && t.kind == targetPrefix && t.expr == "" }, ""); n != 1 {
+		t.Fatalf("expected 1 whole-table subscriber to be disabled, got %d", n)
+	}
+	for _, want := range []string{"user:7", "cfg-x", "user:7", "user:8"} {
+		if ev := readEvent(t, whole); ev["key"] != want {
+			t.Fatalf("expected %s on whole-table subscriber, got %v", want, ev)
+		}
+	}
+	if ev := readEvent(t, whole); ev["event"] != "unsubscribed" || ev["prefix"] != "" || ev["table"] != "b" {
+		t.Fatalf("expected unsubscribed for whole table, got %v", ev)
+	}
+}
+
+// attachWS wysyła auth_key (opcjonalnie last_seq) i czeka na "subscribed".
+func attachWS(t *testing.T, conn *websocket.Conn, msg map[string]string) map[string]any {
+	t.Helper()
+	_ = conn.WriteJSON(msg)
+	ev := readEvent(t, conn)
+	if ev["event"] != "subscribed" {
+		t.Fatalf("expected subscribed, got %v", ev)
+	}
+	return ev
+}
+
+func TestResumeFromLastSeq(t *testing.T) {
+	srv := setupSubTest(t, authConfig())
+	docKey := func() string {
+		return authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"doc"}, "table": "t"}))
+	}
+
+	first := dialSub(t, srv, nil)
+	if ack := attachWS(t, first, map[string]string{"auth_key": docKey()}); ack["seq"] != "0" {
+		t.Fatalf("expected seq 0 in ack, got %v", ack)
+	}
+	NotifySubscribers("t", "doc", []byte("v1"))
+	if ev := readEvent(t, first); ev["seq"] != "1" || ev["data"] != "v1" {
+		t.Fatalf("expected v1 with seq 1, got %v", ev)
+	}
+	first.Close()
+
+	NotifySubscribers("t", "doc", []byte("v2"))
+	NotifySubscribers("t", "other", []byte("x"))
+	NotifyIncTableSubscribers("t", "doc", "add", 0, []byte("e0"))
+	NotifyDeleteAndRemove("t", "doc")
+
+	// restart: seq i zdarzenia wczytane z dysku
+	logMu.Lock()
+	_ = logCurrent.Close()
+	logCurrent, logSegments, logLoaded, lastSeq = nil, nil, false, 0
+	logMu.Unlock()
+	if seq := currentSeq(); seq != 5 {
+		t.Fatalf("expected seq 5 after reload, got %d", seq)
+	}
+
+	second := dialSub(t, srv, nil)
+	_ = second.WriteJSON(map[string]string{"auth_key": "x", "last_seq": "nope"})
+	if ev := readEvent(t, second); ev["message"] != "invalid_last_seq" {
+		t.Fatalf("expected invalid_last_seq, got %v", ev)
+	}
+	if ack := attachWS(t, second, map[string]string{"auth_key": docKey(), "last_seq": "1"}); ack["seq"] != nil {
+		t.Fatalf("resumed ack should not carry seq: %v", ack)
+	}
+	for _, want := range []string{"2:updated", "4:inc_table_update", "5:deleted"} {
+		ev := readEvent(t, second)
+		if got := ev["seq"].(string) + ":" + ev["event"].(string); got != want {
+			t.Fatalf("expected %s, got %v", want, ev)
+		}
+	}
+	if ev := readEvent(t, second); ev["event"] != "resume_done" || ev["last_seq"] != "5" {
+		t.Fatalf("expected resume_done at 5, got %v", ev)
+	}
+	NotifySubscribers("t", "doc", []byte("v3"))
+	if ev := readEvent(t, second); ev["seq"] != "6" || ev["data"] != "v3" {
+		t.Fatalf("expected live v3, got %v", ev)
+	}
+}
+
+func TestResumeNoGapUnderLoad(t *testing.T) {
+	srv := setupSubTest(t, authConfig())
+	for i := 0; i < 50; i++ {
+		NotifySubscribers("t", "doc", []byte("old"))
+	}
+
+	conn := dialSub(t, srv, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			NotifySubscribers("t", "doc", []byte("new"))
+		}
+	}()
+	attachWS(t, conn, map[string]string{
+		"auth_key": authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"doc"}, "table": "t"})),
+		"last_seq": "10",
+	})
+
+	next := uint64(11)
+	for next <= 250 {
+		ev := readEvent(t, conn)
+		if ev["event"] == "resume_done" {
+			continue
+		}
+		if ev["seq"] != strconv.FormatUint(next, 10) {
+			t.Fatalf("expected seq %d, got %v", next, ev)
+		}
+		next++
+	}
+	<-done
+}
+
+func TestResumeOffsetTrimmed(t *testing.T) {
+	srv := setupSubTest(t, &config.Config{Subscriptions: config.Subscriptions{
+		EventLog: config.Sub_event_log{SegmentBytes: 300, MaxBytes: 600},
+	}})
+	for i := 0; i < 40; i++ {
+		NotifySubscribers("t", "doc", []byte("payload"))
+	}
+
+	logMu.Lock()
+	oldest := oldestSeqLocked()
+	logMu.Unlock()
+	if oldest <= 1 {
+		t.Fatalf("expected retention to drop old segments, oldest=%d", oldest)
+	}
+
+	conn := dialSub(t, srv, nil)
+	attachWS(t, conn, map[string]string{
+		"auth_key": authKeyFrom(t, enable(t, "", map[string]any{"keys": []string{"doc"}, "table": "t"})),
+		"last_seq": "0",
+	})
+	ev := readEvent(t, conn)
+	if ev["message"] != "offset_trimmed" || ev["oldest_seq"] != strconv.FormatUint(oldest, 10) {
+		t.Fatalf("expected offset_trimmed with oldest_seq %d, got %v", oldest, ev)
+	}
+	if ev := readEvent(t, conn); ev["event"] != "resume_done" || ev["last_seq"] != "0" {
+		t.Fatalf("expected resume_done, got %v", ev)
+	}
+}
+
+// readBinary czyta ramkę binarną zdarzenia: metadane JSON + wartość.
+func readBinary(t *testing.T, conn *websocket.Conn) (map[string]any, []byte) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msgType, frame, err := conn.ReadMessage()
+	if err != nil || msgType != websocket.BinaryMessage || len(frame) < 5 || frame[0] != binaryFrameVersion {
+		t.Fatalf("expected binary frame, got type=%d err=%v frame=%q", msgType, err, frame)
+	}
+	n := binary.BigEndian.Uint32(frame[1:5])
+	var meta map[string]any
+	if err := json.Unmarshal(frame[5:5+n], &meta); err != nil {
+		t.Fatalf("bad metadata: %v", err)
+	}
+	return meta, frame[5+n:]
+}
+
+func TestPayloadEncodings(t *testing.T) {
+	srv := setupSubTest(t, authConfig())
+	sub := func(encoding string) *websocket.Conn {
+		conn := dialSub(t, srv, nil)
+		ack := attachWS(t, conn, map[string]string{
+			"auth_key": authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"v"}, "table": "t"})),
+			"encoding": encoding,
+		})
+		if ack["encoding"] != encoding {
+			t.Fatalf("expected encoding %s in ack, got %v", encoding, ack)
+		}
+		return conn
+	}
+
+	bad := dialSub(t, srv, nil)
+	_ = bad.WriteJSON(map[string]string{"auth_key": "x", "encoding": "utf-16"})
+	if ev := readEvent(t, bad); ev["message"] != "invalid_encoding" {
+		t.Fatalf("expected invalid_encoding, got %v", ev)
+	}
+
+	b64, js, bin := sub(EncodingBase64), sub(EncodingJSON), sub(EncodingBinary)
+	raw := []byte{0xff, 0x00, 'a'}
+
+	NotifySubscribers("t", "v", raw)
+	NotifySubscribers("t", "v", []byte(`{"n":1}`))
+	NotifyIncTableSubscribers("t", "v", "add", 3, raw)
+	NotifyDeleteAndRemove("t", "v")
+
+	want64 := base64.StdEncoding.EncodeToString(raw)
+	if ev := readEvent(t, b64); ev["data"] != want64 {
+		t.Fatalf("base64: unexpected data %v", ev)
+	}
+	if ev := readEvent(t, b64); ev["data"] != base64.StdEncoding.EncodeToString([]byte(`{"n":1}`)) {
+		t.Fatalf("base64: unexpected data %v", ev)
+	}
+	if ev := readEvent(t, b64); ev["data"].(map[string]any)["new_data"].(map[string]any)["data"] != want64 {
+		t.Fatalf("base64: unexpected inc data %v", ev)
+	}
+
+	if ev := readEvent(t, js); ev["data"] != want64 || ev["data_encoding"] != "base64" {
+		t.Fatalf("json: non-JSON value should fall back to base64, got %v", ev)
+	}
+	if ev := readEvent(t, js); ev["data"].(map[string]any)["n"] != float64(1) {
+		t.Fatalf("json: value should be embedded, got %v", ev)
+	}
+
+	meta, value := readBinary(t, bin)
+	if meta["event"] != "updated" || meta["data"] != nil || !bytes.Equal(value, raw) {
+		t.Fatalf("binary: unexpected frame %v %q", meta, value)
+	}
+	readBinary(t, bin)
+	meta, value = readBinary(t, bin)
+	if meta["data"].(map[string]any)["new_data"].(map[string]any)["id"] != "3" || !bytes.Equal(value, raw) {
+		t.Fatalf("binary: unexpected inc frame %v %q", meta, value)
+	}
+	if meta, value = readBinary(t, bin); meta["event"] != "deleted" || len(value) != 0 {
+		t.Fatalf("binary: unexpected delete frame %v %q", meta, value)
+	}
+
+	// wartości z logu zdarzeń zachowują bajty
+	resumed := dialSub(t, srv, nil)
+	attachWS(t, resumed, map[string]string{
+		"auth_key": authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"v"}, "table": "t"})),
+		"encoding": EncodingBase64,
+		"last_seq": "0",
+	})
+	if ev := readEvent(t, resumed); ev["seq"] != "1" || ev["data"] != want64 {
+		t.Fatalf("resumed event lost bytes: %v", ev)
+	}
+}
+
+// readSSE czyta jedno zdarzenie SSE (pomija komentarze ": ping"); zwraca id i dane JSON.
+func readSSE(t *testing.T, r *bufio.Reader) (string, map[string]any) {
+	t.Helper()
+	var id string
+	var data []byte
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read sse: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && data != nil:
+			var ev map[string]any
+			if err := json.Unmarshal(data, &ev); err != nil {
+				t.Fatalf("sse data %q: %v", data, err)
+			}
+			return id, ev
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, strings.TrimPrefix(line, "data: ")...)
+		}
+	}
+}
+
+func TestSSEStream(t *testing.T) {
+	setupSubTest(t, authConfig())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { HandleSSE(w, r, nil) }))
+	t.Cleanup(srv.Close)
+	docKey := func() string {
+		return authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"doc"}, "table": "t"}))
+	}
+
+	if resp, err := http.Get(srv.URL + "?auth_key=" + docKey() + "&encoding=binary"); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("binary encoding over SSE should be rejected: %v %v", resp.StatusCode, err)
+	}
+	if resp, err := http.Get(srv.URL + "?auth_key=nope"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unknown auth_key should be 401: %v %v", resp.StatusCode, err)
+	}
+
+	resp, err := http.Get(srv.URL + "?auth_key=" + docKey() + "&encoding=json")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body := bufio.NewReader(resp.Body)
+	if id, ack := readSSE(t, body); id != "" || ack["event"] != "subscribed" || ack["encoding"] != "json" {
+		t.Fatalf("expected subscribed ack without id, got %q %v", id, ack)
+	}
+	NotifySubscribers("t", "doc", []byte(`{"n":1}`))
+	id, ev := readSSE(t, body)
+	if data, _ := ev["data"].(map[string]any); id != "1" || ev["event"] != "updated" || data["n"] != float64(1) {
+		t.Fatalf("expected updated with id 1, got %q %v", id, ev)
+	}
+	resp.Body.Close()
+
+	// reconnect EventSource: nowy auth_key + Last-Event-ID
+	NotifySubscribers("t", "doc", []byte(`{"n":2}`))
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"?auth_key="+docKey(), nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	body = bufio.NewReader(resp.Body)
+	readSSE(t, body) // subscribed
+	if id, ev := readSSE(t, body); id != "2" || ev["data"] != `{"n":2}` {
+		t.Fatalf("expected resumed event 2, got %q %v", id, ev)
+	}
+	if _, ev := readSSE(t, body); ev["event"] != "resume_done" {
+		t.Fatalf("expected resume_done, got %v", ev)
+	}
+}
+
+type pollResponse struct {
+	Session string           `json:"session"`
+	Cursor  string           `json:"cursor"`
+	Events  []map[string]any `json:"events"`
+}
+
+func doPoll(t *testing.T, srv *httptest.Server, query string) (int, pollResponse) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "?" + query)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	defer resp.Body.Close()
+	var out pollResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("poll body: %v", err)
+		}
+	}
+	return resp.StatusCode, out
+}
+
+func TestLongPoll(t *testing.T) {
+	setupSubTest(t, authConfig())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { HandlePoll(w, r, nil) }))
+	t.Cleanup(srv.Close)
+	key := authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"doc"}, "table": "t"}))
+
+	code, first := doPoll(t, srv, "auth_key="+key)
+	if code != http.StatusOK || first.Session == "" || len(first.Events) != 1 || first.Events[0]["event"] != "subscribed" {
+		t.Fatalf("expected session with subscribed ack, got %d %+v", code, first)
+	}
+	session := "session=" + first.Session
+
+	// brak zdarzeń: pusta partia po timeout, cursor bez zmian
+	if code, out := doPoll(t, srv, session+"&cursor="+first.Cursor+"&timeout=0"); code != http.StatusOK || len(out.Events) != 0 || out.Cursor != first.Cursor {
+		t.Fatalf("expected empty batch, got %d %+v", code, out)
+	}
+
+	// zdarzenie w trakcie oczekiwania budzi poll
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		NotifySubscribers("t", "doc", []byte("v1"))
+		NotifySubscribers("t", "doc", []byte("v2"))
+	}()
+	code, batch := doPoll(t, srv, session+"&cursor="+first.Cursor+"&timeout=5&limit=1")
+	if code != http.StatusOK || len(batch.Events) != 1 || batch.Events[0]["data"] != "v1" {
+		t.Fatalf("expected v1, got %d %+v", code, batch)
+	}
+	time.Sleep(100 * time.Millisecond)
+	// powtórzony poll z tym samym cursor dostaje tę samą partię
+	if _, again := doPoll(t, srv, session+"&cursor="+first.Cursor+"&limit=1"); again.Cursor != batch.Cursor || again.Events[0]["data"] != "v1" {
+		t.Fatalf("expected same batch on retry, got %+v", again)
+	}
+	if _, next := doPoll(t, srv, session+"&cursor="+batch.Cursor); len(next.Events) != 1 || next.Events[0]["data"] != "v2" {
+		t.Fatalf("expected v2 after cursor, got %+v", next)
+	}
+
+	if code, _ := doPoll(t, srv, "session=nope"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown session, got %d", code)
+	}
+	if code, _ := doPoll(t, srv, "auth_key=nope"); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for unknown auth_key, got %d", code)
+	}
+}
+
+func TestLongPollSessionBoundToClientIP(t *testing.T) {
+	setupSubTest(t, authConfig())
+	var remote atomic.Value
+	remote.Store("127.0.0.1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = remote.Load().(string) + ":1234"
+		HandlePoll(w, r, nil)
+	}))
+	t.Cleanup(srv.Close)
+
+	key := authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"doc"}, "table": "t", "client_ip": "127.0.0.1"}))
+	code, first := doPoll(t, srv, "auth_key="+key)
+	if code != http.StatusOK || first.Session == "" {
+		t.Fatalf("expected session, got %d %+v", code, first)
+	}
+	session := "session=" + first.Session + "&cursor=" + first.Cursor + "&timeout=0"
+
+	remote.Store("203.0.113.9")
+	if code, _ := doPoll(t, srv, session); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for poll from another IP, got %d", code)
+	}
+	remote.Store("127.0.0.1")
+	if code, _ := doPoll(t, srv, session); code != http.StatusOK {
+		t.Fatalf("expected poll from bound IP to work, got %d", code)
+	}
+
+	// bez client_ip sesja nie jest powiązana z IP
+	key = authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"doc"}, "table": "t"}))
+	_, free := doPoll(t, srv, "auth_key="+key)
+	remote.Store("203.0.113.9")
+	if code, _ := doPoll(t, srv, "session="+free.Session+"&cursor="+free.Cursor+"&timeout=0"); code != http.StatusOK {
+		t.Fatalf("expected unbound session to work from any IP, got %d", code)
+	}
+}
+
+func TestAuthKeyIdentityForLimits(t *testing.T) {
+	setupSubTest(t, authConfig())
+	var got string
+	handler := WithAuthKeyIdentity(func(w http.ResponseWriter, r *http.Request) {
+		got = ""
+		if id := auth.FromRequest(r); id != nil {
+			got = id.Name
+		}
+	})
+	identityOf := func(query string) string {
+		t.Helper()
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/subscriptions/poll?"+query, nil))
+		return got
+	}
+
+	key := authKeyFrom(t, enable(t, "chat-key", map[string]any{"keys": []string{"room"}, "table": "messages"}))
+	if name := identityOf("auth_key=" + key); name != "chat" {
+		t.Fatalf("expected auth_key owner identity, got %q", name)
+	}
+	if name := identityOf("auth_key=nope"); name != "" {
+		t.Fatalf("unknown auth_key should not get an identity, got %q", name)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { HandlePoll(w, r, nil) }))
+	t.Cleanup(srv.Close)
+	_, first := doPoll(t, srv, "auth_key="+key)
+	if name := identityOf("session=" + first.Session); name != "chat" {
+		t.Fatalf("expected session owner identity, got %q", name)
+	}
+}
+
+// gateTransport wstrzymuje zapisy do zamknięcia release (wolny klient).
+type gateTransport struct {
+	release chan struct{}
+	entered chan struct{} // pierwszy send czeka na release
+	mu      sync.Mutex
+	sent    []map[string]any
+	closed  bool
+}
+
+func newGateTransport() *gateTransport {
+	return &gateTransport{release: make(chan struct{}), entered: make(chan struct{}, 1)}
+}
+
+func (g *gateTransport) send(m outMsg) error {
+	select {
+	case g.entered <- struct{}{}:
+	default:
+	}
+	<-g.release
+	var ev map[string]any
+	_ = json.Unmarshal(m.data, &ev)
+	g.mu.Lock()
+	g.sent = append(g.sent, ev)
+	g.mu.Unlock()
+	return nil
+}
+
+func (g *gateTransport) ping() error  { return nil }
+func (g *gateTransport) binary() bool { return false }
+func (g *gateTransport) close() {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+}
+
+// summary - "event" albo "event:data" dla updated / "event:count" dla events_dropped.
+func (g *gateTransport) summary() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make([]string, 0, len(g.sent))
+	for _, ev := range g.sent {
+		s := ev["event"].(string)
+		switch s {
+		case "updated":
+			s += ":" + ev["data"].(string)
+		case "events_dropped":
+			s += ":" + strconv.Itoa(int(ev["count"].(float64)))
+		case "error":
+			s += ":" + ev["message"].(string)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestSlowConsumerQueuePolicies(t *testing.T) {
+	cases := []struct {
+		policy  string
+		publish func()
+		want    []string
+	}{
+		{
+			policy: QueuePolicyDisconnect,
+			publish: func() {
+				for i := 1; i <= 4; i++ {
+					NotifySubscribers("slow", "doc", []byte("v"+strconv.Itoa(i)))
+				}
+			},
+			want: []string{"subscribed", "error:slow_consumer"},
+		},
+		{
+			policy: QueuePolicyDropOldest,
+			publish: func() {
+				for i := 1; i <= 5; i++ {
+					NotifySubscribers("slow", "doc", []byte("v"+strconv.Itoa(i)))
+				}
+			},
+			want: []string{"subscribed", "events_dropped:2", "updated:v3", "updated:v4", "updated:v5"},
+		},
+		{
+			policy: QueuePolicyCoalesce,
+			publish: func() {
+				NotifySubscribers("slow", "doc", []byte("a"))
+				NotifySubscribers("slow", "other", []byte("b"))
+				NotifySubscribers("slow", "doc", []byte("c"))
+				NotifySubscribers("slow", "other", []byte("d")) // zastępuje b
+				NotifyIncTableSubscribers("slow", "doc", "add", 0, []byte("e"))
+			},
+			want: []string{"subscribed", "events_dropped:1", "updated:c", "updated:d", "inc_table_update"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			setupSubTest(t, &config.Config{Subscriptions: config.Subscriptions{
+				Queue: config.Sub_queue{Size: 3, Policy: tc.policy},
+			}})
+			before := StatsSnapshot()
+
+			g := newGateTransport()
+			release := sync.OnceFunc(func() { close(g.release) })
+			c, _ := registerClient(g)
+			t.Cleanup(func() { release(); cleanupClient(c) })
+			key, _ := EnableTableSubscriptionInternal("slow", nil, []string{""}, nil)
+			if code := attach(c, "", attachRequest{AuthKey: key}); code != "" {
+				t.Fatalf("attach: %s", code)
+			}
+			<-g.entered // writer trzyma "subscribed"
+			tc.publish()
+
+			stats := StatsSnapshot()
+			if tc.policy == QueuePolicyDisconnect {
+				if stats.SlowConsumerDisconnects == before.SlowConsumerDisconnects {
+					t.Fatalf("expected slow consumer disconnect, got %+v", stats)
+				}
+				mu.Lock()
+				_, still := connToTargets[c]
+				mu.Unlock()
+				if still {
+					t.Fatalf("slow consumer should be unsubscribed")
+				}
+			} else if stats.QueuedMessages < 3 || stats.MaxQueueDepth != 3 {
+				t.Fatalf("expected full queue of 3, got %+v", stats)
+			}
+			if tc.policy == QueuePolicyCoalesce && stats.CoalescedMessages-before.CoalescedMessages != 1 {
+				t.Fatalf("expected one coalesced message, got %+v", stats)
+			}
+
+			release()
+			deadline := time.Now().Add(2 * time.Second)
+			for len(g.summary()) < len(tc.want) && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := g.summary(); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInBandCommands(t *testing.T) {
+	srv := setupSubTest(t, authConfig())
+	roomKey := func(room string) string {
+		return authKeyFrom(t, enable(t, "chat-key", map[string]any{"keys": []string{room}, "table": "messages"}))
+	}
+	command := func(conn *websocket.Conn, cmd map[string]any) map[string]any {
+		t.Helper()
+		_ = conn.WriteJSON(cmd)
+		return readEvent(t, conn)
+	}
+
+	first := dialSub(t, srv, nil)
+	if ev := command(first, map[string]any{"op": "subscribe", "id": "a", "table": "messages", "keys": []string{"room2"}}); ev["event"] != "error" || ev["message"] != "not_authenticated" || ev["id"] != "a" {
+		t.Fatalf("expected not_authenticated before auth_key, got %v", ev)
+	}
+	attachWS(t, first, map[string]string{"auth_key": roomKey("room1")})
+
+	if ev := command(first, map[string]any{"op": "subscribe", "id": "b", "table": "secret", "keys": []string{"x"}}); ev["message"] != "forbidden" || ev["op"] != "subscribe" {
+		t.Fatalf("expected forbidden for unreadable table, got %v", ev)
+	}
+	if ev := command(first, map[string]any{"op": "subscribe", "id": 7, "table": "messages", "keys": []string{"room2"}}); ev["event"] != "ack" || ev["id"] != float64(7) || ev["added"] != float64(1) {
+		t.Fatalf("expected subscribe ack with numeric id, got %v", ev)
+	}
+
+	second := dialSub(t, srv, nil)
+	attachWS(t, second, map[string]string{"auth_key": roomKey("room2")})
+
+	// unsubscribe dotyczy tylko pierwszego połączenia
+	if ev := command(first, map[string]any{"op": "unsubscribe", "id": "c", "table": "messages", "keys": []string{"room2"}}); ev["event"] != "ack" || ev["removed"] != float64(1) {
+		t.Fatalf("expected unsubscribe ack, got %v", ev)
+	}
+	NotifySubscribers("messages", "room2", []byte("hi"))
+	if ev := readEvent(t, second); ev["key"] != "room2" {
+		t.Fatalf("second connection should still get room2, got %v", ev)
+	}
+	NotifySubscribers("messages", "room1", []byte("hello"))
+	if ev := readEvent(t, first); ev["key"] != "room1" {
+		t.Fatalf("first connection should only get room1, got %v", ev)
+	}
+
+	ev := command(first, map[string]any{"op": "list", "id": "d"})
+	subs, _ := ev["subscriptions"].([]any)
+	if ev["event"] != "ack" || len(subs) != 1 || subs[0].(map[string]any)["key"] != "room1" {
+		t.Fatalf("expected only room1 in list, got %v", ev)
+	}
+	if ev := command(first, map[string]any{"op": "nope", "id": "e"}); ev["message"] != "unknown_op" || ev["id"] != "e" {
+		t.Fatalf("expected unknown_op, got %v", ev)
+	}
+}
+
+func TestClusterFanOut(t *testing.T) {
+	srv := setupSubTest(t, authConfig())
+	forwarded := make(chan clusterOut, 16)
+	SetClusterForwarder("node-a", func(ev ClusterEvent, from string) {
+		forwarded <- clusterOut{ev: ev, from: from}
+	})
+	t.Cleanup(func() {
+		SetClusterForwarder("", nil)
+		clusterMu.Lock()
+		clusterSeen, clusterSeenRing = make(map[string]struct{}), nil
+		clusterMu.Unlock()
+	})
+	nextForward := func() clusterOut {
+		t.Helper()
+		select {
+		case out := <-forwarded:
+			return out
+		case <-time.After(2 * time.Second):
+			t.Fatal("event was not forwarded")
+			return clusterOut{}
+		}
+	}
+
+	conn := dialSub(t, srv, nil)
+	attachWS(t, conn, map[string]string{"auth_key": authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"doc"}, "table": "t"}))})
+
+	// zapis na tym node'cie: origin w zdarzeniu i wysyłka do wszystkich peerów
+	NotifySubscribers("t", "doc", []byte("local"))
+	if ev := readEvent(t, conn); ev["origin"] != "node-a" || ev["data"] != "local" {
+		t.Fatalf("expected local event with origin, got %v", ev)
+	}
+	out := nextForward()
+	if out.from != "" || out.ev.ID != "node-a:1" || out.ev.Origin != "node-a" || string(out.ev.Data) != "local" {
+		t.Fatalf("unexpected forwarded event %+v", out)
+	}
+	// własne zdarzenie, które wróciło od peera, jest odrzucane
+	if PublishRemote(out.ev, "peer-b") {
+		t.Fatal("own event must not be published again")
+	}
+
+	remote := ClusterEvent{
+		ID: "node-b:41", Origin: "node-b", Table: "t", Key: "doc",
+		Event: json.RawMessage(`{"event":"updated","table":"t","key":"doc","origin":"node-b"}`),
+		Data:  []byte("remote"),
+	}
+	if !PublishRemote(remote, "peer-b") {
+		t.Fatal("remote event rejected")
+	}
+	if ev := readEvent(t, conn); ev["origin"] != "node-b" || ev["data"] != "remote" || ev["seq"] != "2" {
+		t.Fatalf("expected remote event with local seq, got %v", ev)
+	}
+	// przekazane dalej z pominięciem peera, od którego przyszło
+	if out := nextForward(); out.from != "peer-b" || out.ev.ID != "node-b:41" {
+		t.Fatalf("expected relay of remote event, got %+v", out)
+	}
+	// ta sama wiadomość inną ścieżką
+	if PublishRemote(remote, "peer-c") {
+		t.Fatal("duplicate remote event published")
+	}
+	NotifySubscribers("t", "doc", []byte("after"))
+	if ev := readEvent(t, conn); ev["data"] != "after" {
+		t.Fatalf("duplicate reached the subscriber: %v", ev)
+	}
+
+	nextForward()
+
+	// zapis szyfrowany: lokalny subskrybent dostaje wartość, peery tylko metadane
+	NotifyEncryptedSubscribers("t", "doc", []byte("plain"))
+	if ev := readEvent(t, conn); ev["data"] != "plain" || ev["encrypted"] != true {
+		t.Fatalf("expected encrypted event with value, got %v", ev)
+	}
+	out = nextForward()
+	if len(out.ev.Data) != 0 || !strings.Contains(string(out.ev.Event), `"encrypted":true`) {
+		t.Fatalf("encrypted value forwarded to peers: %+v", out.ev)
+	}
+	remote = ClusterEvent{
+		ID: "node-b:42", Origin: "node-b", Table: "t", Key: "doc",
+		Event: json.RawMessage(`{"event":"updated","table":"t","key":"doc","encrypted":true}`),
+	}
+	PublishRemote(remote, "peer-b")
+	if ev := readEvent(t, conn); ev["encrypted"] != true || ev["data"] != nil {
+		t.Fatalf("expected remote encrypted event without data, got %v", ev)
+	}
+}
+
+func TestSubscriptionFilters(t *testing.T) {
+	srv := setupSubTest(t, authConfig())
+	if rr := enable(t, "admin-key", map[string]any{"keys": []string{"sensor"}, "table": "t", "filter": "temp >"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid filter, got %d", rr.Code)
+	}
+
+	hot := dialSub(t, srv, nil)
+	key := authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"sensor"}, "table": "t", "filter": `temp > 30 && exists(unit)`}))
+	if ack := attachWS(t, hot, map[string]string{"auth_key": key}); ack["filter"] != `temp > 30 && exists(unit)` {
+		t.Fatalf("expected filter in ack, got %v", ack)
+	}
+
+	status := dialSub(t, srv, nil)
+	attachWS(t, status, map[string]string{"auth_key": authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"other"}, "table": "t"}))})
+	_ = status.WriteJSON(map[string]any{"op": "subscribe", "id": 1, "table": "t", "keys": []string{"sensor"}, "filter": "changed(status)"})
+	if ev := readEvent(t, status); ev["event"] != "ack" || ev["filter"] != "changed(status)" {
+		t.Fatalf("expected subscribe ack with filter, got %v", ev)
+	}
+	_ = status.WriteJSON(map[string]any{"op": "subscribe", "id": 2, "table": "t", "keys": []string{"x"}, "filter": "a == "})
+	if ev := readEvent(t, status); ev["message"] != "invalid_filter" {
+		t.Fatalf("expected invalid_filter, got %v", ev)
+	}
+
+	for _, v := range []string{
+		`{"temp":20,"unit":"C","status":"ok"}`,
+		`{"temp":35,"unit":"C","status":"ok"}`,
+		`{"temp":40,"status":"alarm"}`,
+		`not json`,
+	} {
+		NotifySubscribers("t", "sensor", []byte(v))
+	}
+	NotifyDeleteAndRemove("t", "sensor")
+
+	expect := func(conn *websocket.Conn, want []string) {
+		t.Helper()
+		for _, w := range want {
+			ev := readEvent(t, conn)
+			got, _ := ev["data"].(string)
+			if ev["event"] == "deleted" {
+				got = "deleted"
+			}
+			if got != w {
+				t.Fatalf("expected %s, got %v", w, ev)
+			}
+		}
+	}
+	expect(hot, []string{`{"temp":35,"unit":"C","status":"ok"}`, "deleted"})
+	expect(status, []string{`{"temp":20,"unit":"C","status":"ok"}`, `{"temp":40,"status":"alarm"}`, "deleted"})
+}
+
+// callAPI wywołuje handler Public API przez auth middleware (jak enable).
+func callAPI(t *testing.T, handler func(http.ResponseWriter, *http.Request, *http.Client), apiKey, method, target string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		raw, _ := json.Marshal(body)
+		r = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if apiKey != "" {
+		req.Header.Set("api_key", apiKey)
+	}
+	rr := httptest.NewRecorder()
+	auth.Middleware(auth.AccessNone, func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, nil)
+	})(rr, req)
+	return rr
+}
+
+// resetWebhooks zatrzymuje webhooki w pamięci; loadWebhooks wczyta je ponownie z dysku.
+func resetWebhooks() {
+	mu.Lock()
+	hooks := make([]*webhook, 0, len(webhooks))
+	for _, h := range webhooks {
+		hooks = append(hooks, h)
+	}
+	mu.Unlock()
+	for _, h := range hooks {
+		stopWebhook(h)
+	}
+	webhooksLoadMu.Lock()
+	webhooksLoaded = false
+	webhooksLoadMu.Unlock()
+}
+
+type webhookHit struct {
+	header http.Header
+	body   []byte
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	cfg := authConfig()
+	// odbiorca testu słucha na loopback
+	cfg.Subscriptions.Webhooks = config.Sub_webhooks{MaxAttempts: 3, InitialBackoffMs: 5, MaxBackoffMs: 10, AllowedNetworks: []string{"127.0.0.0/8", "::1"}}
+	setupSubTest(t, cfg)
+	resetWebhooks()
+	t.Cleanup(resetWebhooks)
+
+	hits := make(chan webhookHit, 32)
+	var flaky sync.Once
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		hits <- webhookHit{header: r.Header.Clone(), body: body}
+		var ev map[string]any
+		_ = json.Unmarshal(body, &ev)
+		switch ev["key"] {
+		case "flaky":
+			status := http.StatusOK
+			flaky.Do(func() { status = http.StatusServiceUnavailable })
+			w.WriteHeader(status)
+		case "reject":
+			w.WriteHeader(http.StatusBadRequest)
+		case "down":
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(receiver.Close)
+
+	if rr := callAPI(t, HandleWebhooks, "chat-key", http.MethodPost, "/subscriptions/webhooks", map[string]any{"url": receiver.URL, "table": "billing", "prefixes": []string{""}}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for table without read access, got %d", rr.Code)
+	}
+	if rr := callAPI(t, HandleWebhooks, "admin-key", http.MethodPost, "/subscriptions/webhooks", map[string]any{"url": "ftp://x", "table": "wh", "prefixes": []string{""}}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid url, got %d", rr.Code)
+	}
+	rr := callAPI(t, HandleWebhooks, "admin-key", http.MethodPost, "/subscriptions/webhooks", map[string]any{
+		"url": receiver.URL, "secret": "s3cret", "table": "wh", "prefixes": []string{""}, "filter": "!exists(skip)",
+	})
+	var spec webhookSpec
+	if err := json.Unmarshal(rr.Body.Bytes(), &spec); err != nil || spec.ID == "" || spec.Secret != "s3cret" {
+		t.Fatalf("register webhook: %d %s", rr.Code, rr.Body.String())
+	}
+
+	next := func() (webhookHit, map[string]any) {
+		t.Helper()
+		select {
+		case hit := <-hits:
+			var ev map[string]any
+			_ = json.Unmarshal(hit.body, &ev)
+			return hit, ev
+		case <-time.After(2 * time.Second):
+			t.Fatal("webhook not called")
+		}
+		return webhookHit{}, nil
+	}
+
+	NotifySubscribers("wh", "a", []byte(`{"skip":true}`))
+	NotifySubscribers("wh", "a", []byte(`{"v":1}`))
+	hit, ev := next()
+	if ev["key"] != "a" || ev["data"] != `{"v":1}` {
+		t.Fatalf("unexpected webhook event %v", ev)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(hit.header.Get("X-Tsunami-Timestamp") + "." + string(hit.body)))
+	if hit.header.Get("X-Tsunami-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("bad signature %q", hit.header.Get("X-Tsunami-Signature"))
+	}
+	if hit.header.Get("X-Tsunami-Seq") != ev["seq"] || hit.header.Get("X-Tsunami-Webhook-Id") != spec.ID {
+		t.Fatalf("unexpected headers %v", hit.header)
+	}
+
+	// zapis szyfrowany - tylko metadane, także w dead letters
+	NotifyEncryptedSubscribers("wh", "reject", []byte(`{"v":"plain"}`))
+	if hit, ev := next(); ev["key"] != "reject" || ev["encrypted"] != true || bytes.Contains(hit.body, []byte("plain")) {
+		t.Fatalf("encrypted value sent to webhook: %s", hit.body)
+	}
+
+	// 503 jest ponawiane, 400 od razu trafia do dead letters, 502 po wyczerpaniu prób
+	NotifySubscribers("wh", "flaky", []byte(`{}`))
+	NotifySubscribers("wh", "reject", []byte(`{}`))
+	NotifySubscribers("wh", "down", []byte(`{}`))
+	var keys []string
+	for range 6 {
+		_, ev := next()
+		keys = append(keys, ev["key"].(string))
+	}
+	if got := strings.Join(keys, ","); got != "flaky,flaky,reject,down,down,down" {
+		t.Fatalf("unexpected delivery attempts %s", got)
+	}
+
+	var dead struct {
+		DeadLetters []deadLetter `json:"dead_letters"`
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(dead.DeadLetters) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		rr = callAPI(t, HandleWebhookDeadLetters, "admin-key", http.MethodGet, "/subscriptions/webhooks/dead_letters?id="+spec.ID, nil)
+		_ = json.Unmarshal(rr.Body.Bytes(), &dead)
+	}
+	if len(dead.DeadLetters) != 3 || bytes.Contains(dead.DeadLetters[0].Event, []byte("plain")) ||
+		dead.DeadLetters[1].Status != http.StatusBadRequest || dead.DeadLetters[1].Attempts != 1 ||
+		dead.DeadLetters[2].Status != http.StatusBadGateway || dead.DeadLetters[2].Attempts != 3 {
+		t.Fatalf("unexpected dead letters %+v", dead.DeadLetters)
+	}
+	if rr := callAPI(t, HandleWebhookDeadLetters, "chat-key", http.MethodGet, "/subscriptions/webhooks/dead_letters?id="+spec.ID, nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for foreign webhook, got %d", rr.Code)
+	}
+
+	// rejestracja przetrwa restart
+	resetWebhooks()
+	loadWebhooks()
+	NotifySubscribers("wh", "b", []byte(`{}`))
+	if _, ev := next(); ev["key"] != "b" {
+		t.Fatalf("expected event after reload, got %v", ev)
+	}
+	rr = callAPI(t, HandleWebhooks, "admin-key", http.MethodGet, "/subscriptions/webhooks", nil)
+	if !strings.Contains(rr.Body.String(), spec.ID) || strings.Contains(rr.Body.String(), "s3cret") {
+		t.Fatalf("unexpected list %s", rr.Body.String())
+	}
+
+	if rr := callAPI(t, HandleWebhooks, "admin-key", http.MethodDelete, "/subscriptions/webhooks?id="+spec.ID, nil); rr.Code != http.StatusOK {
+		t.Fatalf("delete webhook: %d", rr.Code)
+	}
+	NotifySubscribers("wh", "c", []byte(`{}`))
+	select {
+	case hit := <-hits:
+		t.Fatalf("deleted webhook called: %s", hit.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+	resetWebhooks()
+	loadWebhooks()
+	if StatsSnapshot().Webhooks != 0 {
+		t.Fatal("deleted webhook restored from disk")
+	}
+}
+
+func TestWebhookRejectsInternalAddresses(t *testing.T) {
+	cfg := authConfig()
+	cfg.Subscriptions.Webhooks = config.Sub_webhooks{MaxAttempts: 1, AllowedNetworks: []string{"127.0.0.1"}}
+	setupSubTest(t, cfg)
+	resetWebhooks()
+	t.Cleanup(resetWebhooks)
+
+	for _, u := range []string{"http://10.1.2.3/hook", "http://169.254.169.254/latest", "http://[::1]:8080/", "http://0.0.0.0/", "https://192.168.0.10/"} {
+		rr := callAPI(t, HandleWebhooks, "admin-key", http.MethodPost, "/subscriptions/webhooks", map[string]any{"url": u, "table": "wh", "prefixes": []string{""}})
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), ErrWebhookAddressBlocked.Error()) {
+			t.Fatalf("%s: expected 400 address not allowed, got %d %s", u, rr.Code, rr.Body.String())
+		}
+	}
+
+	var hits atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { hits.Add(1) }))
+	t.Cleanup(receiver.Close)
+	rr := callAPI(t, HandleWebhooks, "admin-key", http.MethodPost, "/subscriptions/webhooks", map[string]any{"url": receiver.URL, "table": "wh", "prefixes": []string{""}})
+	var spec webhookSpec
+	if err := json.Unmarshal(rr.Body.Bytes(), &spec); err != nil || spec.ID == "" {
+		t.Fatalf("allowlisted address rejected: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// adres sprawdzany też przy połączeniu (np. zapisany webhook po zmianie configu)
+	cfg.Subscriptions.Webhooks.AllowedNetworks = nil
+	config.Set(cfg)
+	if rr := callAPI(t, HandleWebhooks, "admin-key", http.MethodPost, "/subscriptions/webhooks", map[string]any{"url": "http://localhost:9/", "table": "wh", "keys": []string{"x"}}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for host resolving to loopback, got %d", rr.Code)
+	}
+	NotifySubscribers("wh", "a", []byte(`{}`))
+	var dead []deadLetter
+	deadline := time.Now().Add(2 * time.Second)
+	for len(dead) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		h := webhooks[spec.ID]
+		mu.Unlock()
+		dead = h.deadLetters()
+	}
+	if len(dead) != 1 || !strings.Contains(dead[0].Error, ErrWebhookAddressBlocked.Error()) || hits.Load() != 0 {
+		t.Fatalf("expected blocked delivery, got %+v (hits %d)", dead, hits.Load())
+	}
+}
+
+func TestChannels(t *testing.T) {
+	cfg := authConfig()
+	cfg.Api_auth.Keys = append(cfg.Api_auth.Keys, config.Api_key{Key: "room-key", Identity: "room", Tables: map[string]string{"#room-7": "rw"}})
+	srv := setupSubTest(t, cfg)
+	forwarded := make(chan ClusterEvent, 16)
+	SetClusterForwarder("node-a", func(ev ClusterEvent, _ string) { forwarded <- ev })
+	t.Cleanup(func() {
+		SetClusterForwarder("", nil)
+		clusterMu.Lock()
+		clusterSeen, clusterSeenRing = make(map[string]struct{}), nil
+		clusterMu.Unlock()
+	})
+
+	if rr := enable(t, "room-key", map[string]any{"channels": []string{"room-8"}}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for channel without read access, got %d", rr.Code)
+	}
+	publish := func(apiKey, channel, body string) *httptest.ResponseRecorder {
+		return callAPI(t, HandlePublish, apiKey, http.MethodPost, "/publish/"+channel, json.RawMessage(body))
+	}
+	if rr := publish("chat-key", "room-7", `{}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for publish without write access, got %d", rr.Code)
+	}
+
+	all := dialSub(t, srv, nil)
+	if ack := attachWS(t, all, map[string]string{"auth_key": authKeyFrom(t, enable(t, "room-key", map[string]any{"channels": []string{"room-7"}}))}); ack["channels"] == nil {
+		t.Fatalf("expected channels in ack, got %v", ack)
+	}
+	typing := dialSub(t, srv, nil)
+	attachWS(t, typing, map[string]string{"auth_key": authKeyFrom(t, enable(t, "admin-key", map[string]any{"keys": []string{"room-7"}, "table": "t"}))})
+	_ = typing.WriteJSON(map[string]any{"op": "subscribe", "channels": []string{"room-7"}, "filter": "typing == true"})
+	if ev := readEvent(t, typing); ev["event"] != "ack" || ev["added"] != float64(1) {
+		t.Fatalf("expected subscribe ack, got %v", ev)
+	}
+
+	seq := currentSeq()
+	if rr := publish("room-key", "room-7", `{"typing":true}`); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"delivered":2`) {
+		t.Fatalf("publish: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := PublishChannel("room-7", []byte(`{"typing":false}`)); err != nil {
+		t.Fatal(err)
+	}
+	// klucz o tej samej nazwie co kanał nie trafia do subskrybentów kanału
+	NotifySubscribers("t", "room-7", []byte("key"))
+	if currentSeq() != seq+1 {
+		t.Fatalf("channel messages must not get seq: %d -> %d", seq, currentSeq())
+	}
+
+	for _, want := range []string{`{"typing":true}`, `{"typing":false}`} {
+		if ev := readEvent(t, all); ev["event"] != "message" || ev["channel"] != "room-7" || ev["data"] != want || ev["seq"] != nil || ev["origin"] != "node-a" {
+			t.Fatalf("expected message %s, got %v", want, ev)
+		}
+	}
+	if ev := readEvent(t, typing); ev["event"] != "message" || ev["data"] != `{"typing":true}` {
+		t.Fatalf("expected filtered message, got %v", ev)
+	}
+	if ev := readEvent(t, typing); ev["event"] != "updated" || ev["data"] != "key" {
+		t.Fatalf("expected key update, got %v", ev)
+	}
+
+	// peery dostają wiadomości kanału; wiadomość od peera idzie do lokalnych subskrybentów
+	for range 2 {
+		select {
+		case ev := <-forwarded:
+			if ev.Channel != "room-7" {
+				t.Fatalf("unexpected forwarded event %+v", ev)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("channel message was not forwarded")
+		}
+	}
+	if !PublishRemote(ClusterEvent{ID: "node-b:m1-1", Origin: "node-b", Channel: "room-7", Event: json.RawMessage(`{"event":"message","channel":"room-7"}`), Data: []byte("remote")}, "peer-b") {
+		t.Fatal("remote channel message rejected")
+	}
+	if ev := readEvent(t, all); ev["data"] != "remote" || ev["origin"] != "node-b" {
+		t.Fatalf("expected remote message, got %v", ev)
+	}
+
+	_ = typing.WriteJSON(map[string]any{"op": "list"})
+	if ev := readEvent(t, typing); !strings.Contains(fmt.Sprint(ev["subscriptions"]), "channel:room-7") {
+		t.Fatalf("expected channel in list, got %v", ev)
+	}
+}
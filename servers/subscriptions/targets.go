@@ -0,0 +1,208 @@
+package subscriptions
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+/*
+Cele subskrypcji.
+
+Subskrypcja to (tabela, klucz) albo wzorzec kluczy w tabeli: prefiks lub regex (Go RE2),
+albo kanał pub/sub bez zapisu (channels.go) - kanał nie pasuje do żadnego klucza.
+  - table "" = dowolna tabela (zachowanie sprzed tabel; enable bez "table" wymaga odczytu "*")
+  - prefiks "" = cała tabela
+Zdarzenie (table, key) trafia do połączeń z pasującym celem; połączenie pasujące przez kilka
+celów dostaje je raz. Usunięcie klucza (/free) zdejmuje tylko dokładne subskrypcje (table, key),
+wzorce i subskrypcje bez tabeli zostają.
+*/
+
+type targetKind uint8
+
+const (
+	targetKey targetKind = iota
+	targetPrefix
+	targetRegex
+	targetChannel
+)
+
+type subTarget struct {
+	table string
+	kind  targetKind
+	expr  string // klucz, prefiks, regex albo nazwa kanału
+}
+
+var (
+	// skompilowane wzorce aktywnych celów prefix/regex, bez kanałów (chronione przez mu)
+	patternTargets = make(map[subTarget]*regexp.Regexp)
+)
+
+func keyTarget(table, key string) subTarget {
+	return subTarget{table: table, kind: targetKey, expr: key}
+}
+
+// matches - czy cel obejmuje klucz key w tabeli table (re tylko dla targetRegex).
+func (t subTarget) matches(re *regexp.Regexp, table, key string) bool {
+	if t.table != "" && t.table != table {
+		return false
+	}
+	switch t.kind {
+	case targetKey:
+		return t.expr == key
+	case targetPrefix:
+		return strings.HasPrefix(key, t.expr)
+	case targetChannel:
+		return false
+	default:
+		return re != nil && re.MatchString(key)
+	}
+}
+
+// fields - pola identyfikujące cel w zdarzeniach (unsubscribed).
+func (t subTarget) fields() map[string]string {
+	if t.kind == targetChannel {
+		return map[string]string{"channel": t.expr}
+	}
+	out := map[string]string{"table": t.table}
+	switch t.kind {
+	case targetKey:
+		out["key"] = t.expr
+	case targetPrefix:
+		out["prefix"] = t.expr
+	default:
+		out["pattern"] = t.expr
+	}
+	return out
+}
+
+// validatePatterns kompiluje regexy z enable, zanim trafią do Pending.
+func validatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return errors.New("invalid pattern " + p + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// pendingTargets - cele z auth_key, w kolejności: klucze, prefiksy, regexy, kanały.
+func pendingTargets(p *Pending) []subTarget {
+	out := make([]subTarget, 0, len(p.Keys)+len(p.Prefixes)+len(p.Patterns)+len(p.Channels))
+	for _, k := range p.Keys {
+		out = append(out, keyTarget(p.Table, k))
+	}
+	for _, pre := range p.Prefixes {
+		out = append(out, subTarget{table: p.Table, kind: targetPrefix, expr: pre})
+	}
+	for _, re := range p.Patterns {
+		out = append(out, subTarget{table: p.Table, kind: targetRegex, expr: re})
+	}
+	for _, ch := range p.Channels {
+		out = append(out, channelTarget(ch))
+	}
+	return out
+}
+
+// addTargetLocked dopisuje conn do celu; false = conn już go subskrybował (pod mu).
+func addTargetLocked(c *client, t subTarget) bool {
+	if _, already := connToTargets[c][t]; already {
+		return false
+	}
+	set, ok := activeSubs[t]
+	if !ok {
+		var re *regexp.Regexp
+		if t.kind == targetRegex {
+			compiled, err := regexp.Compile(t.expr)
+			if err != nil {
+				return false // regexy są walidowane w enable
+			}
+			re = compiled
+		}
+		set = make(map[*client]struct{})
+		activeSubs[t] = set
+		if t.kind == targetPrefix || t.kind == targetRegex {
+			patternTargets[t] = re
+		}
+	}
+	set[c] = struct{}{}
+	if _, ok := connToTargets[c]; !ok {
+		connToTargets[c] = make(map[subTarget]struct{})
+	}
+	connToTargets[c][t] = struct{}{}
+	return true
+}
+
+// removeTargetLocked odpina conn od celu w obu mapach (pod mu).
+func removeTargetLocked(c *client, t subTarget) {
+	if set, ok := activeSubs[t]; ok {
+		delete(set, c)
+		if len(set) == 0 {
+			delete(activeSubs, t)
+			delete(patternTargets, t)
+		}
+	}
+	setFilterLocked(c, t, nil)
+	if m := replayedTo[c]; m != nil {
+		delete(m, t)
+		if len(m) == 0 {
+			delete(replayedTo, c)
+		}
+	}
+	if m := connToTargets[c]; m != nil {
+		delete(m, t)
+		if len(m) == 0 {
+			delete(connToTargets, c)
+		}
+	}
+}
+
+// matchingConnsLocked - połączenia z celem pasującym do (table, key), bez duplikatów (pod mu).
+func matchingConnsLocked(table, key string) []*client {
+	seen := make(map[*client]struct{})
+	conns := make([]*client, 0)
+	add := func(set map[*client]struct{}) {
+		for c := range set {
+			if _, ok := seen[c]; !ok {
+				seen[c] = struct{}{}
+				conns = append(conns, c)
+			}
+		}
+	}
+	add(activeSubs[keyTarget(table, key)])
+	if table != "" {
+		add(activeSubs[keyTarget("", key)])
+	}
+	for t, re := range patternTargets {
+		if t.matches(re, table, key) {
+			add(activeSubs[t])
+		}
+	}
+	return conns
+}
+
+// dropTargetsLocked zdejmuje cele spełniające match (owner != "" - tylko klientom z auth_key tej identity)
+// i zwraca (conn, cel) do powiadomienia (pod mu).
+func dropTargetsLocked(match func(subTarget) bool, owner string) []droppedSub {
+	var out []droppedSub
+	for t, set := range activeSubs {
+		if !match(t) {
+			continue
+		}
+		for c := range set {
+			if _, ok := connIdentities[c][owner]; owner != "" && !ok {
+				continue
+			}
+			out = append(out, droppedSub{conn: c, target: t})
+		}
+	}
+	for _, d := range out {
+		removeTargetLocked(d.conn, d.target)
+	}
+	return out
+}
+
+type droppedSub struct {
+	conn   *client
+	target subTarget
+}
@@ -0,0 +1,664 @@
+package subscriptions
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	auth "github.com/PAW122/TsunamiDB/servers/auth"
+	config "github.com/PAW122/TsunamiDB/servers/config"
+	"github.com/google/uuid"
+)
+
+/*
+Webhooki - zdarzenia POSTowane na URL, dla serwisów bez otwartego połączenia.
+
+	POST   /subscriptions/webhooks                        {"url","secret","table","keys","prefixes","patterns","filter","encoding"}
+	GET    /subscriptions/webhooks                        własne webhooki (admin - wszystkie)
+	DELETE /subscriptions/webhooks?id=...
+	GET    /subscriptions/webhooks/dead_letters?id=...    nieudane dostarczenia
+
+Webhook to subskrybent z własną kolejką i writerem (queue.go), jak połączenie, ale bez wpisu
+w activeSubs: cele są dopasowywane w publish (matchingWebhooksLocked), więc /free i
+/subscriptions/disable ich nie zdejmują. Filtry i kodowania działają jak dla /sub.
+
+Każde zdarzenie to jeden POST z ciałem = ramka JSON zdarzenia i nagłówkami:
+
+	X-Tsunami-Webhook-Id  id webhooka
+	X-Tsunami-Seq         seq zdarzenia (do odrzucania powtórzeń po retry)
+	X-Tsunami-Timestamp   unix (s) wysłania
+	X-Tsunami-Signature   "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body))
+
+Zapisy szyfrowane (/save_encrypted) idą bez wartości: "encrypted": true, table, key i seq.
+
+2xx = dostarczone. Błąd sieci, 408, 429 i 5xx są ponawiane z wykładniczym backoffem
+(subscriptions.webhooks), inne odpowiedzi i wyczerpane próby trafiają do dead letters.
+Zdarzenia jednego webhooka idą po kolei, więc ponawianie wstrzymuje kolejne; pełna kolejka
+nie rozłącza webhooka (polityka disconnect działa jak drop_oldest).
+
+URL nie może wskazywać na loopback, sieci prywatne, link-local ani adresy nieokreślone
+(SSRF), chyba że subscriptions.webhooks.allowed_networks je dopuszcza. Adres jest sprawdzany
+przy rejestracji (po rozwiązaniu nazwy) i przy każdym połączeniu (webhookDialer), więc zmiana
+DNS po rejestracji nie omija blokady.
+
+Rejestracje są zapisywane w ./db/subscriptions/webhooks.json i wczytywane przy starcie;
+dead letters są tylko w pamięci.
+*/
+
+const (
+	defaultWebhookAttempts    = 8
+	defaultWebhookBackoff     = time.Second
+	defaultWebhookMaxBackoff  = time.Minute
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookDeadLetters = 1000
+	// maks. ilość czytanej odpowiedzi odbiorcy (reszta jest pomijana)
+	webhookMaxResponse = 64 << 10
+)
+
+var (
+	ErrInvalidWebhookURL     = errors.New("invalid webhook url")
+	ErrWebhookAddressBlocked = errors.New("webhook address not allowed")
+
+	// id -> webhook (chronione przez mu)
+	webhooks = make(map[string]*webhook)
+
+	webhooksLoadMu sync.Mutex
+	webhooksLoaded bool
+	// kolejność zapisów webhooks.json (przed mu)
+	webhooksSaveMu sync.Mutex
+
+	webhookDeadLetters atomic.Uint64
+
+	webhookDialer = &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		// adres po rozwiązaniu DNS, tuż przed połączeniem
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !webhookIPAllowed(ip) {
+				return ErrWebhookAddressBlocked
+			}
+			return nil
+		},
+	}
+
+	webhookHTTP = &http.Client{
+		// bez proxy z env - połączenie musi iść wprost na sprawdzony adres
+		Transport: &http.Transport{
+			DialContext:         webhookDialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		// przekierowanie nie jest dostarczeniem (i nie prowadzi pod inny adres)
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+)
+
+// webhookSpec - rejestracja zapisywana na dysku.
+type webhookSpec struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Identity  string    `json:"identity"`
+	Table     string    `json:"table"`
+	Keys      []string  `json:"keys,omitempty"`
+	Prefixes  []string  `json:"prefixes,omitempty"`
+	Patterns  []string  `json:"patterns,omitempty"`
+	Filter    string    `json:"filter,omitempty"`
+	Encoding  string    `json:"encoding,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type deadLetter struct {
+	Seq      string          `json:"seq,omitempty"`
+	Event    json.RawMessage `json:"event"`
+	Attempts int             `json:"attempts"`
+	Status   int             `json:"status,omitempty"` // 0 = brak odpowiedzi
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+type webhook struct {
+	spec    webhookSpec
+	targets map[subTarget]*regexp.Regexp
+	filter  *eventFilter
+	c       *client
+	ctx     context.Context // anulowany przy usunięciu - przerywa ponawianie
+	cancel  context.CancelFunc
+
+	delivered atomic.Uint64
+	deadMu    sync.Mutex
+	dead      []deadLetter
+}
+
+type webhookSettings struct {
+	attempts    int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	timeout     time.Duration
+	deadLetters int
+}
+
+func webhookConfig() webhookSettings {
+	cfg := config.Get().Subscriptions.Webhooks
+	s := webhookSettings{
+		attempts:    defaultWebhookAttempts,
+		backoff:     defaultWebhookBackoff,
+		maxBackoff:  defaultWebhookMaxBackoff,
+		timeout:     defaultWebhookTimeout,
+		deadLetters: defaultWebhookDeadLetters,
+	}
+	if cfg.MaxAttempts > 0 {
+		s.attempts = cfg.MaxAttempts
+	}
+	if cfg.InitialBackoffMs > 0 {
+		s.backoff = time.Duration(cfg.InitialBackoffMs) * time.Millisecond
+	}
+	if cfg.MaxBackoffMs > 0 {
+		s.maxBackoff = time.Duration(cfg.MaxBackoffMs) * time.Millisecond
+	}
+	if cfg.TimeoutMs > 0 {
+		s.timeout = time.Duration(cfg.TimeoutMs) * time.Millisecond
+	}
+	if cfg.DeadLetters > 0 {
+		s.deadLetters = cfg.DeadLetters
+	}
+	return s
+}
+
+// ---------------------------
+// Transport
+// ---------------------------
+
+func (h *webhook) send(m outMsg) error {
+	cfg := webhookConfig()
+	backoff := cfg.backoff
+	for attempt := 1; ; attempt++ {
+		status, err := h.post(m, cfg.timeout)
+		if err == nil {
+			h.delivered.Add(1)
+			return nil
+		}
+		if h.ctx.Err() != nil {
+			return errClientClosed
+		}
+		if attempt >= cfg.attempts || !retryableStatus(status) {
+			h.addDeadLetter(m, attempt, status, err, cfg.deadLetters)
+			return nil
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-h.ctx.Done():
+			timer.Stop()
+			return errClientClosed
+		}
+		backoff = min(backoff*2, cfg.maxBackoff)
+	}
+}
+
+// ping - webhook nie ma połączenia do sprawdzania.
+func (h *webhook) ping() error { return nil }
+
+func (h *webhook) close() { h.cancel() }
+
+func (h *webhook) binary() bool { return false }
+
+// post wysyła jedną próbę; status 0 = brak odpowiedzi.
+func (h *webhook) post(m outMsg, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(h.ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.spec.URL, bytes.NewReader(m.data))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tsunami-Webhook-Id", h.spec.ID)
+	req.Header.Set("X-Tsunami-Timestamp", ts)
+	req.Header.Set("X-Tsunami-Signature", signWebhook(h.spec.Secret, ts, m.data))
+	if m.seq > 0 {
+		req.Header.Set("X-Tsunami-Seq", strconv.FormatUint(m.seq, 10))
+	}
+
+	resp, err := webhookHTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, webhookMaxResponse))
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookIPAllowed - false dla adresów wewnętrznych spoza subscriptions.webhooks.allowed_networks.
+func webhookIPAllowed(ip net.IP) bool {
+	for _, entry := range config.Get().Subscriptions.Webhooks.AllowedNetworks {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified())
+}
+
+// validateWebhookURL sprawdza schemat i wszystkie adresy hosta (rejestracja; przy wysyłce webhookDialer).
+func validateWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Hostname() == "" {
+		return ErrInvalidWebhookURL
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		if !webhookIPAllowed(ip) {
+			return ErrWebhookAddressBlocked
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return ErrInvalidWebhookURL
+	}
+	for _, addr := range addrs {
+		if !webhookIPAllowed(addr.IP) {
+			return ErrWebhookAddressBlocked
+		}
+	}
+	return nil
+}
+
+func signWebhook(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryableStatus - błąd sieci (0), 408, 429 i 5xx mogą się udać przy kolejnej próbie.
+func retryableStatus(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+func (h *webhook) addDeadLetter(m outMsg, attempts, status int, err error, limit int) {
+	dl := deadLetter{
+		Event:    json.RawMessage(bytes.Clone(m.data)),
+		Attempts: attempts,
+		Status:   status,
+		Error:    err.Error(),
+		FailedAt: time.Now().UTC(),
+	}
+	if m.seq > 0 {
+		dl.Seq = strconv.FormatUint(m.seq, 10)
+	}
+	h.deadMu.Lock()
+	h.dead = append(h.dead, dl)
+	if over := len(h.dead) - limit; over > 0 {
+		h.dead = append([]deadLetter(nil), h.dead[over:]...)
+	}
+	h.deadMu.Unlock()
+	webhookDeadLetters.Add(1)
+	log.Printf("subscriptions: webhook %s delivery failed after %d attempt(s): %v", h.spec.ID, attempts, err)
+}
+
+func (h *webhook) deadLetters() []deadLetter {
+	h.deadMu.Lock()
+	defer h.deadMu.Unlock()
+	return append([]deadLetter{}, h.dead...)
+}
+
+// ---------------------------
+// Rejestr
+// ---------------------------
+
+// startWebhook kompiluje cele i filtr i uruchamia writer webhooka.
+func startWebhook(spec webhookSpec) (*webhook, error) {
+	filter, err := parseFilter(spec.Filter)
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[subTarget]*regexp.Regexp)
+	for _, t := range pendingTargets(&Pending{Table: spec.Table, Keys: spec.Keys, Prefixes: spec.Prefixes, Patterns: spec.Patterns}) {
+		var re *regexp.Regexp
+		if t.kind == targetRegex {
+			if re, err = regexp.Compile(t.expr); err != nil {
+				return nil, err
+			}
+		}
+		targets[t] = re
+	}
+
+	h := &webhook{spec: spec, targets: targets, filter: filter}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.c, _ = registerClient(h)
+	mu.Lock()
+	if spec.Encoding != "" {
+		connEncodings[h.c] = spec.Encoding
+	}
+	webhooks[spec.ID] = h
+	mu.Unlock()
+	return h, nil
+}
+
+// stopWebhook usuwa webhook z rejestru (bez zapisu na dysk) i przerywa ponawianie.
+func stopWebhook(h *webhook) {
+	mu.Lock()
+	delete(webhooks, h.spec.ID)
+	mu.Unlock()
+	h.cancel()
+	cleanupClient(h.c)
+}
+
+// matchingWebhooksLocked - klienci webhooków, których cel i filtr obejmują zdarzenie (pod mu).
+func matchingWebhooksLocked(ev bufferedEvent, filters *filterEval) []*client {
+	var out []*client
+	for _, h := range webhooks {
+		for t, re := range h.targets {
+			if !t.matches(re, ev.table, ev.key) {
+				continue
+			}
+			if h.filter == nil || !filters.hasValue() || filters.match(h.filter) {
+				out = append(out, h.c)
+			}
+			break
+		}
+	}
+	return out
+}
+
+// deleteWebhooksOf usuwa webhooki identity (revoke); zwraca ilość usuniętych.
+func deleteWebhooksOf(identity string) int {
+	loadWebhooks()
+	mu.Lock()
+	var hooks []*webhook
+	for _, h := range webhooks {
+		if h.spec.Identity == identity {
+			hooks = append(hooks, h)
+		}
+	}
+	mu.Unlock()
+	if len(hooks) == 0 {
+		return 0
+	}
+	for _, h := range hooks {
+		stopWebhook(h)
+	}
+	if err := saveWebhooks(); err != nil {
+		log.Println("subscriptions: saving webhooks failed:", err)
+	}
+	return len(hooks)
+}
+
+func webhooksPath() string {
+	return filepath.Join(eventLogDir, "webhooks.json")
+}
+
+// loadWebhooks wczytuje zapisane rejestracje (raz, przy pierwszym użyciu albo starcie serwera).
+func loadWebhooks() {
+	webhooksLoadMu.Lock()
+	defer webhooksLoadMu.Unlock()
+	if webhooksLoaded {
+		return
+	}
+	webhooksLoaded = true
+
+	raw, err := os.ReadFile(webhooksPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("subscriptions: reading webhooks failed:", err)
+		}
+		return
+	}
+	var specs []webhookSpec
+	if err := json.Unmarshal(raw, &specs); err != nil {
+		log.Println("subscriptions: invalid webhooks file:", err)
+		return
+	}
+	for _, spec := range specs {
+		if _, err := startWebhook(spec); err != nil {
+			log.Println("subscriptions: webhook", spec.ID, "skipped:", err)
+		}
+	}
+}
+
+// saveWebhooks zapisuje aktualne rejestracje (tmp + rename).
+func saveWebhooks() error {
+	webhooksSaveMu.Lock()
+	defer webhooksSaveMu.Unlock()
+
+	mu.Lock()
+	specs := make([]webhookSpec, 0, len(webhooks))
+	for _, h := range webhooks {
+		specs = append(specs, h.spec)
+	}
+	mu.Unlock()
+	sort.Slice(specs, func(i, j int) bool {
+		if !specs[i].CreatedAt.Equal(specs[j].CreatedAt) {
+			return specs[i].CreatedAt.Before(specs[j].CreatedAt)
+		}
+		return specs[i].ID < specs[j].ID
+	})
+
+	raw, err := json.MarshalIndent(specs, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := webhooksPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// plik zawiera sekrety
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// webhookFor - webhook o id, jeśli identity może nim zarządzać (właściciel albo admin).
+func webhookFor(id *auth.Identity, hookID string) (*webhook, int) {
+	mu.Lock()
+	h := webhooks[hookID]
+	mu.Unlock()
+	if h == nil {
+		return nil, http.StatusNotFound
+	}
+	if h.spec.Identity != id.Name && !id.Admin {
+		return nil, http.StatusForbidden
+	}
+	return h, 0
+}
+
+// ---------------------------
+// HTTP Handlery
+// ---------------------------
+
+// HandleWebhooks: POST rejestruje webhook, GET listuje, DELETE ?id= usuwa.
+func HandleWebhooks(w http.ResponseWriter, r *http.Request, _ *http.Client) {
+	loadWebhooks()
+	id := auth.FromRequest(r)
+	if id == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		registerWebhook(w, r, id)
+	case http.MethodGet:
+		listWebhooks(w, id)
+	case http.MethodDelete:
+		h, status := webhookFor(id, r.URL.Query().Get("id"))
+		if h == nil {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		stopWebhook(h)
+		if err := saveWebhooks(); err != nil {
+			log.Println("subscriptions: saving webhooks failed:", err)
+			http.Error(w, "saving webhooks failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func registerWebhook(w http.ResponseWriter, r *http.Request, id *auth.Identity) {
+	var req struct {
+		URL      string   `json:"url"`
+		Secret   string   `json:"secret"`
+		Table    string   `json:"table"`
+		Keys     []string `json:"keys"`
+		Prefixes []string `json:"prefixes"`
+		Patterns []string `json:"patterns"`
+		Filter   string   `json:"filter"`
+		Encoding string   `json:"encoding"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Keys)+len(req.Prefixes)+len(req.Patterns) == 0 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	// jak enable: bez "table" wymagany jest odczyt wszystkich tabel ("*")
+	if !id.CanRead(req.Table) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := validateWebhookURL(r.Context(), req.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validatePatterns(req.Patterns); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := parseFilter(req.Filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Encoding != "" && (!validEncoding(req.Encoding) || req.Encoding == EncodingBinary) {
+		http.Error(w, "invalid encoding", http.StatusBadRequest)
+		return
+	}
+	if req.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			http.Error(w, "generating secret failed", http.StatusInternalServerError)
+			return
+		}
+		req.Secret = hex.EncodeToString(secret)
+	}
+
+	h, err := startWebhook(webhookSpec{
+		ID:        uuid.NewString(),
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Identity:  id.Name,
+		Table:     req.Table,
+		Keys:      append([]string(nil), req.Keys...),
+		Prefixes:  append([]string(nil), req.Prefixes...),
+		Patterns:  append([]string(nil), req.Patterns...),
+		Filter:    req.Filter,
+		Encoding:  req.Encoding,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := saveWebhooks(); err != nil {
+		stopWebhook(h)
+		log.Println("subscriptions: saving webhooks failed:", err)
+		http.Error(w, "saving webhooks failed", http.StatusInternalServerError)
+		return
+	}
+
+	// sekret jest zwracany tylko tutaj
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.spec)
+}
+
+type webhookInfo struct {
+	webhookSpec
+	Delivered   uint64 `json:"delivered"`
+	DeadLetters int    `json:"dead_letters"`
+}
+
+func listWebhooks(w http.ResponseWriter, id *auth.Identity) {
+	mu.Lock()
+	hooks := make([]*webhook, 0, len(webhooks))
+	for _, h := range webhooks {
+		if h.spec.Identity == id.Name || id.Admin {
+			hooks = append(hooks, h)
+		}
+	}
+	mu.Unlock()
+
+	out := make([]webhookInfo, 0, len(hooks))
+	for _, h := range hooks {
+		info := webhookInfo{webhookSpec: h.spec, Delivered: h.delivered.Load()}
+		info.Secret = ""
+		h.deadMu.Lock()
+		info.DeadLetters = len(h.dead)
+		h.deadMu.Unlock()
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"webhooks": out})
+}
+
+// HandleWebhookDeadLetters: GET ?id= - nieudane dostarczenia webhooka, od najstarszego.
+func HandleWebhookDeadLetters(w http.ResponseWriter, r *http.Request, _ *http.Client) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	loadWebhooks()
+	id := auth.FromRequest(r)
+	if id == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h, status := webhookFor(id, r.URL.Query().Get("id"))
+	if h == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id":           h.spec.ID,
+		"dead_letters": h.deadLetters(),
+	})
+}
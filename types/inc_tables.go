@@ -2,8 +2,20 @@ package types
 
 // dane inc-table wewnątrz wpisu w KV store
 type IncTableEntryData struct {
-	EntrySize     uint64 `json:"entry_size"` // maksymalny rozmiar wpisu
-	TableFileName string `json:"table_file"` // plik z tabelą przyrostową
+	EntrySize     uint64       `json:"entry_size"` // maksymalny rozmiar wpisu
+	TableFileName string       `json:"table_file"` // plik z tabelą przyrostową
+	Retention     IncRetention `json:"retention"`
+}
+
+// IncRetention - limity inc table egzekwowane obcinaniem najstarszych wpisów (0 = bez limitu).
+type IncRetention struct {
+	MaxEntries    uint64 `json:"max_entries,omitempty"`
+	MaxBytes      uint64 `json:"max_bytes,omitempty"` // liczone jako wpisy * (entry_size + 3)
+	MaxAgeSeconds uint64 `json:"max_age_seconds,omitempty"`
+}
+
+func (r IncRetention) Enabled() bool {
+	return r.MaxEntries > 0 || r.MaxBytes > 0 || r.MaxAgeSeconds > 0
 }
 
 type IncTableBody struct {
@@ -11,4 +23,5 @@ type IncTableBody struct {
 	EntrySize        uint64
 	SkipBit          bool
 	NextEntryPointer uint64
+	Overflow         bool // Data = referencja do payloadu w pliku overflow (encoding_v1.IncOverflowRef)
 }